@@ -4,24 +4,67 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"math/rand"
+	"math"
 	"net/http"
+	"net/url"
+	"slices"
+	"time"
 
+	"github.com/temporalio/reference-app-orders-go/app/catalog"
+	"github.com/temporalio/reference-app-orders-go/app/currency"
 	"github.com/temporalio/reference-app-orders-go/app/fraud"
+	"github.com/temporalio/reference-app-orders-go/app/ledger"
+	"github.com/temporalio/reference-app-orders-go/app/loyalty"
+	"github.com/temporalio/reference-app-orders-go/app/payment"
+	"github.com/temporalio/reference-app-orders-go/app/promotion"
+	"github.com/temporalio/reference-app-orders-go/app/shipment"
+	"github.com/temporalio/reference-app-orders-go/app/shipping"
+	"github.com/temporalio/reference-app-orders-go/app/tax"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
 	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
 )
 
 // Activities implements the billing package's Activities.
 // Any state shared by the worker among the activities is stored here.
 type Activities struct {
+	BillingURL    string
 	FraudCheckURL string
+	CatalogURL    string
+	OrderURL      string
+	ShipmentURL   string
+	Tax           tax.Calculator
+	Shipping      *shipping.RateTable
+	Currency      *currency.Table
+	Gateway       PaymentGateway
+	Client        client.Client
+	// FraudReviewTimeout is how long a manager has to review a charge the fraud check holds, or zero for
+	// defaultFraudReviewTimeout.
+	FraudReviewTimeout time.Duration
+	// FraudMaintenanceAction is what happens to a charge while the fraud check is down for maintenance,
+	// FraudMaintenanceQueue or FraudMaintenanceDecline. Empty means FraudMaintenanceQueue.
+	FraudMaintenanceAction string
 }
 
+const (
+	// FraudMaintenanceQueue queues charges made while the fraud check is down for maintenance, and authorizes them
+	// once it is back.
+	FraudMaintenanceQueue = "queue"
+
+	// FraudMaintenanceDecline declines charges made while the fraud check is down for maintenance.
+	FraudMaintenanceDecline = "decline"
+)
+
 var a Activities
 
 // GenerateInvoice activity creates an invoice for a fulfillment.
+// Promotions claimed with the input's coupon codes are listed as discount lines after the products they apply to,
+// and tax is charged on the discounted price.
 func (a *Activities) GenerateInvoice(ctx context.Context, input *GenerateInvoiceInput) (*GenerateInvoiceResult, error) {
 	var result GenerateInvoiceResult
 
@@ -35,51 +78,466 @@ func (a *Activities) GenerateInvoice(ctx context.Context, input *GenerateInvoice
 		return nil, fmt.Errorf("invoice must have items")
 	}
 
+	rate := input.ExchangeRate
+	if rate == nil {
+		var err error
+		rate, err = a.Currency.Rate(input.Currency)
+		if err != nil {
+			return nil, rejectInvoice(err)
+		}
+	}
+
 	result.InvoiceReference = input.Reference
+	result.Currency = rate.Currency
+	result.ExchangeRate = rate
+
+	lines := make([]tax.Line, len(input.Items))
+	prices := make([]currency.Money, len(input.Items))
+	promotionLines := make([]promotion.Line, len(input.Items))
+	weights := make([]int32, len(input.Items))
+	parcel := shipping.Parcel{Origin: input.Origin, Region: input.Region, Service: input.ShippingService}
+
+	var parcelWeight int64
+	for i, item := range input.Items {
+		product, err := a.getProduct(ctx, item.SKU)
+		if err != nil {
+			return nil, err
+		}
+
+		// Catalog prices are in the base currency.
+		unitPrice, err := rate.FromBase(currency.NewMoney(int64(product.UnitPrice), rate.Base))
+		if err != nil {
+			return nil, rejectInvoice(err)
+		}
+		amount, err := unitPrice.Mul(int64(item.Quantity))
+		if err != nil {
+			return nil, rejectInvoice(err)
+		}
+
+		weight := int64(product.Weight) * int64(item.Quantity)
+		parcelWeight += weight
+		if parcelWeight > math.MaxInt32 {
+			return nil, rejectInvoice(fmt.Errorf("parcel weight out of range: %s", item.SKU))
+		}
+
+		lines[i] = tax.Line{SKU: item.SKU, TaxClass: product.TaxClass, Amount: amount.Amount}
+		prices[i] = amount
+		promotionLines[i] = promotion.Line{SKU: item.SKU, Quantity: item.Quantity, Amount: amount}
+		weights[i] = int32(weight)
+	}
+	parcel.Weight = int32(parcelWeight)
+
+	discounts, err := a.applyPromotions(ctx, input, promotionLines, *rate)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range discounts {
+		lines[d.Line].Amount -= d.Amount.Amount
+	}
+
+	if input.LoyaltyCredit.Amount > 0 {
+		credit, used, err := applyLoyaltyCredit(input.LoyaltyCredit, lines, *rate)
+		if err != nil {
+			return nil, rejectInvoice(err)
+		}
+		for _, d := range credit {
+			lines[d.Line].Amount -= d.Amount.Amount
+		}
+		discounts = append(discounts, credit...)
+		result.LoyaltyCredit = used
+	}
 
-	for _, item := range input.Items {
-		cost, tax := calculateCosts(item)
-		result.SubTotal += cost
-		result.Tax += tax
-		result.Shipping += calculateShippingCost(item)
-		result.Total += result.SubTotal + result.Tax + result.Shipping
+	taxes, err := a.Tax.Calculate(input.Region, lines)
+	if err != nil {
+		return nil, rejectInvoice(err)
+	}
+
+	quote, err := a.Shipping.Quote(parcel)
+	if err != nil {
+		return nil, rejectInvoice(err)
 	}
 
+	shippingPrice, err := rate.FromBase(currency.NewMoney(int64(quote.Price), rate.Base))
+	if err != nil {
+		return nil, rejectInvoice(err)
+	}
+
+	result.ShippingRate = quote
+	shares, err := allocateShipping(shippingPrice, weights)
+	if err != nil {
+		return nil, rejectInvoice(err)
+	}
+
+	for i, item := range input.Items {
+		line := InvoiceItem{
+			SKU:       item.SKU,
+			Quantity:  item.Quantity,
+			SubTotal:  prices[i],
+			Tax:       currency.NewMoney(taxes[i].Amount, rate.Currency),
+			TaxDetail: &taxes[i],
+			Shipping:  shares[i],
+		}
+		line.Total, err = currency.Sum(line.SubTotal, line.Tax, line.Shipping)
+		if err != nil {
+			return nil, rejectInvoice(err)
+		}
+
+		result.Items = append(result.Items, line)
+
+		for _, d := range discounts {
+			if d.Line != i {
+				continue
+			}
+			zero := currency.NewMoney(0, rate.Currency)
+			result.Items = append(result.Items, InvoiceItem{
+				SKU:       item.SKU,
+				SubTotal:  d.Amount.Neg(),
+				Shipping:  zero,
+				Tax:       zero,
+				Total:     d.Amount.Neg(),
+				Promotion: d.Code,
+			})
+		}
+	}
+
+	totals, err := sumInvoiceItems(result.Items)
+	if err != nil {
+		return nil, rejectInvoice(err)
+	}
+	result.SubTotal = totals.SubTotal
+	result.Shipping = totals.Shipping
+	result.Total = totals.Total
+
 	activity.GetLogger(ctx).Info(
 		"Invoice",
 		"Customer", input.CustomerID,
 		"Total", result.Total,
+		"Currency", result.Currency,
 		"Reference", result.InvoiceReference,
 	)
 
 	return &result, nil
 }
 
-// calculateCosts calculates the cost and tax for an item.
-func calculateCosts(item Item) (cost int32, tax int32) {
-	// This is just a simulation, so make up a cost
-	// Normally this would be looked up on the SKU
-	costPerUnit := 3500 + rand.Int31n(8500)
-	// Return tax at 20%
-	return costPerUnit * int32(item.Quantity), costPerUnit * int32(item.Quantity) / 5
+// allocateShipping splits the cost of shipping a parcel between its lines in proportion to their weight.
+// Any remainder goes to the last line so the shares add up to the parcel price.
+func allocateShipping(price currency.Money, weights []int32) ([]currency.Money, error) {
+	var total int64
+	for _, w := range weights {
+		total += int64(w)
+	}
+
+	shares := make([]currency.Money, len(weights))
+
+	remaining := price
+	for i, w := range weights {
+		if i == len(weights)-1 {
+			shares[i] = remaining
+			break
+		}
+		shares[i] = currency.Money{Currency: price.Currency}
+		if total > 0 {
+			share, err := price.MulDiv(int64(w), total)
+			if err != nil {
+				return nil, err
+			}
+			shares[i] = share
+		}
+
+		var err error
+		if remaining, err = remaining.Sub(shares[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return shares, nil
+}
+
+// applyPromotions works out the discounts given by the promotions claimed with a fulfillment's coupon codes.
+// Each promotion which gives a discount is redeemed against the invoice reference, and one which the customer
+// may not use, because it has expired or they have used it too often, is left out. Unknown codes are ignored.
+// A retried activity finds the promotions it redeemed before, so they are only counted once.
+func (a *Activities) applyPromotions(ctx context.Context, input *GenerateInvoiceInput, lines []promotion.Line, rate currency.Rate) ([]promotion.Discount, error) {
+	logger := activity.GetLogger(ctx)
+
+	var promotions []promotion.Promotion
+	for _, code := range input.CouponCodes {
+		if slices.ContainsFunc(promotions, func(p promotion.Promotion) bool { return p.Code == code }) {
+			continue
+		}
+
+		p, err := a.getPromotion(ctx, code)
+		if err != nil {
+			return nil, err
+		}
+		if p == nil {
+			logger.Warn("Unknown coupon code", "Customer", input.CustomerID, "Code", code)
+			continue
+		}
+		promotions = append(promotions, *p)
+	}
+
+	for {
+		discounts, err := promotion.Apply(promotions, lines, rate)
+		if err != nil {
+			return nil, rejectInvoice(err)
+		}
+
+		refused := -1
+		for i, p := range promotions {
+			if !slices.ContainsFunc(discounts, func(d promotion.Discount) bool { return d.Code == p.Code }) {
+				continue
+			}
+
+			redeemed, err := a.redeemPromotion(ctx, p.Code, input.CustomerID, input.Reference)
+			if err != nil {
+				return nil, err
+			}
+			if !redeemed {
+				refused = i
+				break
+			}
+		}
+
+		if refused < 0 {
+			return discounts, nil
+		}
+
+		// Leaving out a promotion only raises the prices the others apply to, so those already redeemed still apply.
+		promotions = slices.Delete(promotions, refused, refused+1)
+	}
+}
+
+// applyLoyaltyCredit works out how loyalty credit pays for a fulfillment's lines, given their prices after any
+// promotions. The credit is shared between the lines like a fixed amount promotion, and never pays for more than the
+// products cost. It returns the discounts and how much of the credit they used, in the base currency.
+func applyLoyaltyCredit(credit currency.Money, lines []tax.Line, rate currency.Rate) ([]promotion.Discount, currency.Money, error) {
+	promotionLines := make([]promotion.Line, len(lines))
+	for i, line := range lines {
+		promotionLines[i] = promotion.Line{SKU: line.SKU, Amount: currency.NewMoney(line.Amount, rate.Currency)}
+	}
+
+	discounts, err := promotion.Apply(
+		[]promotion.Promotion{{Code: LoyaltyDiscount, Type: promotion.TypeFixedAmount, Amount: credit}},
+		promotionLines,
+		rate,
+	)
+	if err != nil {
+		return nil, currency.Money{}, err
+	}
+
+	var applied currency.Money
+	for _, d := range discounts {
+		if applied, err = applied.Add(d.Amount); err != nil {
+			return nil, currency.Money{}, err
+		}
+	}
+
+	// Converting back to the base currency could round above the credit when all of it was used.
+	full, err := rate.FromBase(credit)
+	if err != nil || applied.Amount >= full.Amount {
+		return discounts, credit, err
+	}
+	used, err := rate.ToBase(applied)
+	if err != nil || used.Amount > credit.Amount {
+		return discounts, credit, err
+	}
+
+	return discounts, used, nil
 }
 
-// calculateShippingCost calculates the shipping cost for an item.
-func calculateShippingCost(item Item) int32 {
-	// This is just a simulation, so make up a cost
-	// Normally this would be looked up on the SKU
-	costPerUnit := 500 + rand.Int31n(500)
-	return costPerUnit * int32(item.Quantity)
+// getPromotion returns the promotion with a coupon code, or nil if there is none.
+func (a *Activities) getPromotion(ctx context.Context, code string) (*promotion.Promotion, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.BillingURL+"/promotions/coupons/"+url.PathEscape(code), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build promotion request: %w", err)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		body, _ := io.ReadAll(res.Body)
+		return nil, fmt.Errorf("promotion request failed: %s: %s", http.StatusText(res.StatusCode), body)
+	}
+
+	var p promotion.Promotion
+
+	err = json.NewDecoder(res.Body).Decode(&p)
+	return &p, err
 }
 
-func (a *Activities) fraudCheck(ctx context.Context, input *ChargeCustomerInput) (*fraud.FraudCheckResult, error) {
+// redeemPromotion records the use of a promotion on an invoice. It returns false if the customer may not use it.
+func (a *Activities) redeemPromotion(ctx context.Context, code string, customerID string, reference string) (bool, error) {
+	jsonInput, err := json.Marshal(promotion.Redemption{CustomerID: customerID, Reference: reference})
+	if err != nil {
+		return false, fmt.Errorf("failed to encode promotion redemption: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.BillingURL+"/promotions/coupons/"+url.PathEscape(code)+"/redemptions", bytes.NewReader(jsonInput))
+	if err != nil {
+		return false, fmt.Errorf("failed to build promotion request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusConflict || res.StatusCode == http.StatusNotFound {
+		body, _ := io.ReadAll(res.Body)
+		activity.GetLogger(ctx).Warn("Promotion not applied", "Customer", customerID, "Code", code, "Reason", string(bytes.TrimSpace(body)))
+		return false, nil
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		body, _ := io.ReadAll(res.Body)
+		return false, fmt.Errorf("promotion redemption failed: %s: %s", http.StatusText(res.StatusCode), body)
+	}
+
+	return true, nil
+}
+
+// ReverseLoyaltyPoints activity takes back the loyalty points awarded for an invoice in proportion to how much of it
+// has been refunded, through the Billing API. Points which have already been spent may leave the balance negative.
+func (a *Activities) ReverseLoyaltyPoints(ctx context.Context, input *LoyaltyReversalInput) error {
+	var account loyalty.Account
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.BillingURL+"/customers/"+url.PathEscape(input.CustomerID)+"/loyalty", nil)
+	if err != nil {
+		return fmt.Errorf("failed to build loyalty request: %w", err)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		body, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("loyalty request failed: %s: %s", http.StatusText(res.StatusCode), body)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&account); err != nil {
+		return err
+	}
+
+	var accrued, reversed int64
+	for _, t := range account.History {
+		switch {
+		case t.Reference != input.Reference:
+		case t.ID == "reversal:"+input.ID:
+			// This refund has already been reversed.
+			return nil
+		case t.Kind == loyalty.KindAccrual:
+			accrued += t.Points
+		case t.Kind == loyalty.KindReversal:
+			reversed -= t.Points
+		}
+	}
+
+	// Reversing the share of all the points refunded so far, less what has been reversed already, means rounding
+	// never leaves points behind once the invoice is fully refunded.
+	target := currency.Money{Amount: accrued}
+	if input.Refunded.Amount < input.Charged.Amount {
+		if target, err = target.MulDiv(input.Refunded.Amount, input.Charged.Amount); err != nil {
+			return temporal.NewNonRetryableApplicationError(err.Error(), "LoyaltyReversalFailed", err)
+		}
+	}
+	points := target.Amount - reversed
+	if points <= 0 {
+		return nil
+	}
+
+	return a.recordLoyaltyTransaction(ctx, loyalty.Transaction{
+		ID:         "reversal:" + input.ID,
+		CustomerID: input.CustomerID,
+		Kind:       loyalty.KindReversal,
+		Points:     -points,
+		Reference:  input.Reference,
+	})
+}
+
+func (a *Activities) recordLoyaltyTransaction(ctx context.Context, transaction loyalty.Transaction) error {
+	jsonInput, err := json.Marshal(transaction)
+	if err != nil {
+		return fmt.Errorf("failed to encode loyalty transaction: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.BillingURL+"/customers/"+url.PathEscape(transaction.CustomerID)+"/loyalty/transactions", bytes.NewReader(jsonInput))
+	if err != nil {
+		return fmt.Errorf("failed to build loyalty request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		body, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("loyalty request failed: %s: %s", http.StatusText(res.StatusCode), body)
+	}
+
+	return nil
+}
+
+// rejectInvoice stops the GenerateInvoice activity being retried when the fulfillment cannot be invoiced.
+func rejectInvoice(err error) error {
+	return temporal.NewNonRetryableApplicationError(err.Error(), invoiceRejectedErrorType, err)
+}
+
+func (a *Activities) getProduct(ctx context.Context, sku string) (*catalog.Product, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.CatalogURL+"/products/"+url.PathEscape(sku), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build catalog request: %w", err)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, temporal.NewNonRetryableApplicationError(fmt.Sprintf("unknown SKU: %s", sku), invoiceRejectedErrorType, nil)
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		body, _ := io.ReadAll(res.Body)
+		return nil, fmt.Errorf("catalog request failed: %s: %s", http.StatusText(res.StatusCode), body)
+	}
+
+	var product catalog.Product
+
+	err = json.NewDecoder(res.Body).Decode(&product)
+	return &product, err
+}
+
+func (a *Activities) fraudCheck(ctx context.Context, input *AuthorizePaymentInput) (*fraud.FraudCheckResult, error) {
 	if a.FraudCheckURL == "" {
 		return &fraud.FraudCheckResult{Declined: false}, nil
 	}
 
 	checkInput := fraud.FraudCheckInput{
-		CustomerID: input.CustomerID,
-		Charge:     input.Charge,
+		CustomerID:      input.CustomerID,
+		Email:           input.Email,
+		ShippingAddress: input.ShippingAddress,
+		SKUs:            input.SKUs,
+		Charge:          input.BaseCharge,
+		IdempotencyKey:  input.FraudKey,
+		Approved:        input.FraudApproved,
 	}
 	jsonInput, err := json.Marshal(checkInput)
 	if err != nil {
@@ -102,6 +560,11 @@ func (a *Activities) fraudCheck(ctx context.Context, input *ChargeCustomerInput)
 
 	if res.StatusCode < 200 || res.StatusCode >= 300 {
 		body, _ := io.ReadAll(res.Body)
+		// A fraud check down for maintenance says why, and until when.
+		var checkResult fraud.FraudCheckResult
+		if res.StatusCode == http.StatusServiceUnavailable && json.Unmarshal(body, &checkResult) == nil && checkResult.Maintenance != nil {
+			return &checkResult, nil
+		}
 		return nil, fmt.Errorf("fraud check request failed: %s: %s", http.StatusText(res.StatusCode), body)
 	}
 
@@ -111,25 +574,464 @@ func (a *Activities) fraudCheck(ctx context.Context, input *ChargeCustomerInput)
 	return &checkResult, err
 }
 
-// ChargeCustomer activity charges a customer for a fulfillment.
-func (a *Activities) ChargeCustomer(ctx context.Context, input *ChargeCustomerInput) (*ChargeCustomerResult, error) {
-	var result ChargeCustomerResult
+// ReleaseFraudCharge activity tells the fraud check that a charge it passed was not authorized, or that its
+// authorization was voided or has expired, so that the charge no longer counts towards the customer's limits.
+func (a *Activities) ReleaseFraudCharge(ctx context.Context, fraudKey string) error {
+	if a.FraudCheckURL == "" {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, a.FraudCheckURL+"/charges/"+url.PathEscape(fraudKey), nil)
+	if err != nil {
+		return fmt.Errorf("failed to build fraud charge request: %w", err)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// A charge the fraud check did not pass, or which was already released, is not counted.
+	if res.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		body, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("fraud charge request failed: %s: %s", http.StatusText(res.StatusCode), body)
+	}
+
+	return nil
+}
+
+// ReleasePromotions activity gives back the uses of promotions redeemed on an invoice which will not be paid, through
+// the Billing API, so that they no longer count towards the customer's limits.
+func (a *Activities) ReleasePromotions(ctx context.Context, input *ReleasePromotionsInput) error {
+	for _, code := range input.Codes {
+		req, err := http.NewRequestWithContext(ctx, http.MethodDelete, a.BillingURL+"/promotions/coupons/"+url.PathEscape(code)+"/redemptions/"+url.PathEscape(input.Reference), nil)
+		if err != nil {
+			return fmt.Errorf("failed to build promotion request: %w", err)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+
+		// A promotion which was already given back is not counted.
+		if res.StatusCode != http.StatusNotFound && (res.StatusCode < 200 || res.StatusCode >= 300) {
+			return fmt.Errorf("promotion release failed: %s", http.StatusText(res.StatusCode))
+		}
+	}
+
+	return nil
+}
+
+// authorizationLifetime is how long the payment gateway holds an authorization before it expires.
+const authorizationLifetime = 7 * 24 * time.Hour
+
+// defaultFraudReviewTimeout is how long a manager has to review a charge the fraud check holds, unless configured.
+const defaultFraudReviewTimeout = 24 * time.Hour
+
+// AuthorizePayment activity places a hold on a customer's funds for a fulfillment.
+// The charge is made against the requested payment method, or the customer's default method if none is requested.
+// A charge the fraud check holds for review is not authorized, and the result says when the review must be decided.
+// A charge made while the fraud check is down for maintenance is declined or queued, as FraudMaintenanceAction says.
+// A queued charge is not authorized, and the result says when the maintenance window ends.
+func (a *Activities) AuthorizePayment(ctx context.Context, input *AuthorizePaymentInput) (*AuthorizePaymentResult, error) {
+	var result AuthorizePaymentResult
 
 	checkResult, err := a.fraudCheck(ctx, input)
 	if err != nil {
 		return nil, err
 	}
+	result.FraudDecisionID = checkResult.DecisionID
+	result.FraudScore = checkResult.Score
+
+	method, err := a.getPaymentMethod(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	if method != nil {
+		result.PaymentMethodID = method.ID
+	}
+
+	switch {
+	case checkResult.Declined:
+		result.DeclineReason = DeclineReasonFraud
+		result.FraudReason = checkResult.Reason
+	case method == nil && input.PaymentMethodID != "":
+		result.DeclineReason = DeclineReasonPaymentMethodNotFound
+	case checkResult.Maintenance != nil:
+		result.MaintenanceReason = checkResult.Maintenance.Reason
+		if a.FraudMaintenanceAction == FraudMaintenanceDecline {
+			result.DeclineReason = DeclineReasonFraudMaintenance
+		} else {
+			result.Queued = true
+			result.RetryAt = checkResult.Maintenance.EndsAt
+		}
+	case checkResult.Review:
+		result.Review = true
+		result.FraudReason = checkResult.Reason
+		timeout := a.FraudReviewTimeout
+		if timeout == 0 {
+			timeout = defaultFraudReviewTimeout
+		}
+		result.ReviewBy = time.Now().Add(timeout)
+	default:
+		auth, err := a.Gateway.Authorize(ctx, &GatewayAuthorization{
+			IdempotencyKey: input.IdempotencyKey,
+			CustomerID:     input.CustomerID,
+			Reference:      input.Reference,
+			Method:         method,
+			Amount:         currency.NewMoney(input.Charge.Amount, input.Currency),
+		})
+		if err != nil {
+			return nil, gatewayError(err)
+		}
+
+		result.Success = auth.Approved
+		result.AuthCode = auth.AuthCode
+		result.DeclineReason = auth.DeclineReason
+	}
 
-	result.Success = !checkResult.Declined
-	result.AuthCode = "1234"
+	if result.Success {
+		result.ExpiresAt = time.Now().Add(authorizationLifetime)
+	}
 
 	activity.GetLogger(ctx).Info(
-		"Charge",
+		"Authorize",
 		"Customer", input.CustomerID,
 		"Amount", input.Charge,
+		"Currency", input.Currency,
 		"Reference", input.Reference,
+		"PaymentMethod", result.PaymentMethodID,
 		"Success", result.Success,
+		"DeclineReason", result.DeclineReason,
+		"FraudReason", result.FraudReason,
+		"FraudDecision", result.FraudDecisionID,
+		"FraudScore", result.FraudScore,
+		"FraudReview", result.Review,
+		"FraudMaintenance", result.MaintenanceReason,
+		"Queued", result.Queued,
 	)
 
 	return &result, nil
 }
+
+// getPaymentMethod looks up the payment method to charge via the Billing API.
+// It returns nil if the requested method does not belong to the customer, or if no method was requested and the
+// customer has no default method.
+func (a *Activities) getPaymentMethod(ctx context.Context, input *AuthorizePaymentInput) (*payment.Method, error) {
+	path := "/payments/customers/" + url.PathEscape(input.CustomerID) + "/methods/default"
+	if input.PaymentMethodID != "" {
+		path = "/payments/methods/" + url.PathEscape(input.PaymentMethodID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.BillingURL+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build payment method request: %w", err)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		body, _ := io.ReadAll(res.Body)
+		return nil, fmt.Errorf("payment method request failed: %s: %s", http.StatusText(res.StatusCode), body)
+	}
+
+	var method payment.Method
+
+	if err := json.NewDecoder(res.Body).Decode(&method); err != nil {
+		return nil, err
+	}
+	if method.CustomerID != input.CustomerID {
+		return nil, nil
+	}
+
+	return &method, nil
+}
+
+// CapturePayment activity takes the funds held by an authorization.
+func (a *Activities) CapturePayment(ctx context.Context, input *CapturePaymentInput) error {
+	activity.GetLogger(ctx).Info(
+		"Capture",
+		"Customer", input.CustomerID,
+		"Amount", input.Charge,
+		"Currency", input.Currency,
+		"Reference", input.Reference,
+		"AuthCode", input.AuthCode,
+	)
+
+	return gatewayError(a.Gateway.Capture(ctx, input.AuthCode, currency.NewMoney(input.Charge.Amount, input.Currency)))
+}
+
+// VoidAuthorization activity releases the funds held by an authorization.
+func (a *Activities) VoidAuthorization(ctx context.Context, input *VoidAuthorizationInput) error {
+	activity.GetLogger(ctx).Info(
+		"Void",
+		"Customer", input.CustomerID,
+		"Reference", input.Reference,
+		"AuthCode", input.AuthCode,
+	)
+
+	return gatewayError(a.Gateway.Void(ctx, input.AuthCode))
+}
+
+// gatewayError stops the activity being retried if the payment gateway rejected the request outright.
+func gatewayError(err error) error {
+	if errors.Is(err, ErrGatewayRejected) {
+		return temporal.NewNonRetryableApplicationError(err.Error(), gatewayRejectedErrorType, err)
+	}
+
+	return err
+}
+
+// StoreInvoice activity stores an invoice via the Billing API.
+func (a *Activities) StoreInvoice(ctx context.Context, invoice *Invoice) error {
+	jsonInput, err := json.Marshal(invoice)
+	if err != nil {
+		return fmt.Errorf("failed to encode invoice: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.BillingURL+"/invoices/"+url.PathEscape(invoice.InvoiceReference), bytes.NewReader(jsonInput))
+	if err != nil {
+		return fmt.Errorf("failed to build invoice request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		body, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("%s: %s", http.StatusText(res.StatusCode), body)
+	}
+
+	return nil
+}
+
+// RecordLedgerTransaction activity records a money movement in the ledger through the Billing API.
+// Recording the same transaction more than once has no further effect.
+func (a *Activities) RecordLedgerTransaction(ctx context.Context, transaction *ledger.Transaction) error {
+	jsonInput, err := json.Marshal(transaction)
+	if err != nil {
+		return fmt.Errorf("failed to encode ledger transaction: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.BillingURL+"/ledger/transactions", bytes.NewReader(jsonInput))
+	if err != nil {
+		return fmt.Errorf("failed to build ledger request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusBadRequest {
+		body, _ := io.ReadAll(res.Body)
+		return temporal.NewNonRetryableApplicationError(fmt.Sprintf("ledger rejected transaction: %s", body), ledgerRejectedErrorType, nil)
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		body, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("%s: %s", http.StatusText(res.StatusCode), body)
+	}
+
+	return nil
+}
+
+// GetCharge activity returns the result of a previous charge.
+func (a *Activities) GetCharge(ctx context.Context, idempotencyKey string) (*ChargeResult, error) {
+	var result ChargeResult
+
+	id := ChargeWorkflowID(ChargeInput{IdempotencyKey: idempotencyKey})
+
+	desc, err := a.Client.DescribeWorkflowExecution(ctx, id, "")
+	if err != nil {
+		var notFound *serviceerror.NotFound
+		if errors.As(err, &notFound) {
+			return nil, temporal.NewNonRetryableApplicationError("charge not found", refundRejectedErrorType, err)
+		}
+		return nil, err
+	}
+
+	// A running charge has not been captured yet, so there is nothing to refund.
+	if desc.WorkflowExecutionInfo.GetStatus() == enums.WORKFLOW_EXECUTION_STATUS_RUNNING {
+		return nil, temporal.NewNonRetryableApplicationError("charge has not been captured", refundRejectedErrorType, nil)
+	}
+
+	err = a.Client.GetWorkflow(ctx, id, "").Get(ctx, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// RefundCustomer activity refunds a customer for all or part of a charge.
+func (a *Activities) RefundCustomer(ctx context.Context, input *RefundCustomerInput) (*RefundCustomerResult, error) {
+	activity.GetLogger(ctx).Info(
+		"Refund",
+		"Customer", input.CustomerID,
+		"Amount", input.Refund,
+		"Currency", input.Currency,
+		"Reference", input.Reference,
+		"IdempotencyKey", input.IdempotencyKey,
+	)
+
+	reference, err := a.Gateway.Refund(ctx, &GatewayRefund{
+		IdempotencyKey: input.IdempotencyKey,
+		CustomerID:     input.CustomerID,
+		Reference:      input.Reference,
+		AuthCode:       input.AuthCode,
+		Amount:         currency.NewMoney(input.Refund.Amount, input.Currency),
+	})
+	if err != nil {
+		return nil, gatewayError(err)
+	}
+
+	return &RefundCustomerResult{AuthCode: reference}, nil
+}
+
+// StoreDispute activity stores the status of a dispute via the Billing API.
+func (a *Activities) StoreDispute(ctx context.Context, dispute *DisputeStatus) error {
+	jsonInput, err := json.Marshal(dispute)
+	if err != nil {
+		return fmt.Errorf("failed to encode dispute: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.BillingURL+"/disputes/"+url.PathEscape(dispute.ID), bytes.NewReader(jsonInput))
+	if err != nil {
+		return fmt.Errorf("failed to build dispute request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		body, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("%s: %s", http.StatusText(res.StatusCode), body)
+	}
+
+	return nil
+}
+
+// StoreFraudReview activity stores the status of a fraud review via the Billing API.
+func (a *Activities) StoreFraudReview(ctx context.Context, review *FraudReviewStatus) error {
+	jsonInput, err := json.Marshal(review)
+	if err != nil {
+		return fmt.Errorf("failed to encode fraud review: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.BillingURL+"/fraud-reviews/"+url.PathEscape(review.ID), bytes.NewReader(jsonInput))
+	if err != nil {
+		return fmt.Errorf("failed to build fraud review request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		body, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("%s: %s", http.StatusText(res.StatusCode), body)
+	}
+
+	return nil
+}
+
+// CollectDisputeEvidence activity gathers evidence that a disputed fulfillment was delivered, by looking up the
+// tracking status of its shipment in the Shipment API.
+func (a *Activities) CollectDisputeEvidence(ctx context.Context, reference string) (*DisputeEvidence, error) {
+	evidence := DisputeEvidence{CollectedAt: time.Now().UTC()}
+
+	// Shipments are booked per fulfillment, and share the fulfillment's ID with its invoice.
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.ShipmentURL+"/shipments/"+url.PathEscape(reference), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build shipment request: %w", err)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return &evidence, nil
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		body, _ := io.ReadAll(res.Body)
+		return nil, fmt.Errorf("shipment request failed: %s: %s", http.StatusText(res.StatusCode), body)
+	}
+
+	var status shipment.ShipmentStatus
+
+	if err := json.NewDecoder(res.Body).Decode(&status); err != nil {
+		return nil, err
+	}
+
+	evidence.ShipmentStatus = status.Status
+	evidence.ShipmentUpdatedAt = status.UpdatedAt
+
+	activity.GetLogger(ctx).Info(
+		"Dispute evidence",
+		"Reference", reference,
+		"ShipmentStatus", evidence.ShipmentStatus,
+	)
+
+	return &evidence, nil
+}
+
+// UpdateOrderStatus activity records a change to an order's status via the Order API.
+func (a *Activities) UpdateOrderStatus(ctx context.Context, status *OrderStatusUpdate) error {
+	jsonInput, err := json.Marshal(status)
+	if err != nil {
+		return fmt.Errorf("failed to encode order status: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.OrderURL+"/orders/"+url.PathEscape(status.ID)+"/status", bytes.NewReader(jsonInput))
+	if err != nil {
+		return fmt.Errorf("failed to build order status request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		body, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("order status request failed: %s: %s", http.StatusText(res.StatusCode), body)
+	}
+
+	return nil
+}
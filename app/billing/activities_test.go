@@ -0,0 +1,653 @@
+package billing_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/temporalio/reference-app-orders-go/app/billing"
+	"github.com/temporalio/reference-app-orders-go/app/catalog"
+	"github.com/temporalio/reference-app-orders-go/app/currency"
+	"github.com/temporalio/reference-app-orders-go/app/db"
+	"github.com/temporalio/reference-app-orders-go/app/fraud"
+	"github.com/temporalio/reference-app-orders-go/app/loyalty"
+	"github.com/temporalio/reference-app-orders-go/app/payment"
+	"github.com/temporalio/reference-app-orders-go/app/promotion"
+	"github.com/temporalio/reference-app-orders-go/app/shipping"
+	"github.com/temporalio/reference-app-orders-go/app/tax"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+)
+
+// gbp returns an amount in the base currency of the activities' exchange rates, as the invoices they generate give it.
+func gbp(amount int64) currency.Money {
+	return currency.NewMoney(amount, "GBP")
+}
+
+func newCatalogAPI(t *testing.T, products ...db.Product) *httptest.Server {
+	store := db.NewSQLiteDB(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, store.Connect(context.Background()))
+	require.NoError(t, store.Setup())
+	t.Cleanup(func() { store.Close() })
+
+	for _, p := range products {
+		require.NoError(t, store.UpsertProduct(context.Background(), &p))
+	}
+
+	api := httptest.NewServer(catalog.Router(store, slog.Default()))
+	t.Cleanup(api.Close)
+
+	return api
+}
+
+func newActivities(t *testing.T, catalogURL string) *billing.Activities {
+	rules, err := tax.LoadRules("")
+	require.NoError(t, err)
+	taxEngine, err := tax.NewRuleEngine(rules)
+	require.NoError(t, err)
+
+	rates, err := shipping.LoadRates("")
+	require.NoError(t, err)
+	rateTable, err := shipping.NewRateTable(rates)
+	require.NoError(t, err)
+
+	currencyTable, err := currency.NewTable(currency.Rates{Base: "GBP", Rates: map[string]int64{"EUR": 1170000}})
+	require.NoError(t, err)
+
+	return &billing.Activities{CatalogURL: catalogURL, Tax: taxEngine, Shipping: rateTable, Currency: currencyTable}
+}
+
+func TestGenerateInvoiceFromCatalog(t *testing.T) {
+	testSuite := testsuite.WorkflowTestSuite{}
+
+	api := newCatalogAPI(t,
+		db.Product{SKU: "Hiking Boots", Name: "Hiking Boots", UnitPrice: 8000, Weight: 1200, TaxClass: catalog.TaxClassStandard},
+		db.Product{SKU: "Guide Book", Name: "Guide Book", UnitPrice: 1500, Weight: 400, TaxClass: catalog.TaxClassZero},
+	)
+	a := newActivities(t, api.URL)
+
+	env := testSuite.NewTestActivityEnvironment()
+	env.RegisterActivity(a.GenerateInvoice)
+
+	future, err := env.ExecuteActivity(a.GenerateInvoice, &billing.GenerateInvoiceInput{
+		CustomerID: "customer",
+		Reference:  "order:01",
+		Items: []billing.Item{
+			{SKU: "Hiking Boots", Quantity: 2},
+			{SKU: "Guide Book", Quantity: 1},
+		},
+		Origin: "Warehouse A",
+	})
+	require.NoError(t, err)
+
+	var result billing.GenerateInvoiceResult
+	require.NoError(t, future.Get(&result))
+
+	require.Equal(t, billing.GenerateInvoiceResult{
+		InvoiceReference: "order:01",
+		Items: []billing.InvoiceItem{
+			{
+				SKU: "Hiking Boots", Quantity: 2, SubTotal: gbp(16000), Shipping: gbp(771), Tax: gbp(3200), Total: gbp(19971),
+				TaxDetail: &tax.LineTax{Region: "GB", TaxClass: "standard", Rate: 2000, Rounding: tax.RoundHalfUp, Taxable: 16000, Amount: 3200},
+			},
+			{
+				SKU: "Guide Book", Quantity: 1, SubTotal: gbp(1500), Shipping: gbp(129), Tax: gbp(0), Total: gbp(1629),
+				TaxDetail: &tax.LineTax{Region: "GB", TaxClass: "zero", Rate: 0, Rounding: tax.RoundHalfUp, Taxable: 1500, Amount: 0},
+			},
+		},
+		SubTotal: gbp(17500),
+		Shipping: gbp(900),
+		Total:    gbp(21600),
+		ShippingRate: &shipping.Quote{
+			RuleID:  "domestic-standard-10kg",
+			Origin:  "Warehouse A",
+			Zone:    "domestic",
+			Service: "standard",
+			Weight:  2800,
+			Rate:    900,
+			Price:   900,
+		},
+		Currency:     "GBP",
+		ExchangeRate: &currency.Rate{Base: "GBP", Currency: "GBP", Rate: currency.RateScale},
+	}, result)
+}
+
+func TestGenerateInvoiceInCurrency(t *testing.T) {
+	testSuite := testsuite.WorkflowTestSuite{}
+
+	api := newCatalogAPI(t,
+		db.Product{SKU: "Hiking Boots", Name: "Hiking Boots", UnitPrice: 8000, Weight: 1200, TaxClass: catalog.TaxClassStandard},
+	)
+	a := newActivities(t, api.URL)
+
+	env := testSuite.NewTestActivityEnvironment()
+	env.RegisterActivity(a.GenerateInvoice)
+
+	input := &billing.GenerateInvoiceInput{
+		CustomerID: "customer",
+		Reference:  "order:01",
+		Items:      []billing.Item{{SKU: "Hiking Boots", Quantity: 2}},
+		Origin:     "Warehouse A",
+		Currency:   "EUR",
+	}
+
+	future, err := env.ExecuteActivity(a.GenerateInvoice, input)
+	require.NoError(t, err)
+
+	var result billing.GenerateInvoiceResult
+	require.NoError(t, future.Get(&result))
+
+	require.Equal(t, "EUR", result.Currency)
+	require.Equal(t, &currency.Rate{Base: "GBP", Currency: "EUR", Rate: 1170000}, result.ExchangeRate)
+	// 80.00 GBP is 93.60 EUR, and 9.00 GBP shipping is 10.53 EUR.
+	require.Equal(t, int64(18720), result.SubTotal.Amount)
+	require.Equal(t, int64(1053), result.Shipping.Amount)
+	require.Equal(t, int64(3744), result.Items[0].Tax.Amount)
+	require.Equal(t, int64(18720+1053+3744), result.Total.Amount)
+	require.Equal(t, int32(900), result.ShippingRate.Price)
+
+	// A rate taken when the order was placed is used in preference to the current rate.
+	input.ExchangeRate = &currency.Rate{Base: "GBP", Currency: "EUR", Rate: 1200000}
+
+	future, err = env.ExecuteActivity(a.GenerateInvoice, input)
+	require.NoError(t, err)
+	require.NoError(t, future.Get(&result))
+	require.Equal(t, int64(19200), result.SubTotal.Amount)
+
+	input.ExchangeRate = nil
+	input.Currency = "JPY"
+
+	_, err = env.ExecuteActivity(a.GenerateInvoice, input)
+	require.ErrorContains(t, err, "unsupported currency")
+
+	var appErr *temporal.ApplicationError
+	require.True(t, errors.As(err, &appErr))
+	require.True(t, appErr.NonRetryable())
+}
+
+func TestGenerateInvoiceForRegion(t *testing.T) {
+	testSuite := testsuite.WorkflowTestSuite{}
+
+	api := newCatalogAPI(t,
+		db.Product{SKU: "Hiking Boots", Name: "Hiking Boots", UnitPrice: 8025, Weight: 1200, TaxClass: catalog.TaxClassStandard},
+	)
+	a := newActivities(t, api.URL)
+
+	env := testSuite.NewTestActivityEnvironment()
+	env.RegisterActivity(a.GenerateInvoice)
+
+	input := &billing.GenerateInvoiceInput{
+		CustomerID: "customer",
+		Reference:  "order:01",
+		Items:      []billing.Item{{SKU: "Hiking Boots", Quantity: 2}},
+		Region:     "US-CA",
+	}
+
+	future, err := env.ExecuteActivity(a.GenerateInvoice, input)
+	require.NoError(t, err)
+
+	var result billing.GenerateInvoiceResult
+	require.NoError(t, future.Get(&result))
+
+	// 16050 * 7.25% = 1163.625
+	require.Equal(t, int64(1164), result.Items[0].Tax.Amount)
+	require.Equal(t, "US-CA", result.Items[0].TaxDetail.Region)
+	require.Equal(t, int32(725), result.Items[0].TaxDetail.Rate)
+
+	input.Region = "Atlantis"
+
+	_, err = env.ExecuteActivity(a.GenerateInvoice, input)
+	require.ErrorContains(t, err, "unknown tax region")
+
+	var appErr *temporal.ApplicationError
+	require.True(t, errors.As(err, &appErr))
+	require.True(t, appErr.NonRetryable())
+}
+
+func TestGenerateInvoiceForLargeOrder(t *testing.T) {
+	testSuite := testsuite.WorkflowTestSuite{}
+
+	api := newCatalogAPI(t,
+		db.Product{SKU: "Yacht", Name: "Yacht", UnitPrice: 100000000, Weight: 100, TaxClass: catalog.TaxClassStandard},
+		db.Product{SKU: "Island", Name: "Island", UnitPrice: math.MaxInt32, TaxClass: catalog.TaxClassStandard},
+	)
+	a := newActivities(t, api.URL)
+
+	env := testSuite.NewTestActivityEnvironment()
+	env.RegisterActivity(a.GenerateInvoice)
+
+	input := &billing.GenerateInvoiceInput{
+		CustomerID: "customer",
+		Reference:  "order:01",
+		Items:      []billing.Item{{SKU: "Yacht", Quantity: 50}},
+		Origin:     "Warehouse A",
+	}
+
+	future, err := env.ExecuteActivity(a.GenerateInvoice, input)
+	require.NoError(t, err)
+
+	var result billing.GenerateInvoiceResult
+	require.NoError(t, future.Get(&result))
+
+	// Totals well beyond what fits in 32 bits are kept exactly.
+	require.Equal(t, int64(5000000000), result.SubTotal.Amount)
+	require.Equal(t, int64(1000000000), result.Items[0].Tax.Amount)
+	require.Equal(t, int64(5000000000+1000000000+900), result.Total.Amount)
+
+	// An order too large to total is rejected rather than wrapping around.
+	input.Items = []billing.Item{{SKU: "Island", Quantity: 5000000}}
+
+	_, err = env.ExecuteActivity(a.GenerateInvoice, input)
+	require.ErrorContains(t, err, "out of range")
+
+	var appErr *temporal.ApplicationError
+	require.True(t, errors.As(err, &appErr))
+	require.True(t, appErr.NonRetryable())
+}
+
+func TestGenerateInvoiceShipping(t *testing.T) {
+	testSuite := testsuite.WorkflowTestSuite{}
+
+	api := newCatalogAPI(t,
+		db.Product{SKU: "Kayak", Name: "Kayak", UnitPrice: 60000, Weight: 22000, TaxClass: catalog.TaxClassStandard},
+		db.Product{SKU: "Paddle", Name: "Paddle", UnitPrice: 4000, Weight: 1000, TaxClass: catalog.TaxClassStandard},
+	)
+	a := newActivities(t, api.URL)
+
+	env := testSuite.NewTestActivityEnvironment()
+	env.RegisterActivity(a.GenerateInvoice)
+
+	input := &billing.GenerateInvoiceInput{
+		CustomerID: "customer",
+		Reference:  "order:01",
+		Items:      []billing.Item{{SKU: "Paddle", Quantity: 1}},
+		Origin:     "Warehouse A",
+	}
+
+	future, err := env.ExecuteActivity(a.GenerateInvoice, input)
+	require.NoError(t, err)
+
+	var result billing.GenerateInvoiceResult
+	require.NoError(t, future.Get(&result))
+	require.Equal(t, "warehouse-a-domestic-standard-2kg", result.ShippingRate.RuleID)
+	require.Equal(t, int64(400), result.Shipping.Amount)
+
+	input.ShippingService = "express"
+
+	future, err = env.ExecuteActivity(a.GenerateInvoice, input)
+	require.NoError(t, err)
+	require.NoError(t, future.Get(&result))
+	require.Equal(t, "domestic-express-2kg", result.ShippingRate.RuleID)
+	require.Equal(t, int64(1200), result.Shipping.Amount)
+
+	input.Items = []billing.Item{{SKU: "Kayak", Quantity: 1}, {SKU: "Paddle", Quantity: 2}}
+	input.ShippingService = ""
+
+	future, err = env.ExecuteActivity(a.GenerateInvoice, input)
+	require.NoError(t, err)
+	require.NoError(t, future.Get(&result))
+	require.Equal(t, "domestic-standard-heavy", result.ShippingRate.RuleID)
+	require.True(t, result.ShippingRate.Oversize)
+	// 1800 for the heaviest band plus a 2500 oversize surcharge, split by weight.
+	require.Equal(t, int64(4300), result.Shipping.Amount)
+	require.Equal(t, int64(3941), result.Items[0].Shipping.Amount)
+	require.Equal(t, int64(359), result.Items[1].Shipping.Amount)
+
+	input.ShippingService = "overnight"
+
+	_, err = env.ExecuteActivity(a.GenerateInvoice, input)
+	require.ErrorContains(t, err, "no shipping rate")
+}
+
+func TestGenerateInvoiceUnknownSKU(t *testing.T) {
+	testSuite := testsuite.WorkflowTestSuite{}
+
+	api := newCatalogAPI(t)
+	a := newActivities(t, api.URL)
+
+	env := testSuite.NewTestActivityEnvironment()
+	env.RegisterActivity(a.GenerateInvoice)
+
+	_, err := env.ExecuteActivity(a.GenerateInvoice, &billing.GenerateInvoiceInput{
+		CustomerID: "customer",
+		Reference:  "order:01",
+		Items:      []billing.Item{{SKU: "Moon Boots", Quantity: 1}},
+	})
+	require.ErrorContains(t, err, "unknown SKU: Moon Boots")
+
+	var appErr *temporal.ApplicationError
+	require.True(t, errors.As(err, &appErr))
+	require.True(t, appErr.NonRetryable())
+}
+
+func TestGenerateInvoiceWithCoupons(t *testing.T) {
+	testSuite := testsuite.WorkflowTestSuite{}
+
+	api := newCatalogAPI(t,
+		db.Product{SKU: "Hiking Boots", Name: "Hiking Boots", UnitPrice: 8000, Weight: 1200, TaxClass: catalog.TaxClassStandard},
+		db.Product{SKU: "Guide Book", Name: "Guide Book", UnitPrice: 1500, Weight: 400, TaxClass: catalog.TaxClassZero},
+	)
+	a := newActivities(t, api.URL)
+
+	store := db.NewSQLiteDB(filepath.Join(t.TempDir(), "promotions.db"))
+	require.NoError(t, store.Connect(context.Background()))
+	require.NoError(t, store.Setup())
+	t.Cleanup(func() { store.Close() })
+
+	for _, p := range []db.Promotion{
+		{Code: "BOOTS10", Type: promotion.TypePercentage, Rate: 1000, SKUs: []string{"Hiking Boots"}},
+		{Code: "ONCE", Type: promotion.TypePercentage, Rate: 5000, LimitPerCustomer: 1},
+		{Code: "FIVER", Type: promotion.TypeFixedAmount, Amount: 500},
+	} {
+		require.NoError(t, store.UpsertPromotion(context.Background(), &p))
+	}
+	// The customer has already used their one go of ONCE.
+	require.NoError(t, store.RedeemPromotion(context.Background(), &db.PromotionRedemption{Code: "ONCE", CustomerID: "customer", Reference: "order:00"}, 1))
+
+	mux := http.NewServeMux()
+	mux.Handle("/promotions/", http.StripPrefix("/promotions", promotion.Router(store, slog.Default())))
+	billingAPI := httptest.NewServer(mux)
+	t.Cleanup(billingAPI.Close)
+	a.BillingURL = billingAPI.URL
+
+	env := testSuite.NewTestActivityEnvironment()
+	env.RegisterActivity(a.GenerateInvoice)
+
+	input := &billing.GenerateInvoiceInput{
+		CustomerID: "customer",
+		Reference:  "order:01",
+		Items: []billing.Item{
+			{SKU: "Hiking Boots", Quantity: 2},
+			{SKU: "Guide Book", Quantity: 1},
+		},
+		Origin:      "Warehouse A",
+		CouponCodes: []string{"BOOTS10", "ONCE", "FIVER", "NOSUCHCODE"},
+	}
+
+	future, err := env.ExecuteActivity(a.GenerateInvoice, input)
+	require.NoError(t, err)
+
+	var result billing.GenerateInvoiceResult
+	require.NoError(t, future.Get(&result))
+
+	// 10% off the boots leaves 144.00, and the 5.00 off is shared 4.52 to the boots and 0.48 to the book.
+	// Tax is charged on the 139.48 left for the boots.
+	require.Equal(t, []billing.InvoiceItem{
+		{
+			SKU: "Hiking Boots", Quantity: 2, SubTotal: gbp(16000), Shipping: gbp(771), Tax: gbp(2790), Total: gbp(19561),
+			TaxDetail: &tax.LineTax{Region: "GB", TaxClass: "standard", Rate: 2000, Rounding: tax.RoundHalfUp, Taxable: 13948, Amount: 2790},
+		},
+		{SKU: "Hiking Boots", SubTotal: gbp(-1600), Shipping: gbp(0), Tax: gbp(0), Total: gbp(-1600), Promotion: "BOOTS10"},
+		{SKU: "Hiking Boots", SubTotal: gbp(-452), Shipping: gbp(0), Tax: gbp(0), Total: gbp(-452), Promotion: "FIVER"},
+		{
+			SKU: "Guide Book", Quantity: 1, SubTotal: gbp(1500), Shipping: gbp(129), Tax: gbp(0), Total: gbp(1629),
+			TaxDetail: &tax.LineTax{Region: "GB", TaxClass: "zero", Rate: 0, Rounding: tax.RoundHalfUp, Taxable: 1452, Amount: 0},
+		},
+		{SKU: "Guide Book", SubTotal: gbp(-48), Shipping: gbp(0), Tax: gbp(0), Total: gbp(-48), Promotion: "FIVER"},
+	}, result.Items)
+	require.Equal(t, gbp(15400), result.SubTotal)
+	require.Equal(t, gbp(19090), result.Total)
+
+	// A retried activity gives the same invoice without redeeming the promotions again.
+	future, err = env.ExecuteActivity(a.GenerateInvoice, input)
+	require.NoError(t, err)
+
+	var retried billing.GenerateInvoiceResult
+	require.NoError(t, future.Get(&retried))
+	require.Equal(t, result, retried)
+
+	for code, count := range map[string]int{"BOOTS10": 1, "FIVER": 1, "ONCE": 1} {
+		var redemptions []db.PromotionRedemption
+		require.NoError(t, store.GetPromotionRedemptions(context.Background(), code, &redemptions))
+		require.Len(t, redemptions, count, code)
+	}
+}
+
+func TestGenerateInvoiceWithLoyaltyCredit(t *testing.T) {
+	testSuite := testsuite.WorkflowTestSuite{}
+
+	api := newCatalogAPI(t,
+		db.Product{SKU: "Hiking Boots", Name: "Hiking Boots", UnitPrice: 8000, Weight: 1200, TaxClass: catalog.TaxClassStandard},
+		db.Product{SKU: "Guide Book", Name: "Guide Book", UnitPrice: 1500, Weight: 400, TaxClass: catalog.TaxClassZero},
+	)
+	a := newActivities(t, api.URL)
+
+	env := testSuite.NewTestActivityEnvironment()
+	env.RegisterActivity(a.GenerateInvoice)
+
+	input := &billing.GenerateInvoiceInput{
+		CustomerID: "customer",
+		Reference:  "order:01",
+		Items: []billing.Item{
+			{SKU: "Hiking Boots", Quantity: 2},
+			{SKU: "Guide Book", Quantity: 1},
+		},
+		Origin:        "Warehouse A",
+		LoyaltyCredit: money(2000),
+	}
+
+	future, err := env.ExecuteActivity(a.GenerateInvoice, input)
+	require.NoError(t, err)
+
+	var result billing.GenerateInvoiceResult
+	require.NoError(t, future.Get(&result))
+
+	// The 20.00 credit is shared 18.28 to the boots and 1.72 to the book, and tax is charged on what is left.
+	require.Equal(t, []billing.InvoiceItem{
+		{
+			SKU: "Hiking Boots", Quantity: 2, SubTotal: gbp(16000), Shipping: gbp(771), Tax: gbp(2834), Total: gbp(19605),
+			TaxDetail: &tax.LineTax{Region: "GB", TaxClass: "standard", Rate: 2000, Rounding: tax.RoundHalfUp, Taxable: 14172, Amount: 2834},
+		},
+		{SKU: "Hiking Boots", SubTotal: gbp(-1828), Shipping: gbp(0), Tax: gbp(0), Total: gbp(-1828), Promotion: billing.LoyaltyDiscount},
+		{
+			SKU: "Guide Book", Quantity: 1, SubTotal: gbp(1500), Shipping: gbp(129), Tax: gbp(0), Total: gbp(1629),
+			TaxDetail: &tax.LineTax{Region: "GB", TaxClass: "zero", Rate: 0, Rounding: tax.RoundHalfUp, Taxable: 1328, Amount: 0},
+		},
+		{SKU: "Guide Book", SubTotal: gbp(-172), Shipping: gbp(0), Tax: gbp(0), Total: gbp(-172), Promotion: billing.LoyaltyDiscount},
+	}, result.Items)
+	require.Equal(t, money(2000), result.LoyaltyCredit)
+	require.Equal(t, gbp(19234), result.Total)
+
+	// Credit never pays for more than the products, leaving tax and shipping to be charged.
+	input.LoyaltyCredit = money(50000)
+
+	future, err = env.ExecuteActivity(a.GenerateInvoice, input)
+	require.NoError(t, err)
+
+	require.NoError(t, future.Get(&result))
+	require.Equal(t, money(17500), result.LoyaltyCredit)
+	require.Equal(t, gbp(0), result.SubTotal)
+	require.Equal(t, gbp(900), result.Total)
+}
+
+func TestReverseLoyaltyPoints(t *testing.T) {
+	testSuite := testsuite.WorkflowTestSuite{}
+
+	store := db.NewSQLiteDB(filepath.Join(t.TempDir(), "loyalty.db"))
+	require.NoError(t, store.Connect(context.Background()))
+	require.NoError(t, store.Setup())
+	t.Cleanup(func() { store.Close() })
+
+	require.NoError(t, store.RecordLoyaltyTransaction(context.Background(), &db.LoyaltyTransaction{
+		ID: "accrual:order:01", CustomerID: "customer", Kind: loyalty.KindAccrual, Points: 301, Reference: "order:01", CreatedAt: time.Now(),
+	}, false))
+
+	billingAPI := httptest.NewServer(loyalty.Router(store, slog.Default()))
+	t.Cleanup(billingAPI.Close)
+	a := &billing.Activities{BillingURL: billingAPI.URL}
+
+	env := testSuite.NewTestActivityEnvironment()
+	env.RegisterActivity(a.ReverseLoyaltyPoints)
+
+	balance := func() int64 {
+		var transactions []db.LoyaltyTransaction
+		require.NoError(t, store.GetLoyaltyTransactions(context.Background(), "customer", &transactions))
+		var points int64
+		for _, t := range transactions {
+			points += t.Points
+		}
+		return points
+	}
+
+	input := &billing.LoyaltyReversalInput{
+		ID: "refund-1", CustomerID: "customer", Reference: "order:01", Refunded: money(2500), Charged: money(10000),
+	}
+
+	// A quarter refunded takes back a quarter of the points, rounded down.
+	_, err := env.ExecuteActivity(a.ReverseLoyaltyPoints, input)
+	require.NoError(t, err)
+	require.Equal(t, int64(301-75), balance())
+
+	// A retried activity takes nothing more back.
+	_, err = env.ExecuteActivity(a.ReverseLoyaltyPoints, input)
+	require.NoError(t, err)
+	require.Equal(t, int64(301-75), balance())
+
+	// Once the invoice is fully refunded, all the points are taken back.
+	input.ID = "refund-2"
+	input.Refunded = money(10000)
+	_, err = env.ExecuteActivity(a.ReverseLoyaltyPoints, input)
+	require.NoError(t, err)
+	require.Zero(t, balance())
+}
+
+func TestAuthorizePaymentMethods(t *testing.T) {
+	testSuite := testsuite.WorkflowTestSuite{}
+
+	store := db.NewSQLiteDB(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, store.Connect(context.Background()))
+	require.NoError(t, store.Setup())
+	t.Cleanup(func() { store.Close() })
+
+	api := httptest.NewServer(billing.Router(nil, store, slog.Default()))
+	t.Cleanup(api.Close)
+
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, m := range []db.PaymentMethod{
+		{ID: "visa", CustomerID: "customer1", Type: payment.MethodTypeCard, Token: "tok_visa", ExpiryMonth: 12, ExpiryYear: 2030, IsDefault: true},
+		{ID: "expired", CustomerID: "customer1", Type: payment.MethodTypeCard, Token: "tok_visa", ExpiryMonth: 5, ExpiryYear: 2024},
+		{ID: "empty", CustomerID: "customer1", Type: payment.MethodTypeBankAccount, Token: billing.SimulatedTokenInsufficientFunds},
+		{ID: "other", CustomerID: "customer2", Type: payment.MethodTypeCard, Token: "tok_visa", ExpiryMonth: 12, ExpiryYear: 2030, IsDefault: true},
+	} {
+		m.CreatedAt = created
+		require.NoError(t, store.UpsertPaymentMethod(context.Background(), &m))
+	}
+
+	a := &billing.Activities{
+		BillingURL: api.URL,
+		Gateway:    &billing.SimulatedGateway{Now: func() time.Time { return time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC) }},
+	}
+
+	for _, tc := range []struct {
+		name            string
+		customerID      string
+		paymentMethodID string
+		method          string
+		declineReason   string
+	}{
+		{name: "default method", customerID: "customer1", method: "visa"},
+		{name: "chosen method", customerID: "customer1", paymentMethodID: "visa", method: "visa"},
+		{name: "expired card", customerID: "customer1", paymentMethodID: "expired", method: "expired", declineReason: billing.DeclineReasonExpired},
+		{name: "insufficient funds", customerID: "customer1", paymentMethodID: "empty", method: "empty", declineReason: billing.DeclineReasonInsufficientFunds},
+		{name: "unknown method", customerID: "customer1", paymentMethodID: "missing", declineReason: billing.DeclineReasonPaymentMethodNotFound},
+		{name: "another customer's method", customerID: "customer1", paymentMethodID: "other", declineReason: billing.DeclineReasonPaymentMethodNotFound},
+		{name: "no stored method", customerID: "customer3"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			env := testSuite.NewTestActivityEnvironment()
+			env.RegisterActivity(a.AuthorizePayment)
+
+			future, err := env.ExecuteActivity(a.AuthorizePayment, &billing.AuthorizePaymentInput{
+				CustomerID:      tc.customerID,
+				Reference:       "order:1",
+				Charge:          money(1000),
+				PaymentMethodID: tc.paymentMethodID,
+			})
+			require.NoError(t, err)
+
+			var result billing.AuthorizePaymentResult
+			require.NoError(t, future.Get(&result))
+
+			require.Equal(t, tc.method, result.PaymentMethodID)
+			require.Equal(t, tc.declineReason, result.DeclineReason)
+			require.Equal(t, tc.declineReason == "", result.Success)
+			if result.Success {
+				require.Len(t, result.AuthCode, 6)
+			} else {
+				require.Empty(t, result.AuthCode)
+			}
+		})
+	}
+}
+
+func TestAuthorizePaymentDuringFraudMaintenance(t *testing.T) {
+	testSuite := testsuite.WorkflowTestSuite{}
+
+	store := db.NewSQLiteDB(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, store.Connect(context.Background()))
+	require.NoError(t, store.Setup())
+	t.Cleanup(func() { store.Close() })
+
+	billingAPI := httptest.NewServer(billing.Router(nil, store, slog.Default()))
+	t.Cleanup(billingAPI.Close)
+	fraudAPI := httptest.NewServer(fraud.Router(store, slog.Default()))
+	t.Cleanup(fraudAPI.Close)
+
+	endsAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	res, err := http.Post(fraudAPI.URL+"/maintenance", "application/json",
+		strings.NewReader(`{"reason":"Database upgrade","endsAt":"`+endsAt.Format(time.RFC3339)+`"}`))
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	for _, tc := range []struct {
+		name          string
+		action        string
+		queued        bool
+		declineReason string
+	}{
+		{name: "queued by default", queued: true},
+		{name: "queued", action: billing.FraudMaintenanceQueue, queued: true},
+		{name: "declined", action: billing.FraudMaintenanceDecline, declineReason: billing.DeclineReasonFraudMaintenance},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a := &billing.Activities{
+				BillingURL:             billingAPI.URL,
+				FraudCheckURL:          fraudAPI.URL,
+				Gateway:                &billing.SimulatedGateway{},
+				FraudMaintenanceAction: tc.action,
+			}
+
+			env := testSuite.NewTestActivityEnvironment()
+			env.RegisterActivity(a.AuthorizePayment)
+
+			future, err := env.ExecuteActivity(a.AuthorizePayment, &billing.AuthorizePaymentInput{
+				CustomerID:     "customer1",
+				Reference:      "order:1",
+				Charge:         money(1000),
+				IdempotencyKey: "charge1",
+			})
+			require.NoError(t, err)
+
+			var result billing.AuthorizePaymentResult
+			require.NoError(t, future.Get(&result))
+
+			require.False(t, result.Success)
+			require.Equal(t, tc.queued, result.Queued)
+			require.Equal(t, tc.declineReason, result.DeclineReason)
+			require.Equal(t, "Database upgrade", result.MaintenanceReason)
+			require.NotEmpty(t, result.FraudDecisionID)
+			if tc.queued {
+				require.True(t, endsAt.Equal(result.RetryAt))
+			}
+		})
+	}
+}
@@ -3,14 +3,25 @@ package billing
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/temporalio/reference-app-orders-go/app/currency"
+	"github.com/temporalio/reference-app-orders-go/app/db"
+	"github.com/temporalio/reference-app-orders-go/app/ledger"
+	"github.com/temporalio/reference-app-orders-go/app/loyalty"
+	"github.com/temporalio/reference-app-orders-go/app/payment"
+	"github.com/temporalio/reference-app-orders-go/app/promotion"
+	"github.com/temporalio/reference-app-orders-go/app/shipping"
+	"github.com/temporalio/reference-app-orders-go/app/tax"
 	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
 	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
 )
 
 // TaskQueue is the default task queue for the Billing system.
@@ -28,18 +39,163 @@ type ChargeInput struct {
 	Reference      string `json:"orderReference"`
 	Items          []Item `json:"items"`
 	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+	// Email is the customer's email address, which the fraud check matches against its email domain lists.
+	Email string `json:"email,omitempty"`
+	// ShippingAddress is where the order is shipped to, which the fraud check uses to score the charge's risk.
+	ShippingAddress string `json:"shippingAddress,omitempty"`
+	// Region is the destination region which determines the tax due, or empty for the default region.
+	Region string `json:"region,omitempty"`
+	// Origin is the warehouse the fulfillment ships from.
+	Origin string `json:"origin,omitempty"`
+	// ShippingService is the shipping service level, or empty for the default service.
+	ShippingService string `json:"shippingService,omitempty"`
+	// PaymentMethodID is the payment method to charge, or empty for the customer's default method.
+	PaymentMethodID string `json:"paymentMethodId,omitempty"`
+	// Currency is the currency to invoice in, or empty for the base currency.
+	Currency string `json:"currency,omitempty"`
+	// ExchangeRate is the rate taken when the order was placed. If it is nil, the current rate for Currency is used.
+	ExchangeRate *currency.Rate `json:"exchangeRate,omitempty"`
+	// CouponCodes are the coupons the customer used on the order. Codes which give no discount are ignored.
+	CouponCodes []string `json:"couponCodes,omitempty"`
+	// LoyaltyCredit is the most that loyalty points redeemed for the order may pay for, in the base currency.
+	LoyaltyCredit currency.Money `json:"loyaltyCredit,omitzero"`
+}
+
+// LoyaltyDiscount is the Promotion of invoice lines paid for with loyalty points.
+const LoyaltyDiscount = "loyalty"
+
+// InvoiceItem is a line on an invoice.
+// A line with a Promotion is a discount on the product line before it, with a negative SubTotal and no quantity.
+type InvoiceItem struct {
+	SKU      string         `json:"sku"`
+	Quantity int32          `json:"quantity"`
+	SubTotal currency.Money `json:"subTotal"`
+	Shipping currency.Money `json:"shipping"`
+	Tax      currency.Money `json:"tax"`
+	Total    currency.Money `json:"total"`
+
+	// Promotion is the coupon code of the promotion which gave a discount line.
+	Promotion string `json:"promotion,omitempty"`
+
+	// TaxDetail is how the tax for the line was calculated.
+	TaxDetail *tax.LineTax `json:"taxDetail,omitempty"`
+}
+
+// applyCurrency gives the amounts of decoded invoice lines the currency of their invoice.
+func applyCurrency(code string, items []InvoiceItem) {
+	for i := range items {
+		currency.Apply(code, &items[i].SubTotal, &items[i].Shipping, &items[i].Tax, &items[i].Total)
+	}
 }
 
 // ChargeResult is the result for the Charge workflow.
 type ChargeResult struct {
-	InvoiceReference string `json:"invoiceReference"`
-	SubTotal         int32  `json:"subTotal"`
-	Shipping         int32  `json:"shipping"`
-	Tax              int32  `json:"tax"`
-	Total            int32  `json:"total"`
+	InvoiceReference string         `json:"invoiceReference"`
+	Items            []InvoiceItem  `json:"items"`
+	SubTotal         currency.Money `json:"subTotal"`
+	Shipping         currency.Money `json:"shipping"`
+	Tax              currency.Money `json:"tax"`
+	Total            currency.Money `json:"total"`
 
+	// ShippingRate is the rate rule used to price shipping.
+	ShippingRate *shipping.Quote `json:"shippingRate,omitempty"`
+
+	// Currency is the currency of the invoice amounts.
+	Currency string `json:"currency,omitempty"`
+	// ExchangeRate is the rate used to convert prices from the base currency.
+	ExchangeRate *currency.Rate `json:"exchangeRate,omitempty"`
+	// LoyaltyCredit is how much of the charge was paid for with loyalty points, in the base currency.
+	LoyaltyCredit currency.Money `json:"loyaltyCredit,omitzero"`
+
+	// Success is true if the payment was authorized.
 	Success  bool   `json:"success"`
 	AuthCode string `json:"authCode"`
+	// PaymentMethodID is the payment method charged, or empty if the customer has no stored payment method.
+	PaymentMethodID string `json:"paymentMethodId,omitempty"`
+	// DeclineReason is why the payment was declined, one of the DeclineReason constants.
+	DeclineReason string `json:"declineReason,omitempty"`
+	// FraudReason is the fraud rule which declined the payment, when DeclineReason is "fraud", or which held it for
+	// review.
+	FraudReason string `json:"fraudReason,omitempty"`
+	// FraudReviewID is the ID of the fraud review, if the fraud check held the charge for review.
+	FraudReviewID string `json:"fraudReviewId,omitempty"`
+	// FraudDecisionID is the ID of the fraud check's most recent decision on the charge, which the fraud API's
+	// decisions endpoint explains.
+	FraudDecisionID string `json:"fraudDecisionId,omitempty"`
+	// FraudScore is the fraud check's risk score for the charge, from 0 to 100, if it scores charges.
+	FraudScore int32 `json:"fraudScore,omitempty"`
+	// MaintenanceReason is why the fraud check was down for maintenance, if the charge was queued or declined because
+	// of it.
+	MaintenanceReason string `json:"maintenanceReason,omitempty"`
+	// RetryAt is when a queued charge will next be authorized, once the fraud check's maintenance window ends.
+	RetryAt time.Time `json:"retryAt,omitempty"`
+
+	// Status is the status of the payment, one of "pending", "review", "queued", "authorized", "declined", "captured",
+	// "voided", "expired".
+	Status string `json:"status"`
+	// AuthorizationExpiresAt is when an uncaptured authorization will be voided.
+	AuthorizationExpiresAt time.Time `json:"authorizationExpiresAt,omitempty"`
+}
+
+// UnmarshalJSON decodes the charge result, giving its amounts its currency.
+func (v *ChargeResult) UnmarshalJSON(data []byte) error {
+	type value ChargeResult
+	if err := json.Unmarshal(data, (*value)(v)); err != nil {
+		return err
+	}
+
+	currency.Apply(v.Currency, &v.SubTotal, &v.Shipping, &v.Tax, &v.Total)
+	applyCurrency(v.Currency, v.Items)
+	return nil
+}
+
+const (
+	// ChargeStatusPending is the status of a charge which has not yet been authorized.
+	ChargeStatusPending = "pending"
+
+	// ChargeStatusReview is the status of a charge the fraud check has held for a manager to approve or reject before
+	// it is authorized.
+	ChargeStatusReview = "review"
+
+	// ChargeStatusQueued is the status of a charge made while the fraud check was down for maintenance, which is
+	// authorized once the maintenance window ends.
+	ChargeStatusQueued = "queued"
+
+	// ChargeStatusAuthorized is the status of a charge whose funds are held, awaiting capture.
+	ChargeStatusAuthorized = "authorized"
+
+	// ChargeStatusDeclined is the status of a charge whose authorization was declined.
+	ChargeStatusDeclined = "declined"
+
+	// ChargeStatusCaptured is the status of a charge whose funds have been taken.
+	ChargeStatusCaptured = "captured"
+
+	// ChargeStatusVoided is the status of a charge whose authorization was voided before capture.
+	ChargeStatusVoided = "voided"
+
+	// ChargeStatusExpired is the status of a charge whose authorization expired before capture.
+	ChargeStatusExpired = "expired"
+)
+
+// AuthorizeUpdateName is the name of the update used to wait for a charge to be authorized.
+const AuthorizeUpdateName = "Authorize"
+
+// CaptureUpdateName is the name of the update used to capture an authorized charge.
+const CaptureUpdateName = "Capture"
+
+// VoidUpdateName is the name of the update used to void an authorized charge.
+const VoidUpdateName = "Void"
+
+// CaptureInput is the input for the capture endpoint.
+type CaptureInput struct {
+	// ChargeIdempotencyKey is the idempotency key of the charge being captured.
+	ChargeIdempotencyKey string `json:"chargeIdempotencyKey"`
+}
+
+// VoidInput is the input for the void endpoint.
+type VoidInput struct {
+	// ChargeIdempotencyKey is the idempotency key of the charge being voided.
+	ChargeIdempotencyKey string `json:"chargeIdempotencyKey"`
 }
 
 // GenerateInvoiceInput is the input for the GenerateInvoice activity.
@@ -47,27 +203,273 @@ type GenerateInvoiceInput struct {
 	CustomerID string `json:"customerId"`
 	Reference  string `json:"orderReference"`
 	Items      []Item `json:"items"`
+	// Region is the destination region which determines the tax due, or empty for the default region.
+	Region string `json:"region,omitempty"`
+	// Origin is the warehouse the fulfillment ships from.
+	Origin string `json:"origin,omitempty"`
+	// ShippingService is the shipping service level, or empty for the default service.
+	ShippingService string `json:"shippingService,omitempty"`
+	// Currency is the currency to invoice in, or empty for the base currency.
+	Currency string `json:"currency,omitempty"`
+	// ExchangeRate is the rate to convert prices at. If it is nil, the current rate for Currency is used.
+	ExchangeRate *currency.Rate `json:"exchangeRate,omitempty"`
+	// CouponCodes are the coupons to apply. Promotions are redeemed against Reference, so each counts once per invoice.
+	CouponCodes []string `json:"couponCodes,omitempty"`
+	// LoyaltyCredit is the most that loyalty points may pay for, in the base currency.
+	// It is applied after promotions, to the price of products but not to tax or shipping.
+	LoyaltyCredit currency.Money `json:"loyaltyCredit,omitzero"`
 }
 
 // GenerateInvoiceResult is the result for the GenerateInvoice activity.
+// The tax due is broken down on each invoice line.
 type GenerateInvoiceResult struct {
+	InvoiceReference string         `json:"invoiceReference"`
+	Items            []InvoiceItem  `json:"items"`
+	SubTotal         currency.Money `json:"subTotal"`
+	Shipping         currency.Money `json:"shipping"`
+	Total            currency.Money `json:"total"`
+
+	// ShippingRate is the rate rule used to price shipping, which is split between the lines by weight.
+	// Its price is in the base currency.
+	ShippingRate *shipping.Quote `json:"shippingRate,omitempty"`
+
+	// Currency is the currency of the invoice amounts.
+	Currency string `json:"currency"`
+	// ExchangeRate is the rate used to convert prices from the base currency.
+	ExchangeRate *currency.Rate `json:"exchangeRate"`
+
+	// LoyaltyCredit is how much of the input's loyalty credit was used, in the base currency.
+	LoyaltyCredit currency.Money `json:"loyaltyCredit,omitzero"`
+}
+
+// UnmarshalJSON decodes the invoice, giving its amounts its currency.
+func (v *GenerateInvoiceResult) UnmarshalJSON(data []byte) error {
+	type value GenerateInvoiceResult
+	if err := json.Unmarshal(data, (*value)(v)); err != nil {
+		return err
+	}
+
+	currency.Apply(v.Currency, &v.SubTotal, &v.Shipping, &v.Total)
+	applyCurrency(v.Currency, v.Items)
+	return nil
+}
+
+// AuthorizePaymentInput is the input for the AuthorizePayment activity.
+type AuthorizePaymentInput struct {
+	CustomerID string         `json:"customerId"`
+	Reference  string         `json:"reference"`
+	Charge     currency.Money `json:"charge"`
+	// Currency is the currency of Charge, or empty for the base currency.
+	Currency string `json:"currency,omitempty"`
+	// BaseCharge is Charge converted to the base currency, which the fraud check tallies.
+	BaseCharge      currency.Money `json:"baseCharge"`
+	PaymentMethodID string         `json:"paymentMethodId,omitempty"`
+	// IdempotencyKey identifies the authorization to the payment gateway, so a retry cannot authorize twice.
+	IdempotencyKey string `json:"idempotencyKey"`
+	// FraudKey identifies the charge to the fraud check. It stays the same when a declined fulfillment is charged
+	// again, so that the fraud check counts each fulfillment once.
+	FraudKey string `json:"fraudKey,omitempty"`
+	// FraudApproved is set when a manager has approved the charge after the fraud check held it for review.
+	FraudApproved bool `json:"fraudApproved,omitempty"`
+	// Email is the customer's email address, or empty if it is not known.
+	Email string `json:"email,omitempty"`
+	// ShippingAddress is where the order is shipped to, and SKUs the products charged for, for the fraud check to
+	// score the charge's risk.
+	ShippingAddress string   `json:"shippingAddress,omitempty"`
+	SKUs            []string `json:"skus,omitempty"`
+}
+
+// UnmarshalJSON decodes the input, giving its amounts its currency.
+func (v *AuthorizePaymentInput) UnmarshalJSON(data []byte) error {
+	type value AuthorizePaymentInput
+	if err := json.Unmarshal(data, (*value)(v)); err != nil {
+		return err
+	}
+
+	currency.Apply(v.Currency, &v.Charge)
+	return nil
+}
+
+// AuthorizePaymentResult is the result for the AuthorizePayment activity.
+type AuthorizePaymentResult struct {
+	Success   bool      `json:"success"`
+	AuthCode  string    `json:"authCode"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	// PaymentMethodID is the payment method charged, or empty if the customer has no stored payment method.
+	PaymentMethodID string `json:"paymentMethodId,omitempty"`
+	// DeclineReason is why the payment was declined, one of the DeclineReason constants.
+	DeclineReason string `json:"declineReason,omitempty"`
+	// FraudReason is the fraud rule which declined the payment, when DeclineReason is "fraud", or which held it for
+	// review.
+	FraudReason string `json:"fraudReason,omitempty"`
+	// FraudDecisionID is the ID of the fraud check's decision, or empty if no fraud check was made.
+	FraudDecisionID string `json:"fraudDecisionId,omitempty"`
+	// FraudScore is the fraud check's risk score for the payment, from 0 to 100, if it scores charges.
+	FraudScore int32 `json:"fraudScore,omitempty"`
+	// Review is set when the fraud check held the payment for review, in which case it was not authorized.
+	Review bool `json:"review,omitempty"`
+	// ReviewBy is when a payment held for review is declined if nobody has reviewed it.
+	ReviewBy time.Time `json:"reviewBy,omitempty"`
+	// Queued is set when the fraud check was down for maintenance, in which case the payment was not authorized and
+	// should be tried again once the maintenance window ends, at RetryAt if the window has an end.
+	Queued  bool      `json:"queued,omitempty"`
+	RetryAt time.Time `json:"retryAt,omitempty"`
+	// MaintenanceReason is why the fraud check was down, if the payment was queued or declined because of it.
+	MaintenanceReason string `json:"maintenanceReason,omitempty"`
+}
+
+// CapturePaymentInput is the input for the CapturePayment activity.
+type CapturePaymentInput struct {
+	CustomerID string         `json:"customerId"`
+	Reference  string         `json:"reference"`
+	AuthCode   string         `json:"authCode"`
+	Charge     currency.Money `json:"charge"`
+	// Currency is the currency of Charge, or empty for the base currency.
+	Currency string `json:"currency,omitempty"`
+}
+
+// UnmarshalJSON decodes the input, giving its amounts its currency.
+func (v *CapturePaymentInput) UnmarshalJSON(data []byte) error {
+	type value CapturePaymentInput
+	if err := json.Unmarshal(data, (*value)(v)); err != nil {
+		return err
+	}
+
+	currency.Apply(v.Currency, &v.Charge)
+	return nil
+}
+
+// ReleasePromotionsInput is the input for the ReleasePromotions activity.
+type ReleasePromotionsInput struct {
+	// Reference is the invoice the promotions were redeemed on.
+	Reference string `json:"reference"`
+	// Codes are the coupon codes of the promotions to give back.
+	Codes []string `json:"codes"`
+}
+
+// VoidAuthorizationInput is the input for the VoidAuthorization activity.
+type VoidAuthorizationInput struct {
+	CustomerID string `json:"customerId"`
+	Reference  string `json:"reference"`
+	AuthCode   string `json:"authCode"`
+}
+
+// RefundInput is the input for the refund endpoint.
+type RefundInput struct {
+	CustomerID       string `json:"customerId"`
 	InvoiceReference string `json:"invoiceReference"`
-	SubTotal         int32  `json:"subTotal"`
-	Shipping         int32  `json:"shipping"`
-	Tax              int32  `json:"tax"`
-	Total            int32  `json:"total"`
+	// ChargeIdempotencyKey is the idempotency key of the charge being refunded.
+	ChargeIdempotencyKey string `json:"chargeIdempotencyKey"`
+	// Items is the set of items to refund. If empty, everything not yet refunded is refunded.
+	Items          []Item `json:"items,omitempty"`
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+}
+
+// RefundWorkflowInput is the input for the Refund workflow.
+type RefundWorkflowInput struct {
+	CustomerID           string `json:"customerId"`
+	InvoiceReference     string `json:"invoiceReference"`
+	ChargeIdempotencyKey string `json:"chargeIdempotencyKey"`
+}
+
+// RefundUpdateName is the name of the update used to request a refund from the Refund workflow.
+const RefundUpdateName = "RefundRequest"
+
+// RefundRequest is the argument for the RefundRequest update.
+type RefundRequest struct {
+	// Items is the set of items to refund. If empty, everything not yet refunded is refunded.
+	Items []Item `json:"items,omitempty"`
 }
 
-// ChargeCustomerInput is the input for the ChargeCustomer activity.
-type ChargeCustomerInput struct {
+// RefundResult is the result for the RefundRequest update.
+type RefundResult struct {
+	InvoiceReference string         `json:"invoiceReference"`
+	Items            []InvoiceItem  `json:"items"`
+	SubTotal         currency.Money `json:"subTotal"`
+	Shipping         currency.Money `json:"shipping"`
+	Tax              currency.Money `json:"tax"`
+	Total            currency.Money `json:"total"`
+
+	// TotalRefunded is the total refunded against the charge so far, including this refund.
+	TotalRefunded currency.Money `json:"totalRefunded"`
+	AuthCode      string         `json:"authCode"`
+
+	// Currency is the currency of the refund amounts, or empty for the base currency.
+	Currency string `json:"currency,omitempty"`
+}
+
+// UnmarshalJSON decodes the refund, giving its amounts its currency.
+func (v *RefundResult) UnmarshalJSON(data []byte) error {
+	type value RefundResult
+	if err := json.Unmarshal(data, (*value)(v)); err != nil {
+		return err
+	}
+
+	currency.Apply(v.Currency, &v.SubTotal, &v.Shipping, &v.Tax, &v.Total, &v.TotalRefunded)
+	applyCurrency(v.Currency, v.Items)
+	return nil
+}
+
+// RefundStatus is the result for the Refund workflow.
+type RefundStatus struct {
+	InvoiceReference string         `json:"invoiceReference"`
+	Items            []InvoiceItem  `json:"items"`
+	Charged          currency.Money `json:"charged"`
+	Refunded         currency.Money `json:"refunded"`
+
+	// Currency is the currency of the amounts, or empty for the base currency.
+	Currency string `json:"currency,omitempty"`
+}
+
+// UnmarshalJSON decodes the refund status, giving its amounts its currency.
+func (v *RefundStatus) UnmarshalJSON(data []byte) error {
+	type value RefundStatus
+	if err := json.Unmarshal(data, (*value)(v)); err != nil {
+		return err
+	}
+
+	currency.Apply(v.Currency, &v.Charged, &v.Refunded)
+	applyCurrency(v.Currency, v.Items)
+	return nil
+}
+
+// RefundCustomerInput is the input for the RefundCustomer activity.
+type RefundCustomerInput struct {
 	CustomerID string `json:"customerId"`
 	Reference  string `json:"reference"`
-	Charge     int32  `json:"charge"`
+	// AuthCode is the authorization code of the payment being refunded.
+	AuthCode string         `json:"authCode"`
+	Refund   currency.Money `json:"refund"`
+	// Currency is the currency of Refund, or empty for the base currency.
+	Currency       string `json:"currency,omitempty"`
+	IdempotencyKey string `json:"idempotencyKey"`
 }
 
-// ChargeCustomerResult is the result for the GenerateInvoice activity.
-type ChargeCustomerResult struct {
-	Success  bool   `json:"success"`
+// UnmarshalJSON decodes the input, giving its amounts its currency.
+func (v *RefundCustomerInput) UnmarshalJSON(data []byte) error {
+	type value RefundCustomerInput
+	if err := json.Unmarshal(data, (*value)(v)); err != nil {
+		return err
+	}
+
+	currency.Apply(v.Currency, &v.Refund)
+	return nil
+}
+
+// LoyaltyReversalInput is the input for the ReverseLoyaltyPoints activity.
+type LoyaltyReversalInput struct {
+	// ID identifies the refund, so that its points are only taken back once.
+	ID         string `json:"id"`
+	CustomerID string `json:"customerId"`
+	// Reference is the invoice which was refunded.
+	Reference string `json:"reference"`
+	// Refunded is the total refunded so far, including this refund, out of the Charged total.
+	Refunded currency.Money `json:"refunded"`
+	Charged  currency.Money `json:"charged"`
+}
+
+// RefundCustomerResult is the result for the RefundCustomer activity.
+type RefundCustomerResult struct {
 	AuthCode string `json:"authCode"`
 }
 
@@ -77,18 +479,234 @@ type ChargeStatsResult struct {
 	Backlog     int64 `json:"backlog"`
 }
 
+// Invoice is the stored invoice for a charge.
+type Invoice struct {
+	InvoiceReference     string         `json:"invoiceReference"`
+	CustomerID           string         `json:"customerId"`
+	ChargeIdempotencyKey string         `json:"chargeIdempotencyKey"`
+	Items                []InvoiceItem  `json:"items,omitempty"`
+	SubTotal             currency.Money `json:"subTotal"`
+	Shipping             currency.Money `json:"shipping"`
+	Tax                  currency.Money `json:"tax"`
+	Total                currency.Money `json:"total"`
+	// ShippingRule is the ID of the rate rule used to price shipping.
+	ShippingRule string `json:"shippingRule,omitempty"`
+	// Currency is the currency of the invoice amounts, or empty for invoices raised before currencies were supported.
+	Currency string `json:"currency,omitempty"`
+	// ExchangeRate is the rate used to convert prices from the base currency.
+	ExchangeRate *currency.Rate `json:"exchangeRate,omitempty"`
+
+	// Status is the status of the payment, as for ChargeResult.
+	Status   string `json:"status"`
+	AuthCode string `json:"authCode"`
+	// DisputeStatus is "open" while the invoice is disputed, "lost" once a dispute has been lost, or "won" if every
+	// dispute was won. An invoice with an open dispute is frozen, and cannot be refunded until the dispute is resolved.
+	DisputeStatus string `json:"disputeStatus,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// UnmarshalJSON decodes the invoice, giving its amounts its currency.
+func (v *Invoice) UnmarshalJSON(data []byte) error {
+	type value Invoice
+	if err := json.Unmarshal(data, (*value)(v)); err != nil {
+		return err
+	}
+
+	currency.Apply(v.Currency, &v.SubTotal, &v.Shipping, &v.Tax, &v.Total)
+	applyCurrency(v.Currency, v.Items)
+	return nil
+}
+
+// DisputeInput is the input for the disputes webhook and the Dispute workflow.
+type DisputeInput struct {
+	// ID is the card issuer's reference for the dispute.
+	ID               string `json:"id"`
+	InvoiceReference string `json:"invoiceReference"`
+	// CustomerID is the customer who was charged, taken from the invoice.
+	CustomerID string `json:"customerId,omitempty"`
+	// Amount is the amount disputed. If zero, the whole invoice is disputed.
+	Amount currency.Money `json:"amount,omitzero"`
+	Reason string         `json:"reason,omitempty"`
+	// RespondBy is when the issuer will rule on the dispute. If zero, the issuer has 30 days.
+	RespondBy time.Time `json:"respondBy,omitempty"`
+	// ExchangeRate is the invoice's exchange rate, taken from the invoice, used to charge back the amount in the base
+	// currency.
+	ExchangeRate *currency.Rate `json:"exchangeRate,omitempty"`
+}
+
+const (
+	// DisputeStatusOpen is the status of a dispute awaiting the issuer's decision.
+	DisputeStatusOpen = "open"
+
+	// DisputeStatusWon is the status of a dispute decided in the business's favour.
+	DisputeStatusWon = "won"
+
+	// DisputeStatusLost is the status of a dispute decided in the customer's favour, whose amount is charged back.
+	DisputeStatusLost = "lost"
+)
+
+// DisputeResolutionSignalName is the name of the signal used to deliver the issuer's decision to a Dispute workflow.
+const DisputeResolutionSignalName = "DisputeResolution"
+
+// DisputeResolution is the issuer's decision on a dispute.
+type DisputeResolution struct {
+	// Outcome is either DisputeStatusWon or DisputeStatusLost.
+	Outcome string `json:"outcome"`
+}
+
+// DisputeEvidence is the evidence collected in defence of a disputed charge.
+type DisputeEvidence struct {
+	CollectedAt time.Time `json:"collectedAt"`
+	// ShipmentStatus is the tracking status of the fulfillment's shipment, or empty if it was never shipped.
+	ShipmentStatus    string    `json:"shipmentStatus,omitempty"`
+	ShipmentUpdatedAt time.Time `json:"shipmentUpdatedAt,omitempty"`
+}
+
+// DisputeStatus is the stored status of a dispute, and the result for the Dispute workflow.
+type DisputeStatus struct {
+	ID               string         `json:"id"`
+	InvoiceReference string         `json:"invoiceReference"`
+	CustomerID       string         `json:"customerId"`
+	Amount           currency.Money `json:"amount"`
+	Reason           string         `json:"reason,omitempty"`
+	// Status is one of "open", "won" or "lost".
+	Status string `json:"status"`
+	// Evidence is the evidence collected for the issuer, once it has been collected.
+	Evidence *DisputeEvidence `json:"evidence,omitempty"`
+
+	RespondBy  time.Time `json:"respondBy"`
+	OpenedAt   time.Time `json:"openedAt"`
+	ResolvedAt time.Time `json:"resolvedAt,omitempty"`
+}
+
+// FraudReviewInput is the input for the FraudReview workflow.
+type FraudReviewInput struct {
+	ID               string         `json:"id"`
+	InvoiceReference string         `json:"invoiceReference"`
+	CustomerID       string         `json:"customerId"`
+	Amount           currency.Money `json:"amount"`
+	// Currency is the currency of Amount, or empty for the base currency.
+	Currency string `json:"currency,omitempty"`
+	// Reason is the fraud rule which held the charge for review.
+	Reason   string    `json:"reason"`
+	ReviewBy time.Time `json:"reviewBy"`
+}
+
+// UnmarshalJSON decodes the input, giving its amounts its currency.
+func (v *FraudReviewInput) UnmarshalJSON(data []byte) error {
+	type value FraudReviewInput
+	if err := json.Unmarshal(data, (*value)(v)); err != nil {
+		return err
+	}
+
+	currency.Apply(v.Currency, &v.Amount)
+	return nil
+}
+
+const (
+	// FraudReviewStatusPending is the status of a fraud review awaiting a manager's decision.
+	FraudReviewStatusPending = "pending"
+
+	// FraudReviewStatusApproved is the status of a fraud review whose charge a manager let go ahead.
+	FraudReviewStatusApproved = "approved"
+
+	// FraudReviewStatusRejected is the status of a fraud review whose charge a manager declined.
+	FraudReviewStatusRejected = "rejected"
+
+	// FraudReviewStatusExpired is the status of a fraud review nobody decided in time, whose charge was declined.
+	FraudReviewStatusExpired = "expired"
+
+	// FraudReviewStatusWithdrawn is the status of a fraud review whose charge was voided before it was decided.
+	FraudReviewStatusWithdrawn = "withdrawn"
+)
+
+// FraudReviewDecisionSignalName is the name of the signal used to deliver a manager's decision to a FraudReview
+// workflow.
+const FraudReviewDecisionSignalName = "FraudReviewDecision"
+
+// FraudReviewDecision is a manager's decision on a charge held for fraud review.
+type FraudReviewDecision struct {
+	// Outcome is either FraudReviewStatusApproved or FraudReviewStatusRejected.
+	Outcome  string `json:"outcome"`
+	Reviewer string `json:"reviewer,omitempty"`
+	Note     string `json:"note,omitempty"`
+}
+
+// FraudReviewStatus is the stored status of a fraud review, and the result for the FraudReview workflow.
+type FraudReviewStatus struct {
+	ID               string         `json:"id"`
+	InvoiceReference string         `json:"invoiceReference"`
+	CustomerID       string         `json:"customerId"`
+	Amount           currency.Money `json:"amount"`
+	Currency         string         `json:"currency,omitempty"`
+	Reason           string         `json:"reason"`
+	// Status is one of "pending", "approved", "rejected", "expired" or "withdrawn".
+	Status   string `json:"status"`
+	Reviewer string `json:"reviewer,omitempty"`
+	Note     string `json:"note,omitempty"`
+
+	ReviewBy   time.Time `json:"reviewBy"`
+	OpenedAt   time.Time `json:"openedAt"`
+	ResolvedAt time.Time `json:"resolvedAt,omitempty"`
+}
+
+// UnmarshalJSON decodes the review, giving its amounts its currency.
+func (v *FraudReviewStatus) UnmarshalJSON(data []byte) error {
+	type value FraudReviewStatus
+	if err := json.Unmarshal(data, (*value)(v)); err != nil {
+		return err
+	}
+
+	currency.Apply(v.Currency, &v.Amount)
+	return nil
+}
+
+// OrderStatusUpdate is the input for the UpdateOrderStatus activity.
+type OrderStatusUpdate struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
 type handlers struct {
 	temporal client.Client
+	db       db.DB
 	logger   *slog.Logger
 }
 
 // Router implements the http.Handler interface for the Billing API
-func Router(c client.Client, logger *slog.Logger) http.Handler {
+func Router(c client.Client, db db.DB, logger *slog.Logger) http.Handler {
 	r := http.NewServeMux()
-	h := handlers{temporal: c, logger: logger}
+	h := handlers{temporal: c, db: db, logger: logger}
 
 	r.HandleFunc("GET /charge/stats", h.handleGetStats)
 	r.HandleFunc("POST /charge", h.handleCharge)
+	r.HandleFunc("POST /capture", h.handleCapture)
+	r.HandleFunc("POST /void", h.handleVoid)
+	r.HandleFunc("POST /refund", h.handleRefund)
+	r.HandleFunc("GET /invoices", h.handleListInvoices)
+	r.HandleFunc("GET /invoices/{ref}", h.handleGetInvoice)
+	r.HandleFunc("GET /invoices/{ref}/html", h.handleGetInvoiceHTML)
+	r.HandleFunc("GET /invoices/{ref}/pdf", h.handleGetInvoicePDF)
+	r.HandleFunc("POST /invoices/{ref}", h.handleStoreInvoice)
+	r.HandleFunc("GET /customers/{id}/invoices", h.handleListCustomerInvoices)
+	r.HandleFunc("POST /disputes", h.handleOpenDispute)
+	r.HandleFunc("GET /disputes", h.handleListDisputes)
+	r.HandleFunc("GET /disputes/{id}", h.handleGetDispute)
+	r.HandleFunc("POST /disputes/{id}", h.handleStoreDispute)
+	r.HandleFunc("POST /disputes/{id}/resolution", h.handleResolveDispute)
+	r.HandleFunc("GET /fraud-reviews", h.handleListFraudReviews)
+	r.HandleFunc("GET /fraud-reviews/{id}", h.handleGetFraudReview)
+	r.HandleFunc("POST /fraud-reviews/{id}", h.handleStoreFraudReview)
+	r.HandleFunc("POST /fraud-reviews/{id}/decision", h.handleDecideFraudReview)
+	r.Handle("/ledger/", http.StripPrefix("/ledger", ledger.Router(db, logger)))
+	r.Handle("/payments/", http.StripPrefix("/payments", payment.Router(db, logger)))
+	r.Handle("/promotions/", http.StripPrefix("/promotions", promotion.Router(db, logger)))
+
+	loyaltyRouter := loyalty.Router(db, logger)
+	r.Handle("/customers/{id}/loyalty", loyaltyRouter)
+	r.Handle("/customers/{id}/loyalty/", loyaltyRouter)
 
 	return r
 }
@@ -117,29 +735,158 @@ func (h *handlers) handleCharge(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Start the Charge workflow.
-	// If the workflow is already running, or is finished but still within retention period, this will return the existing workflow.
+	id := ChargeWorkflowID(input)
+
+	// Start the Charge workflow and wait for the payment to be authorized.
+	// If the workflow is already running this waits on the existing workflow.
 	// If an idempotency key was provided, this provides idempotency guarantees for the Charge operation.
-	wf, err := h.temporal.ExecuteWorkflow(context.Background(),
+	start := h.temporal.NewWithStartWorkflowOperation(
 		client.StartWorkflowOptions{
-			TaskQueue:             TaskQueue,
-			ID:                    ChargeWorkflowID(input),
-			WorkflowIDReusePolicy: enums.WORKFLOW_ID_REUSE_POLICY_REJECT_DUPLICATE,
+			TaskQueue:                TaskQueue,
+			ID:                       id,
+			WorkflowIDConflictPolicy: enums.WORKFLOW_ID_CONFLICT_POLICY_USE_EXISTING,
+			WorkflowIDReusePolicy:    enums.WORKFLOW_ID_REUSE_POLICY_REJECT_DUPLICATE,
 		},
 		Charge,
 		&input,
 	)
+
+	update, err := h.temporal.UpdateWithStartWorkflow(r.Context(), client.UpdateWithStartWorkflowOptions{
+		StartWorkflowOperation: start,
+		UpdateOptions: client.UpdateWorkflowOptions{
+			UpdateName:   AuthorizeUpdateName,
+			WaitForStage: client.WorkflowUpdateStageCompleted,
+		},
+	})
 	if err != nil {
+		var alreadyStarted *serviceerror.WorkflowExecutionAlreadyStarted
+		if errors.As(err, &alreadyStarted) {
+			// The charge has already finished, so return its final result.
+			h.writeChargeResult(w, r, id, "")
+			return
+		}
 		h.logger.Error("Failed to start charge workflow", "error", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	var result ChargeResult
-	err = wf.Get(r.Context(), &result)
+	err = update.Get(r.Context(), &result)
+	if err != nil {
+		if invoiceRejected(err) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		h.logger.Error("Failed to get charge result", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		h.logger.Error("Failed to encode charge result", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h *handlers) handleCapture(w http.ResponseWriter, r *http.Request) {
+	var input CaptureInput
+
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		h.logger.Error("Failed to decode capture input", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.updateCharge(w, r, input.ChargeIdempotencyKey, CaptureUpdateName, ChargeStatusCaptured)
+}
+
+func (h *handlers) handleVoid(w http.ResponseWriter, r *http.Request) {
+	var input VoidInput
+
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		h.logger.Error("Failed to decode void input", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.updateCharge(w, r, input.ChargeIdempotencyKey, VoidUpdateName, ChargeStatusVoided)
+}
+
+// updateCharge sends an update to a Charge workflow, responding with the resulting charge.
+// If the charge has already finished with the desired status the final result is returned, so retries are safe.
+func (h *handlers) updateCharge(w http.ResponseWriter, r *http.Request, key string, updateName string, status string) {
+	if key == "" {
+		http.Error(w, "chargeIdempotencyKey is required", http.StatusBadRequest)
+		return
+	}
+
+	id := ChargeWorkflowID(ChargeInput{IdempotencyKey: key})
+
+	update, err := h.temporal.UpdateWorkflow(r.Context(), client.UpdateWorkflowOptions{
+		WorkflowID:   id,
+		UpdateName:   updateName,
+		WaitForStage: client.WorkflowUpdateStageCompleted,
+	})
+	if err != nil {
+		var notFound *serviceerror.NotFound
+		if errors.As(err, &notFound) {
+			h.writeChargeResult(w, r, id, status)
+			return
+		}
+		h.logger.Error("Failed to update charge", "update", updateName, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var result ChargeResult
+	err = update.Get(r.Context(), &result)
+	if err != nil {
+		var appErr *temporal.ApplicationError
+		if errors.As(err, &appErr) && appErr.Type() == chargeRejectedErrorType {
+			http.Error(w, appErr.Message(), http.StatusConflict)
+			return
+		}
+		h.logger.Error("Failed to get charge result", "update", updateName, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		h.logger.Error("Failed to encode charge result", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// writeChargeResult responds with the result of a finished Charge workflow.
+// If a status is given, it responds with a conflict if the charge did not finish with that status.
+func (h *handlers) writeChargeResult(w http.ResponseWriter, r *http.Request, id string, status string) {
+	var result ChargeResult
+
+	err := h.temporal.GetWorkflow(r.Context(), id, "").Get(r.Context(), &result)
 	if err != nil {
+		var notFound *serviceerror.NotFound
+		if errors.As(err, &notFound) {
+			http.Error(w, "charge not found", http.StatusNotFound)
+			return
+		}
+		if invoiceRejected(err) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
 		h.logger.Error("Failed to get charge result", "error", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if status != "" && result.Status != status {
+		http.Error(w, fmt.Sprintf("payment is %s", result.Status), http.StatusConflict)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -150,6 +897,120 @@ func (h *handlers) handleCharge(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// invoiceRejected reports whether a charge failed because its fulfillment could not be invoiced.
+func invoiceRejected(err error) bool {
+	var appErr *temporal.ApplicationError
+	return errors.As(err, &appErr) && appErr.Type() == invoiceRejectedErrorType
+}
+
+// RefundWorkflowID returns the workflow ID for the Refund workflow of a charge.
+func RefundWorkflowID(chargeIdempotencyKey string) string {
+	return fmt.Sprintf("Refund:%s", chargeIdempotencyKey)
+}
+
+func (h *handlers) handleRefund(w http.ResponseWriter, r *http.Request) {
+	var input RefundInput
+
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		h.logger.Error("Failed to decode refund input", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if input.ChargeIdempotencyKey == "" || input.InvoiceReference == "" {
+		http.Error(w, "chargeIdempotencyKey and invoiceReference are required", http.StatusBadRequest)
+		return
+	}
+
+	for _, item := range input.Items {
+		if item.SKU == "" || item.Quantity < 1 {
+			http.Error(w, "items must have a sku and a positive quantity", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// The customer's bank holds a disputed payment, so it cannot be refunded as well.
+	disputed, err := h.disputeStatus(r.Context(), input.InvoiceReference)
+	if err != nil {
+		h.logger.Error("Failed to get invoice disputes", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	switch disputed {
+	case DisputeStatusOpen:
+		http.Error(w, "invoice is frozen by an open dispute", http.StatusConflict)
+		return
+	case DisputeStatusLost:
+		http.Error(w, "invoice has been charged back", http.StatusConflict)
+		return
+	}
+
+	// As with charges, a missing idempotency key offers no idempotency guarantees.
+	if input.IdempotencyKey == "" {
+		input.IdempotencyKey = uuid.NewString()
+	}
+
+	// All refunds for a charge are handled by a single Refund workflow, which tracks how much has been refunded.
+	// The workflow is started by the first refund request, later requests are delivered to the running workflow.
+	// Once it has closed, the charge can no longer be refunded unless the workflow failed.
+	start := h.temporal.NewWithStartWorkflowOperation(
+		client.StartWorkflowOptions{
+			TaskQueue:                TaskQueue,
+			ID:                       RefundWorkflowID(input.ChargeIdempotencyKey),
+			WorkflowIDConflictPolicy: enums.WORKFLOW_ID_CONFLICT_POLICY_USE_EXISTING,
+			WorkflowIDReusePolicy:    enums.WORKFLOW_ID_REUSE_POLICY_ALLOW_DUPLICATE_FAILED_ONLY,
+		},
+		Refund,
+		&RefundWorkflowInput{
+			CustomerID:           input.CustomerID,
+			InvoiceReference:     input.InvoiceReference,
+			ChargeIdempotencyKey: input.ChargeIdempotencyKey,
+		},
+	)
+
+	// The idempotency key is used as the update ID, so a retried request is only refunded once.
+	update, err := h.temporal.UpdateWithStartWorkflow(r.Context(), client.UpdateWithStartWorkflowOptions{
+		StartWorkflowOperation: start,
+		UpdateOptions: client.UpdateWorkflowOptions{
+			UpdateID:     input.IdempotencyKey,
+			UpdateName:   RefundUpdateName,
+			WaitForStage: client.WorkflowUpdateStageCompleted,
+			Args:         []any{&RefundRequest{Items: input.Items}},
+		},
+	})
+	if err != nil {
+		var alreadyStarted *serviceerror.WorkflowExecutionAlreadyStarted
+		if errors.As(err, &alreadyStarted) {
+			http.Error(w, "charge can no longer be refunded", http.StatusConflict)
+			return
+		}
+		h.logger.Error("Failed to request refund", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var result RefundResult
+	err = update.Get(r.Context(), &result)
+	if err != nil {
+		var appErr *temporal.ApplicationError
+		if errors.As(err, &appErr) && appErr.Type() == refundRejectedErrorType {
+			http.Error(w, appErr.Message(), http.StatusConflict)
+			return
+		}
+		h.logger.Error("Failed to get refund result", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		h.logger.Error("Failed to encode refund result", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
 func (h *handlers) handleGetStats(w http.ResponseWriter, _ *http.Request) {
 	resp, err := h.temporal.DescribeTaskQueueEnhanced(context.Background(), client.DescribeTaskQueueEnhancedOptions{
 		TaskQueue:     TaskQueue,
@@ -1,11 +1,25 @@
 package billing_test
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
 	"regexp"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/temporalio/reference-app-orders-go/app/billing"
+	"github.com/temporalio/reference-app-orders-go/app/db"
+	"github.com/temporalio/reference-app-orders-go/app/tax"
 )
 
 func TestChargeWorkflowID(t *testing.T) {
@@ -21,3 +35,366 @@ func TestChargeWorkflowID(t *testing.T) {
 
 	assert.Regexp(t, regexp.MustCompile("Charge:[0-9a-f]+-[0-9a-f]+-[0-9a-f]+-[0-9a-f]+"), wfid)
 }
+
+func TestInvoices(t *testing.T) {
+	store := db.NewSQLiteDB(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, store.Connect(context.Background()))
+	require.NoError(t, store.Setup())
+	t.Cleanup(func() { store.Close() })
+
+	api := httptest.NewServer(billing.Router(nil, store, slog.Default()))
+	t.Cleanup(api.Close)
+
+	a := &billing.Activities{BillingURL: api.URL}
+	created := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	invoice := billing.Invoice{
+		InvoiceReference:     "order1:1",
+		CustomerID:           "customer1",
+		ChargeIdempotencyKey: "charge1",
+		Items: []billing.InvoiceItem{
+			{
+				SKU: "Nike Air", Quantity: 2, SubTotal: money(24000), Shipping: money(900), Tax: money(4800), Total: money(29700),
+				TaxDetail: &tax.LineTax{Region: "GB", TaxClass: "standard", Rate: 2000, Taxable: 24000, Amount: 4800},
+			},
+		},
+		SubTotal:     money(24000),
+		Shipping:     money(900),
+		Tax:          money(4800),
+		Total:        money(29700),
+		ShippingRule: "domestic-standard-2kg",
+		Status:       billing.ChargeStatusAuthorized,
+		AuthCode:     "1234",
+		CreatedAt:    created,
+		UpdatedAt:    created,
+	}
+	require.NoError(t, a.StoreInvoice(context.Background(), &invoice))
+
+	// Storing the invoice again updates it.
+	invoice.Status = billing.ChargeStatusCaptured
+	invoice.UpdatedAt = created.Add(time.Hour)
+	require.NoError(t, a.StoreInvoice(context.Background(), &invoice))
+
+	require.NoError(t, a.StoreInvoice(context.Background(), &billing.Invoice{
+		InvoiceReference: "order2:1",
+		CustomerID:       "customer2",
+		Status:           billing.ChargeStatusDeclined,
+		CreatedAt:        created.Add(time.Minute),
+		UpdatedAt:        created.Add(time.Minute),
+	}))
+
+	var got billing.Invoice
+	res, err := http.Get(api.URL + "/invoices/order1:1")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&got))
+	res.Body.Close()
+
+	got.CreatedAt = got.CreatedAt.UTC()
+	got.UpdatedAt = got.UpdatedAt.UTC()
+	require.Equal(t, invoice, got)
+
+	var list []billing.Invoice
+	res, err = http.Get(api.URL + "/invoices")
+	require.NoError(t, err)
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&list))
+	res.Body.Close()
+
+	require.Len(t, list, 2)
+	require.Equal(t, "order2:1", list[0].InvoiceReference)
+	require.Equal(t, "order1:1", list[1].InvoiceReference)
+	require.Empty(t, list[1].Items)
+
+	res, err = http.Get(api.URL + "/customers/customer1/invoices")
+	require.NoError(t, err)
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&list))
+	res.Body.Close()
+
+	require.Len(t, list, 1)
+	require.Equal(t, billing.ChargeStatusCaptured, list[0].Status)
+
+	res, err = http.Get(api.URL + "/invoices/unknown")
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestInvoiceDocuments(t *testing.T) {
+	store := db.NewSQLiteDB(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, store.Connect(context.Background()))
+	require.NoError(t, store.Setup())
+	t.Cleanup(func() { store.Close() })
+
+	api := httptest.NewServer(billing.Router(nil, store, slog.Default()))
+	t.Cleanup(api.Close)
+
+	a := &billing.Activities{BillingURL: api.URL}
+	created := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	invoice := billing.Invoice{
+		InvoiceReference: "order1:2",
+		CustomerID:       "customer1",
+		Items: []billing.InvoiceItem{
+			{SKU: "Nike Air", Quantity: 2, SubTotal: money(24000), Shipping: money(900), Tax: money(4800), Total: money(29700)},
+			{SKU: "Socks (3 pack)", Quantity: 1, SubTotal: money(999), Shipping: money(100), Tax: money(200), Total: money(1299)},
+		},
+		SubTotal:  money(24999),
+		Shipping:  money(1000),
+		Tax:       money(5000),
+		Total:     money(30999),
+		Status:    billing.ChargeStatusCaptured,
+		CreatedAt: created,
+		UpdatedAt: created,
+	}
+	require.NoError(t, a.StoreInvoice(context.Background(), &invoice))
+
+	get := func(path string) (*http.Response, []byte) {
+		res, err := http.Get(api.URL + path)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res, body
+	}
+
+	res, body := get("/invoices/order1:2/html")
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/html; charset=utf-8", res.Header.Get("Content-Type"))
+
+	html := string(body)
+	assert.Contains(t, html, "<dt>Order</dt><dd>order1</dd>")
+	assert.Contains(t, html, "<dt>Fulfillment</dt><dd>order1:2</dd>")
+	assert.Contains(t, html, "<dt>Date</dt><dd>1 June 2024</dd>")
+	assert.Contains(t, html, "<td>Nike Air</td>")
+	assert.Contains(t, html, "<td>Socks (3 pack)</td>")
+	assert.Contains(t, html, "<td class=\"amount\">249.99</td>")
+	assert.Contains(t, html, "<td class=\"amount\">309.99</td>")
+
+	res, body = get("/invoices/order1:2/pdf")
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "application/pdf", res.Header.Get("Content-Type"))
+	requireValidPDF(t, body)
+
+	pdf := string(body)
+	assert.Contains(t, pdf, "/Count 1")
+	assert.Contains(t, pdf, "(Order:       order1)")
+	assert.Contains(t, pdf, "Socks \\(3 pack\\)")
+	assert.Contains(t, pdf, "(Total: 309.99)")
+
+	// Invoices with many lines continue onto further pages.
+	invoice.InvoiceReference = "order1:3"
+	invoice.Items = nil
+	for i := 0; i < 100; i++ {
+		invoice.Items = append(invoice.Items, billing.InvoiceItem{SKU: fmt.Sprintf("SKU %d", i), Quantity: 1, SubTotal: money(100), Total: money(100)})
+	}
+	require.NoError(t, a.StoreInvoice(context.Background(), &invoice))
+
+	res, body = get("/invoices/order1:3/pdf")
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	requireValidPDF(t, body)
+	assert.Contains(t, string(body), "/Count 3")
+	assert.Contains(t, string(body), "SKU 99")
+
+	res, _ = get("/invoices/unknown/pdf")
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+	res, _ = get("/invoices/unknown/html")
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+// requireValidPDF checks the structure of a PDF document: the cross-reference table must point at each object.
+func requireValidPDF(t *testing.T, body []byte) {
+	pdf := string(body)
+	require.True(t, strings.HasPrefix(pdf, "%PDF-1.4\n"))
+	require.True(t, strings.HasSuffix(pdf, "%%EOF\n"))
+
+	m := regexp.MustCompile(`startxref\n(\d+)\n`).FindStringSubmatch(pdf)
+	require.NotNil(t, m)
+	xref, err := strconv.Atoi(m[1])
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(pdf[xref:], "xref\n"))
+
+	lines := strings.Split(pdf[xref:], "\n")
+	count, err := strconv.Atoi(strings.Fields(lines[1])[1])
+	require.NoError(t, err)
+	for i := 1; i < count; i++ {
+		offset, err := strconv.Atoi(strings.Fields(lines[2+i])[0])
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(pdf[offset:], fmt.Sprintf("%d 0 obj\n", i)), "object %d", i)
+	}
+}
+
+func TestDisputes(t *testing.T) {
+	store := db.NewSQLiteDB(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, store.Connect(context.Background()))
+	require.NoError(t, store.Setup())
+	t.Cleanup(func() { store.Close() })
+
+	api := httptest.NewServer(billing.Router(nil, store, slog.Default()))
+	t.Cleanup(api.Close)
+
+	a := &billing.Activities{BillingURL: api.URL}
+	opened := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	post := func(path string, body string) int {
+		res, err := http.Post(api.URL+path, "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		res.Body.Close()
+		return res.StatusCode
+	}
+	invoiceDisputeStatus := func() string {
+		var invoice billing.Invoice
+		res, err := http.Get(api.URL + "/invoices/order1:1")
+		require.NoError(t, err)
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&invoice))
+		res.Body.Close()
+		return invoice.DisputeStatus
+	}
+
+	require.NoError(t, a.StoreInvoice(context.Background(), &billing.Invoice{
+		InvoiceReference: "order1:1",
+		CustomerID:       "customer1",
+		Total:            money(1000),
+		Status:           billing.ChargeStatusCaptured,
+		CreatedAt:        opened,
+		UpdatedAt:        opened,
+	}))
+	require.NoError(t, a.StoreInvoice(context.Background(), &billing.Invoice{
+		InvoiceReference: "order2:1",
+		CustomerID:       "customer2",
+		Status:           billing.ChargeStatusAuthorized,
+		CreatedAt:        opened,
+		UpdatedAt:        opened,
+	}))
+
+	// The webhook checks the dispute against the invoice before starting the Dispute workflow.
+	assert.Equal(t, http.StatusBadRequest, post("/disputes", `{"invoiceReference":"order1:1"}`))
+	assert.Equal(t, http.StatusNotFound, post("/disputes", `{"id":"d1","invoiceReference":"unknown"}`))
+	assert.Equal(t, http.StatusConflict, post("/disputes", `{"id":"d1","invoiceReference":"order2:1"}`))
+	assert.Equal(t, http.StatusBadRequest, post("/disputes", `{"id":"d1","invoiceReference":"order1:1","amount":1001}`))
+	assert.Equal(t, http.StatusBadRequest, post("/disputes/d1/resolution", `{"outcome":"maybe"}`))
+
+	assert.Empty(t, invoiceDisputeStatus())
+
+	dispute := billing.DisputeStatus{
+		ID:               "d1",
+		InvoiceReference: "order1:1",
+		CustomerID:       "customer1",
+		Amount:           money(1000),
+		Reason:           "not received",
+		Status:           billing.DisputeStatusOpen,
+		RespondBy:        opened.Add(30 * 24 * time.Hour),
+		OpenedAt:         opened,
+	}
+	require.NoError(t, a.StoreDispute(context.Background(), &dispute))
+
+	// An open dispute freezes the invoice.
+	assert.Equal(t, billing.DisputeStatusOpen, invoiceDisputeStatus())
+	assert.Equal(t, http.StatusConflict, post("/refund", `{"customerId":"customer1","invoiceReference":"order1:1","chargeIdempotencyKey":"charge1"}`))
+
+	dispute.Evidence = &billing.DisputeEvidence{CollectedAt: opened.Add(time.Minute), ShipmentStatus: "delivered", ShipmentUpdatedAt: opened.Add(-time.Hour)}
+	dispute.Status = billing.DisputeStatusLost
+	dispute.ResolvedAt = opened.Add(48 * time.Hour)
+	require.NoError(t, a.StoreDispute(context.Background(), &dispute))
+
+	var got billing.DisputeStatus
+	res, err := http.Get(api.URL + "/disputes/d1")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&got))
+	res.Body.Close()
+
+	got.RespondBy = got.RespondBy.UTC()
+	got.OpenedAt = got.OpenedAt.UTC()
+	got.ResolvedAt = got.ResolvedAt.UTC()
+	got.Evidence.CollectedAt = got.Evidence.CollectedAt.UTC()
+	got.Evidence.ShipmentUpdatedAt = got.Evidence.ShipmentUpdatedAt.UTC()
+	assert.Equal(t, dispute, got)
+
+	// A lost dispute has already returned the money, so the invoice cannot be refunded either.
+	assert.Equal(t, billing.DisputeStatusLost, invoiceDisputeStatus())
+	assert.Equal(t, http.StatusConflict, post("/refund", `{"customerId":"customer1","invoiceReference":"order1:1","chargeIdempotencyKey":"charge1"}`))
+
+	var list []billing.DisputeStatus
+	res, err = http.Get(api.URL + "/disputes")
+	require.NoError(t, err)
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&list))
+	res.Body.Close()
+	require.Len(t, list, 1)
+
+	res, err = http.Get(api.URL + "/disputes/unknown")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestFraudReviews(t *testing.T) {
+	store := db.NewSQLiteDB(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, store.Connect(context.Background()))
+	require.NoError(t, store.Setup())
+	t.Cleanup(func() { store.Close() })
+
+	api := httptest.NewServer(billing.Router(nil, store, slog.Default()))
+	t.Cleanup(api.Close)
+
+	a := &billing.Activities{BillingURL: api.URL}
+	opened := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	post := func(path string, body string) int {
+		res, err := http.Post(api.URL+path, "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		res.Body.Close()
+		return res.StatusCode
+	}
+	list := func(query string) []billing.FraudReviewStatus {
+		var reviews []billing.FraudReviewStatus
+		res, err := http.Get(api.URL + "/fraud-reviews" + query)
+		require.NoError(t, err)
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&reviews))
+		res.Body.Close()
+		return reviews
+	}
+
+	pending := billing.FraudReviewStatus{
+		ID:               "charge1",
+		InvoiceReference: "order1:1",
+		CustomerID:       "customer1",
+		Amount:           money(4500),
+		Reason:           "limit",
+		Status:           billing.FraudReviewStatusPending,
+		ReviewBy:         opened.Add(24 * time.Hour),
+		OpenedAt:         opened,
+	}
+	require.NoError(t, a.StoreFraudReview(context.Background(), &pending))
+
+	approved := pending
+	approved.ID = "charge2"
+	approved.InvoiceReference = "order2:1"
+	approved.Status = billing.FraudReviewStatusApproved
+	approved.Reviewer = "manager"
+	approved.OpenedAt = opened.Add(-time.Hour)
+	approved.ResolvedAt = opened
+	require.NoError(t, a.StoreFraudReview(context.Background(), &approved))
+
+	all := list("")
+	if assert.Len(t, all, 2) {
+		assert.Equal(t, "charge2", all[0].ID)
+		assert.Equal(t, "manager", all[0].Reviewer)
+	}
+
+	queue := list("?status=pending")
+	if assert.Len(t, queue, 1) {
+		assert.Equal(t, "charge1", queue[0].ID)
+		assert.Equal(t, money(4500), queue[0].Amount)
+		assert.Equal(t, pending.ReviewBy, queue[0].ReviewBy.UTC())
+	}
+
+	// Decisions are checked before the FraudReview workflow is signalled.
+	assert.Equal(t, http.StatusBadRequest, post("/fraud-reviews/charge1/decision", `{"outcome":"maybe"}`))
+	assert.Equal(t, http.StatusNotFound, post("/fraud-reviews/unknown/decision", `{"outcome":"approved"}`))
+	assert.Equal(t, http.StatusConflict, post("/fraud-reviews/charge2/decision", `{"outcome":"rejected"}`))
+
+	res, err := http.Get(api.URL + "/fraud-reviews/unknown")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
@@ -0,0 +1,260 @@
+package billing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/temporalio/reference-app-orders-go/app/currency"
+	"github.com/temporalio/reference-app-orders-go/app/db"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+)
+
+// DisputeWorkflowID returns the workflow ID for the Dispute workflow of an issuer's dispute.
+func DisputeWorkflowID(id string) string {
+	return fmt.Sprintf("Dispute:%s", id)
+}
+
+// handleOpenDispute is the webhook called by the card issuer when a customer disputes a charge.
+// The issuer may call it more than once for the same dispute, so a dispute which is already open is left as it is.
+func (h *handlers) handleOpenDispute(w http.ResponseWriter, r *http.Request) {
+	var input DisputeInput
+
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		h.logger.Error("Failed to decode dispute input", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if input.ID == "" || input.InvoiceReference == "" {
+		http.Error(w, "id and invoiceReference are required", http.StatusBadRequest)
+		return
+	}
+
+	var invoice db.Invoice
+
+	err = h.db.GetInvoice(r.Context(), input.InvoiceReference, &invoice)
+	if err != nil {
+		if errors.Is(err, db.ErrInvoiceNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			h.logger.Error("Failed to get invoice", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if invoice.Status != ChargeStatusCaptured {
+		http.Error(w, fmt.Sprintf("payment is %s", invoice.Status), http.StatusConflict)
+		return
+	}
+	if input.Amount.Amount < 0 || input.Amount.Amount > invoice.Total {
+		http.Error(w, fmt.Sprintf("amount must be between 0 and the invoice total of %d", invoice.Total), http.StatusBadRequest)
+		return
+	}
+	if input.Amount.IsZero() {
+		input.Amount = currency.NewMoney(invoice.Total, invoice.Currency)
+	}
+	input.CustomerID = invoice.CustomerID
+	input.ExchangeRate = invoiceFromDB(invoice).ExchangeRate
+
+	_, err = h.temporal.ExecuteWorkflow(r.Context(),
+		client.StartWorkflowOptions{
+			TaskQueue:                TaskQueue,
+			ID:                       DisputeWorkflowID(input.ID),
+			WorkflowIDConflictPolicy: enums.WORKFLOW_ID_CONFLICT_POLICY_USE_EXISTING,
+			WorkflowIDReusePolicy:    enums.WORKFLOW_ID_REUSE_POLICY_REJECT_DUPLICATE,
+		},
+		Dispute,
+		&input,
+	)
+	if err != nil {
+		var alreadyStarted *serviceerror.WorkflowExecutionAlreadyStarted
+		if !errors.As(err, &alreadyStarted) {
+			h.logger.Error("Failed to start dispute workflow", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// The dispute has already been resolved.
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *handlers) handleListDisputes(w http.ResponseWriter, r *http.Request) {
+	disputes := []db.Dispute{}
+
+	err := h.db.GetDisputes(r.Context(), &disputes)
+	if err != nil {
+		h.logger.Error("Failed to list disputes", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	list := make([]DisputeStatus, len(disputes))
+	for i, d := range disputes {
+		list[i] = disputeFromDB(d)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		h.logger.Error("Failed to encode disputes", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h *handlers) handleGetDispute(w http.ResponseWriter, r *http.Request) {
+	var dispute db.Dispute
+
+	err := h.db.GetDispute(r.Context(), r.PathValue("id"), &dispute)
+	if err != nil {
+		if errors.Is(err, db.ErrDisputeNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			h.logger.Error("Failed to get dispute", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(disputeFromDB(dispute)); err != nil {
+		h.logger.Error("Failed to encode dispute", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h *handlers) handleStoreDispute(w http.ResponseWriter, r *http.Request) {
+	var dispute DisputeStatus
+
+	err := json.NewDecoder(r.Body).Decode(&dispute)
+	if err != nil {
+		h.logger.Error("Failed to decode dispute", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if dispute.ID != r.PathValue("id") {
+		http.Error(w, "dispute ID does not match the path", http.StatusBadRequest)
+		return
+	}
+
+	err = h.db.UpsertDispute(r.Context(), disputeToDB(dispute))
+	if err != nil {
+		h.logger.Error("Failed to store dispute", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleResolveDispute is the webhook called by the card issuer when it has decided a dispute.
+func (h *handlers) handleResolveDispute(w http.ResponseWriter, r *http.Request) {
+	var resolution DisputeResolution
+
+	err := json.NewDecoder(r.Body).Decode(&resolution)
+	if err != nil {
+		h.logger.Error("Failed to decode dispute resolution", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if resolution.Outcome != DisputeStatusWon && resolution.Outcome != DisputeStatusLost {
+		http.Error(w, fmt.Sprintf("outcome must be %q or %q", DisputeStatusWon, DisputeStatusLost), http.StatusBadRequest)
+		return
+	}
+
+	err = h.temporal.SignalWorkflow(r.Context(),
+		DisputeWorkflowID(r.PathValue("id")), "",
+		DisputeResolutionSignalName,
+		resolution,
+	)
+	if err != nil {
+		var notFound *serviceerror.NotFound
+		if errors.As(err, &notFound) {
+			http.Error(w, "no open dispute with this ID", http.StatusNotFound)
+			return
+		}
+		h.logger.Error("Failed to signal dispute workflow", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// disputeStatus returns the dispute status of an invoice, as for Invoice, or empty if it has never been disputed.
+func (h *handlers) disputeStatus(ctx context.Context, reference string) (string, error) {
+	var disputes []db.Dispute
+
+	if err := h.db.GetInvoiceDisputes(ctx, reference, &disputes); err != nil {
+		return "", err
+	}
+
+	status := ""
+	for _, d := range disputes {
+		switch d.Status {
+		case DisputeStatusOpen, DisputeStatusLost:
+			return d.Status, nil
+		}
+		if status == "" {
+			status = d.Status
+		}
+	}
+
+	return status, nil
+}
+
+func disputeToDB(dispute DisputeStatus) *db.Dispute {
+	result := &db.Dispute{
+		ID:               dispute.ID,
+		InvoiceReference: dispute.InvoiceReference,
+		CustomerID:       dispute.CustomerID,
+		Amount:           dispute.Amount.Amount,
+		Reason:           dispute.Reason,
+		Status:           dispute.Status,
+		RespondBy:        dispute.RespondBy.UTC(),
+		OpenedAt:         dispute.OpenedAt.UTC(),
+		ResolvedAt:       dispute.ResolvedAt.UTC(),
+	}
+
+	if dispute.Evidence != nil {
+		result.EvidenceCollectedAt = dispute.Evidence.CollectedAt.UTC()
+		result.ShipmentStatus = dispute.Evidence.ShipmentStatus
+		result.ShipmentUpdatedAt = dispute.Evidence.ShipmentUpdatedAt.UTC()
+	}
+
+	return result
+}
+
+func disputeFromDB(dispute db.Dispute) DisputeStatus {
+	result := DisputeStatus{
+		ID:               dispute.ID,
+		InvoiceReference: dispute.InvoiceReference,
+		CustomerID:       dispute.CustomerID,
+		Amount:           currency.Money{Amount: dispute.Amount},
+		Reason:           dispute.Reason,
+		Status:           dispute.Status,
+		RespondBy:        dispute.RespondBy,
+		OpenedAt:         dispute.OpenedAt,
+		ResolvedAt:       dispute.ResolvedAt,
+	}
+
+	if !dispute.EvidenceCollectedAt.IsZero() {
+		result.Evidence = &DisputeEvidence{
+			CollectedAt:       dispute.EvidenceCollectedAt,
+			ShipmentStatus:    dispute.ShipmentStatus,
+			ShipmentUpdatedAt: dispute.ShipmentUpdatedAt,
+		}
+	}
+
+	return result
+}
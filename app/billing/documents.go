@@ -0,0 +1,148 @@
+package billing
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/temporalio/reference-app-orders-go/app/currency"
+)
+
+//go:embed invoice.html
+var invoiceHTML string
+
+var invoiceTemplate = template.Must(template.New("invoice").Funcs(template.FuncMap{"money": formatMoney, "describe": describeItem, "quantity": quantity}).Parse(invoiceHTML))
+
+// invoiceDocument is the content of a printable invoice.
+type invoiceDocument struct {
+	Invoice
+
+	OrderReference string
+	FulfillmentID  string
+	Date           string
+}
+
+func newInvoiceDocument(invoice Invoice) invoiceDocument {
+	return invoiceDocument{
+		Invoice:        invoice,
+		OrderReference: orderReference(invoice.InvoiceReference),
+		FulfillmentID:  invoice.InvoiceReference,
+		Date:           invoice.CreatedAt.UTC().Format("2 January 2006"),
+	}
+}
+
+// orderReference returns the ID of the order an invoice belongs to.
+// Invoices are raised per fulfillment, and fulfillment IDs are the order ID followed by the fulfillment number.
+func orderReference(invoiceReference string) string {
+	if i := strings.LastIndex(invoiceReference, ":"); i > 0 {
+		return invoiceReference[:i]
+	}
+	return invoiceReference
+}
+
+// formatMoney formats an amount in cents. The currency is shown once on the document rather than on every amount.
+func formatMoney(m currency.Money) string {
+	return currency.Money{Amount: m.Amount}.String()
+}
+
+// describeItem returns the name of an invoice line: the SKU of a product, or the coupon code of a discount.
+func describeItem(item InvoiceItem) string {
+	if item.Promotion != "" {
+		return fmt.Sprintf("%s (%s)", item.Promotion, item.SKU)
+	}
+	return item.SKU
+}
+
+// quantity returns the quantity shown for an invoice line. Discount lines have none.
+func quantity(item InvoiceItem) string {
+	if item.Promotion != "" {
+		return ""
+	}
+	return fmt.Sprint(item.Quantity)
+}
+
+// renderInvoiceHTML writes an invoice as an HTML document.
+func renderInvoiceHTML(w io.Writer, invoice Invoice) error {
+	return invoiceTemplate.Execute(w, newInvoiceDocument(invoice))
+}
+
+// renderInvoicePDF returns an invoice as a PDF document.
+func renderInvoicePDF(invoice Invoice) []byte {
+	doc := newInvoiceDocument(invoice)
+	pdf := newPDFDocument()
+
+	pdf.line(pdfHelveticaBold, 22, "Invoice")
+	pdf.space(8)
+	for _, field := range [][2]string{
+		{"Invoice", doc.InvoiceReference},
+		{"Order", doc.OrderReference},
+		{"Fulfillment", doc.FulfillmentID},
+		{"Customer", doc.CustomerID},
+		{"Date", doc.Date},
+		{"Payment", doc.Status},
+		{"Currency", doc.Currency},
+	} {
+		if field[1] == "" {
+			continue
+		}
+		pdf.line(pdfHelvetica, 11, fmt.Sprintf("%-12s %s", field[0]+":", field[1]))
+	}
+	pdf.space(12)
+
+	// The item table uses a fixed width font so the columns line up.
+	row := "%-22.22s %5s %10s %9s %9s %10s"
+	pdf.line(pdfCourier, 9, fmt.Sprintf(row, "SKU", "Qty", "Subtotal", "Shipping", "Tax", "Total"))
+	pdf.line(pdfCourier, 9, strings.Repeat("-", 70))
+	for _, item := range doc.Items {
+		pdf.line(pdfCourier, 9, fmt.Sprintf(row,
+			describeItem(item),
+			quantity(item),
+			formatMoney(item.SubTotal),
+			formatMoney(item.Shipping),
+			formatMoney(item.Tax),
+			formatMoney(item.Total),
+		))
+	}
+	pdf.line(pdfCourier, 9, strings.Repeat("-", 70))
+
+	total := "%-59s %10s"
+	pdf.line(pdfCourier, 9, fmt.Sprintf(total, "Subtotal", formatMoney(doc.SubTotal)))
+	pdf.line(pdfCourier, 9, fmt.Sprintf(total, "Shipping", formatMoney(doc.Shipping)))
+	pdf.line(pdfCourier, 9, fmt.Sprintf(total, "Tax", formatMoney(doc.Tax)))
+	pdf.space(4)
+	pdf.line(pdfHelveticaBold, 12, fmt.Sprintf("Total: %s", formatMoney(doc.Total)))
+
+	return pdf.bytes()
+}
+
+func (h *handlers) handleGetInvoiceHTML(w http.ResponseWriter, r *http.Request) {
+	invoice, ok := h.getInvoice(w, r)
+	if !ok {
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := renderInvoiceHTML(&buf, invoice); err != nil {
+		h.logger.Error("Failed to render invoice", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+func (h *handlers) handleGetInvoicePDF(w http.ResponseWriter, r *http.Request) {
+	invoice, ok := h.getInvoice(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", "invoice-"+strings.ReplaceAll(invoice.InvoiceReference, ":", "-")+".pdf"))
+	w.Write(renderInvoicePDF(invoice))
+}
@@ -0,0 +1,355 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/temporalio/reference-app-orders-go/app/config"
+	"github.com/temporalio/reference-app-orders-go/app/currency"
+	"github.com/temporalio/reference-app-orders-go/app/payment"
+)
+
+const (
+	// DeclineReasonFraud is the decline reason for a charge which failed the fraud check.
+	DeclineReasonFraud = "fraud"
+
+	// DeclineReasonFraudMaintenance is the decline reason for a charge made while the fraud check was down for
+	// maintenance, when Billing is configured to decline such charges rather than queue them.
+	DeclineReasonFraudMaintenance = "fraudMaintenance"
+
+	// DeclineReasonPaymentMethodNotFound is the decline reason for a charge against an unknown payment method,
+	// or one belonging to another customer.
+	DeclineReasonPaymentMethodNotFound = "paymentMethodNotFound"
+
+	// DeclineReasonExpired is the decline reason for a charge against an expired card.
+	DeclineReasonExpired = "expiredCard"
+
+	// DeclineReasonInsufficientFunds is the decline reason for a charge the customer cannot cover.
+	DeclineReasonInsufficientFunds = "insufficientFunds"
+
+	// DeclineReasonDeclined is the decline reason for a charge the gateway refused without saying why.
+	DeclineReasonDeclined = "declined"
+)
+
+// ErrGatewayRejected is returned by a PaymentGateway for a request which will never succeed, such as capturing a
+// voided authorization. Other errors are assumed to be temporary, and the request may be retried.
+var ErrGatewayRejected = errors.New("payment gateway rejected the request")
+
+// ErrGatewayTimeout is returned by a PaymentGateway which did not respond in time.
+// The request may or may not have been carried out, so it should be retried with the same idempotency key.
+var ErrGatewayTimeout = errors.New("payment gateway timed out")
+
+// PaymentGateway moves money between customers and the business.
+// Retrying a request, identified by its idempotency key or authorization code, must not move money twice.
+type PaymentGateway interface {
+	// Authorize places a hold on funds from a payment method. A declined authorization is not an error.
+	Authorize(ctx context.Context, request *GatewayAuthorization) (*Authorization, error)
+	// Capture takes some or all of the funds held by an authorization.
+	Capture(ctx context.Context, authCode string, amount currency.Money) error
+	// Void releases the funds held by an authorization which has not been captured.
+	Void(ctx context.Context, authCode string) error
+	// Refund returns captured funds to the customer, returning the refund's reference.
+	Refund(ctx context.Context, request *GatewayRefund) (string, error)
+}
+
+// GatewayAuthorization is a request to authorize a payment.
+type GatewayAuthorization struct {
+	IdempotencyKey string
+	CustomerID     string
+	Reference      string
+	// Method is the payment method to charge, or nil if the customer has no stored payment method.
+	Method *payment.Method
+	Amount currency.Money
+}
+
+// Authorization is a payment gateway's response to an authorization request.
+type Authorization struct {
+	Approved bool
+	AuthCode string
+	// DeclineReason is why the authorization was not approved.
+	DeclineReason string
+}
+
+// GatewayRefund is a request to refund a captured payment.
+type GatewayRefund struct {
+	IdempotencyKey string
+	CustomerID     string
+	Reference      string
+	// AuthCode is the authorization code of the captured payment.
+	AuthCode string
+	Amount   currency.Money
+}
+
+// NewPaymentGateway returns the payment gateway chosen by the configuration.
+func NewPaymentGateway(config config.AppConfig) (PaymentGateway, error) {
+	switch config.PaymentGateway {
+	case "", "simulator":
+		return &SimulatedGateway{
+			Latency:     config.PaymentGatewayLatency,
+			DeclineRate: config.PaymentGatewayDeclineRate,
+			TimeoutRate: config.PaymentGatewayTimeoutRate,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown payment gateway: %s", config.PaymentGateway)
+	}
+}
+
+const (
+	// SimulatedTokenInsufficientFunds is a token which the simulated gateway always declines for insufficient funds.
+	SimulatedTokenInsufficientFunds = "tok_insufficient_funds"
+
+	// SimulatedTokenDeclined is a token which the simulated gateway always declines without a reason.
+	SimulatedTokenDeclined = "tok_declined"
+)
+
+// SimulatedGateway is an in-process PaymentGateway which stands in for a real gateway in development and tests.
+// It declines expired cards and the SimulatedToken* tokens, and approves everything else, including customers
+// without a stored payment method. It can also be made slow and unreliable, to exercise retries.
+//
+// The simulator remembers what it has done for as long as it runs, which it uses to detect duplicate requests.
+// It cannot check requests about authorizations it did not make, such as those made before a restart, and accepts them.
+// The zero value is ready to use.
+type SimulatedGateway struct {
+	// Latency is how long each request takes.
+	Latency time.Duration
+	// DeclineRate is the fraction of otherwise valid authorizations which are declined.
+	DeclineRate float64
+	// TimeoutRate is the fraction of requests which are carried out, but return ErrGatewayTimeout.
+	TimeoutRate float64
+	// Now returns the current time, used to check card expiry. Defaults to time.Now.
+	Now func() time.Time
+
+	mu             sync.Mutex
+	authorizations map[string]*simulatedAuthorization
+	authorizeKeys  map[string]*simulatedAuthorizeRequest
+	refundKeys     map[string]*simulatedRefundRequest
+}
+
+type simulatedAuthorization struct {
+	currency string
+	amount   int64
+	captured int64
+	refunded int64
+	voided   bool
+}
+
+type simulatedAuthorizeRequest struct {
+	request GatewayAuthorization
+	result  Authorization
+}
+
+type simulatedRefundRequest struct {
+	request   GatewayRefund
+	reference string
+}
+
+// Authorize implements PaymentGateway.
+func (g *SimulatedGateway) Authorize(ctx context.Context, request *GatewayAuthorization) (*Authorization, error) {
+	if err := g.wait(ctx); err != nil {
+		return nil, err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.init()
+
+	if previous, ok := g.authorizeKeys[request.IdempotencyKey]; ok && request.IdempotencyKey != "" {
+		if !sameAuthorization(previous.request, *request) {
+			return nil, fmt.Errorf("%w: idempotency key %s was used for a different authorization", ErrGatewayRejected, request.IdempotencyKey)
+		}
+		result := previous.result
+		return &result, g.timeout()
+	}
+
+	if request.Amount.Amount < 0 {
+		return nil, fmt.Errorf("%w: cannot authorize a negative amount: %s", ErrGatewayRejected, request.Amount)
+	}
+
+	result := Authorization{DeclineReason: g.declineReason(request.Method)}
+	if result.DeclineReason == "" {
+		result.Approved = true
+		result.AuthCode = g.newAuthCode()
+		g.authorizations[result.AuthCode] = &simulatedAuthorization{currency: request.Amount.Currency, amount: request.Amount.Amount}
+	}
+
+	if request.IdempotencyKey != "" {
+		g.authorizeKeys[request.IdempotencyKey] = &simulatedAuthorizeRequest{request: *request, result: result}
+	}
+
+	return &result, g.timeout()
+}
+
+// Capture implements PaymentGateway.
+func (g *SimulatedGateway) Capture(ctx context.Context, authCode string, amount currency.Money) error {
+	if err := g.wait(ctx); err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.init()
+
+	if auth, ok := g.authorizations[authCode]; ok {
+		switch {
+		case auth.voided:
+			return fmt.Errorf("%w: authorization %s has been voided", ErrGatewayRejected, authCode)
+		case amount.Currency != "" && amount.Currency != auth.currency:
+			return fmt.Errorf("%w: cannot capture %s from an authorization in %s", ErrGatewayRejected, amount, auth.currency)
+		case auth.captured != 0:
+			if auth.captured != amount.Amount {
+				return fmt.Errorf("%w: authorization %s has already been captured for %d", ErrGatewayRejected, authCode, auth.captured)
+			}
+		case amount.Amount > auth.amount:
+			return fmt.Errorf("%w: cannot capture %d from an authorization for %d", ErrGatewayRejected, amount.Amount, auth.amount)
+		default:
+			auth.captured = amount.Amount
+		}
+	}
+
+	return g.timeout()
+}
+
+// Void implements PaymentGateway.
+func (g *SimulatedGateway) Void(ctx context.Context, authCode string) error {
+	if err := g.wait(ctx); err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.init()
+
+	if auth, ok := g.authorizations[authCode]; ok {
+		if auth.captured != 0 {
+			return fmt.Errorf("%w: authorization %s has been captured", ErrGatewayRejected, authCode)
+		}
+		auth.voided = true
+	}
+
+	return g.timeout()
+}
+
+// Refund implements PaymentGateway.
+func (g *SimulatedGateway) Refund(ctx context.Context, request *GatewayRefund) (string, error) {
+	if err := g.wait(ctx); err != nil {
+		return "", err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.init()
+
+	if previous, ok := g.refundKeys[request.IdempotencyKey]; ok && request.IdempotencyKey != "" {
+		if previous.request != *request {
+			return "", fmt.Errorf("%w: idempotency key %s was used for a different refund", ErrGatewayRejected, request.IdempotencyKey)
+		}
+		return previous.reference, g.timeout()
+	}
+
+	if request.Amount.Amount < 0 {
+		return "", fmt.Errorf("%w: cannot refund a negative amount: %s", ErrGatewayRejected, request.Amount)
+	}
+
+	if auth, ok := g.authorizations[request.AuthCode]; ok {
+		if request.Amount.Amount > auth.captured-auth.refunded {
+			return "", fmt.Errorf("%w: cannot refund %d of the %d remaining on authorization %s", ErrGatewayRejected, request.Amount.Amount, auth.captured-auth.refunded, request.AuthCode)
+		}
+		auth.refunded += request.Amount.Amount
+	}
+
+	reference := g.newAuthCode()
+	if request.IdempotencyKey != "" {
+		g.refundKeys[request.IdempotencyKey] = &simulatedRefundRequest{request: *request, reference: reference}
+	}
+
+	return reference, g.timeout()
+}
+
+func (g *SimulatedGateway) init() {
+	if g.authorizations == nil {
+		g.authorizations = make(map[string]*simulatedAuthorization)
+		g.authorizeKeys = make(map[string]*simulatedAuthorizeRequest)
+		g.refundKeys = make(map[string]*simulatedRefundRequest)
+	}
+}
+
+// wait simulates the time taken to reach the gateway.
+func (g *SimulatedGateway) wait(ctx context.Context) error {
+	if g.Latency <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(g.Latency)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// timeout returns ErrGatewayTimeout for the configured fraction of requests.
+func (g *SimulatedGateway) timeout() error {
+	if g.TimeoutRate > 0 && rand.Float64() < g.TimeoutRate {
+		return ErrGatewayTimeout
+	}
+
+	return nil
+}
+
+func (g *SimulatedGateway) declineReason(method *payment.Method) string {
+	now := time.Now
+	if g.Now != nil {
+		now = g.Now
+	}
+
+	if method != nil {
+		if method.Expired(now()) {
+			return DeclineReasonExpired
+		}
+
+		switch method.Token {
+		case SimulatedTokenInsufficientFunds:
+			return DeclineReasonInsufficientFunds
+		case SimulatedTokenDeclined:
+			return DeclineReasonDeclined
+		}
+	}
+
+	if g.DeclineRate > 0 && rand.Float64() < g.DeclineRate {
+		return DeclineReasonDeclined
+	}
+
+	return ""
+}
+
+// newAuthCode returns a six digit code which has not been used before.
+func (g *SimulatedGateway) newAuthCode() string {
+	for {
+		code := fmt.Sprintf("%06d", rand.IntN(1000000))
+		if _, ok := g.authorizations[code]; !ok {
+			return code
+		}
+	}
+}
+
+func sameAuthorization(a GatewayAuthorization, b GatewayAuthorization) bool {
+	methodID := func(m *payment.Method) string {
+		if m == nil {
+			return ""
+		}
+		return m.ID
+	}
+
+	return a.CustomerID == b.CustomerID && a.Reference == b.Reference && a.Amount == b.Amount && methodID(a.Method) == methodID(b.Method)
+}
@@ -0,0 +1,158 @@
+package billing_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/temporalio/reference-app-orders-go/app/billing"
+	"github.com/temporalio/reference-app-orders-go/app/config"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+)
+
+func TestSimulatedGatewayDetectsDuplicates(t *testing.T) {
+	ctx := context.Background()
+	g := &billing.SimulatedGateway{TimeoutRate: 1}
+
+	request := &billing.GatewayAuthorization{IdempotencyKey: "charge1", CustomerID: "customer1", Reference: "order1:1", Amount: money(1000)}
+
+	// The gateway authorizes the payment but the response is lost.
+	_, err := g.Authorize(ctx, request)
+	require.ErrorIs(t, err, billing.ErrGatewayTimeout)
+
+	// Retrying with the same key returns the original authorization rather than authorizing again.
+	g.TimeoutRate = 0
+	first, err := g.Authorize(ctx, request)
+	require.NoError(t, err)
+	require.True(t, first.Approved)
+
+	again, err := g.Authorize(ctx, request)
+	require.NoError(t, err)
+	assert.Equal(t, first, again)
+
+	_, err = g.Authorize(ctx, &billing.GatewayAuthorization{IdempotencyKey: "charge1", CustomerID: "customer1", Reference: "order1:1", Amount: money(2000)})
+	assert.ErrorIs(t, err, billing.ErrGatewayRejected)
+
+	other, err := g.Authorize(ctx, &billing.GatewayAuthorization{IdempotencyKey: "charge2", CustomerID: "customer1", Reference: "order1:2", Amount: money(1000)})
+	require.NoError(t, err)
+	assert.NotEqual(t, first.AuthCode, other.AuthCode)
+
+	// Capturing twice only takes the funds once.
+	require.NoError(t, g.Capture(ctx, first.AuthCode, money(1000)))
+	require.NoError(t, g.Capture(ctx, first.AuthCode, money(1000)))
+	assert.ErrorIs(t, g.Void(ctx, first.AuthCode), billing.ErrGatewayRejected)
+
+	refund := &billing.GatewayRefund{IdempotencyKey: "refund1", CustomerID: "customer1", Reference: "order1:1", AuthCode: first.AuthCode, Amount: money(600)}
+	ref, err := g.Refund(ctx, refund)
+	require.NoError(t, err)
+
+	again2, err := g.Refund(ctx, refund)
+	require.NoError(t, err)
+	assert.Equal(t, ref, again2)
+
+	// Only 400 remains to be refunded, as the retried refund was not paid out twice.
+	_, err = g.Refund(ctx, &billing.GatewayRefund{IdempotencyKey: "refund2", CustomerID: "customer1", Reference: "order1:1", AuthCode: first.AuthCode, Amount: money(500)})
+	assert.ErrorIs(t, err, billing.ErrGatewayRejected)
+
+	_, err = g.Refund(ctx, &billing.GatewayRefund{IdempotencyKey: "refund2", CustomerID: "customer1", Reference: "order1:1", AuthCode: first.AuthCode, Amount: money(400)})
+	assert.NoError(t, err)
+}
+
+func TestSimulatedGatewaySettlement(t *testing.T) {
+	ctx := context.Background()
+	g := &billing.SimulatedGateway{}
+
+	auth, err := g.Authorize(ctx, &billing.GatewayAuthorization{IdempotencyKey: "charge1", CustomerID: "customer1", Amount: money(1000)})
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, g.Capture(ctx, auth.AuthCode, money(1001)), billing.ErrGatewayRejected)
+
+	require.NoError(t, g.Void(ctx, auth.AuthCode))
+	require.NoError(t, g.Void(ctx, auth.AuthCode))
+	assert.ErrorIs(t, g.Capture(ctx, auth.AuthCode, money(1000)), billing.ErrGatewayRejected)
+
+	_, err = g.Refund(ctx, &billing.GatewayRefund{IdempotencyKey: "refund1", AuthCode: auth.AuthCode, Amount: money(1)})
+	assert.ErrorIs(t, err, billing.ErrGatewayRejected)
+
+	// Authorizations the gateway does not know about, such as those from before a restart, are accepted.
+	assert.NoError(t, g.Capture(ctx, "999999", money(1000)))
+}
+
+func TestSimulatedGatewayDeclineRate(t *testing.T) {
+	ctx := context.Background()
+
+	g := &billing.SimulatedGateway{DeclineRate: 1}
+	auth, err := g.Authorize(ctx, &billing.GatewayAuthorization{IdempotencyKey: "charge1", Amount: money(1000)})
+	require.NoError(t, err)
+	assert.False(t, auth.Approved)
+	assert.Equal(t, billing.DeclineReasonDeclined, auth.DeclineReason)
+	assert.Empty(t, auth.AuthCode)
+
+	g = &billing.SimulatedGateway{}
+	for i := 0; i < 20; i++ {
+		auth, err := g.Authorize(ctx, &billing.GatewayAuthorization{Amount: money(1000)})
+		require.NoError(t, err)
+		assert.True(t, auth.Approved)
+	}
+}
+
+func TestSimulatedGatewayLatency(t *testing.T) {
+	g := &billing.SimulatedGateway{Latency: time.Hour}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := g.Authorize(ctx, &billing.GatewayAuthorization{IdempotencyKey: "charge1", Amount: money(1000)})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// The request never reached the gateway.
+	g.Latency = 0
+	auth, err := g.Authorize(context.Background(), &billing.GatewayAuthorization{IdempotencyKey: "charge1", Amount: money(2000)})
+	require.NoError(t, err)
+	assert.True(t, auth.Approved)
+}
+
+func TestNewPaymentGateway(t *testing.T) {
+	g, err := billing.NewPaymentGateway(config.AppConfig{PaymentGatewayLatency: time.Second, PaymentGatewayDeclineRate: 0.1, PaymentGatewayTimeoutRate: 0.2})
+	require.NoError(t, err)
+
+	simulator, ok := g.(*billing.SimulatedGateway)
+	require.True(t, ok)
+	assert.Equal(t, time.Second, simulator.Latency)
+	assert.Equal(t, 0.1, simulator.DeclineRate)
+	assert.Equal(t, 0.2, simulator.TimeoutRate)
+
+	_, err = billing.NewPaymentGateway(config.AppConfig{PaymentGateway: "acme"})
+	assert.Error(t, err)
+}
+
+func TestGatewayRejectionsAreNotRetried(t *testing.T) {
+	testSuite := testsuite.WorkflowTestSuite{}
+	g := &billing.SimulatedGateway{}
+	a := &billing.Activities{Gateway: g}
+
+	auth, err := g.Authorize(context.Background(), &billing.GatewayAuthorization{IdempotencyKey: "charge1", Amount: money(1000)})
+	require.NoError(t, err)
+	require.NoError(t, g.Void(context.Background(), auth.AuthCode))
+
+	env := testSuite.NewTestActivityEnvironment()
+	env.RegisterActivity(a.CapturePayment)
+
+	_, err = env.ExecuteActivity(a.CapturePayment, &billing.CapturePaymentInput{AuthCode: auth.AuthCode, Charge: money(1000)})
+	var appErr *temporal.ApplicationError
+	require.True(t, errors.As(err, &appErr), "error %v", err)
+	assert.True(t, appErr.NonRetryable())
+
+	// A timeout is left for Temporal to retry.
+	g.TimeoutRate = 1
+	env = testSuite.NewTestActivityEnvironment()
+	env.RegisterActivity(a.VoidAuthorization)
+
+	_, err = env.ExecuteActivity(a.VoidAuthorization, &billing.VoidAuthorizationInput{AuthCode: "123456"})
+	require.True(t, errors.As(err, &appErr), "error %v", err)
+	assert.False(t, appErr.NonRetryable())
+}
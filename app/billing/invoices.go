@@ -0,0 +1,211 @@
+package billing
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/temporalio/reference-app-orders-go/app/currency"
+	"github.com/temporalio/reference-app-orders-go/app/db"
+	"github.com/temporalio/reference-app-orders-go/app/tax"
+)
+
+func (h *handlers) handleListInvoices(w http.ResponseWriter, r *http.Request) {
+	invoices := []db.Invoice{}
+
+	err := h.db.GetInvoices(r.Context(), &invoices)
+	if err != nil {
+		h.logger.Error("Failed to list invoices", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.writeInvoices(w, invoices)
+}
+
+func (h *handlers) handleListCustomerInvoices(w http.ResponseWriter, r *http.Request) {
+	invoices := []db.Invoice{}
+
+	err := h.db.GetCustomerInvoices(r.Context(), r.PathValue("id"), &invoices)
+	if err != nil {
+		h.logger.Error("Failed to list customer invoices", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.writeInvoices(w, invoices)
+}
+
+func (h *handlers) writeInvoices(w http.ResponseWriter, invoices []db.Invoice) {
+	list := make([]Invoice, len(invoices))
+	for i, inv := range invoices {
+		list[i] = invoiceFromDB(inv)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		h.logger.Error("Failed to encode invoices", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// getInvoice loads the invoice named in the request path, writing an error response if it cannot be loaded.
+func (h *handlers) getInvoice(w http.ResponseWriter, r *http.Request) (Invoice, bool) {
+	var invoice db.Invoice
+
+	err := h.db.GetInvoice(r.Context(), r.PathValue("ref"), &invoice)
+	if err != nil {
+		if errors.Is(err, db.ErrInvoiceNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			h.logger.Error("Failed to get invoice", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return Invoice{}, false
+	}
+
+	result := invoiceFromDB(invoice)
+
+	result.DisputeStatus, err = h.disputeStatus(r.Context(), invoice.Reference)
+	if err != nil {
+		h.logger.Error("Failed to get invoice disputes", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return Invoice{}, false
+	}
+
+	return result, true
+}
+
+func (h *handlers) handleGetInvoice(w http.ResponseWriter, r *http.Request) {
+	invoice, ok := h.getInvoice(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(invoice); err != nil {
+		h.logger.Error("Failed to encode invoice", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h *handlers) handleStoreInvoice(w http.ResponseWriter, r *http.Request) {
+	var invoice Invoice
+
+	err := json.NewDecoder(r.Body).Decode(&invoice)
+	if err != nil {
+		h.logger.Error("Failed to decode invoice", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if invoice.InvoiceReference != r.PathValue("ref") {
+		http.Error(w, "invoice reference does not match the path", http.StatusBadRequest)
+		return
+	}
+
+	err = h.db.UpsertInvoice(r.Context(), invoiceToDB(invoice))
+	if err != nil {
+		h.logger.Error("Failed to store invoice", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func invoiceToDB(invoice Invoice) *db.Invoice {
+	result := &db.Invoice{
+		Reference:    invoice.InvoiceReference,
+		CustomerID:   invoice.CustomerID,
+		ChargeKey:    invoice.ChargeIdempotencyKey,
+		SubTotal:     invoice.SubTotal.Amount,
+		Shipping:     invoice.Shipping.Amount,
+		Tax:          invoice.Tax.Amount,
+		Total:        invoice.Total.Amount,
+		ShippingRule: invoice.ShippingRule,
+		Currency:     invoice.Currency,
+		Status:       invoice.Status,
+		AuthCode:     invoice.AuthCode,
+		CreatedAt:    invoice.CreatedAt.UTC(),
+		UpdatedAt:    invoice.UpdatedAt.UTC(),
+		Items:        make([]db.InvoiceItem, len(invoice.Items)),
+	}
+	if invoice.ExchangeRate != nil {
+		result.BaseCurrency = invoice.ExchangeRate.Base
+		result.ExchangeRate = invoice.ExchangeRate.Rate
+	}
+
+	for i, item := range invoice.Items {
+		result.Items[i] = db.InvoiceItem{
+			SKU:       item.SKU,
+			Quantity:  item.Quantity,
+			SubTotal:  item.SubTotal.Amount,
+			Shipping:  item.Shipping.Amount,
+			Tax:       item.Tax.Amount,
+			Total:     item.Total.Amount,
+			Promotion: item.Promotion,
+		}
+		if item.TaxDetail != nil {
+			result.Items[i].TaxRegion = item.TaxDetail.Region
+			result.Items[i].TaxClass = item.TaxDetail.TaxClass
+			result.Items[i].TaxRate = item.TaxDetail.Rate
+		}
+	}
+
+	return result
+}
+
+func invoiceFromDB(invoice db.Invoice) Invoice {
+	result := Invoice{
+		InvoiceReference:     invoice.Reference,
+		CustomerID:           invoice.CustomerID,
+		ChargeIdempotencyKey: invoice.ChargeKey,
+		SubTotal:             currency.NewMoney(invoice.SubTotal, invoice.Currency),
+		Shipping:             currency.NewMoney(invoice.Shipping, invoice.Currency),
+		Tax:                  currency.NewMoney(invoice.Tax, invoice.Currency),
+		Total:                currency.NewMoney(invoice.Total, invoice.Currency),
+		ShippingRule:         invoice.ShippingRule,
+		Currency:             invoice.Currency,
+		Status:               invoice.Status,
+		AuthCode:             invoice.AuthCode,
+		CreatedAt:            invoice.CreatedAt,
+		UpdatedAt:            invoice.UpdatedAt,
+	}
+	if invoice.ExchangeRate != 0 {
+		result.ExchangeRate = &currency.Rate{Base: invoice.BaseCurrency, Currency: invoice.Currency, Rate: invoice.ExchangeRate}
+	}
+
+	var product *tax.LineTax
+	for _, item := range invoice.Items {
+		line := InvoiceItem{
+			SKU:       item.SKU,
+			Quantity:  item.Quantity,
+			SubTotal:  currency.NewMoney(item.SubTotal, invoice.Currency),
+			Shipping:  currency.NewMoney(item.Shipping, invoice.Currency),
+			Tax:       currency.NewMoney(item.Tax, invoice.Currency),
+			Total:     currency.NewMoney(item.Total, invoice.Currency),
+			Promotion: item.Promotion,
+		}
+		if item.TaxRegion != "" {
+			line.TaxDetail = &tax.LineTax{
+				Region:   item.TaxRegion,
+				TaxClass: item.TaxClass,
+				Rate:     item.TaxRate,
+				Taxable:  item.SubTotal,
+				Amount:   item.Tax,
+			}
+		}
+		// Tax is charged on the discounted price, so discounts reduce the taxable amount of the product line before them.
+		if item.Promotion == "" {
+			product = line.TaxDetail
+		} else if product != nil {
+			product.Taxable += item.SubTotal
+		}
+		result.Items = append(result.Items, line)
+	}
+
+	return result
+}
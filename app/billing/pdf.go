@@ -0,0 +1,152 @@
+package billing
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// pdfFont is one of the standard PDF fonts, which every PDF reader provides.
+type pdfFont string
+
+const (
+	pdfHelvetica     pdfFont = "Helvetica"
+	pdfHelveticaBold pdfFont = "Helvetica-Bold"
+	pdfCourier       pdfFont = "Courier"
+)
+
+var pdfFonts = []pdfFont{pdfHelvetica, pdfHelveticaBold, pdfCourier}
+
+// A4 page size and margins, in points.
+const (
+	pdfPageWidth  = 595
+	pdfPageHeight = 842
+	pdfMargin     = 56
+)
+
+type pdfText struct {
+	x, y float64
+	font pdfFont
+	size float64
+	text string
+}
+
+// pdfDocument is a minimal PDF writer for text-only documents.
+// Text is laid out top to bottom, starting a new page when the current one is full.
+type pdfDocument struct {
+	pages [][]pdfText
+	y     float64
+}
+
+func newPDFDocument() *pdfDocument {
+	d := &pdfDocument{}
+	d.newPage()
+	return d
+}
+
+func (d *pdfDocument) newPage() {
+	d.pages = append(d.pages, nil)
+	d.y = pdfPageHeight - pdfMargin
+}
+
+// line writes a line of text, moving down the page by the line height.
+func (d *pdfDocument) line(font pdfFont, size float64, text string) {
+	height := size * 1.4
+	if d.y-height < pdfMargin {
+		d.newPage()
+	}
+	d.y -= height
+
+	page := len(d.pages) - 1
+	d.pages[page] = append(d.pages[page], pdfText{x: pdfMargin, y: d.y, font: font, size: size, text: text})
+}
+
+// space moves down the page without writing anything.
+func (d *pdfDocument) space(height float64) {
+	d.y -= height
+}
+
+// bytes returns the encoded PDF document.
+func (d *pdfDocument) bytes() []byte {
+	var buf bytes.Buffer
+	var offsets []int
+
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n")
+
+	// Objects are numbered in the order they are written: the catalog, the page tree, the fonts, then a page and its
+	// content stream for each page.
+	fontsStart := 3
+	pagesStart := fontsStart + len(pdfFonts)
+
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", pagesStart+i*2)
+	}
+
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+
+	var resources strings.Builder
+	resources.WriteString("<< /Font <<")
+	for i, f := range pdfFonts {
+		object(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", f))
+		fmt.Fprintf(&resources, " /F%d %d 0 R", i+1, fontsStart+i)
+	}
+	resources.WriteString(" >> >>")
+
+	for i, page := range d.pages {
+		var content strings.Builder
+		for _, t := range page {
+			fmt.Fprintf(&content, "BT /F%d %.1f Tf %.1f %.1f Td (%s) Tj ET\n", fontIndex(t.font), t.size, t.x, t.y, pdfString(t.text))
+		}
+
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources %s /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, resources.String(), pagesStart+i*2+1))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, o := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", o)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return buf.Bytes()
+}
+
+func fontIndex(f pdfFont) int {
+	for i, font := range pdfFonts {
+		if font == f {
+			return i + 1
+		}
+	}
+	return 1
+}
+
+// pdfString escapes text for use in a PDF string literal.
+// Characters outside Latin-1 cannot be shown with the standard fonts, so they are replaced.
+func pdfString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20:
+			b.WriteByte(' ')
+		case r < 0x80:
+			b.WriteRune(r)
+		case r <= 0xff:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,177 @@
+package billing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/temporalio/reference-app-orders-go/app/currency"
+	"github.com/temporalio/reference-app-orders-go/app/db"
+	"go.temporal.io/api/serviceerror"
+)
+
+// FraudReviewWorkflowID returns the workflow ID for the FraudReview workflow of a charge held for review.
+func FraudReviewWorkflowID(id string) string {
+	return fmt.Sprintf("FraudReview:%s", id)
+}
+
+// handleListFraudReviews lists the fraud reviews, oldest first. The status query parameter limits the list to reviews
+// with that status, such as "pending" for the reviews awaiting a decision.
+func (h *handlers) handleListFraudReviews(w http.ResponseWriter, r *http.Request) {
+	reviews := []db.FraudReview{}
+
+	err := h.db.GetFraudReviews(r.Context(), r.URL.Query().Get("status"), &reviews)
+	if err != nil {
+		h.logger.Error("Failed to list fraud reviews", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	list := make([]FraudReviewStatus, len(reviews))
+	for i, review := range reviews {
+		list[i] = fraudReviewFromDB(review)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		h.logger.Error("Failed to encode fraud reviews", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h *handlers) handleGetFraudReview(w http.ResponseWriter, r *http.Request) {
+	var review db.FraudReview
+
+	err := h.db.GetFraudReview(r.Context(), r.PathValue("id"), &review)
+	if err != nil {
+		if errors.Is(err, db.ErrFraudReviewNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			h.logger.Error("Failed to get fraud review", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(fraudReviewFromDB(review)); err != nil {
+		h.logger.Error("Failed to encode fraud review", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h *handlers) handleStoreFraudReview(w http.ResponseWriter, r *http.Request) {
+	var review FraudReviewStatus
+
+	err := json.NewDecoder(r.Body).Decode(&review)
+	if err != nil {
+		h.logger.Error("Failed to decode fraud review", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if review.ID != r.PathValue("id") {
+		http.Error(w, "fraud review ID does not match the path", http.StatusBadRequest)
+		return
+	}
+
+	err = h.db.UpsertFraudReview(r.Context(), fraudReviewToDB(review))
+	if err != nil {
+		h.logger.Error("Failed to store fraud review", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleDecideFraudReview approves or rejects a charge held for fraud review.
+func (h *handlers) handleDecideFraudReview(w http.ResponseWriter, r *http.Request) {
+	var decision FraudReviewDecision
+
+	err := json.NewDecoder(r.Body).Decode(&decision)
+	if err != nil {
+		h.logger.Error("Failed to decode fraud review decision", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if decision.Outcome != FraudReviewStatusApproved && decision.Outcome != FraudReviewStatusRejected {
+		http.Error(w, fmt.Sprintf("outcome must be %q or %q", FraudReviewStatusApproved, FraudReviewStatusRejected), http.StatusBadRequest)
+		return
+	}
+
+	var review db.FraudReview
+
+	err = h.db.GetFraudReview(r.Context(), r.PathValue("id"), &review)
+	if err != nil {
+		if errors.Is(err, db.ErrFraudReviewNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			h.logger.Error("Failed to get fraud review", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if review.Status != FraudReviewStatusPending {
+		http.Error(w, fmt.Sprintf("fraud review is %s", review.Status), http.StatusConflict)
+		return
+	}
+
+	err = h.temporal.SignalWorkflow(r.Context(),
+		FraudReviewWorkflowID(review.ID), "",
+		FraudReviewDecisionSignalName,
+		decision,
+	)
+	if err != nil {
+		var notFound *serviceerror.NotFound
+		if errors.As(err, &notFound) {
+			// The review was decided, or ran out of time, after we read it.
+			http.Error(w, "fraud review is no longer pending", http.StatusConflict)
+			return
+		}
+		h.logger.Error("Failed to signal fraud review workflow", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func fraudReviewToDB(review FraudReviewStatus) *db.FraudReview {
+	return &db.FraudReview{
+		ID:               review.ID,
+		InvoiceReference: review.InvoiceReference,
+		CustomerID:       review.CustomerID,
+		Amount:           review.Amount.Amount,
+		Currency:         review.Currency,
+		Reason:           review.Reason,
+		Status:           review.Status,
+		Reviewer:         review.Reviewer,
+		Note:             review.Note,
+		ReviewBy:         review.ReviewBy.UTC(),
+		OpenedAt:         review.OpenedAt.UTC(),
+		ResolvedAt:       review.ResolvedAt.UTC(),
+	}
+}
+
+func fraudReviewFromDB(review db.FraudReview) FraudReviewStatus {
+	return FraudReviewStatus{
+		ID:               review.ID,
+		InvoiceReference: review.InvoiceReference,
+		CustomerID:       review.CustomerID,
+		Amount:           currency.NewMoney(review.Amount, review.Currency),
+		Currency:         review.Currency,
+		Reason:           review.Reason,
+		Status:           review.Status,
+		Reviewer:         review.Reviewer,
+		Note:             review.Note,
+		ReviewBy:         review.ReviewBy,
+		OpenedAt:         review.OpenedAt,
+		ResolvedAt:       review.ResolvedAt,
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"context"
 
 	"github.com/temporalio/reference-app-orders-go/app/config"
+	"github.com/temporalio/reference-app-orders-go/app/currency"
+	"github.com/temporalio/reference-app-orders-go/app/shipping"
+	"github.com/temporalio/reference-app-orders-go/app/tax"
 	"github.com/temporalio/reference-app-orders-go/app/temporalutil"
 	"go.temporal.io/sdk/client"
 	"go.temporal.io/sdk/worker"
@@ -12,13 +15,48 @@ import (
 
 // RunWorker runs a Workflow and Activity worker for the Billing system.
 func RunWorker(ctx context.Context, config config.AppConfig, client client.Client) error {
+	rules, err := tax.LoadRules(config.TaxRulesFile)
+	if err != nil {
+		return err
+	}
+	taxEngine, err := tax.NewRuleEngine(rules)
+	if err != nil {
+		return err
+	}
+
+	rates, err := shipping.LoadRates(config.ShippingRatesFile)
+	if err != nil {
+		return err
+	}
+	rateTable, err := shipping.NewRateTable(rates)
+	if err != nil {
+		return err
+	}
+
+	exchangeRates, err := currency.LoadRates(config.ExchangeRatesFile)
+	if err != nil {
+		return err
+	}
+	currencyTable, err := currency.NewTable(exchangeRates)
+	if err != nil {
+		return err
+	}
+
+	gateway, err := NewPaymentGateway(config)
+	if err != nil {
+		return err
+	}
+
 	w := worker.New(client, TaskQueue, worker.Options{
 		MaxConcurrentWorkflowTaskPollers: 8,
 		MaxConcurrentActivityTaskPollers: 8,
 	})
 
 	w.RegisterWorkflow(Charge)
-	w.RegisterActivity(&Activities{FraudCheckURL: config.FraudURL})
+	w.RegisterWorkflow(Refund)
+	w.RegisterWorkflow(Dispute)
+	w.RegisterWorkflow(FraudReview)
+	w.RegisterActivity(&Activities{BillingURL: config.BillingURL, FraudCheckURL: config.FraudURL, CatalogURL: config.CatalogURL, OrderURL: config.OrderURL, ShipmentURL: config.ShipmentURL, Tax: taxEngine, Shipping: rateTable, Currency: currencyTable, Gateway: gateway, Client: client, FraudReviewTimeout: config.FraudReviewTimeout, FraudMaintenanceAction: config.FraudMaintenanceAction})
 
 	return w.Run(temporalutil.WorkerInterruptFromContext(ctx))
 }
@@ -1,14 +1,129 @@
 package billing
 
 import (
+	"fmt"
+	"slices"
 	"time"
 
+	"github.com/temporalio/reference-app-orders-go/app/currency"
+	"github.com/temporalio/reference-app-orders-go/app/ledger"
+	"go.temporal.io/sdk/log"
+	"go.temporal.io/sdk/temporal"
 	"go.temporal.io/sdk/workflow"
 )
 
-// Charge Workflow invoices and processes payment for a fulfillment.
+// chargeRejectedErrorType is the error type used when a charge cannot be captured or voided.
+const chargeRejectedErrorType = "ChargeRejected"
+
+// invoiceRejectedErrorType is the error type used when a fulfillment cannot be invoiced, such as for an unknown SKU.
+const invoiceRejectedErrorType = "InvoiceRejected"
+
+// ledgerRejectedErrorType is the error type used when the ledger rejects a transaction as invalid.
+const ledgerRejectedErrorType = "LedgerRejected"
+
+// gatewayRejectedErrorType is the error type used when the payment gateway rejects a request which cannot succeed.
+const gatewayRejectedErrorType = "PaymentGatewayRejected"
+
+type chargeImpl struct {
+	input  *ChargeInput
+	result ChargeResult
+
+	// err is set if the charge could not be processed.
+	err error
+	// busy is set while the authorization is being captured or voided, or while a charge approved by a fraud review
+	// or queued during fraud maintenance is being authorized.
+	busy bool
+	// reviewBy is when a charge held for fraud review is declined if nobody has reviewed it.
+	reviewBy time.Time
+	// cancelReview withdraws the charge from fraud review.
+	cancelReview workflow.CancelFunc
+
+	// baseTotal is the invoice total in the base currency, which the fraud check tallies.
+	baseTotal currency.Money
+
+	createdAt time.Time
+	// storedStatus is the payment status of the last invoice stored.
+	storedStatus string
+
+	logger log.Logger
+}
+
+// Charge Workflow invoices a fulfillment and authorizes payment for it.
+// The authorized payment is then captured or voided with the Capture and Void updates.
+// If neither happens before the authorization expires, it is voided.
+// A charge the fraud check holds for review waits in a FraudReview workflow, and is only authorized if a manager
+// approves it. A charge made while the fraud check is down for maintenance may be queued, and is authorized once the
+// maintenance window ends.
 func Charge(ctx workflow.Context, input *ChargeInput) (*ChargeResult, error) {
-	logger := workflow.GetLogger(ctx)
+	wf := &chargeImpl{
+		input:     input,
+		result:    ChargeResult{Status: ChargeStatusPending},
+		createdAt: workflow.Now(ctx),
+		logger:    workflow.GetLogger(ctx),
+	}
+
+	if err := wf.setup(ctx); err != nil {
+		return nil, err
+	}
+
+	wf.authorize(ctx)
+	wf.storeInvoice(ctx)
+
+	if wf.result.Status == ChargeStatusQueued {
+		if err := wf.queue(ctx, false); err != nil {
+			return nil, err
+		}
+		wf.storeInvoice(ctx)
+	}
+
+	if wf.result.Status == ChargeStatusReview {
+		if err := wf.review(ctx); err != nil {
+			return nil, err
+		}
+		wf.storeInvoice(ctx)
+	}
+
+	if wf.result.Status == ChargeStatusAuthorized {
+		if err := wf.awaitSettlement(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	err := workflow.Await(ctx, func() bool { return workflow.AllHandlersFinished(ctx) })
+	if err != nil {
+		return nil, err
+	}
+
+	wf.storeInvoice(ctx)
+	wf.recordCharge(ctx)
+
+	if wf.err != nil {
+		return nil, wf.err
+	}
+
+	return &wf.result, nil
+}
+
+func (wf *chargeImpl) setup(ctx workflow.Context) error {
+	err := workflow.SetUpdateHandler(ctx, AuthorizeUpdateName, wf.handleAuthorize)
+	if err != nil {
+		return err
+	}
+
+	err = workflow.SetUpdateHandlerWithOptions(ctx, CaptureUpdateName, wf.handleCapture,
+		workflow.UpdateHandlerOptions{Validator: wf.validateSettlement(ChargeStatusCaptured)},
+	)
+	if err != nil {
+		return err
+	}
+
+	return workflow.SetUpdateHandlerWithOptions(ctx, VoidUpdateName, wf.handleVoid,
+		workflow.UpdateHandlerOptions{Validator: wf.validateSettlement(ChargeStatusVoided)},
+	)
+}
+
+// authorize generates the invoice and authorizes payment for it.
+func (wf *chargeImpl) authorize(ctx workflow.Context) {
 	ctx = workflow.WithActivityOptions(ctx,
 		workflow.ActivityOptions{
 			ScheduleToCloseTimeout: 30 * time.Second,
@@ -17,42 +132,1308 @@ func Charge(ctx workflow.Context, input *ChargeInput) (*ChargeResult, error) {
 
 	var invoice GenerateInvoiceResult
 
-	cwf := workflow.ExecuteActivity(ctx,
+	err := workflow.ExecuteActivity(ctx,
 		a.GenerateInvoice,
 		GenerateInvoiceInput{
-			CustomerID: input.CustomerID,
-			Reference:  input.Reference,
-			Items:      input.Items,
+			CustomerID:      wf.input.CustomerID,
+			Reference:       wf.input.Reference,
+			Items:           wf.input.Items,
+			Region:          wf.input.Region,
+			Origin:          wf.input.Origin,
+			ShippingService: wf.input.ShippingService,
+			Currency:        wf.input.Currency,
+			ExchangeRate:    wf.input.ExchangeRate,
+			CouponCodes:     wf.input.CouponCodes,
+			LoyaltyCredit:   wf.input.LoyaltyCredit,
+		},
+	).Get(ctx, &invoice)
+	if err != nil {
+		wf.err = err
+		return
+	}
+
+	baseTotal, err := toBase(invoice.ExchangeRate, invoice.Total)
+	if err != nil {
+		wf.err = temporal.NewNonRetryableApplicationError(err.Error(), invoiceRejectedErrorType, err)
+		return
+	}
+
+	totals, err := sumInvoiceItems(invoice.Items)
+	if err != nil {
+		wf.err = temporal.NewNonRetryableApplicationError(err.Error(), invoiceRejectedErrorType, err)
+		return
+	}
+
+	wf.result.InvoiceReference = invoice.InvoiceReference
+	wf.result.Items = invoice.Items
+	wf.result.SubTotal = invoice.SubTotal
+	wf.result.Tax = totals.Tax
+	wf.result.Shipping = invoice.Shipping
+	wf.result.ShippingRate = invoice.ShippingRate
+	wf.result.Total = invoice.Total
+	wf.result.Currency = invoice.Currency
+	wf.result.ExchangeRate = invoice.ExchangeRate
+	wf.result.LoyaltyCredit = invoice.LoyaltyCredit
+	wf.baseTotal = baseTotal
+
+	wf.authorizePayment(ctx, false)
+}
+
+// authorizePayment authorizes payment for the invoice. fraudApproved is set once a manager has approved a charge
+// held for fraud review.
+func (wf *chargeImpl) authorizePayment(ctx workflow.Context, fraudApproved bool) {
+	ctx = workflow.WithActivityOptions(ctx,
+		workflow.ActivityOptions{
+			ScheduleToCloseTimeout: 30 * time.Second,
 		},
 	)
-	err := cwf.Get(ctx, &invoice)
+
+	var auth AuthorizePaymentResult
+
+	err := workflow.ExecuteActivity(ctx,
+		a.AuthorizePayment,
+		AuthorizePaymentInput{
+			CustomerID:      wf.input.CustomerID,
+			Email:           wf.input.Email,
+			ShippingAddress: wf.input.ShippingAddress,
+			SKUs:            wf.skus(),
+			Reference:       wf.result.InvoiceReference,
+			Charge:          wf.result.Total,
+			Currency:        wf.result.Currency,
+			BaseCharge:      wf.baseTotal,
+			PaymentMethodID: wf.input.PaymentMethodID,
+			IdempotencyKey:  workflow.GetInfo(ctx).WorkflowExecution.ID,
+			FraudKey:        wf.fraudKey(ctx),
+			FraudApproved:   fraudApproved,
+		},
+	).Get(ctx, &auth)
+	if err != nil {
+		wf.logger.Warn("Authorization failed", "customer_id", wf.input.CustomerID, "error", err)
+		auth.Success = false
+	}
+
+	if auth.FraudDecisionID != "" {
+		wf.result.FraudDecisionID = auth.FraudDecisionID
+		wf.result.FraudScore = auth.FraudScore
+	}
+	wf.result.MaintenanceReason = auth.MaintenanceReason
+	wf.result.RetryAt = auth.RetryAt
+
+	if auth.Queued {
+		wf.result.Status = ChargeStatusQueued
+		return
+	}
+
+	if auth.Review {
+		wf.result.Status = ChargeStatusReview
+		wf.result.FraudReason = auth.FraudReason
+		wf.result.FraudReviewID = wf.input.IdempotencyKey
+		if wf.result.FraudReviewID == "" {
+			wf.result.FraudReviewID = workflow.GetInfo(ctx).WorkflowExecution.ID
+		}
+		wf.reviewBy = auth.ReviewBy
+		return
+	}
+
+	if !auth.Success {
+		// The promotions are given back before the decline is reported, so that charging the fulfillment again can
+		// redeem them again.
+		wf.releasePromotions(ctx)
+	}
+
+	wf.result.Success = auth.Success
+	wf.result.AuthCode = auth.AuthCode
+	wf.result.PaymentMethodID = auth.PaymentMethodID
+	wf.result.DeclineReason = auth.DeclineReason
+	if auth.FraudReason != "" {
+		wf.result.FraudReason = auth.FraudReason
+	}
+	if auth.Success {
+		wf.result.Status = ChargeStatusAuthorized
+		wf.result.AuthorizationExpiresAt = auth.ExpiresAt
+	} else {
+		wf.result.Status = ChargeStatusDeclined
+	}
+
+	// A charge the fraud check declined, or did not check, was never counted.
+	if !auth.Success && auth.DeclineReason != DeclineReasonFraud && auth.DeclineReason != DeclineReasonFraudMaintenance {
+		wf.releaseFraudCharge(ctx)
+	}
+}
+
+// fraudKey identifies the charge to the fraud check: the fulfillment's reference, which stays the same when a
+// declined fulfillment is charged again.
+func (wf *chargeImpl) fraudKey(ctx workflow.Context) string {
+	if wf.input.Reference == "" {
+		return workflow.GetInfo(ctx).WorkflowExecution.ID
+	}
+
+	return wf.input.Reference
+}
+
+// releaseFraudCharge stops a charge which was not authorized, or whose authorization was voided or has expired,
+// counting towards the customer's fraud limits.
+func (wf *chargeImpl) releaseFraudCharge(ctx workflow.Context) {
+	ctx = workflow.WithActivityOptions(ctx,
+		workflow.ActivityOptions{
+			ScheduleToCloseTimeout: 30 * time.Second,
+		},
+	)
+
+	err := workflow.ExecuteActivity(ctx, a.ReleaseFraudCharge, wf.fraudKey(ctx)).Get(ctx, nil)
+	if err != nil {
+		wf.logger.Warn("Failed to release fraud charge", "customer_id", wf.input.CustomerID, "error", err)
+	}
+}
+
+// releasePromotions gives back the promotions redeemed on the invoice of a charge which will not be paid, so that they
+// no longer count towards the customer's limits.
+func (wf *chargeImpl) releasePromotions(ctx workflow.Context) {
+	var codes []string
+	for _, item := range wf.result.Items {
+		if item.Promotion != "" && item.Promotion != LoyaltyDiscount && !slices.Contains(codes, item.Promotion) {
+			codes = append(codes, item.Promotion)
+		}
+	}
+	if len(codes) == 0 {
+		return
+	}
+
+	ctx = workflow.WithActivityOptions(ctx,
+		workflow.ActivityOptions{
+			ScheduleToCloseTimeout: 30 * time.Second,
+		},
+	)
+
+	err := workflow.ExecuteActivity(ctx, a.ReleasePromotions, ReleasePromotionsInput{Reference: wf.input.Reference, Codes: codes}).Get(ctx, nil)
+	if err != nil {
+		wf.logger.Warn("Failed to release promotions", "customer_id", wf.input.CustomerID, "error", err)
+	}
+}
+
+// skus returns the SKUs of the products charged for.
+func (wf *chargeImpl) skus() []string {
+	skus := make([]string, len(wf.input.Items))
+	for i, item := range wf.input.Items {
+		skus[i] = item.SKU
+	}
+
+	return skus
+}
+
+// review holds the charge in a FraudReview workflow until a manager decides it or the review runs out of time, and
+// authorizes payment if the charge was approved. Voiding the charge while it is held withdraws it from review.
+func (wf *chargeImpl) review(ctx workflow.Context) error {
+	id := wf.result.FraudReviewID
+
+	reviewCtx, cancel := workflow.WithCancel(ctx)
+	wf.cancelReview = cancel
+	defer cancel()
+
+	reviewCtx = workflow.WithChildOptions(reviewCtx, workflow.ChildWorkflowOptions{
+		WorkflowID: FraudReviewWorkflowID(id),
+	})
+
+	var status FraudReviewStatus
+
+	err := workflow.ExecuteChildWorkflow(reviewCtx,
+		FraudReview,
+		&FraudReviewInput{
+			ID:               id,
+			InvoiceReference: wf.result.InvoiceReference,
+			CustomerID:       wf.input.CustomerID,
+			Amount:           wf.result.Total,
+			Currency:         wf.result.Currency,
+			Reason:           wf.result.FraudReason,
+			ReviewBy:         wf.reviewBy,
+		},
+	).Get(reviewCtx, &status)
+	if wf.result.Status == ChargeStatusVoided {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	wf.logger.Info("Fraud review decided", "customer_id", wf.input.CustomerID, "outcome", status.Status)
+
+	if status.Status != FraudReviewStatusApproved {
+		wf.releasePromotions(ctx)
+		wf.result.Status = ChargeStatusDeclined
+		wf.result.DeclineReason = DeclineReasonFraud
+		return nil
+	}
+
+	// A void must wait for the authorization, so that it can release the funds.
+	wf.busy = true
+	wf.authorizePayment(ctx, true)
+	wf.busy = false
+
+	switch wf.result.Status {
+	case ChargeStatusReview:
+		// The fraud check records an approved charge without checking it again, so this cannot happen.
+		wf.result.Status = ChargeStatusDeclined
+		wf.result.DeclineReason = DeclineReasonFraud
+	case ChargeStatusQueued:
+		// The fraud check went down for maintenance while the charge was being reviewed.
+		wf.storeInvoice(ctx)
+		return wf.queue(ctx, true)
+	}
+
+	return nil
+}
+
+// maintenanceRetryInterval is how long a queued charge waits before it is authorized again, when the fraud check's
+// maintenance window has no end, or has passed its end without the check coming back.
+const maintenanceRetryInterval = 5 * time.Minute
+
+// queue waits until the fraud check's maintenance window ends and then authorizes payment again, for as long as the
+// check stays down. fraudApproved is set for a charge a manager has already approved. Voiding the charge while it is
+// queued withdraws it.
+func (wf *chargeImpl) queue(ctx workflow.Context, fraudApproved bool) error {
+	wf.logger.Info("Charge queued during fraud maintenance", "reason", wf.result.MaintenanceReason, "retry_at", wf.result.RetryAt)
+
+	for wf.result.Status == ChargeStatusQueued {
+		wait := wf.result.RetryAt.Sub(workflow.Now(ctx))
+		if wait <= 0 {
+			wait = maintenanceRetryInterval
+		}
+
+		withdrawn, err := workflow.AwaitWithTimeout(ctx, wait, func() bool { return wf.result.Status != ChargeStatusQueued })
+		if err != nil {
+			return err
+		}
+		if withdrawn {
+			return nil
+		}
+
+		// A void must wait for the authorization, so that it can release the funds.
+		wf.busy = true
+		wf.authorizePayment(ctx, fraudApproved)
+		wf.busy = false
+	}
+
+	return nil
+}
+
+// storeInvoice records the invoice and the current payment status, unless it is already up to date.
+// Failing to store the invoice does not affect the charge.
+func (wf *chargeImpl) storeInvoice(ctx workflow.Context) {
+	if wf.err != nil || wf.result.Status == wf.storedStatus {
+		return
+	}
+
+	ctx = workflow.WithActivityOptions(ctx,
+		workflow.ActivityOptions{
+			ScheduleToCloseTimeout: 5 * time.Minute,
+		},
+	)
+
+	invoice := Invoice{
+		InvoiceReference:     wf.result.InvoiceReference,
+		CustomerID:           wf.input.CustomerID,
+		ChargeIdempotencyKey: wf.input.IdempotencyKey,
+		Items:                wf.result.Items,
+		SubTotal:             wf.result.SubTotal,
+		Shipping:             wf.result.Shipping,
+		Tax:                  wf.result.Tax,
+		Total:                wf.result.Total,
+		Currency:             wf.result.Currency,
+		ExchangeRate:         wf.result.ExchangeRate,
+		Status:               wf.result.Status,
+		AuthCode:             wf.result.AuthCode,
+		CreatedAt:            wf.createdAt,
+		UpdatedAt:            workflow.Now(ctx),
+	}
+	if wf.result.ShippingRate != nil {
+		invoice.ShippingRule = wf.result.ShippingRate.RuleID
+	}
+
+	err := workflow.ExecuteActivity(ctx, a.StoreInvoice, invoice).Get(ctx, nil)
+	if err != nil {
+		wf.logger.Warn("Failed to store invoice", "customer_id", wf.input.CustomerID, "error", err)
+		return
+	}
+
+	wf.storedStatus = invoice.Status
+}
+
+// recordCharge records a captured payment in the ledger.
+func (wf *chargeImpl) recordCharge(ctx workflow.Context) {
+	if wf.err != nil || wf.result.Status != ChargeStatusCaptured || wf.result.Total.IsZero() {
+		return
+	}
+
+	amounts, err := ledgerAmounts(wf.result.ExchangeRate, wf.result.SubTotal, wf.result.Shipping, wf.result.Tax)
+	if err != nil {
+		wf.logger.Error("Failed to record charge in ledger", "customer_id", wf.input.CustomerID, "error", err)
+		return
+	}
+
+	transaction := ledger.NewCharge(
+		"charge:"+wf.input.IdempotencyKey,
+		wf.input.CustomerID,
+		wf.result.InvoiceReference,
+		amounts,
+		workflow.Now(ctx),
+	)
+
+	if err := recordLedgerTransaction(ctx, transaction); err != nil {
+		wf.logger.Error("Failed to record charge in ledger", "customer_id", wf.input.CustomerID, "error", err)
+	}
+}
+
+// toBase converts an amount to the base currency. A nil rate means the amount is already in the base currency.
+func toBase(rate *currency.Rate, amount currency.Money) (currency.Money, error) {
+	if rate == nil {
+		return amount, nil
+	}
+
+	return rate.ToBase(amount)
+}
+
+// ledgerAmounts converts invoice amounts to the base currency, which the ledger is kept in.
+func ledgerAmounts(rate *currency.Rate, subTotal currency.Money, shipping currency.Money, tax currency.Money) (ledger.Amounts, error) {
+	var amounts ledger.Amounts
+
+	for _, c := range []struct {
+		amount currency.Money
+		dest   *int64
+	}{
+		{subTotal, &amounts.SubTotal},
+		{shipping, &amounts.Shipping},
+		{tax, &amounts.Tax},
+	} {
+		base, err := toBase(rate, c.amount)
+		if err != nil {
+			return amounts, err
+		}
+		*c.dest = base.Amount
+	}
+
+	return amounts, nil
+}
+
+// recordLedgerTransaction records a money movement in the ledger.
+// The ledger must not miss a movement, so this keeps retrying until the ledger accepts or rejects the transaction.
+func recordLedgerTransaction(ctx workflow.Context, transaction ledger.Transaction) error {
+	ctx = workflow.WithActivityOptions(ctx,
+		workflow.ActivityOptions{
+			StartToCloseTimeout: 30 * time.Second,
+		},
+	)
+
+	return workflow.ExecuteActivity(ctx, a.RecordLedgerTransaction, transaction).Get(ctx, nil)
+}
+
+// awaitSettlement waits for the authorization to be captured or voided, voiding it when it expires.
+func (wf *chargeImpl) awaitSettlement(ctx workflow.Context) error {
+	for {
+		settled, err := workflow.AwaitWithTimeout(ctx,
+			wf.result.AuthorizationExpiresAt.Sub(workflow.Now(ctx)),
+			func() bool { return wf.result.Status != ChargeStatusAuthorized },
+		)
+		if err != nil {
+			return err
+		}
+		if settled {
+			return nil
+		}
+
+		// Let a capture or void which is already in progress finish first.
+		if wf.busy {
+			if err := workflow.Await(ctx, func() bool { return !wf.busy }); err != nil {
+				return err
+			}
+			continue
+		}
+
+		wf.expire(ctx)
+
+		return nil
+	}
+}
+
+// expire voids an authorization which was not captured in time.
+func (wf *chargeImpl) expire(ctx workflow.Context) {
+	ctx = workflow.WithActivityOptions(ctx,
+		workflow.ActivityOptions{
+			ScheduleToCloseTimeout: 30 * time.Second,
+		},
+	)
+
+	err := workflow.ExecuteActivity(ctx, a.VoidAuthorization, wf.voidInput()).Get(ctx, nil)
+	if err != nil {
+		// The payment gateway releases the funds itself once the authorization has expired.
+		wf.logger.Warn("Failed to void expired authorization", "customer_id", wf.input.CustomerID, "error", err)
+	}
+	wf.releaseFraudCharge(ctx)
+	wf.releasePromotions(ctx)
+
+	wf.result.Status = ChargeStatusExpired
+
+	wf.logger.Info("Authorization expired", "total", wf.result.Total)
+}
+
+func (wf *chargeImpl) handleAuthorize(ctx workflow.Context) (*ChargeResult, error) {
+	err := workflow.Await(ctx, func() bool { return wf.result.Status != ChargeStatusPending || wf.err != nil })
+	if err != nil {
+		return nil, err
+	}
+	if wf.err != nil {
+		return nil, wf.err
+	}
+
+	return &wf.result, nil
+}
+
+func (wf *chargeImpl) validateSettlement(status string) func(workflow.Context) error {
+	return func(_ workflow.Context) error {
+		switch wf.result.Status {
+		case ChargeStatusPending, ChargeStatusAuthorized, status:
+			return nil
+		case ChargeStatusReview, ChargeStatusQueued:
+			// A charge held for review or queued has nothing to capture yet, but may be withdrawn.
+			if status == ChargeStatusVoided {
+				return nil
+			}
+		}
+
+		return temporal.NewApplicationError(fmt.Sprintf("payment is %s", wf.result.Status), chargeRejectedErrorType)
+	}
+}
+
+func (wf *chargeImpl) handleCapture(ctx workflow.Context) (*ChargeResult, error) {
+	return wf.settle(ctx, ChargeStatusCaptured, func(ctx workflow.Context) error {
+		return workflow.ExecuteActivity(ctx,
+			a.CapturePayment,
+			CapturePaymentInput{
+				CustomerID: wf.input.CustomerID,
+				Reference:  wf.result.InvoiceReference,
+				AuthCode:   wf.result.AuthCode,
+				Charge:     wf.result.Total,
+				Currency:   wf.result.Currency,
+			},
+		).Get(ctx, nil)
+	})
+}
+
+func (wf *chargeImpl) handleVoid(ctx workflow.Context) (*ChargeResult, error) {
+	return wf.settle(ctx, ChargeStatusVoided, func(ctx workflow.Context) error {
+		if err := workflow.ExecuteActivity(ctx, a.VoidAuthorization, wf.voidInput()).Get(ctx, nil); err != nil {
+			return err
+		}
+		wf.releaseFraudCharge(ctx)
+		wf.releasePromotions(ctx)
+
+		return nil
+	})
+}
+
+func (wf *chargeImpl) voidInput() VoidAuthorizationInput {
+	return VoidAuthorizationInput{
+		CustomerID: wf.input.CustomerID,
+		Reference:  wf.result.InvoiceReference,
+		AuthCode:   wf.result.AuthCode,
+	}
+}
+
+// settle captures or voids the authorization, one at a time.
+// Asking for the status the charge already has returns the charge, so retries are safe.
+func (wf *chargeImpl) settle(ctx workflow.Context, status string, fn func(workflow.Context) error) (*ChargeResult, error) {
+	err := workflow.Await(ctx, func() bool {
+		return !wf.busy && (wf.result.Status != ChargeStatusPending || wf.err != nil)
+	})
 	if err != nil {
 		return nil, err
 	}
+	if wf.err != nil {
+		return nil, wf.err
+	}
+
+	if wf.result.Status == status {
+		return &wf.result, nil
+	}
+	if (wf.result.Status == ChargeStatusReview || wf.result.Status == ChargeStatusQueued) && status == ChargeStatusVoided {
+		wf.logger.Info("Charge withdrawn before authorization", "status", wf.result.Status, "total", wf.result.Total)
 
-	var charge ChargeCustomerResult
+		wf.releasePromotions(ctx)
+		wf.result.Status = ChargeStatusVoided
+		if wf.cancelReview != nil {
+			wf.cancelReview()
+		}
 
-	cwf = workflow.ExecuteActivity(ctx,
-		a.ChargeCustomer,
-		ChargeCustomerInput{
-			CustomerID: input.CustomerID,
-			Reference:  invoice.InvoiceReference,
-			Charge:     invoice.Total,
+		return &wf.result, nil
+	}
+	if wf.result.Status != ChargeStatusAuthorized {
+		return nil, temporal.NewApplicationError(fmt.Sprintf("payment is %s", wf.result.Status), chargeRejectedErrorType)
+	}
+
+	wf.busy = true
+	defer func() { wf.busy = false }()
+
+	ctx = workflow.WithActivityOptions(ctx,
+		workflow.ActivityOptions{
+			ScheduleToCloseTimeout: 30 * time.Second,
 		},
 	)
-	if err := cwf.Get(ctx, &charge); err != nil {
-		logger.Warn("Charge failed", "customer_id", input.CustomerID, "error", err)
-		charge.Success = false
+
+	if err := fn(ctx); err != nil {
+		wf.logger.Warn("Failed to settle payment", "customer_id", wf.input.CustomerID, "status", status, "error", err)
+		return nil, err
 	}
 
-	return &ChargeResult{
-		InvoiceReference: invoice.InvoiceReference,
-		SubTotal:         invoice.SubTotal,
-		Tax:              invoice.Tax,
-		Shipping:         invoice.Shipping,
-		Total:            invoice.Total,
+	wf.result.Status = status
+
+	wf.logger.Info("Payment settled", "total", wf.result.Total, "status", status)
+
+	return &wf.result, nil
+}
+
+// refundWindow is how long the Refund workflow for a charge accepts further refunds.
+const refundWindow = 30 * 24 * time.Hour
+
+// refundRejectedErrorType is the error type used when a refund request cannot be honoured.
+const refundRejectedErrorType = "RefundRejected"
+
+type refundImpl struct {
+	input *RefundWorkflowInput
+
+	// charge is the original charge, once it has been loaded.
+	charge *ChargeResult
+	// chargeErr is set if the original charge cannot be refunded.
+	chargeErr error
 
-		Success:  charge.Success,
-		AuthCode: charge.AuthCode,
-	}, nil
+	// charged and refunded hold the charged and refunded amounts for each SKU.
+	charged  map[string]*InvoiceItem
+	refunded map[string]*InvoiceItem
+
+	logger log.Logger
+}
+
+// Refund Workflow refunds all or part of a charge.
+// Refunds are requested with the RefundRequest update, and are handled by a single workflow per charge
+// so that the total refunded can never exceed what was charged.
+func Refund(ctx workflow.Context, input *RefundWorkflowInput) (*RefundStatus, error) {
+	wf := &refundImpl{
+		input:    input,
+		refunded: make(map[string]*InvoiceItem),
+		logger:   workflow.GetLogger(ctx),
+	}
+
+	err := workflow.SetUpdateHandlerWithOptions(ctx, RefundUpdateName, wf.handleRefund,
+		workflow.UpdateHandlerOptions{Validator: validateRefundRequest},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	wf.loadCharge(ctx)
+	if wf.chargeErr == nil {
+		_, err = workflow.AwaitWithTimeout(ctx, refundWindow, wf.fullyRefunded)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = workflow.Await(ctx, func() bool { return workflow.AllHandlersFinished(ctx) })
+	if err != nil {
+		return nil, err
+	}
+
+	if wf.chargeErr != nil {
+		return nil, wf.chargeErr
+	}
+
+	return wf.status()
+}
+
+func (wf *refundImpl) loadCharge(ctx workflow.Context) {
+	ctx = workflow.WithActivityOptions(ctx,
+		workflow.ActivityOptions{
+			ScheduleToCloseTimeout: 30 * time.Second,
+		},
+	)
+
+	var charge ChargeResult
+
+	err := workflow.ExecuteActivity(ctx, a.GetCharge, wf.input.ChargeIdempotencyKey).Get(ctx, &charge)
+	if err != nil {
+		wf.chargeErr = err
+		return
+	}
+
+	if charge.Status != ChargeStatusCaptured {
+		wf.chargeErr = temporal.NewApplicationError(fmt.Sprintf("payment is %s", charge.Status), refundRejectedErrorType)
+		return
+	}
+	if charge.InvoiceReference != wf.input.InvoiceReference {
+		wf.chargeErr = temporal.NewApplicationError("invoice reference does not match the charge", refundRejectedErrorType)
+		return
+	}
+
+	wf.charge = &charge
+	wf.charged = make(map[string]*InvoiceItem)
+	for _, line := range charge.Items {
+		if err := addInvoiceItem(wf.charged, line); err != nil {
+			wf.chargeErr = temporal.NewNonRetryableApplicationError(err.Error(), refundRejectedErrorType, err)
+			return
+		}
+	}
+}
+
+func validateRefundRequest(_ workflow.Context, request *RefundRequest) error {
+	for _, item := range request.Items {
+		if item.SKU == "" || item.Quantity < 1 {
+			return fmt.Errorf("items must have a sku and a positive quantity")
+		}
+	}
+
+	return nil
+}
+
+func (wf *refundImpl) handleRefund(ctx workflow.Context, request *RefundRequest) (*RefundResult, error) {
+	err := workflow.Await(ctx, func() bool { return wf.charge != nil || wf.chargeErr != nil })
+	if err != nil {
+		return nil, err
+	}
+	if wf.chargeErr != nil {
+		return nil, wf.chargeErr
+	}
+
+	// Record the refund before paying it out so that concurrent requests cannot refund the same items.
+	lines, err := wf.reserve(request.Items)
+	if err != nil {
+		return nil, err
+	}
+
+	totals, err := sumInvoiceItems(lines)
+	if err != nil {
+		wf.release(lines)
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(), refundRejectedErrorType, err)
+	}
+
+	result := RefundResult{
+		InvoiceReference: wf.charge.InvoiceReference,
+		Items:            lines,
+		SubTotal:         totals.SubTotal,
+		Shipping:         totals.Shipping,
+		Tax:              totals.Tax,
+		Total:            totals.Total,
+		Currency:         wf.charge.Currency,
+	}
+
+	ctx = workflow.WithActivityOptions(ctx,
+		workflow.ActivityOptions{
+			ScheduleToCloseTimeout: 30 * time.Second,
+		},
+	)
+
+	var refund RefundCustomerResult
+
+	err = workflow.ExecuteActivity(ctx,
+		a.RefundCustomer,
+		RefundCustomerInput{
+			CustomerID:     wf.input.CustomerID,
+			Reference:      wf.charge.InvoiceReference,
+			AuthCode:       wf.charge.AuthCode,
+			Refund:         result.Total,
+			Currency:       wf.charge.Currency,
+			IdempotencyKey: workflow.GetCurrentUpdateInfo(ctx).ID,
+		},
+	).Get(ctx, &refund)
+	if err != nil {
+		wf.logger.Warn("Refund failed", "customer_id", wf.input.CustomerID, "error", err)
+		wf.release(lines)
+		return nil, err
+	}
+
+	status, err := wf.status()
+	if err != nil {
+		return nil, err
+	}
+
+	result.AuthCode = refund.AuthCode
+	result.TotalRefunded = status.Refunded
+
+	if !result.Total.IsZero() {
+		err := wf.recordRefund(ctx, result)
+		if err != nil {
+			wf.logger.Error("Failed to record refund in ledger", "customer_id", wf.input.CustomerID, "error", err)
+		}
+
+		err = workflow.ExecuteActivity(ctx,
+			a.ReverseLoyaltyPoints,
+			LoyaltyReversalInput{
+				ID:         workflow.GetCurrentUpdateInfo(ctx).ID,
+				CustomerID: wf.input.CustomerID,
+				Reference:  wf.charge.InvoiceReference,
+				Refunded:   result.TotalRefunded,
+				Charged:    wf.charge.Total,
+			},
+		).Get(ctx, nil)
+		if err != nil {
+			wf.logger.Error("Failed to reverse loyalty points", "customer_id", wf.input.CustomerID, "error", err)
+		}
+	}
+
+	wf.logger.Info("Refunded", "total", result.Total, "totalRefunded", result.TotalRefunded)
+
+	return &result, nil
+}
+
+// recordRefund records a refund in the ledger, at the exchange rate of the original charge.
+func (wf *refundImpl) recordRefund(ctx workflow.Context, result RefundResult) error {
+	amounts, err := ledgerAmounts(wf.charge.ExchangeRate, result.SubTotal, result.Shipping, result.Tax)
+	if err != nil {
+		return err
+	}
+
+	transaction := ledger.NewRefund(
+		"refund:"+workflow.GetCurrentUpdateInfo(ctx).ID,
+		wf.input.CustomerID,
+		wf.charge.InvoiceReference,
+		amounts,
+		workflow.Now(ctx),
+	)
+
+	return recordLedgerTransaction(ctx, transaction)
+}
+
+// reserve records a refund of the given items, or everything not yet refunded if no items are given.
+// It returns the refunded invoice lines.
+func (wf *refundImpl) reserve(items []Item) ([]InvoiceItem, error) {
+	if len(items) == 0 {
+		for _, sku := range wf.skus() {
+			items = append(items, Item{SKU: sku, Quantity: wf.remaining(sku)})
+		}
+	}
+
+	requested := make(map[string]int32)
+	var skus []string
+	for _, item := range items {
+		charged, found := wf.charged[item.SKU]
+		if !found {
+			return nil, temporal.NewApplicationError(fmt.Sprintf("%s was not charged", item.SKU), refundRejectedErrorType)
+		}
+		if _, seen := requested[item.SKU]; !seen {
+			skus = append(skus, item.SKU)
+		}
+		requested[item.SKU] += item.Quantity
+		if requested[item.SKU] > wf.remaining(item.SKU) {
+			return nil, temporal.NewApplicationError(
+				fmt.Sprintf("cannot refund %d of %s, %d charged and %d already refunded",
+					requested[item.SKU], item.SKU, charged.Quantity, charged.Quantity-wf.remaining(item.SKU)),
+				refundRejectedErrorType,
+			)
+		}
+	}
+
+	var lines []InvoiceItem
+	for _, sku := range skus {
+		if requested[sku] == 0 {
+			continue
+		}
+		line, err := wf.refundLine(sku, requested[sku])
+		if err == nil {
+			err = addInvoiceItem(wf.refunded, line)
+		}
+		if err != nil {
+			wf.release(lines)
+			return nil, temporal.NewNonRetryableApplicationError(err.Error(), refundRejectedErrorType, err)
+		}
+		lines = append(lines, line)
+	}
+
+	if len(lines) == 0 {
+		return nil, temporal.NewApplicationError("nothing left to refund", refundRejectedErrorType)
+	}
+
+	return lines, nil
+}
+
+// release cancels the reservation of refund lines which were not paid out.
+func (wf *refundImpl) release(lines []InvoiceItem) {
+	for _, line := range lines {
+		// Taking back amounts which were added cannot overflow.
+		_ = subtractInvoiceItem(wf.refunded, line)
+	}
+}
+
+// remaining returns the quantity of a SKU which has not yet been refunded.
+func (wf *refundImpl) remaining(sku string) int32 {
+	remaining := wf.charged[sku].Quantity
+	if refunded, found := wf.refunded[sku]; found {
+		remaining -= refunded.Quantity
+	}
+	return remaining
+}
+
+// refundLine calculates the refund for a quantity of a SKU, pro rata to the charge.
+// Refunding the last of a SKU refunds whatever is left, so rounding never leaves money behind
+// or refunds more than was charged.
+func (wf *refundImpl) refundLine(sku string, quantity int32) (InvoiceItem, error) {
+	charged := wf.charged[sku]
+	refunded := InvoiceItem{}
+	if r, found := wf.refunded[sku]; found {
+		refunded = *r
+	}
+
+	share := func(chargedAmount, refundedAmount currency.Money) (currency.Money, error) {
+		left, err := chargedAmount.Sub(refundedAmount)
+		if err != nil || refunded.Quantity+quantity == charged.Quantity {
+			return left, err
+		}
+
+		share, err := chargedAmount.MulDiv(int64(quantity), int64(charged.Quantity))
+		if err != nil || share.Amount > left.Amount {
+			return left, err
+		}
+		return share, nil
+	}
+
+	line := InvoiceItem{SKU: sku, Quantity: quantity}
+
+	var err error
+	if line.SubTotal, err = share(charged.SubTotal, refunded.SubTotal); err != nil {
+		return line, err
+	}
+	if line.Shipping, err = share(charged.Shipping, refunded.Shipping); err != nil {
+		return line, err
+	}
+	if line.Tax, err = share(charged.Tax, refunded.Tax); err != nil {
+		return line, err
+	}
+	line.Total, err = currency.Sum(line.SubTotal, line.Shipping, line.Tax)
+
+	return line, err
+}
+
+func (wf *refundImpl) fullyRefunded() bool {
+	for sku := range wf.charged {
+		if wf.remaining(sku) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (wf *refundImpl) status() (*RefundStatus, error) {
+	status := RefundStatus{
+		InvoiceReference: wf.charge.InvoiceReference,
+		Charged:          wf.charge.Total,
+		Currency:         wf.charge.Currency,
+	}
+
+	for _, sku := range wf.skus() {
+		if refunded, found := wf.refunded[sku]; found && refunded.Quantity > 0 {
+			status.Items = append(status.Items, *refunded)
+		}
+	}
+
+	totals, err := sumInvoiceItems(status.Items)
+	if err != nil {
+		return nil, err
+	}
+	status.Refunded = totals.Total
+
+	return &status, nil
+}
+
+// skus returns the charged SKUs in invoice order.
+func (wf *refundImpl) skus() []string {
+	var skus []string
+	seen := make(map[string]bool)
+	for _, line := range wf.charge.Items {
+		if !seen[line.SKU] {
+			seen[line.SKU] = true
+			skus = append(skus, line.SKU)
+		}
+	}
+	return skus
+}
+
+func addInvoiceItem(items map[string]*InvoiceItem, line InvoiceItem) error {
+	item, found := items[line.SKU]
+	if !found {
+		item = &InvoiceItem{SKU: line.SKU}
+	}
+
+	sum, err := addAmounts(*item, line)
+	if err != nil {
+		return err
+	}
+
+	*item = sum
+	items[line.SKU] = item
+
+	return nil
+}
+
+func subtractInvoiceItem(items map[string]*InvoiceItem, line InvoiceItem) error {
+	return addInvoiceItem(items, InvoiceItem{
+		SKU:      line.SKU,
+		Quantity: -line.Quantity,
+		SubTotal: line.SubTotal.Neg(),
+		Shipping: line.Shipping.Neg(),
+		Tax:      line.Tax.Neg(),
+		Total:    line.Total.Neg(),
+	})
+}
+
+// sumInvoiceItems adds up the quantities and amounts of invoice lines.
+func sumInvoiceItems(lines []InvoiceItem) (InvoiceItem, error) {
+	var total InvoiceItem
+
+	for _, line := range lines {
+		var err error
+		if total, err = addAmounts(total, line); err != nil {
+			return InvoiceItem{}, err
+		}
+	}
+
+	return total, nil
+}
+
+// addAmounts returns item with a line's quantity and amounts added, or an error if an amount overflows.
+func addAmounts(item InvoiceItem, line InvoiceItem) (InvoiceItem, error) {
+	var err error
+
+	item.Quantity += line.Quantity
+	for _, c := range []struct {
+		dest   *currency.Money
+		amount currency.Money
+	}{
+		{&item.SubTotal, line.SubTotal},
+		{&item.Shipping, line.Shipping},
+		{&item.Tax, line.Tax},
+		{&item.Total, line.Total},
+	} {
+		if *c.dest, err = c.dest.Add(c.amount); err != nil {
+			return item, err
+		}
+	}
+
+	return item, nil
+}
+
+// disputeResponseWindow is how long the card issuer has to rule on a dispute, unless it says otherwise.
+const disputeResponseWindow = 30 * 24 * time.Hour
+
+// The order statuses which reflect a dispute, as defined by the order package.
+const (
+	orderStatusDisputed    = "disputed"
+	orderStatusDisputeWon  = "disputeWon"
+	orderStatusDisputeLost = "disputeLost"
+)
+
+type disputeImpl struct {
+	status DisputeStatus
+	// exchangeRate is the disputed invoice's exchange rate.
+	exchangeRate *currency.Rate
+
+	logger log.Logger
+}
+
+// Dispute Workflow handles a customer's dispute of a captured charge, raised by the card issuer.
+// The disputed invoice is frozen while the dispute is open. Evidence of delivery is collected from the Shipment
+// system, and the workflow then waits for the issuer's decision on the DisputeResolution signal. If the issuer has
+// not ruled by the deadline the dispute is lost. A lost dispute is charged back in the ledger, and either outcome is
+// recorded on the order.
+func Dispute(ctx workflow.Context, input *DisputeInput) (*DisputeStatus, error) {
+	now := workflow.Now(ctx)
+
+	wf := &disputeImpl{
+		status: DisputeStatus{
+			ID:               input.ID,
+			InvoiceReference: input.InvoiceReference,
+			CustomerID:       input.CustomerID,
+			Amount:           input.Amount,
+			Reason:           input.Reason,
+			Status:           DisputeStatusOpen,
+			RespondBy:        input.RespondBy,
+			OpenedAt:         now,
+		},
+		exchangeRate: input.ExchangeRate,
+		logger:       workflow.GetLogger(ctx),
+	}
+	if wf.status.RespondBy.IsZero() {
+		wf.status.RespondBy = now.Add(disputeResponseWindow)
+	}
+
+	// Storing the open dispute is what freezes the invoice, so this must succeed before anything else.
+	if err := wf.store(ctx); err != nil {
+		return nil, err
+	}
+
+	wf.updateOrder(ctx, orderStatusDisputed)
+
+	wf.collectEvidence(ctx)
+
+	outcome, err := wf.awaitResolution(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &wf.status, wf.resolve(ctx, outcome)
+}
+
+// store records the current status of the dispute, retrying until it is stored.
+func (wf *disputeImpl) store(ctx workflow.Context) error {
+	ctx = workflow.WithActivityOptions(ctx,
+		workflow.ActivityOptions{
+			StartToCloseTimeout: 30 * time.Second,
+		},
+	)
+
+	return workflow.ExecuteActivity(ctx, a.StoreDispute, wf.status).Get(ctx, nil)
+}
+
+// updateOrder records the dispute on the order's status. Failing to do so does not affect the dispute.
+func (wf *disputeImpl) updateOrder(ctx workflow.Context, status string) {
+	ctx = workflow.WithActivityOptions(ctx,
+		workflow.ActivityOptions{
+			ScheduleToCloseTimeout: 5 * time.Minute,
+		},
+	)
+
+	err := workflow.ExecuteActivity(ctx,
+		a.UpdateOrderStatus,
+		OrderStatusUpdate{ID: orderReference(wf.status.InvoiceReference), Status: status},
+	).Get(ctx, nil)
+	if err != nil {
+		wf.logger.Warn("Failed to update order status", "invoice_reference", wf.status.InvoiceReference, "status", status, "error", err)
+	}
+}
+
+// collectEvidence gathers the shipment's tracking status as evidence for the issuer.
+// The dispute goes ahead without evidence if it cannot be collected.
+func (wf *disputeImpl) collectEvidence(ctx workflow.Context) {
+	ctx = workflow.WithActivityOptions(ctx,
+		workflow.ActivityOptions{
+			ScheduleToCloseTimeout: 5 * time.Minute,
+		},
+	)
+
+	var evidence DisputeEvidence
+
+	err := workflow.ExecuteActivity(ctx, a.CollectDisputeEvidence, wf.status.InvoiceReference).Get(ctx, &evidence)
+	if err != nil {
+		wf.logger.Warn("Failed to collect dispute evidence", "invoice_reference", wf.status.InvoiceReference, "error", err)
+		return
+	}
+
+	wf.status.Evidence = &evidence
+
+	if err := wf.store(ctx); err != nil {
+		wf.logger.Warn("Failed to store dispute evidence", "invoice_reference", wf.status.InvoiceReference, "error", err)
+	}
+}
+
+// awaitResolution waits for the issuer's decision, returning the outcome.
+// If the issuer has not ruled by the deadline the chargeback stands, and the dispute is lost.
+func (wf *disputeImpl) awaitResolution(ctx workflow.Context) (string, error) {
+	ch := workflow.GetSignalChannel(ctx, DisputeResolutionSignalName)
+
+	timerCtx, cancelTimer := workflow.WithCancel(ctx)
+	defer cancelTimer()
+
+	deadline := workflow.NewTimer(timerCtx, max(wf.status.RespondBy.Sub(workflow.Now(ctx)), 0))
+
+	for {
+		var resolution DisputeResolution
+		var err error
+
+		s := workflow.NewSelector(ctx)
+
+		s.AddFuture(deadline, func(f workflow.Future) {
+			if err = f.Get(timerCtx, nil); err != nil {
+				return
+			}
+
+			wf.logger.Info("Dispute deadline passed without a resolution", "respond_by", wf.status.RespondBy)
+
+			resolution.Outcome = DisputeStatusLost
+		})
+
+		s.AddReceive(ch, func(c workflow.ReceiveChannel, _ bool) {
+			c.Receive(ctx, &resolution)
+
+			wf.logger.Info("Received dispute resolution", "outcome", resolution.Outcome)
+		})
+
+		s.Select(ctx)
+
+		if err != nil {
+			return "", err
+		}
+
+		switch resolution.Outcome {
+		case DisputeStatusWon, DisputeStatusLost:
+			return resolution.Outcome, nil
+		}
+
+		wf.logger.Warn("Ignoring invalid dispute resolution", "outcome", resolution.Outcome)
+	}
+}
+
+// resolve records the outcome of the dispute, charging back a lost dispute in the ledger.
+func (wf *disputeImpl) resolve(ctx workflow.Context, outcome string) error {
+	wf.status.Status = outcome
+	wf.status.ResolvedAt = workflow.Now(ctx)
+
+	if outcome == DisputeStatusLost && !wf.status.Amount.IsZero() {
+		if err := wf.recordChargeback(ctx); err != nil {
+			wf.logger.Error("Failed to record chargeback in ledger", "customer_id", wf.status.CustomerID, "error", err)
+		}
+	}
+
+	if err := wf.store(ctx); err != nil {
+		return err
+	}
+
+	if outcome == DisputeStatusWon {
+		wf.updateOrder(ctx, orderStatusDisputeWon)
+	} else {
+		wf.updateOrder(ctx, orderStatusDisputeLost)
+	}
+
+	wf.logger.Info("Dispute resolved", "outcome", outcome, "amount", wf.status.Amount)
+
+	return nil
+}
+
+// recordChargeback records a lost dispute in the ledger, at the exchange rate of the disputed invoice.
+func (wf *disputeImpl) recordChargeback(ctx workflow.Context) error {
+	amount, err := toBase(wf.exchangeRate, wf.status.Amount)
+	if err != nil {
+		return err
+	}
+
+	transaction := ledger.NewChargeback(
+		"chargeback:"+wf.status.ID,
+		wf.status.CustomerID,
+		wf.status.InvoiceReference,
+		amount.Amount,
+		wf.status.ResolvedAt,
+	)
+
+	return recordLedgerTransaction(ctx, transaction)
+}
+
+type fraudReviewImpl struct {
+	status FraudReviewStatus
+
+	logger log.Logger
+}
+
+// FraudReview Workflow holds a charge the fraud check flagged until a manager approves or rejects it with the
+// FraudReviewDecision signal. A charge nobody has decided by the deadline is declined. The review is stored so that
+// managers can list the reviews awaiting a decision.
+func FraudReview(ctx workflow.Context, input *FraudReviewInput) (*FraudReviewStatus, error) {
+	wf := &fraudReviewImpl{
+		status: FraudReviewStatus{
+			ID:               input.ID,
+			InvoiceReference: input.InvoiceReference,
+			CustomerID:       input.CustomerID,
+			Amount:           input.Amount,
+			Currency:         input.Currency,
+			Reason:           input.Reason,
+			Status:           FraudReviewStatusPending,
+			ReviewBy:         input.ReviewBy,
+			OpenedAt:         workflow.Now(ctx),
+		},
+		logger: workflow.GetLogger(ctx),
+	}
+
+	var decision FraudReviewDecision
+
+	err := wf.store(ctx)
+	if err == nil {
+		decision, err = wf.awaitDecision(ctx)
+	}
+	if temporal.IsCanceledError(err) {
+		wf.withdraw(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	wf.status.Status = decision.Outcome
+	wf.status.Reviewer = decision.Reviewer
+	wf.status.Note = decision.Note
+	wf.status.ResolvedAt = workflow.Now(ctx)
+
+	wf.logger.Info("Fraud review resolved", "outcome", decision.Outcome, "amount", wf.status.Amount)
+
+	return &wf.status, wf.store(ctx)
+}
+
+// store records the current status of the review, retrying until it is stored.
+func (wf *fraudReviewImpl) store(ctx workflow.Context) error {
+	ctx = workflow.WithActivityOptions(ctx,
+		workflow.ActivityOptions{
+			StartToCloseTimeout: 30 * time.Second,
+		},
+	)
+
+	return workflow.ExecuteActivity(ctx, a.StoreFraudReview, wf.status).Get(ctx, nil)
+}
+
+// awaitDecision waits for a manager's decision. If nobody has decided by the deadline the review expires.
+func (wf *fraudReviewImpl) awaitDecision(ctx workflow.Context) (FraudReviewDecision, error) {
+	ch := workflow.GetSignalChannel(ctx, FraudReviewDecisionSignalName)
+
+	timerCtx, cancelTimer := workflow.WithCancel(ctx)
+	defer cancelTimer()
+
+	deadline := workflow.NewTimer(timerCtx, max(wf.status.ReviewBy.Sub(workflow.Now(ctx)), 0))
+
+	for {
+		var decision FraudReviewDecision
+		var err error
+
+		s := workflow.NewSelector(ctx)
+
+		s.AddFuture(deadline, func(f workflow.Future) {
+			if err = f.Get(timerCtx, nil); err != nil {
+				return
+			}
+
+			wf.logger.Info("Fraud review deadline passed without a decision", "review_by", wf.status.ReviewBy)
+
+			decision.Outcome = FraudReviewStatusExpired
+		})
+
+		s.AddReceive(ch, func(c workflow.ReceiveChannel, _ bool) {
+			c.Receive(ctx, &decision)
+
+			wf.logger.Info("Received fraud review decision", "outcome", decision.Outcome, "reviewer", decision.Reviewer)
+		})
+
+		s.Select(ctx)
+
+		if err != nil {
+			return decision, err
+		}
+
+		switch decision.Outcome {
+		case FraudReviewStatusApproved, FraudReviewStatusRejected, FraudReviewStatusExpired:
+			return decision, nil
+		}
+
+		wf.logger.Warn("Ignoring invalid fraud review decision", "outcome", decision.Outcome)
+	}
+}
+
+// withdraw records that the charge was voided before the review was decided.
+func (wf *fraudReviewImpl) withdraw(ctx workflow.Context) {
+	ctx, _ = workflow.NewDisconnectedContext(ctx)
+
+	wf.status.Status = FraudReviewStatusWithdrawn
+	wf.status.ResolvedAt = workflow.Now(ctx)
+
+	if err := wf.store(ctx); err != nil {
+		wf.logger.Warn("Failed to store withdrawn fraud review", "customer_id", wf.status.CustomerID, "error", err)
+	}
 }
@@ -0,0 +1,833 @@
+package billing_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/temporalio/reference-app-orders-go/app/billing"
+	"github.com/temporalio/reference-app-orders-go/app/currency"
+	"github.com/temporalio/reference-app-orders-go/app/fraud"
+	"github.com/temporalio/reference-app-orders-go/app/ledger"
+	"go.temporal.io/sdk/testsuite"
+)
+
+// money returns an amount in an unspecified currency.
+func money(amount int64) currency.Money {
+	return currency.Money{Amount: amount}
+}
+
+var chargeInput = billing.ChargeInput{
+	CustomerID:     "1234",
+	Reference:      "1234:1",
+	Items:          []billing.Item{{SKU: "test1", Quantity: 3}, {SKU: "test2", Quantity: 1}},
+	IdempotencyKey: "charge",
+}
+
+var invoice = billing.GenerateInvoiceResult{
+	InvoiceReference: "1234:1",
+	Items: []billing.InvoiceItem{
+		{SKU: "test1", Quantity: 3, SubTotal: money(1200), Shipping: money(90), Tax: money(240), Total: money(1530)},
+		{SKU: "test2", Quantity: 1, SubTotal: money(200), Shipping: money(30), Tax: money(40), Total: money(270)},
+	},
+	SubTotal: money(1400),
+	Shipping: money(120),
+	Total:    money(1800),
+}
+
+func updateCharge(t *testing.T, env *testsuite.TestWorkflowEnvironment, name string, complete func(*billing.ChargeResult, error)) {
+	env.UpdateWorkflow(name, name, &testsuite.TestUpdateCallback{
+		OnReject: func(err error) {
+			complete(nil, err)
+		},
+		OnAccept: func() {},
+		OnComplete: func(result interface{}, err error) {
+			if err != nil {
+				complete(nil, err)
+				return
+			}
+			complete(result.(*billing.ChargeResult), nil)
+		},
+	})
+}
+
+// storedInvoices records the payment status of each invoice the Charge workflow stores.
+func storedInvoices(env *testsuite.TestWorkflowEnvironment) *[]string {
+	var a *billing.Activities
+	var statuses []string
+
+	env.OnActivity(a.StoreInvoice, mock.Anything, mock.Anything).Return(func(_ context.Context, invoice *billing.Invoice) error {
+		statuses = append(statuses, invoice.Status)
+		return nil
+	})
+
+	return &statuses
+}
+
+// releasedFraudCharges records the fraud key of each charge the workflow releases from the fraud check's tally.
+func releasedFraudCharges(env *testsuite.TestWorkflowEnvironment) *[]string {
+	var a *billing.Activities
+	var keys []string
+
+	env.OnActivity(a.ReleaseFraudCharge, mock.Anything, mock.Anything).Return(func(_ context.Context, fraudKey string) error {
+		keys = append(keys, fraudKey)
+		return nil
+	})
+
+	return &keys
+}
+
+// ledgerTransactions records each transaction the workflow records in the ledger.
+func ledgerTransactions(env *testsuite.TestWorkflowEnvironment) *[]ledger.Transaction {
+	var a *billing.Activities
+	var transactions []ledger.Transaction
+
+	env.OnActivity(a.RecordLedgerTransaction, mock.Anything, mock.Anything).Return(func(_ context.Context, transaction *ledger.Transaction) error {
+		transactions = append(transactions, *transaction)
+		return nil
+	})
+
+	return &transactions
+}
+
+func TestChargeAuthorizeThenCapture(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	var a *billing.Activities
+	invoices := storedInvoices(env)
+	transactions := ledgerTransactions(env)
+
+	env.OnActivity(a.GenerateInvoice, mock.Anything, mock.Anything).Return(&invoice, nil)
+	env.OnActivity(a.AuthorizePayment, mock.Anything, mock.Anything).Return(func(_ context.Context, _ *billing.AuthorizePaymentInput) (*billing.AuthorizePaymentResult, error) {
+		return &billing.AuthorizePaymentResult{Success: true, AuthCode: "1234", ExpiresAt: env.Now().Add(time.Hour)}, nil
+	})
+	env.OnActivity(a.CapturePayment, mock.Anything, mock.Anything).Return(nil).Once()
+
+	env.RegisterDelayedCallback(func() {
+		updateCharge(t, env, billing.AuthorizeUpdateName, func(result *billing.ChargeResult, err error) {
+			if assert.NoError(t, err) {
+				assert.Equal(t, billing.ChargeStatusAuthorized, result.Status)
+				assert.True(t, result.Success)
+			}
+		})
+	}, 0)
+
+	env.RegisterDelayedCallback(func() {
+		updateCharge(t, env, billing.CaptureUpdateName, func(result *billing.ChargeResult, err error) {
+			if assert.NoError(t, err) {
+				assert.Equal(t, billing.ChargeStatusCaptured, result.Status)
+			}
+		})
+	}, time.Minute)
+
+	env.ExecuteWorkflow(billing.Charge, &chargeInput)
+
+	var result billing.ChargeResult
+	err := env.GetWorkflowResult(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, billing.ChargeStatusCaptured, result.Status)
+	assert.Equal(t, []string{billing.ChargeStatusAuthorized, billing.ChargeStatusCaptured}, *invoices)
+	assert.Equal(t, money(1800), result.Total)
+
+	// The captured payment is recorded in the ledger, split between revenue, shipping and tax.
+	if assert.Len(t, *transactions, 1) {
+		transaction := (*transactions)[0]
+		assert.Equal(t, "charge:charge", transaction.ID)
+		assert.Equal(t, ledger.TransactionKindCharge, transaction.Kind)
+		assert.Equal(t, "1234:1", transaction.Reference)
+		assert.Equal(t, []ledger.Entry{
+			{Account: ledger.AccountReceivable, Debit: 1800},
+			{Account: ledger.AccountRevenue, Credit: 1400},
+			{Account: ledger.AccountShippingRevenue, Credit: 120},
+			{Account: ledger.AccountTaxPayable, Credit: 280},
+		}, transaction.Entries)
+		assert.NoError(t, transaction.Validate())
+	}
+	env.AssertExpectations(t)
+}
+
+func TestChargeInCurrency(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	var a *billing.Activities
+	storedInvoices(env)
+	transactions := ledgerTransactions(env)
+
+	rate := &currency.Rate{Base: "GBP", Currency: "EUR", Rate: 1250000}
+	eurInvoice := invoice
+	eurInvoice.Currency = "EUR"
+	eurInvoice.ExchangeRate = rate
+
+	env.OnActivity(a.GenerateInvoice, mock.Anything, mock.Anything).Return(func(_ context.Context, input *billing.GenerateInvoiceInput) (*billing.GenerateInvoiceResult, error) {
+		assert.Equal(t, rate, input.ExchangeRate)
+		return &eurInvoice, nil
+	})
+	env.OnActivity(a.AuthorizePayment, mock.Anything, mock.Anything).Return(func(_ context.Context, input *billing.AuthorizePaymentInput) (*billing.AuthorizePaymentResult, error) {
+		// The payment is taken in euros, but the fraud check tallies it in pounds.
+		assert.Equal(t, currency.NewMoney(1800, "EUR"), input.Charge)
+		assert.Equal(t, "EUR", input.Currency)
+		assert.Equal(t, money(1440), input.BaseCharge)
+		return &billing.AuthorizePaymentResult{Success: true, AuthCode: "1234", ExpiresAt: env.Now().Add(time.Hour)}, nil
+	})
+	env.OnActivity(a.CapturePayment, mock.Anything, mock.Anything).Return(nil).Once()
+
+	env.RegisterDelayedCallback(func() {
+		updateCharge(t, env, billing.CaptureUpdateName, func(_ *billing.ChargeResult, err error) {
+			assert.NoError(t, err)
+		})
+	}, time.Minute)
+
+	input := chargeInput
+	input.ExchangeRate = rate
+	env.ExecuteWorkflow(billing.Charge, &input)
+
+	var result billing.ChargeResult
+	err := env.GetWorkflowResult(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, "EUR", result.Currency)
+	assert.Equal(t, rate, result.ExchangeRate)
+	assert.Equal(t, currency.NewMoney(1800, "EUR"), result.Total)
+
+	// The ledger is kept in the base currency.
+	if assert.Len(t, *transactions, 1) {
+		assert.Equal(t, []ledger.Entry{
+			{Account: ledger.AccountReceivable, Debit: 1440},
+			{Account: ledger.AccountRevenue, Credit: 1120},
+			{Account: ledger.AccountShippingRevenue, Credit: 96},
+			{Account: ledger.AccountTaxPayable, Credit: 224},
+		}, (*transactions)[0].Entries)
+	}
+	env.AssertExpectations(t)
+}
+
+func TestChargeVoid(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	var a *billing.Activities
+	invoices := storedInvoices(env)
+
+	env.OnActivity(a.GenerateInvoice, mock.Anything, mock.Anything).Return(&invoice, nil)
+	env.OnActivity(a.AuthorizePayment, mock.Anything, mock.Anything).Return(func(_ context.Context, _ *billing.AuthorizePaymentInput) (*billing.AuthorizePaymentResult, error) {
+		return &billing.AuthorizePaymentResult{Success: true, AuthCode: "1234", ExpiresAt: env.Now().Add(time.Hour)}, nil
+	})
+	env.OnActivity(a.VoidAuthorization, mock.Anything, mock.Anything).Return(nil).Once()
+	released := releasedFraudCharges(env)
+
+	env.RegisterDelayedCallback(func() {
+		updateCharge(t, env, billing.VoidUpdateName, func(result *billing.ChargeResult, err error) {
+			if assert.NoError(t, err) {
+				assert.Equal(t, billing.ChargeStatusVoided, result.Status)
+			}
+		})
+	}, time.Minute)
+
+	env.RegisterDelayedCallback(func() {
+		updateCharge(t, env, billing.CaptureUpdateName, func(_ *billing.ChargeResult, err error) {
+			assert.ErrorContains(t, err, "payment is voided")
+		})
+	}, time.Minute)
+
+	env.ExecuteWorkflow(billing.Charge, &chargeInput)
+
+	var result billing.ChargeResult
+	err := env.GetWorkflowResult(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, billing.ChargeStatusVoided, result.Status)
+	assert.Equal(t, []string{billing.ChargeStatusAuthorized, billing.ChargeStatusVoided}, *invoices)
+	assert.Equal(t, []string{"1234:1"}, *released)
+	env.AssertExpectations(t)
+}
+
+func TestChargeAuthorizationExpires(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	var a *billing.Activities
+	invoices := storedInvoices(env)
+
+	env.OnActivity(a.GenerateInvoice, mock.Anything, mock.Anything).Return(&invoice, nil)
+	env.OnActivity(a.AuthorizePayment, mock.Anything, mock.Anything).Return(func(_ context.Context, _ *billing.AuthorizePaymentInput) (*billing.AuthorizePaymentResult, error) {
+		return &billing.AuthorizePaymentResult{Success: true, AuthCode: "1234", ExpiresAt: env.Now().Add(time.Hour)}, nil
+	})
+	env.OnActivity(a.VoidAuthorization, mock.Anything, mock.Anything).Return(nil).Once()
+	released := releasedFraudCharges(env)
+
+	start := env.Now()
+	env.ExecuteWorkflow(billing.Charge, &chargeInput)
+
+	var result billing.ChargeResult
+	err := env.GetWorkflowResult(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, billing.ChargeStatusExpired, result.Status)
+	assert.Equal(t, []string{billing.ChargeStatusAuthorized, billing.ChargeStatusExpired}, *invoices)
+	assert.GreaterOrEqual(t, env.Now().Sub(start), time.Hour)
+	assert.Equal(t, []string{"1234:1"}, *released)
+	env.AssertExpectations(t)
+}
+
+func TestChargeDeclined(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	var a *billing.Activities
+	invoices := storedInvoices(env)
+
+	env.OnActivity(a.GenerateInvoice, mock.Anything, mock.Anything).Return(&invoice, nil)
+	env.OnActivity(a.AuthorizePayment, mock.Anything, mock.Anything).Return(&billing.AuthorizePaymentResult{
+		Success:         false,
+		DeclineReason:   billing.DeclineReasonFraud,
+		FraudReason:     fraud.ReasonScore,
+		FraudDecisionID: "decision-1",
+		FraudScore:      95,
+	}, nil)
+
+	env.RegisterDelayedCallback(func() {
+		updateCharge(t, env, billing.AuthorizeUpdateName, func(result *billing.ChargeResult, err error) {
+			if assert.NoError(t, err) {
+				assert.Equal(t, billing.ChargeStatusDeclined, result.Status)
+				assert.False(t, result.Success)
+				assert.Equal(t, fraud.ReasonScore, result.FraudReason)
+				assert.Equal(t, "decision-1", result.FraudDecisionID)
+				assert.Equal(t, int32(95), result.FraudScore)
+			}
+		})
+	}, 0)
+
+	env.ExecuteWorkflow(billing.Charge, &chargeInput)
+
+	var result billing.ChargeResult
+	err := env.GetWorkflowResult(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, billing.ChargeStatusDeclined, result.Status)
+	assert.Equal(t, []string{billing.ChargeStatusDeclined}, *invoices)
+	env.AssertNotCalled(t, "ReleaseFraudCharge", mock.Anything, mock.Anything)
+}
+
+func TestChargeDeclinedByGatewayIsReleased(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	var a *billing.Activities
+	invoices := storedInvoices(env)
+	released := releasedFraudCharges(env)
+
+	env.OnActivity(a.GenerateInvoice, mock.Anything, mock.Anything).Return(&invoice, nil)
+	var fraudKey string
+	env.OnActivity(a.AuthorizePayment, mock.Anything, mock.Anything).Return(func(_ context.Context, input *billing.AuthorizePaymentInput) (*billing.AuthorizePaymentResult, error) {
+		fraudKey = input.FraudKey
+		return &billing.AuthorizePaymentResult{Success: false, DeclineReason: billing.DeclineReasonInsufficientFunds}, nil
+	})
+
+	env.ExecuteWorkflow(billing.Charge, &chargeInput)
+
+	var result billing.ChargeResult
+	err := env.GetWorkflowResult(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, billing.ChargeStatusDeclined, result.Status)
+	assert.Equal(t, []string{billing.ChargeStatusDeclined}, *invoices)
+
+	// The fraud check counted the charge, keyed on the fulfillment rather than this attempt to charge it, and it is
+	// released as the gateway declined it.
+	assert.Equal(t, "1234:1", fraudKey)
+	assert.Equal(t, []string{"1234:1"}, *released)
+}
+
+func TestChargeDeclinedReleasesPromotions(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	var a *billing.Activities
+	storedInvoices(env)
+	releasedFraudCharges(env)
+
+	discounted := invoice
+	discounted.Items = []billing.InvoiceItem{
+		invoice.Items[0],
+		{SKU: "test1", Promotion: "BOOTS10", SubTotal: money(-120), Total: money(-144)},
+		{SKU: "test1", Promotion: billing.LoyaltyDiscount, SubTotal: money(-100), Total: money(-120)},
+		invoice.Items[1],
+		{SKU: "test2", Promotion: "BOOTS10", SubTotal: money(-20), Total: money(-24)},
+	}
+	env.OnActivity(a.GenerateInvoice, mock.Anything, mock.Anything).Return(&discounted, nil)
+	env.OnActivity(a.AuthorizePayment, mock.Anything, mock.Anything).Return(&billing.AuthorizePaymentResult{Success: false, DeclineReason: billing.DeclineReasonDeclined}, nil)
+
+	var released []billing.ReleasePromotionsInput
+	env.OnActivity(a.ReleasePromotions, mock.Anything, mock.Anything).Return(func(_ context.Context, input *billing.ReleasePromotionsInput) error {
+		released = append(released, *input)
+		return nil
+	})
+
+	env.ExecuteWorkflow(billing.Charge, &chargeInput)
+
+	var result billing.ChargeResult
+	assert.NoError(t, env.GetWorkflowResult(&result))
+	assert.Equal(t, billing.ChargeStatusDeclined, result.Status)
+
+	// The coupon is given back once, so the customer can use it again, but loyalty points are not a coupon.
+	assert.Equal(t, []billing.ReleasePromotionsInput{{Reference: "1234:1", Codes: []string{"BOOTS10"}}}, released)
+}
+
+// heldForFraudReview mocks the AuthorizePayment activity to hold the charge for review until it is approved, and
+// records each status of the review that is stored.
+func heldForFraudReview(env *testsuite.TestWorkflowEnvironment) *[]string {
+	var a *billing.Activities
+	var statuses []string
+
+	env.RegisterWorkflow(billing.FraudReview)
+	env.OnActivity(a.AuthorizePayment, mock.Anything, mock.Anything).Return(func(_ context.Context, input *billing.AuthorizePaymentInput) (*billing.AuthorizePaymentResult, error) {
+		if !input.FraudApproved {
+			return &billing.AuthorizePaymentResult{Review: true, FraudReason: "limit", ReviewBy: env.Now().Add(24 * time.Hour)}, nil
+		}
+		return &billing.AuthorizePaymentResult{Success: true, AuthCode: "1234", ExpiresAt: env.Now().Add(time.Hour)}, nil
+	})
+	env.OnActivity(a.StoreFraudReview, mock.Anything, mock.Anything).Return(func(_ context.Context, review *billing.FraudReviewStatus) error {
+		statuses = append(statuses, review.Status)
+		return nil
+	})
+
+	return &statuses
+}
+
+func TestChargeApprovedByFraudReview(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	var a *billing.Activities
+	invoices := storedInvoices(env)
+	reviews := heldForFraudReview(env)
+
+	env.OnActivity(a.GenerateInvoice, mock.Anything, mock.Anything).Return(&invoice, nil)
+	env.OnActivity(a.CapturePayment, mock.Anything, mock.Anything).Return(nil).Once()
+
+	env.RegisterDelayedCallback(func() {
+		updateCharge(t, env, billing.AuthorizeUpdateName, func(result *billing.ChargeResult, err error) {
+			if assert.NoError(t, err) {
+				assert.Equal(t, billing.ChargeStatusReview, result.Status)
+				assert.Equal(t, "charge", result.FraudReviewID)
+			}
+		})
+	}, 0)
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflowByID(billing.FraudReviewWorkflowID("charge"), billing.FraudReviewDecisionSignalName,
+			billing.FraudReviewDecision{Outcome: billing.FraudReviewStatusApproved, Reviewer: "manager"})
+	}, time.Hour)
+
+	env.RegisterDelayedCallback(func() {
+		updateCharge(t, env, billing.CaptureUpdateName, func(result *billing.ChargeResult, err error) {
+			if assert.NoError(t, err) {
+				assert.Equal(t, billing.ChargeStatusCaptured, result.Status)
+			}
+		})
+	}, 90*time.Minute)
+
+	env.ExecuteWorkflow(billing.Charge, &chargeInput)
+
+	var result billing.ChargeResult
+	assert.NoError(t, env.GetWorkflowResult(&result))
+	assert.Equal(t, billing.ChargeStatusCaptured, result.Status)
+	assert.Equal(t, "limit", result.FraudReason)
+	assert.Equal(t, []string{billing.ChargeStatusReview, billing.ChargeStatusAuthorized, billing.ChargeStatusCaptured}, *invoices)
+	assert.Equal(t, []string{billing.FraudReviewStatusPending, billing.FraudReviewStatusApproved}, *reviews)
+}
+
+func TestChargeDeclinedWhenFraudReviewExpires(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	var a *billing.Activities
+	invoices := storedInvoices(env)
+	reviews := heldForFraudReview(env)
+
+	env.OnActivity(a.GenerateInvoice, mock.Anything, mock.Anything).Return(&invoice, nil)
+
+	start := env.Now()
+	env.ExecuteWorkflow(billing.Charge, &chargeInput)
+
+	var result billing.ChargeResult
+	assert.NoError(t, env.GetWorkflowResult(&result))
+	assert.Equal(t, billing.ChargeStatusDeclined, result.Status)
+	assert.Equal(t, billing.DeclineReasonFraud, result.DeclineReason)
+	assert.GreaterOrEqual(t, env.Now().Sub(start), 24*time.Hour)
+	assert.Equal(t, []string{billing.ChargeStatusReview, billing.ChargeStatusDeclined}, *invoices)
+	assert.Equal(t, []string{billing.FraudReviewStatusPending, billing.FraudReviewStatusExpired}, *reviews)
+}
+
+func TestChargeVoidedDuringFraudReview(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	var a *billing.Activities
+	invoices := storedInvoices(env)
+	reviews := heldForFraudReview(env)
+
+	env.OnActivity(a.GenerateInvoice, mock.Anything, mock.Anything).Return(&invoice, nil)
+
+	env.RegisterDelayedCallback(func() {
+		updateCharge(t, env, billing.VoidUpdateName, func(result *billing.ChargeResult, err error) {
+			if assert.NoError(t, err) {
+				assert.Equal(t, billing.ChargeStatusVoided, result.Status)
+			}
+		})
+	}, time.Hour)
+
+	env.ExecuteWorkflow(billing.Charge, &chargeInput)
+
+	var result billing.ChargeResult
+	assert.NoError(t, env.GetWorkflowResult(&result))
+	assert.Equal(t, billing.ChargeStatusVoided, result.Status)
+	assert.Equal(t, []string{billing.ChargeStatusReview, billing.ChargeStatusVoided}, *invoices)
+	assert.Equal(t, []string{billing.FraudReviewStatusPending, billing.FraudReviewStatusWithdrawn}, *reviews)
+	env.AssertNotCalled(t, "VoidAuthorization", mock.Anything, mock.Anything)
+}
+
+// queuedDuringFraudMaintenance has the fraud check down for maintenance for the first hour of the test, queuing
+// every charge until then.
+func queuedDuringFraudMaintenance(env *testsuite.TestWorkflowEnvironment) {
+	var a *billing.Activities
+	end := env.Now().Add(time.Hour)
+
+	env.OnActivity(a.AuthorizePayment, mock.Anything, mock.Anything).Return(func(_ context.Context, _ *billing.AuthorizePaymentInput) (*billing.AuthorizePaymentResult, error) {
+		if env.Now().Before(end) {
+			return &billing.AuthorizePaymentResult{Queued: true, RetryAt: end, MaintenanceReason: "Database upgrade"}, nil
+		}
+		return &billing.AuthorizePaymentResult{Success: true, AuthCode: "1234", ExpiresAt: env.Now().Add(7 * 24 * time.Hour)}, nil
+	})
+}
+
+func TestChargeQueuedDuringFraudMaintenance(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	var a *billing.Activities
+	invoices := storedInvoices(env)
+	queuedDuringFraudMaintenance(env)
+
+	env.OnActivity(a.GenerateInvoice, mock.Anything, mock.Anything).Return(&invoice, nil)
+	env.OnActivity(a.CapturePayment, mock.Anything, mock.Anything).Return(nil).Once()
+
+	env.RegisterDelayedCallback(func() {
+		updateCharge(t, env, billing.AuthorizeUpdateName, func(result *billing.ChargeResult, err error) {
+			if assert.NoError(t, err) {
+				assert.Equal(t, billing.ChargeStatusQueued, result.Status)
+				assert.Equal(t, "Database upgrade", result.MaintenanceReason)
+				assert.False(t, result.RetryAt.IsZero())
+			}
+		})
+	}, 0)
+
+	env.RegisterDelayedCallback(func() {
+		updateCharge(t, env, billing.CaptureUpdateName, func(result *billing.ChargeResult, err error) {
+			if assert.NoError(t, err) {
+				assert.Equal(t, billing.ChargeStatusCaptured, result.Status)
+			}
+		})
+	}, 2*time.Hour)
+
+	start := env.Now()
+	env.ExecuteWorkflow(billing.Charge, &chargeInput)
+
+	var result billing.ChargeResult
+	assert.NoError(t, env.GetWorkflowResult(&result))
+	assert.Equal(t, billing.ChargeStatusCaptured, result.Status)
+	assert.Empty(t, result.MaintenanceReason)
+	assert.GreaterOrEqual(t, env.Now().Sub(start), 2*time.Hour)
+	assert.Equal(t, []string{billing.ChargeStatusQueued, billing.ChargeStatusAuthorized, billing.ChargeStatusCaptured}, *invoices)
+}
+
+func TestChargeVoidedWhileQueued(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	var a *billing.Activities
+	invoices := storedInvoices(env)
+	queuedDuringFraudMaintenance(env)
+
+	env.OnActivity(a.GenerateInvoice, mock.Anything, mock.Anything).Return(&invoice, nil)
+
+	env.RegisterDelayedCallback(func() {
+		updateCharge(t, env, billing.VoidUpdateName, func(result *billing.ChargeResult, err error) {
+			if assert.NoError(t, err) {
+				assert.Equal(t, billing.ChargeStatusVoided, result.Status)
+			}
+		})
+	}, 30*time.Minute)
+
+	env.ExecuteWorkflow(billing.Charge, &chargeInput)
+
+	var result billing.ChargeResult
+	assert.NoError(t, env.GetWorkflowResult(&result))
+	assert.Equal(t, billing.ChargeStatusVoided, result.Status)
+	assert.Equal(t, []string{billing.ChargeStatusQueued, billing.ChargeStatusVoided}, *invoices)
+	env.AssertNotCalled(t, "VoidAuthorization", mock.Anything, mock.Anything)
+}
+
+var charge = billing.ChargeResult{
+	InvoiceReference: "1234:1",
+	Items: []billing.InvoiceItem{
+		{SKU: "test1", Quantity: 3, SubTotal: money(1000), Shipping: money(100), Tax: money(200), Total: money(1300)},
+		{SKU: "test2", Quantity: 1, SubTotal: money(400), Shipping: money(20), Tax: money(80), Total: money(500)},
+	},
+	SubTotal: money(1400),
+	Shipping: money(120),
+	Tax:      money(280),
+	Total:    money(1800),
+	Success:  true,
+	Status:   billing.ChargeStatusCaptured,
+}
+
+var refundInput = billing.RefundWorkflowInput{
+	CustomerID:           "1234",
+	InvoiceReference:     "1234:1",
+	ChargeIdempotencyKey: "charge",
+}
+
+func requestRefund(env *testsuite.TestWorkflowEnvironment, id string, items []billing.Item, complete func(*billing.RefundResult, error)) {
+	env.UpdateWorkflow(billing.RefundUpdateName, id, &testsuite.TestUpdateCallback{
+		OnReject: func(err error) {
+			complete(nil, err)
+		},
+		OnAccept: func() {},
+		OnComplete: func(result interface{}, err error) {
+			if err != nil {
+				complete(nil, err)
+				return
+			}
+			complete(result.(*billing.RefundResult), nil)
+		},
+	}, &billing.RefundRequest{Items: items})
+}
+
+func TestRefundPartialThenFull(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	var a *billing.Activities
+	transactions := ledgerTransactions(env)
+
+	env.OnActivity(a.GetCharge, mock.Anything, "charge").Return(&charge, nil)
+	var refunded []int64
+	env.OnActivity(a.RefundCustomer, mock.Anything, mock.Anything).Return(func(_ context.Context, input *billing.RefundCustomerInput) (*billing.RefundCustomerResult, error) {
+		refunded = append(refunded, input.Refund.Amount)
+		return &billing.RefundCustomerResult{AuthCode: "1234"}, nil
+	})
+
+	env.RegisterDelayedCallback(func() {
+		requestRefund(env, "first", []billing.Item{{SKU: "test1", Quantity: 1}}, func(result *billing.RefundResult, err error) {
+			if assert.NoError(t, err) {
+				assert.Equal(t, []billing.InvoiceItem{
+					{SKU: "test1", Quantity: 1, SubTotal: money(333), Shipping: money(33), Tax: money(66), Total: money(432)},
+				}, result.Items)
+				assert.Equal(t, money(432), result.Total)
+				assert.Equal(t, money(432), result.TotalRefunded)
+			}
+		})
+	}, time.Second)
+
+	env.RegisterDelayedCallback(func() {
+		requestRefund(env, "second", []billing.Item{{SKU: "test1", Quantity: 2}}, func(result *billing.RefundResult, err error) {
+			if assert.NoError(t, err) {
+				// The last of a SKU refunds whatever is left of its charge.
+				assert.Equal(t, []billing.InvoiceItem{
+					{SKU: "test1", Quantity: 2, SubTotal: money(667), Shipping: money(67), Tax: money(134), Total: money(868)},
+				}, result.Items)
+				assert.Equal(t, money(1300), result.TotalRefunded)
+			}
+		})
+	}, time.Second*2)
+
+	env.RegisterDelayedCallback(func() {
+		requestRefund(env, "rest", nil, func(result *billing.RefundResult, err error) {
+			if assert.NoError(t, err) {
+				assert.Equal(t, []billing.InvoiceItem{
+					{SKU: "test2", Quantity: 1, SubTotal: money(400), Shipping: money(20), Tax: money(80), Total: money(500)},
+				}, result.Items)
+				assert.Equal(t, money(1800), result.TotalRefunded)
+			}
+		})
+	}, time.Second*3)
+
+	start := env.Now()
+	env.ExecuteWorkflow(billing.Refund, &refundInput)
+
+	var status billing.RefundStatus
+	err := env.GetWorkflowResult(&status)
+	assert.NoError(t, err)
+	assert.Equal(t, money(1800), status.Charged)
+	assert.Equal(t, money(1800), status.Refunded)
+	assert.Equal(t, []int64{432, 868, 500}, refunded)
+
+	// Each refund reverses its share of the charge in the ledger.
+	if assert.Len(t, *transactions, 3) {
+		assert.Equal(t, "refund:first", (*transactions)[0].ID)
+		assert.Equal(t, []ledger.Entry{
+			{Account: ledger.AccountRevenue, Debit: 333},
+			{Account: ledger.AccountShippingRevenue, Debit: 33},
+			{Account: ledger.AccountTaxPayable, Debit: 66},
+			{Account: ledger.AccountReceivable, Credit: 432},
+		}, (*transactions)[0].Entries)
+		for _, transaction := range *transactions {
+			assert.Equal(t, ledger.TransactionKindRefund, transaction.Kind)
+			assert.NoError(t, transaction.Validate())
+		}
+	}
+
+	// The workflow closes as soon as everything has been refunded.
+	assert.Less(t, env.Now().Sub(start), time.Minute)
+}
+
+func TestRefundCannotExceedCharge(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	var a *billing.Activities
+	ledgerTransactions(env)
+
+	env.OnActivity(a.GetCharge, mock.Anything, "charge").Return(&charge, nil)
+	env.OnActivity(a.RefundCustomer, mock.Anything, mock.Anything).Return(&billing.RefundCustomerResult{AuthCode: "1234"}, nil)
+
+	env.RegisterDelayedCallback(func() {
+		requestRefund(env, "too-many", []billing.Item{{SKU: "test1", Quantity: 4}}, func(_ *billing.RefundResult, err error) {
+			assert.ErrorContains(t, err, "cannot refund 4 of test1")
+		})
+		requestRefund(env, "unknown", []billing.Item{{SKU: "test3", Quantity: 1}}, func(_ *billing.RefundResult, err error) {
+			assert.ErrorContains(t, err, "test3 was not charged")
+		})
+		requestRefund(env, "invalid", []billing.Item{{SKU: "test1", Quantity: 0}}, func(_ *billing.RefundResult, err error) {
+			assert.ErrorContains(t, err, "positive quantity")
+		})
+	}, time.Second)
+
+	env.RegisterDelayedCallback(func() {
+		requestRefund(env, "all-test1", []billing.Item{{SKU: "test1", Quantity: 3}}, func(result *billing.RefundResult, err error) {
+			if assert.NoError(t, err) {
+				assert.Equal(t, money(1300), result.Total)
+			}
+		})
+	}, time.Second*2)
+
+	env.RegisterDelayedCallback(func() {
+		requestRefund(env, "again", []billing.Item{{SKU: "test1", Quantity: 1}}, func(_ *billing.RefundResult, err error) {
+			assert.ErrorContains(t, err, "cannot refund 1 of test1, 3 charged and 3 already refunded")
+		})
+	}, time.Second*3)
+
+	env.ExecuteWorkflow(billing.Refund, &refundInput)
+
+	var status billing.RefundStatus
+	err := env.GetWorkflowResult(&status)
+	assert.NoError(t, err)
+	assert.Equal(t, money(1800), status.Charged)
+	assert.Equal(t, money(1300), status.Refunded)
+	assert.Equal(t, []billing.InvoiceItem{
+		{SKU: "test1", Quantity: 3, SubTotal: money(1000), Shipping: money(100), Tax: money(200), Total: money(1300)},
+	}, status.Items)
+}
+
+func TestRefundUncapturedCharge(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	var a *billing.Activities
+
+	voided := charge
+	voided.Status = billing.ChargeStatusVoided
+
+	env.OnActivity(a.GetCharge, mock.Anything, "charge").Return(&voided, nil)
+
+	env.RegisterDelayedCallback(func() {
+		requestRefund(env, "first", nil, func(_ *billing.RefundResult, err error) {
+			assert.ErrorContains(t, err, "payment is voided")
+		})
+	}, 0)
+
+	env.ExecuteWorkflow(billing.Refund, &refundInput)
+
+	err := env.GetWorkflowError()
+	assert.ErrorContains(t, err, "payment is voided")
+}
+
+var disputeInput = billing.DisputeInput{
+	ID:               "dispute1",
+	InvoiceReference: "1234:1",
+	CustomerID:       "1234",
+	Amount:           money(1800),
+	Reason:           "not received",
+}
+
+// disputeActivities mocks the Dispute workflow's activities, recording each dispute status stored and each order
+// status set.
+func disputeActivities(env *testsuite.TestWorkflowEnvironment) (*[]billing.DisputeStatus, *[]string) {
+	var a *billing.Activities
+	var stored []billing.DisputeStatus
+	var orderStatuses []string
+
+	env.OnActivity(a.StoreDispute, mock.Anything, mock.Anything).Return(func(_ context.Context, dispute *billing.DisputeStatus) error {
+		stored = append(stored, *dispute)
+		return nil
+	})
+	env.OnActivity(a.UpdateOrderStatus, mock.Anything, mock.Anything).Return(func(_ context.Context, status *billing.OrderStatusUpdate) error {
+		orderStatuses = append(orderStatuses, status.ID+" "+status.Status)
+		return nil
+	})
+	env.OnActivity(a.CollectDisputeEvidence, mock.Anything, "1234:1").Return(&billing.DisputeEvidence{ShipmentStatus: "delivered"}, nil)
+
+	return &stored, &orderStatuses
+}
+
+func TestDisputeWon(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	transactions := ledgerTransactions(env)
+	stored, orderStatuses := disputeActivities(env)
+
+	env.RegisterDelayedCallback(func() {
+		// Unknown outcomes are ignored.
+		env.SignalWorkflow(billing.DisputeResolutionSignalName, billing.DisputeResolution{Outcome: "maybe"})
+	}, 24*time.Hour)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(billing.DisputeResolutionSignalName, billing.DisputeResolution{Outcome: billing.DisputeStatusWon})
+	}, 48*time.Hour)
+
+	start := env.Now()
+	env.ExecuteWorkflow(billing.Dispute, &disputeInput)
+
+	var status billing.DisputeStatus
+	assert.NoError(t, env.GetWorkflowResult(&status))
+	assert.Equal(t, billing.DisputeStatusWon, status.Status)
+	assert.Equal(t, start.Add(30*24*time.Hour).UTC(), status.RespondBy.UTC())
+	assert.Equal(t, start.Add(48*time.Hour).UTC(), status.ResolvedAt.UTC())
+
+	// The dispute is stored as soon as it is opened, which freezes the invoice, then again with the evidence.
+	if assert.Len(t, *stored, 3) {
+		assert.Equal(t, billing.DisputeStatusOpen, (*stored)[0].Status)
+		assert.Nil(t, (*stored)[0].Evidence)
+		assert.Equal(t, billing.DisputeStatusOpen, (*stored)[1].Status)
+		assert.Equal(t, "delivered", (*stored)[1].Evidence.ShipmentStatus)
+		assert.Equal(t, status, (*stored)[2])
+	}
+	assert.Equal(t, []string{"1234 disputed", "1234 disputeWon"}, *orderStatuses)
+	assert.Empty(t, *transactions)
+}
+
+func TestDisputeLostAtDeadline(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	transactions := ledgerTransactions(env)
+	stored, orderStatuses := disputeActivities(env)
+
+	input := disputeInput
+	input.Amount = money(500)
+	input.RespondBy = env.Now().Add(7 * 24 * time.Hour)
+
+	env.ExecuteWorkflow(billing.Dispute, &input)
+
+	var status billing.DisputeStatus
+	assert.NoError(t, env.GetWorkflowResult(&status))
+	assert.Equal(t, billing.DisputeStatusLost, status.Status)
+	assert.Equal(t, input.RespondBy.UTC(), status.ResolvedAt.UTC())
+	assert.Equal(t, billing.DisputeStatusLost, (*stored)[len(*stored)-1].Status)
+	assert.Equal(t, []string{"1234 disputed", "1234 disputeLost"}, *orderStatuses)
+
+	// The disputed amount is taken back from the business as a loss.
+	if assert.Len(t, *transactions, 1) {
+		transaction := (*transactions)[0]
+		assert.Equal(t, "chargeback:dispute1", transaction.ID)
+		assert.Equal(t, ledger.TransactionKindChargeback, transaction.Kind)
+		assert.Equal(t, []ledger.Entry{
+			{Account: ledger.AccountChargebackLosses, Debit: 500},
+			{Account: ledger.AccountReceivable, Credit: 500},
+		}, transaction.Entries)
+		assert.NoError(t, transaction.Validate())
+	}
+}
@@ -0,0 +1,175 @@
+package catalog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/temporalio/reference-app-orders-go/app/db"
+)
+
+const (
+	// TaxClassStandard is the tax class for goods taxed at the standard rate.
+	TaxClassStandard = "standard"
+
+	// TaxClassReduced is the tax class for goods taxed at a reduced rate.
+	TaxClassReduced = "reduced"
+
+	// TaxClassZero is the tax class for goods which are not taxed.
+	TaxClassZero = "zero"
+)
+
+// TaxClasses is the list of known tax classes.
+var TaxClasses = []string{TaxClassStandard, TaxClassReduced, TaxClassZero}
+
+// Product is a SKU in the product catalog.
+type Product struct {
+	SKU  string `json:"sku"`
+	Name string `json:"name"`
+	// UnitPrice is the price of a single unit, in cents.
+	UnitPrice int32 `json:"unitPrice"`
+	// Weight is the shipping weight of a single unit, in grams.
+	Weight   int32  `json:"weight"`
+	TaxClass string `json:"taxClass"`
+}
+
+type handlers struct {
+	db     db.DB
+	logger *slog.Logger
+}
+
+// Router implements the http.Handler interface for the Catalog API
+func Router(db db.DB, logger *slog.Logger) http.Handler {
+	r := http.NewServeMux()
+
+	h := handlers{db: db, logger: logger}
+
+	r.HandleFunc("GET /products", h.handleListProducts)
+	r.HandleFunc("POST /products", h.handleUpsertProduct)
+	r.HandleFunc("GET /products/{sku}", h.handleGetProduct)
+	r.HandleFunc("DELETE /products/{sku}", h.handleDeleteProduct)
+
+	return r
+}
+
+func (h *handlers) handleListProducts(w http.ResponseWriter, r *http.Request) {
+	products := []db.Product{}
+
+	err := h.db.GetProducts(r.Context(), &products)
+	if err != nil {
+		h.logger.Error("Failed to list products", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	list := make([]Product, len(products))
+	for i, p := range products {
+		list[i] = productFromDB(p)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		h.logger.Error("Failed to encode products", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h *handlers) handleGetProduct(w http.ResponseWriter, r *http.Request) {
+	var product db.Product
+
+	err := h.db.GetProduct(r.Context(), r.PathValue("sku"), &product)
+	if err != nil {
+		if errors.Is(err, db.ErrProductNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			h.logger.Error("Failed to get product", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(productFromDB(product)); err != nil {
+		h.logger.Error("Failed to encode product", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h *handlers) handleUpsertProduct(w http.ResponseWriter, r *http.Request) {
+	var input Product
+
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		h.logger.Error("Failed to decode product", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if input.TaxClass == "" {
+		input.TaxClass = TaxClassStandard
+	}
+
+	if err := validateProduct(input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.db.UpsertProduct(r.Context(), &db.Product{
+		SKU:       input.SKU,
+		Name:      input.Name,
+		UnitPrice: input.UnitPrice,
+		Weight:    input.Weight,
+		TaxClass:  input.TaxClass,
+	})
+	if err != nil {
+		h.logger.Error("Failed to store product", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *handlers) handleDeleteProduct(w http.ResponseWriter, r *http.Request) {
+	err := h.db.DeleteProduct(r.Context(), r.PathValue("sku"))
+	if err != nil {
+		if errors.Is(err, db.ErrProductNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			h.logger.Error("Failed to delete product", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func validateProduct(p Product) error {
+	if p.SKU == "" || p.Name == "" {
+		return fmt.Errorf("sku and name are required")
+	}
+	if p.UnitPrice < 0 || p.Weight < 0 {
+		return fmt.Errorf("unitPrice and weight must not be negative")
+	}
+	if !slices.Contains(TaxClasses, p.TaxClass) {
+		return fmt.Errorf("unknown tax class: %s", p.TaxClass)
+	}
+
+	return nil
+}
+
+func productFromDB(p db.Product) Product {
+	return Product{
+		SKU:       p.SKU,
+		Name:      p.Name,
+		UnitPrice: p.UnitPrice,
+		Weight:    p.Weight,
+		TaxClass:  p.TaxClass,
+	}
+}
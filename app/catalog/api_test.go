@@ -0,0 +1,90 @@
+package catalog_test
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/temporalio/reference-app-orders-go/app/catalog"
+	"github.com/temporalio/reference-app-orders-go/app/db"
+)
+
+func newRouter(t *testing.T) http.Handler {
+	store := db.NewSQLiteDB(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, store.Connect(context.Background()))
+	require.NoError(t, store.Setup())
+	t.Cleanup(func() { store.Close() })
+
+	return catalog.Router(store, slog.Default())
+}
+
+func do(t *testing.T, r http.Handler, method string, path string, body string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, path, strings.NewReader(body))
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	return rr
+}
+
+func TestProductLifecycle(t *testing.T) {
+	r := newRouter(t)
+
+	rr := do(t, r, "POST", "/products", `{"sku":"Nike Air","name":"Nike Air Max","unitPrice":12000,"weight":900}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = do(t, r, "GET", "/products/Nike Air", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var product catalog.Product
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&product))
+	require.Equal(t, catalog.Product{
+		SKU:       "Nike Air",
+		Name:      "Nike Air Max",
+		UnitPrice: 12000,
+		Weight:    900,
+		TaxClass:  catalog.TaxClassStandard,
+	}, product)
+
+	rr = do(t, r, "POST", "/products", `{"sku":"Nike Air","name":"Nike Air Max","unitPrice":9900,"weight":900,"taxClass":"reduced"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = do(t, r, "GET", "/products", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var products []catalog.Product
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&products))
+	require.Len(t, products, 1)
+	require.Equal(t, int32(9900), products[0].UnitPrice)
+	require.Equal(t, catalog.TaxClassReduced, products[0].TaxClass)
+
+	rr = do(t, r, "DELETE", "/products/Nike Air", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	rr = do(t, r, "GET", "/products/Nike Air", "")
+	require.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = do(t, r, "DELETE", "/products/Nike Air", "")
+	require.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestInvalidProducts(t *testing.T) {
+	r := newRouter(t)
+
+	for _, body := range []string{
+		`{"name":"No SKU","unitPrice":100,"weight":100}`,
+		`{"sku":"no-name","unitPrice":100,"weight":100}`,
+		`{"sku":"negative","name":"Negative","unitPrice":-1,"weight":100}`,
+		`{"sku":"unknown-tax","name":"Unknown tax","unitPrice":100,"weight":100,"taxClass":"luxury"}`,
+	} {
+		rr := do(t, r, "POST", "/products", body)
+		require.Equal(t, http.StatusBadRequest, rr.Code, body)
+	}
+}
@@ -4,20 +4,52 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"time"
 )
 
-// AppConfig is a struct that holds the configuration for the Order/Shipment/Fraud/Billing system.
+// AppConfig is a struct that holds the configuration for the Order/Shipment/Fraud/Billing/Inventory/Catalog system.
 type AppConfig struct {
-	BindOnIP     string
-	MongoURL     string
-	BillingPort  int32
-	BillingURL   string
-	OrderPort    int32
-	OrderURL     string
-	ShipmentPort int32
-	ShipmentURL  string
-	FraudPort    int32
-	FraudURL     string
+	BindOnIP      string
+	MongoURL      string
+	BillingPort   int32
+	BillingURL    string
+	OrderPort     int32
+	OrderURL      string
+	ShipmentPort  int32
+	ShipmentURL   string
+	FraudPort     int32
+	FraudURL      string
+	InventoryPort int32
+	InventoryURL  string
+	CatalogPort   int32
+	CatalogURL    string
+	// TaxRulesFile is the path to a JSON file of tax rules, or empty to use the built-in rules.
+	TaxRulesFile string
+	// ShippingRatesFile is the path to a JSON file of shipping rates, or empty to use the built-in rates.
+	ShippingRatesFile string
+	// ExchangeRatesFile is the path to a JSON file of currency exchange rates, or empty to use the built-in rates.
+	ExchangeRatesFile string
+	// LoyaltyRulesFile is the path to a JSON file of loyalty points rules, or empty to use the built-in rules.
+	LoyaltyRulesFile string
+	// FraudRulesFile is the path to a JSON file of fraud rules, which replace the stored rules when the Fraud API
+	// starts, or empty to keep the stored rules.
+	FraudRulesFile string
+	// FraudReviewTimeout is how long a manager has to review a charge the fraud check holds before it is declined, or
+	// zero for a day.
+	FraudReviewTimeout time.Duration
+	// FraudMaintenanceAction is what Billing does with a charge while the fraud check is down for maintenance:
+	// "queue" to authorize it once the maintenance window ends, or "decline". Empty means "queue".
+	FraudMaintenanceAction string
+	// PaymentGateway is the payment gateway used by Billing, or empty for the default. Only "simulator" is currently
+	// supported.
+	PaymentGateway string
+	// PaymentGatewayLatency is how long the simulated payment gateway takes to respond to each request.
+	PaymentGatewayLatency time.Duration
+	// PaymentGatewayDeclineRate is the fraction of otherwise valid authorizations the simulated payment gateway declines.
+	PaymentGatewayDeclineRate float64
+	// PaymentGatewayTimeoutRate is the fraction of requests the simulated payment gateway carries out but does not
+	// respond to.
+	PaymentGatewayTimeoutRate float64
 }
 
 // ServiceHostPort returns the host:port for a given service.
@@ -33,6 +65,10 @@ func (c *AppConfig) ServiceHostPort(service string) (string, error) {
 		port = c.OrderPort
 	case "shipment":
 		port = c.ShipmentPort
+	case "inventory":
+		port = c.InventoryPort
+	case "catalog":
+		port = c.CatalogPort
 	default:
 		return "", fmt.Errorf("unknown service: %s", service)
 	}
@@ -43,16 +79,20 @@ func (c *AppConfig) ServiceHostPort(service string) (string, error) {
 // AppConfigFromEnv creates an AppConfig from environment variables.
 func AppConfigFromEnv() (AppConfig, error) {
 	conf := AppConfig{
-		BindOnIP:     "127.0.0.1",
-		MongoURL:     "",
-		BillingPort:  8081,
-		BillingURL:   "http://127.0.0.1:8081",
-		OrderPort:    8082,
-		OrderURL:     "http://127.0.0.1:8082",
-		ShipmentPort: 8083,
-		ShipmentURL:  "http://127.0.0.1:8083",
-		FraudPort:    8084,
-		FraudURL:     "http://127.0.0.1:8084",
+		BindOnIP:      "127.0.0.1",
+		MongoURL:      "",
+		BillingPort:   8081,
+		BillingURL:    "http://127.0.0.1:8081",
+		OrderPort:     8082,
+		OrderURL:      "http://127.0.0.1:8082",
+		ShipmentPort:  8083,
+		ShipmentURL:   "http://127.0.0.1:8083",
+		FraudPort:     8084,
+		FraudURL:      "http://127.0.0.1:8084",
+		InventoryPort: 8085,
+		InventoryURL:  "http://127.0.0.1:8085",
+		CatalogPort:   8086,
+		CatalogURL:    "http://127.0.0.1:8086",
 	}
 
 	if ip := os.Getenv("BIND_ON_IP"); ip != "" {
@@ -111,5 +151,105 @@ func AppConfigFromEnv() (AppConfig, error) {
 		conf.FraudPort = int32(v)
 	}
 
+	if p := os.Getenv("INVENTORY_API_URL"); p != "" {
+		conf.InventoryURL = p
+	}
+
+	if p := os.Getenv("INVENTORY_API_PORT"); p != "" {
+		v, err := strconv.Atoi(p)
+		if err != nil {
+			return conf, err
+		}
+		conf.InventoryPort = int32(v)
+	}
+
+	if p := os.Getenv("CATALOG_API_URL"); p != "" {
+		conf.CatalogURL = p
+	}
+
+	if p := os.Getenv("CATALOG_API_PORT"); p != "" {
+		v, err := strconv.Atoi(p)
+		if err != nil {
+			return conf, err
+		}
+		conf.CatalogPort = int32(v)
+	}
+
+	if p := os.Getenv("TAX_RULES_FILE"); p != "" {
+		conf.TaxRulesFile = p
+	}
+
+	if p := os.Getenv("SHIPPING_RATES_FILE"); p != "" {
+		conf.ShippingRatesFile = p
+	}
+
+	if p := os.Getenv("EXCHANGE_RATES_FILE"); p != "" {
+		conf.ExchangeRatesFile = p
+	}
+
+	if p := os.Getenv("LOYALTY_RULES_FILE"); p != "" {
+		conf.LoyaltyRulesFile = p
+	}
+
+	if p := os.Getenv("FRAUD_RULES_FILE"); p != "" {
+		conf.FraudRulesFile = p
+	}
+
+	if p := os.Getenv("FRAUD_REVIEW_TIMEOUT"); p != "" {
+		v, err := time.ParseDuration(p)
+		if err != nil {
+			return conf, err
+		}
+		if v <= 0 {
+			return conf, fmt.Errorf("FRAUD_REVIEW_TIMEOUT must be positive")
+		}
+		conf.FraudReviewTimeout = v
+	}
+
+	if p := os.Getenv("FRAUD_MAINTENANCE_ACTION"); p != "" {
+		if p != "queue" && p != "decline" {
+			return conf, fmt.Errorf("FRAUD_MAINTENANCE_ACTION must be \"queue\" or \"decline\"")
+		}
+		conf.FraudMaintenanceAction = p
+	}
+
+	if p := os.Getenv("PAYMENT_GATEWAY"); p != "" {
+		conf.PaymentGateway = p
+	}
+
+	if p := os.Getenv("PAYMENT_GATEWAY_LATENCY"); p != "" {
+		v, err := time.ParseDuration(p)
+		if err != nil {
+			return conf, err
+		}
+		conf.PaymentGatewayLatency = v
+	}
+
+	if p := os.Getenv("PAYMENT_GATEWAY_DECLINE_RATE"); p != "" {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil {
+			return conf, err
+		}
+		if v < 0 || v > 1 {
+			return conf, fmt.Errorf("PAYMENT_GATEWAY_DECLINE_RATE must be between 0 and 1")
+		}
+		conf.PaymentGatewayDeclineRate = v
+	}
+
+	if p := os.Getenv("PAYMENT_GATEWAY_TIMEOUT_RATE"); p != "" {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil {
+			return conf, err
+		}
+		if v < 0 || v > 1 {
+			return conf, fmt.Errorf("PAYMENT_GATEWAY_TIMEOUT_RATE must be between 0 and 1")
+		}
+		conf.PaymentGatewayTimeoutRate = v
+	}
+
+	if conf.PaymentGatewayDeclineRate+conf.PaymentGatewayTimeoutRate > 1 {
+		return conf, fmt.Errorf("PAYMENT_GATEWAY_DECLINE_RATE and PAYMENT_GATEWAY_TIMEOUT_RATE must add up to at most 1")
+	}
+
 	return conf, nil
 }
@@ -0,0 +1,146 @@
+package currency
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// RateScale is the scale of exchange rates, which are given in millionths. A rate of RateScale leaves amounts unchanged.
+const RateScale = 1000000
+
+// ErrUnsupportedCurrency is returned for a currency which has no exchange rate.
+var ErrUnsupportedCurrency = errors.New("unsupported currency")
+
+// ErrOverflow is returned when a converted amount is too large to represent.
+var ErrOverflow = errors.New("amount out of range")
+
+// Rates is the exchange rate configuration.
+// All currencies are assumed to have two decimal places, so amounts are always in cents.
+type Rates struct {
+	// Base is the currency of catalog prices and shipping rates.
+	Base string `json:"base"`
+	// Rates maps each currency code to the amount of that currency one unit of the base currency buys, in millionths.
+	Rates map[string]int64 `json:"rates"`
+}
+
+//go:embed rates.json
+var defaultRates []byte
+
+// LoadRates reads exchange rates from a JSON file, or returns the built-in rates if path is empty.
+func LoadRates(path string) (Rates, error) {
+	var rates Rates
+
+	data := defaultRates
+	if path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return rates, fmt.Errorf("failed to read exchange rates: %w", err)
+		}
+	}
+
+	if err := json.Unmarshal(data, &rates); err != nil {
+		return rates, fmt.Errorf("failed to decode exchange rates: %w", err)
+	}
+
+	return rates, nil
+}
+
+// ValidCode reports whether code looks like an ISO 4217 currency code, such as "GBP".
+func ValidCode(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// Table looks up exchange rates from the base currency.
+type Table struct {
+	rates Rates
+}
+
+// NewTable validates rates and returns a Table which looks them up.
+func NewTable(rates Rates) (*Table, error) {
+	if !ValidCode(rates.Base) {
+		return nil, fmt.Errorf("invalid base currency: %q", rates.Base)
+	}
+
+	for code, rate := range rates.Rates {
+		if !ValidCode(code) {
+			return nil, fmt.Errorf("invalid currency: %q", code)
+		}
+		if rate <= 0 {
+			return nil, fmt.Errorf("exchange rate for %s must be positive", code)
+		}
+	}
+
+	if rate, ok := rates.Rates[rates.Base]; ok && rate != RateScale {
+		return nil, fmt.Errorf("exchange rate for the base currency %s must be %d", rates.Base, RateScale)
+	}
+
+	return &Table{rates: rates}, nil
+}
+
+// Base returns the base currency.
+func (t *Table) Base() string {
+	return t.rates.Base
+}
+
+// Rate returns the current exchange rate from the base currency to code, or to the base currency if code is empty.
+func (t *Table) Rate(code string) (*Rate, error) {
+	if code == "" || code == t.rates.Base {
+		return &Rate{Base: t.rates.Base, Currency: t.rates.Base, Rate: RateScale}, nil
+	}
+
+	rate, ok := t.rates.Rates[code]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedCurrency, code)
+	}
+
+	return &Rate{Base: t.rates.Base, Currency: code, Rate: rate}, nil
+}
+
+// Rate is an exchange rate from the base currency to another currency.
+// Orders take a copy of the rate when they are placed, so they are priced consistently however the rates change.
+type Rate struct {
+	Base     string `json:"base"`
+	Currency string `json:"currency"`
+	// Rate is the amount of Currency one unit of Base buys, in millionths.
+	Rate int64 `json:"rate"`
+}
+
+// FromBase converts an amount in the base currency to the rate's currency, rounding half away from zero.
+func (r Rate) FromBase(m Money) (Money, error) {
+	if m.Currency != "" && m.Currency != r.Base {
+		return Money{}, fmt.Errorf("%w: %s is not %s", ErrCurrencyMismatch, m.Currency, r.Base)
+	}
+
+	amount, err := mulDiv(m.Amount, r.Rate, RateScale, true)
+	if err != nil {
+		return Money{}, err
+	}
+
+	return Money{Amount: amount, Currency: r.Currency}, nil
+}
+
+// ToBase converts an amount in the rate's currency to the base currency, rounding half away from zero.
+func (r Rate) ToBase(m Money) (Money, error) {
+	if m.Currency != "" && m.Currency != r.Currency {
+		return Money{}, fmt.Errorf("%w: %s is not %s", ErrCurrencyMismatch, m.Currency, r.Currency)
+	}
+
+	amount, err := mulDiv(m.Amount, RateScale, r.Rate, true)
+	if err != nil {
+		return Money{}, err
+	}
+
+	return Money{Amount: amount, Currency: r.Base}, nil
+}
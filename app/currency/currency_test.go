@@ -0,0 +1,91 @@
+package currency_test
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/temporalio/reference-app-orders-go/app/currency"
+)
+
+func TestRate(t *testing.T) {
+	table, err := currency.NewTable(currency.Rates{Base: "GBP", Rates: map[string]int64{"EUR": 1170000, "USD": 1270000}})
+	require.NoError(t, err)
+
+	base, err := table.Rate("")
+	require.NoError(t, err)
+	assert.Equal(t, &currency.Rate{Base: "GBP", Currency: "GBP", Rate: currency.RateScale}, base)
+
+	eur, err := table.Rate("EUR")
+	require.NoError(t, err)
+	assert.Equal(t, "EUR", eur.Currency)
+
+	_, err = table.Rate("JPY")
+	assert.ErrorIs(t, err, currency.ErrUnsupportedCurrency)
+
+	for _, tc := range []struct {
+		base int64
+		eur  int64
+	}{
+		{1000, 1170},
+		{1, 1},
+		{2, 2},
+		{3, 4},
+		{-3, -4},
+		{0, 0},
+	} {
+		got, err := eur.FromBase(currency.NewMoney(tc.base, "GBP"))
+		require.NoError(t, err)
+		assert.Equal(t, currency.NewMoney(tc.eur, "EUR"), got, "%d GBP", tc.base)
+	}
+
+	got, err := eur.ToBase(currency.NewMoney(1170, "EUR"))
+	require.NoError(t, err)
+	assert.Equal(t, currency.NewMoney(1000, "GBP"), got)
+
+	// Amounts of an unspecified currency are taken to be in the expected currency.
+	got, err = eur.ToBase(currency.Money{Amount: 1170})
+	require.NoError(t, err)
+	assert.Equal(t, currency.NewMoney(1000, "GBP"), got)
+
+	_, err = eur.ToBase(currency.NewMoney(1170, "USD"))
+	assert.ErrorIs(t, err, currency.ErrCurrencyMismatch)
+
+	got, err = base.FromBase(currency.Money{Amount: math.MaxInt64})
+	require.NoError(t, err)
+	assert.Equal(t, int64(math.MaxInt64), got.Amount)
+
+	_, err = eur.FromBase(currency.Money{Amount: math.MaxInt64})
+	assert.ErrorIs(t, err, currency.ErrOverflow)
+}
+
+func TestNewTableRejectsInvalidRates(t *testing.T) {
+	for name, rates := range map[string]currency.Rates{
+		"missing base":       {Rates: map[string]int64{"EUR": 1170000}},
+		"invalid code":       {Base: "GBP", Rates: map[string]int64{"euro": 1170000}},
+		"zero rate":          {Base: "GBP", Rates: map[string]int64{"EUR": 0}},
+		"base rate is not 1": {Base: "GBP", Rates: map[string]int64{"GBP": 1100000}},
+	} {
+		_, err := currency.NewTable(rates)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestLoadRates(t *testing.T) {
+	rates, err := currency.LoadRates("")
+	require.NoError(t, err)
+
+	_, err = currency.NewTable(rates)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "rates.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"base":"USD","rates":{"CAD":1360000}}`), 0o600))
+
+	rates, err = currency.LoadRates(path)
+	require.NoError(t, err)
+	assert.Equal(t, "USD", rates.Base)
+	assert.Equal(t, int64(1360000), rates.Rates["CAD"])
+}
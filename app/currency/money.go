@@ -0,0 +1,244 @@
+package currency
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+)
+
+// ErrCurrencyMismatch is returned when amounts in different currencies are combined.
+var ErrCurrencyMismatch = errors.New("currency mismatch")
+
+// Money is an amount of money in minor units (cents) of a currency.
+//
+// Money is encoded in JSON as a bare number of minor units, as amounts always have been, so the currency is not
+// carried in the encoding: documents which hold amounts give their currency alongside them, and apply it to their
+// amounts with Apply when they are decoded. An empty Currency means the currency is unspecified, and such an amount
+// can be combined with an amount in any currency.
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+// NewMoney returns an amount of minor units of a currency.
+func NewMoney(amount int64, code string) Money {
+	return Money{Amount: amount, Currency: code}
+}
+
+// Apply sets the currency of each of the amounts whose currency is unspecified to code.
+func Apply(code string, amounts ...*Money) {
+	for _, m := range amounts {
+		if m.Currency == "" {
+			m.Currency = code
+		}
+	}
+}
+
+// IsZero reports whether the amount is zero, whatever its currency.
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// Neg returns the amount with its sign reversed.
+func (m Money) Neg() Money {
+	return Money{Amount: -m.Amount, Currency: m.Currency}
+}
+
+// Add returns the sum of two amounts, or an error if they are in different currencies or the sum overflows.
+func (m Money) Add(o Money) (Money, error) {
+	code, err := m.common(o)
+	if err != nil {
+		return Money{}, err
+	}
+
+	if (o.Amount > 0 && m.Amount > math.MaxInt64-o.Amount) || (o.Amount < 0 && m.Amount < math.MinInt64-o.Amount) {
+		return Money{}, fmt.Errorf("%w: %d + %d", ErrOverflow, m.Amount, o.Amount)
+	}
+
+	return Money{Amount: m.Amount + o.Amount, Currency: code}, nil
+}
+
+// Sum returns the sum of amounts, or an error if they are in different currencies or the sum overflows.
+func Sum(amounts ...Money) (Money, error) {
+	var total Money
+
+	for _, m := range amounts {
+		var err error
+		if total, err = total.Add(m); err != nil {
+			return Money{}, err
+		}
+	}
+
+	return total, nil
+}
+
+// Sub returns the difference between two amounts, or an error if they are in different currencies or the difference
+// overflows.
+func (m Money) Sub(o Money) (Money, error) {
+	if o.Amount == math.MinInt64 {
+		return Money{}, fmt.Errorf("%w: %d - %d", ErrOverflow, m.Amount, o.Amount)
+	}
+
+	return m.Add(o.Neg())
+}
+
+// Mul returns the amount multiplied by n, such as a unit price by a quantity, or an error if the product overflows.
+func (m Money) Mul(n int64) (Money, error) {
+	if m.Amount == 0 || n == 0 {
+		return Money{Currency: m.Currency}, nil
+	}
+
+	product := m.Amount * n
+	if product/n != m.Amount || (m.Amount == -1 && n == math.MinInt64) || (n == -1 && m.Amount == math.MinInt64) {
+		return Money{}, fmt.Errorf("%w: %d * %d", ErrOverflow, m.Amount, n)
+	}
+
+	return Money{Amount: product, Currency: m.Currency}, nil
+}
+
+// MulDiv returns the amount multiplied by n/d, rounded towards zero, such as the share of a charge refunded for part
+// of a quantity. The intermediate product cannot overflow.
+func (m Money) MulDiv(n int64, d int64) (Money, error) {
+	amount, err := mulDiv(m.Amount, n, d, false)
+	if err != nil {
+		return Money{}, err
+	}
+
+	return Money{Amount: amount, Currency: m.Currency}, nil
+}
+
+// Int32 returns the amount as an int32, for APIs which still take 32 bit amounts, or an error if it does not fit.
+func (m Money) Int32() (int32, error) {
+	if m.Amount > math.MaxInt32 || m.Amount < math.MinInt32 {
+		return 0, fmt.Errorf("%w: %d", ErrOverflow, m.Amount)
+	}
+
+	return int32(m.Amount), nil
+}
+
+// String formats the amount with two decimal places, followed by the currency if it is known.
+func (m Money) String() string {
+	sign := ""
+	v := uint64(m.Amount)
+	if m.Amount < 0 {
+		sign = "-"
+		v = -v
+	}
+
+	s := fmt.Sprintf("%s%d.%02d", sign, v/100, v%100)
+	if m.Currency != "" {
+		s += " " + m.Currency
+	}
+
+	return s
+}
+
+// MarshalJSON encodes the amount as a bare number of minor units.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return strconv.AppendInt(nil, m.Amount, 10), nil
+}
+
+// UnmarshalJSON decodes a bare number of minor units, or an object with "amount" and "currency" fields.
+// Fractional amounts and amounts too large for 64 bits are rejected rather than rounded.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	if len(data) > 0 && data[0] == '{' {
+		var v struct {
+			Amount   json.Number `json:"amount"`
+			Currency string      `json:"currency"`
+		}
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		if v.Currency != "" && !ValidCode(v.Currency) {
+			return fmt.Errorf("invalid currency: %q", v.Currency)
+		}
+
+		amount, err := parseAmount(v.Amount.String())
+		if err != nil {
+			return err
+		}
+
+		*m = Money{Amount: amount, Currency: v.Currency}
+		return nil
+	}
+
+	amount, err := parseAmount(string(data))
+	if err != nil {
+		return err
+	}
+
+	*m = Money{Amount: amount}
+	return nil
+}
+
+func parseAmount(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	amount, err := strconv.ParseInt(s, 10, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		return 0, fmt.Errorf("%w: %s", ErrOverflow, s)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("amount must be a whole number of minor units: %s", s)
+	}
+
+	return amount, nil
+}
+
+// common returns the currency of the result of combining two amounts.
+func (m Money) common(o Money) (string, error) {
+	switch {
+	case m.Currency == "":
+		return o.Currency, nil
+	case o.Currency == "" || o.Currency == m.Currency:
+		return m.Currency, nil
+	}
+
+	return "", fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, o.Currency)
+}
+
+// mulDiv returns amount*n/d, using a 128 bit intermediate product so only the result can overflow.
+// If round is set the result is rounded half away from zero, otherwise towards zero.
+func mulDiv(amount int64, n int64, d int64, round bool) (int64, error) {
+	if d <= 0 || n < 0 {
+		return 0, fmt.Errorf("invalid ratio %d/%d", n, d)
+	}
+
+	negative := amount < 0
+	abs := uint64(amount)
+	if negative {
+		abs = -abs
+	}
+
+	hi, lo := bits.Mul64(abs, uint64(n))
+	if round {
+		var carry uint64
+		lo, carry = bits.Add64(lo, uint64(d)/2, 0)
+		hi += carry
+	}
+	if hi >= uint64(d) {
+		return 0, fmt.Errorf("%w: %d * %d / %d", ErrOverflow, amount, n, d)
+	}
+
+	q, _ := bits.Div64(hi, lo, uint64(d))
+	if q > math.MaxInt64 {
+		return 0, fmt.Errorf("%w: %d * %d / %d", ErrOverflow, amount, n, d)
+	}
+
+	if negative {
+		return -int64(q), nil
+	}
+	return int64(q), nil
+}
@@ -0,0 +1,102 @@
+package currency_test
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/temporalio/reference-app-orders-go/app/currency"
+)
+
+func TestMoneyArithmetic(t *testing.T) {
+	price := currency.NewMoney(8000, "EUR")
+
+	sum, err := price.Add(currency.NewMoney(1500, "EUR"))
+	require.NoError(t, err)
+	assert.Equal(t, currency.NewMoney(9500, "EUR"), sum)
+
+	// An amount with no currency takes on the currency of the other.
+	sum, err = currency.Money{Amount: 500}.Add(price)
+	require.NoError(t, err)
+	assert.Equal(t, currency.NewMoney(8500, "EUR"), sum)
+
+	_, err = price.Add(currency.NewMoney(1500, "GBP"))
+	assert.ErrorIs(t, err, currency.ErrCurrencyMismatch)
+
+	diff, err := price.Sub(currency.NewMoney(9000, "EUR"))
+	require.NoError(t, err)
+	assert.Equal(t, currency.NewMoney(-1000, "EUR"), diff)
+
+	// 2^31 cents is where 32 bit amounts used to wrap.
+	total, err := price.Mul(1 << 20)
+	require.NoError(t, err)
+	assert.Equal(t, int64(8000)<<20, total.Amount)
+
+	_, err = total.Int32()
+	assert.ErrorIs(t, err, currency.ErrOverflow)
+
+	share, err := currency.NewMoney(1000, "EUR").MulDiv(1, 3)
+	require.NoError(t, err)
+	assert.Equal(t, currency.NewMoney(333, "EUR"), share)
+
+	// The intermediate product of MulDiv may exceed 64 bits.
+	share, err = currency.Money{Amount: math.MaxInt64}.MulDiv(1000, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, int64(math.MaxInt64), share.Amount)
+
+	for name, fn := range map[string]func() (currency.Money, error){
+		"add": func() (currency.Money, error) {
+			return currency.Money{Amount: math.MaxInt64}.Add(currency.Money{Amount: 1})
+		},
+		"sub": func() (currency.Money, error) {
+			return currency.Money{Amount: math.MinInt64}.Sub(currency.Money{Amount: 1})
+		},
+		"mul":    func() (currency.Money, error) { return currency.Money{Amount: math.MaxInt64 / 2}.Mul(3) },
+		"muldiv": func() (currency.Money, error) { return currency.Money{Amount: math.MaxInt64}.MulDiv(2, 1) },
+	} {
+		_, err := fn()
+		assert.ErrorIs(t, err, currency.ErrOverflow, name)
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	assert.Equal(t, "12.05 EUR", currency.NewMoney(1205, "EUR").String())
+	assert.Equal(t, "-0.50", currency.Money{Amount: -50}.String())
+	assert.Equal(t, "-92233720368547758.08", currency.Money{Amount: math.MinInt64}.String())
+}
+
+func TestMoneyJSON(t *testing.T) {
+	type invoice struct {
+		Total    currency.Money `json:"total"`
+		Currency string         `json:"currency"`
+	}
+
+	// Amounts are encoded as bare numbers of minor units, as they were before the Money type.
+	data, err := json.Marshal(invoice{Total: currency.NewMoney(5000000000, "EUR"), Currency: "EUR"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"total":5000000000,"currency":"EUR"}`, string(data))
+
+	// The document's currency is applied to its amounts once they are decoded.
+	var decoded invoice
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	currency.Apply(decoded.Currency, &decoded.Total)
+	assert.Equal(t, currency.NewMoney(5000000000, "EUR"), decoded.Total)
+
+	decoded = invoice{}
+	require.NoError(t, json.Unmarshal([]byte(`{"total":1800}`), &decoded))
+	assert.Equal(t, currency.Money{Amount: 1800}, decoded.Total)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"total":{"amount":1800,"currency":"EUR"}}`), &decoded))
+	assert.Equal(t, currency.NewMoney(1800, "EUR"), decoded.Total)
+
+	for _, input := range []string{
+		`{"total":18.5}`,
+		`{"total":"1800"}`,
+		`{"total":99999999999999999999}`,
+		`{"total":{"amount":1800,"currency":"euro"}}`,
+	} {
+		assert.Error(t, json.Unmarshal([]byte(input), &decoded), input)
+	}
+}
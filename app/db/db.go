@@ -2,7 +2,9 @@ package db
 
 import (
 	"context"
+	"database/sql"
 	_ "embed"
+	"errors"
 	"fmt"
 	"time"
 
@@ -27,6 +29,9 @@ type OrderStatus struct {
 	ReceivedAt time.Time `db:"received_at" bson:"received_at"`
 }
 
+// ErrOrderNotFound is returned when there is no order with a given ID.
+var ErrOrderNotFound = errors.New("order not found")
+
 // ShipmentStatus is a struct that represents the status of a Shipment
 type ShipmentStatus struct {
 	ID     string `db:"id" bson:"id"`
@@ -36,18 +41,461 @@ type ShipmentStatus struct {
 // ShipmentCollection is the name of the MongoDB collection to use for Shipment data.
 const ShipmentCollection = "shipments"
 
+// InventoryCollection is the name of the MongoDB collection to use for Inventory stock levels.
+// Each level also holds the reservations which have taken stock from it.
+const InventoryCollection = "inventory"
+
+// ErrInsufficientStock is returned when a warehouse does not hold enough stock to satisfy a request.
+var ErrInsufficientStock = errors.New("insufficient stock")
+
+// InventoryLevel is a struct that represents the stock level of a SKU at a warehouse
+type InventoryLevel struct {
+	Warehouse string `db:"warehouse" bson:"warehouse"`
+	SKU       string `db:"sku" bson:"sku"`
+	Quantity  int32  `db:"quantity" bson:"quantity"`
+}
+
+// InventoryReservation is a struct that represents stock reserved from a warehouse for an Order
+type InventoryReservation struct {
+	OrderID   string `db:"order_id" bson:"order_id"`
+	Warehouse string `db:"warehouse" bson:"warehouse"`
+	SKU       string `db:"sku" bson:"sku"`
+	Quantity  int32  `db:"quantity" bson:"quantity"`
+}
+
+// ProductsCollection is the name of the MongoDB collection to use for the product catalog.
+const ProductsCollection = "products"
+
+// ErrProductNotFound is returned when a SKU is not in the product catalog.
+var ErrProductNotFound = errors.New("product not found")
+
+// Product is a struct that represents a SKU in the product catalog
+type Product struct {
+	SKU       string `db:"sku" bson:"sku"`
+	Name      string `db:"name" bson:"name"`
+	UnitPrice int32  `db:"unit_price" bson:"unit_price"`
+	Weight    int32  `db:"weight" bson:"weight"`
+	TaxClass  string `db:"tax_class" bson:"tax_class"`
+}
+
+// InvoicesCollection is the name of the MongoDB collection to use for Invoices.
+const InvoicesCollection = "invoices"
+
+// ErrInvoiceNotFound is returned when there is no invoice with a given reference.
+var ErrInvoiceNotFound = errors.New("invoice not found")
+
+// Invoice is a struct that represents an invoice for a charge
+type Invoice struct {
+	Reference    string    `db:"reference" bson:"reference"`
+	CustomerID   string    `db:"customer_id" bson:"customer_id"`
+	ChargeKey    string    `db:"charge_key" bson:"charge_key"`
+	SubTotal     int64     `db:"sub_total" bson:"sub_total"`
+	Shipping     int64     `db:"shipping" bson:"shipping"`
+	Tax          int64     `db:"tax" bson:"tax"`
+	Total        int64     `db:"total" bson:"total"`
+	ShippingRule string    `db:"shipping_rule" bson:"shipping_rule"`
+	Currency     string    `db:"currency" bson:"currency"`
+	BaseCurrency string    `db:"base_currency" bson:"base_currency"`
+	ExchangeRate int64     `db:"exchange_rate" bson:"exchange_rate"`
+	Status       string    `db:"status" bson:"status"`
+	AuthCode     string    `db:"auth_code" bson:"auth_code"`
+	CreatedAt    time.Time `db:"created_at" bson:"created_at"`
+	UpdatedAt    time.Time `db:"updated_at" bson:"updated_at"`
+
+	Items []InvoiceItem `db:"-" bson:"items"`
+}
+
+// InvoiceItem is a struct that represents a line on an invoice
+type InvoiceItem struct {
+	Reference string `db:"reference" bson:"-"`
+	SKU       string `db:"sku" bson:"sku"`
+	Quantity  int32  `db:"quantity" bson:"quantity"`
+	SubTotal  int64  `db:"sub_total" bson:"sub_total"`
+	Shipping  int64  `db:"shipping" bson:"shipping"`
+	Tax       int64  `db:"tax" bson:"tax"`
+	Total     int64  `db:"total" bson:"total"`
+	TaxRegion string `db:"tax_region" bson:"tax_region"`
+	TaxClass  string `db:"tax_class" bson:"tax_class"`
+	TaxRate   int32  `db:"tax_rate" bson:"tax_rate"`
+	Promotion string `db:"promotion" bson:"promotion"`
+}
+
+// LedgerCollection is the name of the MongoDB collection to use for ledger transactions.
+const LedgerCollection = "ledger"
+
+// LedgerTransaction is a struct that represents a balanced set of ledger entries for a single money movement
+type LedgerTransaction struct {
+	ID         string    `db:"id" bson:"id"`
+	Kind       string    `db:"kind" bson:"kind"`
+	Reference  string    `db:"reference" bson:"reference"`
+	CustomerID string    `db:"customer_id" bson:"customer_id"`
+	CreatedAt  time.Time `db:"created_at" bson:"created_at"`
+
+	Entries []LedgerEntry `db:"-" bson:"entries"`
+}
+
+// LedgerEntry is a struct that represents a debit or credit to an account
+type LedgerEntry struct {
+	TransactionID string `db:"transaction_id" bson:"-"`
+	Account       string `db:"account" bson:"account"`
+	Debit         int64  `db:"debit" bson:"debit"`
+	Credit        int64  `db:"credit" bson:"credit"`
+}
+
+// LedgerBalance is a struct that represents the total debits and credits to an account
+type LedgerBalance struct {
+	Account string `db:"account" bson:"account"`
+	Debit   int64  `db:"debit" bson:"debit"`
+	Credit  int64  `db:"credit" bson:"credit"`
+}
+
+// LedgerTransactionTotal is a struct that represents the total debits and credits of a ledger transaction
+type LedgerTransactionTotal struct {
+	TransactionID string `db:"transaction_id" bson:"transaction_id"`
+	Debit         int64  `db:"debit" bson:"debit"`
+	Credit        int64  `db:"credit" bson:"credit"`
+}
+
+// PaymentMethodsCollection is the name of the MongoDB collection to use for customers' payment methods.
+const PaymentMethodsCollection = "payment_methods"
+
+// ErrPaymentMethodNotFound is returned when there is no payment method with a given ID.
+var ErrPaymentMethodNotFound = errors.New("payment method not found")
+
+// PaymentMethod is a struct that represents a customer's stored payment method
+type PaymentMethod struct {
+	ID          string    `db:"id" bson:"id"`
+	CustomerID  string    `db:"customer_id" bson:"customer_id"`
+	Type        string    `db:"type" bson:"type"`
+	Token       string    `db:"token" bson:"token"`
+	ExpiryMonth int32     `db:"expiry_month" bson:"expiry_month"`
+	ExpiryYear  int32     `db:"expiry_year" bson:"expiry_year"`
+	IsDefault   bool      `db:"is_default" bson:"is_default"`
+	CreatedAt   time.Time `db:"created_at" bson:"created_at"`
+}
+
+// DisputesCollection is the name of the MongoDB collection to use for payment disputes.
+const DisputesCollection = "disputes"
+
+// ErrDisputeNotFound is returned when there is no dispute with a given ID.
+var ErrDisputeNotFound = errors.New("dispute not found")
+
+// Dispute is a struct that represents a card issuer's dispute of a charge
+type Dispute struct {
+	ID                  string    `db:"id" bson:"id"`
+	InvoiceReference    string    `db:"invoice_reference" bson:"invoice_reference"`
+	CustomerID          string    `db:"customer_id" bson:"customer_id"`
+	Amount              int64     `db:"amount" bson:"amount"`
+	Reason              string    `db:"reason" bson:"reason"`
+	Status              string    `db:"status" bson:"status"`
+	EvidenceCollectedAt time.Time `db:"evidence_collected_at" bson:"evidence_collected_at"`
+	ShipmentStatus      string    `db:"shipment_status" bson:"shipment_status"`
+	ShipmentUpdatedAt   time.Time `db:"shipment_updated_at" bson:"shipment_updated_at"`
+	RespondBy           time.Time `db:"respond_by" bson:"respond_by"`
+	OpenedAt            time.Time `db:"opened_at" bson:"opened_at"`
+	ResolvedAt          time.Time `db:"resolved_at" bson:"resolved_at"`
+}
+
+// PromotionsCollection is the name of the MongoDB collection to use for promotions.
+const PromotionsCollection = "promotions"
+
+// PromotionRedemptionsCollection is the name of the MongoDB collection to use for redemptions of promotions.
+const PromotionRedemptionsCollection = "promotion_redemptions"
+
+// PromotionUsesCollection is the name of the MongoDB collection to use for the version of each customer's redemptions
+// of each promotion, which lets a redemption be kept only if no other has been made since the limit was checked.
+const PromotionUsesCollection = "promotion_uses"
+
+// ErrPromotionNotFound is returned when there is no promotion with a given code.
+var ErrPromotionNotFound = errors.New("promotion not found")
+
+// ErrPromotionLimitReached is returned when a customer has used a promotion as many times as they are allowed.
+var ErrPromotionLimitReached = errors.New("promotion limit reached")
+
+// ErrPromotionRedemptionNotFound is returned when a promotion has not been redeemed on a given invoice.
+var ErrPromotionRedemptionNotFound = errors.New("promotion redemption not found")
+
+// Promotion is a struct that represents a discount claimed with a coupon code
+type Promotion struct {
+	Code             string    `db:"code" bson:"code"`
+	Description      string    `db:"description" bson:"description"`
+	Type             string    `db:"type" bson:"type"`
+	Rate             int32     `db:"rate" bson:"rate"`
+	Amount           int64     `db:"amount" bson:"amount"`
+	BuyQuantity      int32     `db:"buy_quantity" bson:"buy_quantity"`
+	GetQuantity      int32     `db:"get_quantity" bson:"get_quantity"`
+	LimitPerCustomer int32     `db:"limit_per_customer" bson:"limit_per_customer"`
+	ExpiresAt        time.Time `db:"expires_at" bson:"expires_at"`
+
+	SKUs []string `db:"-" bson:"skus"`
+}
+
+// PromotionRedemption is a struct that represents the use of a promotion on an invoice
+type PromotionRedemption struct {
+	Code       string    `db:"code" bson:"code"`
+	CustomerID string    `db:"customer_id" bson:"customer_id"`
+	Reference  string    `db:"reference" bson:"reference"`
+	RedeemedAt time.Time `db:"redeemed_at" bson:"redeemed_at"`
+}
+
+// LoyaltyCollection is the name of the MongoDB collection to use for loyalty points transactions.
+const LoyaltyCollection = "loyalty_transactions"
+
+// LoyaltyVersionsCollection is the name of the MongoDB collection to use for the version of each customer's loyalty
+// points debits, which lets a debit be kept only if no other has been recorded since the balance was checked.
+const LoyaltyVersionsCollection = "loyalty_versions"
+
+// ErrLoyaltyInsufficientPoints is returned when a customer does not have the points a transaction takes away.
+var ErrLoyaltyInsufficientPoints = errors.New("insufficient loyalty points")
+
+// LoyaltyTransaction is a struct that represents a change to a customer's loyalty points balance
+type LoyaltyTransaction struct {
+	ID         string    `db:"id" bson:"id"`
+	CustomerID string    `db:"customer_id" bson:"customer_id"`
+	Kind       string    `db:"kind" bson:"kind"`
+	Points     int64     `db:"points" bson:"points"`
+	Reference  string    `db:"reference" bson:"reference"`
+	CreatedAt  time.Time `db:"created_at" bson:"created_at"`
+}
+
+// FraudRulesCollection is the name of the MongoDB collection to use for fraud rules.
+const FraudRulesCollection = "fraud_rules"
+
+// FraudSegmentsCollection is the name of the MongoDB collection to use for the segments customers belong to.
+const FraudSegmentsCollection = "fraud_segments"
+
+// FraudRuleSetsCollection is the name of the MongoDB collection to use for the version of the fraud rules and segments
+// in use, which lets a whole new set of them be written before checks switch to it.
+const FraudRuleSetsCollection = "fraud_rule_sets"
+
+// FraudChargesCollection is the name of the MongoDB collection to use for charges passed by the fraud check.
+const FraudChargesCollection = "fraud_charges"
+
+// ErrFraudRuleNotFound is returned when there is no fraud rule with a given ID.
+var ErrFraudRuleNotFound = errors.New("fraud rule not found")
+
+// FraudRule is a struct that represents a rule applied by the fraud check
+type FraudRule struct {
+	ID           string `db:"id" bson:"id"`
+	Type         string `db:"type" bson:"type"`
+	CustomerID   string `db:"customer_id" bson:"customer_id"`
+	Segment      string `db:"segment" bson:"segment"`
+	LimitAmount  int64  `db:"limit_amount" bson:"limit_amount"`
+	ReviewAmount int64  `db:"review_amount" bson:"review_amount"`
+	MaxCharges   int32  `db:"max_charges" bson:"max_charges"`
+	TimeWindow   string `db:"time_window" bson:"time_window"`
+}
+
+// FraudSegment is a struct that represents the segment a customer belongs to for the fraud check
+type FraudSegment struct {
+	CustomerID string `db:"customer_id" bson:"customer_id"`
+	Segment    string `db:"segment" bson:"segment"`
+}
+
+// FraudTalliesCollection is the name of the MongoDB collection to use for the version of each customer's fraud charges,
+// which lets a fraud check record a charge only if no other check has recorded one since it read them.
+const FraudTalliesCollection = "fraud_tallies"
+
+// ErrFraudChargeNotFound is returned when there is no fraud charge with a given ID.
+var ErrFraudChargeNotFound = errors.New("fraud charge not found")
+
+// FraudCharge is a struct that represents a charge which passed the fraud check
+type FraudCharge struct {
+	ID         string    `db:"id" bson:"id"`
+	CustomerID string    `db:"customer_id" bson:"customer_id"`
+	Amount     int64     `db:"amount" bson:"amount"`
+	CreatedAt  time.Time `db:"created_at" bson:"created_at"`
+	// ShippingAddress is where the charged order was shipped to, or empty if it is not known.
+	ShippingAddress string `db:"shipping_address" bson:"shipping_address"`
+}
+
+// FraudReviewsCollection is the name of the MongoDB collection to use for charges held for fraud review.
+const FraudReviewsCollection = "fraud_reviews"
+
+// ErrFraudReviewNotFound is returned when there is no fraud review with a given ID.
+var ErrFraudReviewNotFound = errors.New("fraud review not found")
+
+// FraudReview is a struct that represents a charge held for a manager to approve or reject
+type FraudReview struct {
+	ID               string    `db:"id" bson:"id"`
+	InvoiceReference string    `db:"invoice_reference" bson:"invoice_reference"`
+	CustomerID       string    `db:"customer_id" bson:"customer_id"`
+	Amount           int64     `db:"amount" bson:"amount"`
+	Currency         string    `db:"currency" bson:"currency"`
+	Reason           string    `db:"reason" bson:"reason"`
+	Status           string    `db:"status" bson:"status"`
+	Reviewer         string    `db:"reviewer" bson:"reviewer"`
+	Note             string    `db:"note" bson:"note"`
+	ReviewBy         time.Time `db:"review_by" bson:"review_by"`
+	OpenedAt         time.Time `db:"opened_at" bson:"opened_at"`
+	ResolvedAt       time.Time `db:"resolved_at" bson:"resolved_at"`
+}
+
+// FraudListEntriesCollection is the name of the MongoDB collection to use for the fraud check's allowlist and
+// blocklist.
+const FraudListEntriesCollection = "fraud_list_entries"
+
+// FraudListChangesCollection is the name of the MongoDB collection to use for the audit trail of the fraud check's
+// allowlist and blocklist.
+const FraudListChangesCollection = "fraud_list_changes"
+
+// ErrFraudListEntryNotFound is returned when a customer or email domain is on neither the allowlist nor the blocklist.
+var ErrFraudListEntryNotFound = errors.New("fraud list entry not found")
+
+// FraudListEntry is a struct that represents a customer or email domain on the fraud check's allowlist or blocklist
+type FraudListEntry struct {
+	Kind      string    `db:"kind" bson:"kind"`
+	Value     string    `db:"value" bson:"value"`
+	List      string    `db:"list" bson:"list"`
+	Note      string    `db:"note" bson:"note"`
+	ChangedBy string    `db:"changed_by" bson:"changed_by"`
+	ChangedAt time.Time `db:"changed_at" bson:"changed_at"`
+}
+
+// FraudListChange is a struct that represents a change to the fraud check's allowlist or blocklist.
+// A change with an empty List removes the entry.
+type FraudListChange struct {
+	ID        string    `db:"id" bson:"id"`
+	Kind      string    `db:"kind" bson:"kind"`
+	Value     string    `db:"value" bson:"value"`
+	List      string    `db:"list" bson:"list"`
+	Note      string    `db:"note" bson:"note"`
+	ChangedBy string    `db:"changed_by" bson:"changed_by"`
+	ChangedAt time.Time `db:"changed_at" bson:"changed_at"`
+}
+
+// FraudDecisionsCollection is the name of the MongoDB collection to use for the fraud check's decisions.
+const FraudDecisionsCollection = "fraud_decisions"
+
+// ErrFraudDecisionNotFound is returned when there is no fraud decision with a given ID.
+var ErrFraudDecisionNotFound = errors.New("fraud decision not found")
+
+// FraudDecision is a struct that represents a decision made by the fraud check, and what it was based on
+type FraudDecision struct {
+	ID              string    `db:"id" bson:"id"`
+	CustomerID      string    `db:"customer_id" bson:"customer_id"`
+	Email           string    `db:"email" bson:"email"`
+	Charge          int64     `db:"charge" bson:"charge"`
+	IdempotencyKey  string    `db:"idempotency_key" bson:"idempotency_key"`
+	Approved        bool      `db:"approved" bson:"approved"`
+	Outcome         string    `db:"outcome" bson:"outcome"`
+	Reason          string    `db:"reason" bson:"reason"`
+	List            string    `db:"list" bson:"list"`
+	Tally           int64     `db:"tally" bson:"tally"`
+	Charges         int32     `db:"charges" bson:"charges"`
+	LimitAmount     int64     `db:"limit_amount" bson:"limit_amount"`
+	ReviewAmount    int64     `db:"review_amount" bson:"review_amount"`
+	MaintenanceMode bool      `db:"maintenance_mode" bson:"maintenance_mode"`
+	DecidedAt       time.Time `db:"decided_at" bson:"decided_at"`
+	Score           int32     `db:"score" bson:"score"`
+}
+
+// FraudSettingsCollection is the name of the MongoDB collection to use for the fraud check's named settings.
+const FraudSettingsCollection = "fraud_settings"
+
+// ErrFraudSettingNotFound is returned when a fraud check setting has not been set.
+var ErrFraudSettingNotFound = errors.New("fraud setting not found")
+
+// FraudSetting is a struct that represents a named setting of the fraud check, held as JSON
+type FraudSetting struct {
+	Name  string `db:"name" bson:"name"`
+	Value string `db:"value" bson:"value"`
+}
+
+// FraudMaintenanceWindowsCollection is the name of the MongoDB collection to use for the fraud check's maintenance
+// windows.
+const FraudMaintenanceWindowsCollection = "fraud_maintenance_windows"
+
+// ErrFraudMaintenanceWindowNotFound is returned when there is no fraud maintenance window with a given ID.
+var ErrFraudMaintenanceWindowNotFound = errors.New("fraud maintenance window not found")
+
+// FraudMaintenanceWindow is a struct that represents a time during which the fraud check is down for maintenance.
+// A zero EndsAt means the window lasts until it is ended.
+type FraudMaintenanceWindow struct {
+	ID        string    `db:"id" bson:"id"`
+	Reason    string    `db:"reason" bson:"reason"`
+	StartsAt  time.Time `db:"starts_at" bson:"starts_at"`
+	EndsAt    time.Time `db:"ends_at" bson:"ends_at"`
+	CreatedBy string    `db:"created_by" bson:"created_by"`
+	CreatedAt time.Time `db:"created_at" bson:"created_at"`
+}
+
 // DB is an interface that defines the methods that a database driver must implement
 type DB interface {
 	Connect(ctx context.Context) error
 	Setup() error
 	Close() error
 	InsertOrder(context.Context, *OrderStatus) error
+	GetOrder(context.Context, string, *OrderStatus) error
 	UpdateOrderStatus(context.Context, string, string) error
 	GetOrders(context.Context, *[]OrderStatus) error
 	CountCompletedOrdersInRange(context.Context, time.Time, time.Time) (int, error)
 	UpdateShipmentStatus(context.Context, string, string) error
 	GetShipments(context.Context, *[]ShipmentStatus) error
 	GetPendingShipments(context.Context, *[]ShipmentStatus) error
+	GetInventoryLevels(context.Context, *[]InventoryLevel) error
+	GetInventoryLevelsForSKU(context.Context, string, *[]InventoryLevel) error
+	SetInventoryLevel(context.Context, *InventoryLevel) error
+	AdjustInventoryLevel(context.Context, string, string, int32) error
+	ReserveInventory(context.Context, *InventoryReservation) error
+	GetInventoryReservations(context.Context, string, *[]InventoryReservation) error
+	ReleaseInventory(context.Context, string, string) error
+	GetProducts(context.Context, *[]Product) error
+	GetProduct(context.Context, string, *Product) error
+	UpsertProduct(context.Context, *Product) error
+	DeleteProduct(context.Context, string) error
+	UpsertInvoice(context.Context, *Invoice) error
+	GetInvoices(context.Context, *[]Invoice) error
+	GetCustomerInvoices(context.Context, string, *[]Invoice) error
+	GetInvoice(context.Context, string, *Invoice) error
+	InsertLedgerTransaction(context.Context, *LedgerTransaction) error
+	GetLedgerBalances(context.Context, string, *[]LedgerBalance) error
+	GetUnbalancedLedgerTransactions(context.Context, *[]LedgerTransactionTotal) error
+	UpsertPaymentMethod(context.Context, *PaymentMethod) error
+	GetPaymentMethod(context.Context, string, *PaymentMethod) error
+	GetCustomerPaymentMethods(context.Context, string, *[]PaymentMethod) error
+	DeletePaymentMethod(context.Context, string) error
+	UpsertDispute(context.Context, *Dispute) error
+	GetDispute(context.Context, string, *Dispute) error
+	GetDisputes(context.Context, *[]Dispute) error
+	GetInvoiceDisputes(context.Context, string, *[]Dispute) error
+	UpsertPromotion(context.Context, *Promotion) error
+	GetPromotion(context.Context, string, *Promotion) error
+	GetPromotions(context.Context, *[]Promotion) error
+	DeletePromotion(context.Context, string) error
+	RedeemPromotion(context.Context, *PromotionRedemption, int32) error
+	DeletePromotionRedemption(context.Context, string, string) error
+	GetPromotionRedemptions(context.Context, string, *[]PromotionRedemption) error
+	RecordLoyaltyTransaction(context.Context, *LoyaltyTransaction, bool) error
+	GetLoyaltyTransactions(context.Context, string, *[]LoyaltyTransaction) error
+	GetFraudRules(context.Context, *[]FraudRule) error
+	UpsertFraudRule(context.Context, *FraudRule) error
+	DeleteFraudRule(context.Context, string) error
+	GetFraudSegments(context.Context, *[]FraudSegment) error
+	SetFraudSegment(context.Context, *FraudSegment) error
+	ReplaceFraudRules(context.Context, []FraudRule, []FraudSegment) error
+	GetFraudCharges(context.Context, string, time.Time, *[]FraudCharge) error
+	GetFirstFraudCharge(context.Context, string, *FraudCharge) error
+	DeleteFraudCharges(context.Context) error
+	DeleteFraudCharge(context.Context, string) error
+	CheckFraudCharge(context.Context, string, time.Time, func([]FraudCharge) (*FraudCharge, error)) error
+	UpsertFraudReview(context.Context, *FraudReview) error
+	GetFraudReview(context.Context, string, *FraudReview) error
+	GetFraudReviews(context.Context, string, *[]FraudReview) error
+	GetFraudListEntries(context.Context, *[]FraudListEntry) error
+	GetFraudListEntry(context.Context, string, string, *FraudListEntry) error
+	RecordFraudListChange(context.Context, *FraudListChange) error
+	GetFraudListChanges(context.Context, string, string, *[]FraudListChange) error
+	InsertFraudDecision(context.Context, *FraudDecision) error
+	GetFraudDecision(context.Context, string, *FraudDecision) error
+	GetFraudDecisions(context.Context, string, *[]FraudDecision) error
+	GetFraudSetting(context.Context, string, *FraudSetting) error
+	SetFraudSetting(context.Context, *FraudSetting) error
+	DeleteFraudSetting(context.Context, string) error
+	UpsertFraudMaintenanceWindow(context.Context, *FraudMaintenanceWindow) error
+	GetFraudMaintenanceWindow(context.Context, string, *FraudMaintenanceWindow) error
+	GetFraudMaintenanceWindows(context.Context, *[]FraudMaintenanceWindow) error
+	DeleteFraudMaintenanceWindow(context.Context, string) error
 }
 
 // CreateDB creates a new DB instance based on the configuration
@@ -56,7 +504,12 @@ func CreateDB(config config.AppConfig) DB {
 		return &MongoDB{uri: config.MongoURL}
 	}
 
-	return &SQLiteDB{path: "./api-store.db"}
+	return NewSQLiteDB("./api-store.db")
+}
+
+// NewSQLiteDB creates a new SQLite DB instance backed by the file at path
+func NewSQLiteDB(path string) *SQLiteDB {
+	return &SQLiteDB{path: path}
 }
 
 // MongoDB is a struct that implements the DB interface for MongoDB
@@ -109,9 +562,311 @@ func (m *MongoDB) Setup() error {
 		return fmt.Errorf("failed to create shipment status index: %w", err)
 	}
 
+	inventory := m.db.Collection(InventoryCollection)
+	_, err = inventory.Indexes().CreateOne(context.TODO(), mongodb.IndexModel{
+		Keys:    bson.D{{Key: "warehouse", Value: 1}, {Key: "sku", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create inventory warehouse_sku index: %w", err)
+	}
+
+	_, err = inventory.Indexes().CreateOne(context.TODO(), mongodb.IndexModel{
+		Keys: map[string]interface{}{"sku": 1},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create inventory sku index: %w", err)
+	}
+
+	_, err = inventory.Indexes().CreateOne(context.TODO(), mongodb.IndexModel{
+		Keys: bson.D{{Key: "reservations.order_id", Value: 1}, {Key: "sku", Value: 1}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create inventory reservations index: %w", err)
+	}
+
+	products := m.db.Collection(ProductsCollection)
+	_, err = products.Indexes().CreateOne(context.TODO(), mongodb.IndexModel{
+		Keys:    map[string]interface{}{"sku": 1},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create products sku index: %w", err)
+	}
+
+	invoices := m.db.Collection(InvoicesCollection)
+	_, err = invoices.Indexes().CreateOne(context.TODO(), mongodb.IndexModel{
+		Keys:    map[string]interface{}{"reference": 1},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create invoices reference index: %w", err)
+	}
+
+	_, err = invoices.Indexes().CreateOne(context.TODO(), mongodb.IndexModel{
+		Keys: bson.D{{Key: "customer_id", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create invoices customer_id index: %w", err)
+	}
+
+	ledger := m.db.Collection(LedgerCollection)
+	_, err = ledger.Indexes().CreateOne(context.TODO(), mongodb.IndexModel{
+		Keys:    map[string]interface{}{"id": 1},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create ledger id index: %w", err)
+	}
+
+	_, err = ledger.Indexes().CreateOne(context.TODO(), mongodb.IndexModel{
+		Keys: map[string]interface{}{"customer_id": 1},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create ledger customer_id index: %w", err)
+	}
+
+	paymentMethods := m.db.Collection(PaymentMethodsCollection)
+	_, err = paymentMethods.Indexes().CreateOne(context.TODO(), mongodb.IndexModel{
+		Keys:    map[string]interface{}{"id": 1},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create payment methods id index: %w", err)
+	}
+
+	_, err = paymentMethods.Indexes().CreateOne(context.TODO(), mongodb.IndexModel{
+		Keys: map[string]interface{}{"customer_id": 1},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create payment methods customer_id index: %w", err)
+	}
+
+	disputes := m.db.Collection(DisputesCollection)
+	_, err = disputes.Indexes().CreateOne(context.TODO(), mongodb.IndexModel{
+		Keys:    map[string]interface{}{"id": 1},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create disputes id index: %w", err)
+	}
+
+	_, err = disputes.Indexes().CreateOne(context.TODO(), mongodb.IndexModel{
+		Keys: map[string]interface{}{"invoice_reference": 1},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create disputes invoice_reference index: %w", err)
+	}
+
+	promotions := m.db.Collection(PromotionsCollection)
+	_, err = promotions.Indexes().CreateOne(context.TODO(), mongodb.IndexModel{
+		Keys:    map[string]interface{}{"code": 1},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create promotions code index: %w", err)
+	}
+
+	redemptions := m.db.Collection(PromotionRedemptionsCollection)
+	_, err = redemptions.Indexes().CreateOne(context.TODO(), mongodb.IndexModel{
+		Keys:    bson.D{{Key: "code", Value: 1}, {Key: "reference", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create promotion redemptions code_reference index: %w", err)
+	}
+
+	_, err = redemptions.Indexes().CreateOne(context.TODO(), mongodb.IndexModel{
+		Keys: bson.D{{Key: "code", Value: 1}, {Key: "customer_id", Value: 1}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create promotion redemptions code_customer_id index: %w", err)
+	}
+
+	loyalty := m.db.Collection(LoyaltyCollection)
+	_, err = loyalty.Indexes().CreateOne(context.TODO(), mongodb.IndexModel{
+		Keys:    map[string]interface{}{"id": 1},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create loyalty transactions id index: %w", err)
+	}
+
+	_, err = loyalty.Indexes().CreateOne(context.TODO(), mongodb.IndexModel{
+		Keys: bson.D{{Key: "customer_id", Value: 1}, {Key: "created_at", Value: 1}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create loyalty transactions customer_id index: %w", err)
+	}
+
+	// Rules and segments written before they were versioned belong to the first set.
+	fraudRules := m.db.Collection(FraudRulesCollection)
+	_, err = fraudRules.UpdateMany(context.TODO(), bson.M{"set": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"set": 0}})
+	if err != nil {
+		return fmt.Errorf("failed to version fraud rules: %w", err)
+	}
+
+	_, err = fraudRules.Indexes().CreateOne(context.TODO(), mongodb.IndexModel{
+		Keys:    bson.D{{Key: "set", Value: 1}, {Key: "id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create fraud rules set_id index: %w", err)
+	}
+
+	if err := dropIndex(fraudRules, "id_1"); err != nil {
+		return fmt.Errorf("failed to drop fraud rules id index: %w", err)
+	}
+
+	fraudSegments := m.db.Collection(FraudSegmentsCollection)
+	_, err = fraudSegments.UpdateMany(context.TODO(), bson.M{"set": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"set": 0}})
+	if err != nil {
+		return fmt.Errorf("failed to version fraud segments: %w", err)
+	}
+
+	_, err = fraudSegments.Indexes().CreateOne(context.TODO(), mongodb.IndexModel{
+		Keys:    bson.D{{Key: "set", Value: 1}, {Key: "customer_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create fraud segments set_customer_id index: %w", err)
+	}
+
+	if err := dropIndex(fraudSegments, "customer_id_1"); err != nil {
+		return fmt.Errorf("failed to drop fraud segments customer_id index: %w", err)
+	}
+
+	fraudCharges := m.db.Collection(FraudChargesCollection)
+	_, err = fraudCharges.Indexes().CreateOne(context.TODO(), mongodb.IndexModel{
+		Keys:    map[string]interface{}{"id": 1},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create fraud charges id index: %w", err)
+	}
+
+	_, err = fraudCharges.Indexes().CreateOne(context.TODO(), mongodb.IndexModel{
+		Keys: bson.D{{Key: "customer_id", Value: 1}, {Key: "created_at", Value: 1}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create fraud charges customer_id index: %w", err)
+	}
+
+	fraudReviews := m.db.Collection(FraudReviewsCollection)
+	_, err = fraudReviews.Indexes().CreateOne(context.TODO(), mongodb.IndexModel{
+		Keys:    map[string]interface{}{"id": 1},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create fraud reviews id index: %w", err)
+	}
+
+	_, err = fraudReviews.Indexes().CreateOne(context.TODO(), mongodb.IndexModel{
+		Keys: bson.D{{Key: "status", Value: 1}, {Key: "opened_at", Value: 1}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create fraud reviews status index: %w", err)
+	}
+
+	fraudListEntries := m.db.Collection(FraudListEntriesCollection)
+	_, err = fraudListEntries.Indexes().CreateOne(context.TODO(), mongodb.IndexModel{
+		Keys:    bson.D{{Key: "kind", Value: 1}, {Key: "value", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create fraud list entries kind index: %w", err)
+	}
+
+	fraudListChanges := m.db.Collection(FraudListChangesCollection)
+	_, err = fraudListChanges.Indexes().CreateOne(context.TODO(), mongodb.IndexModel{
+		Keys:    map[string]interface{}{"id": 1},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create fraud list changes id index: %w", err)
+	}
+
+	_, err = fraudListChanges.Indexes().CreateOne(context.TODO(), mongodb.IndexModel{
+		Keys: bson.D{{Key: "kind", Value: 1}, {Key: "value", Value: 1}, {Key: "changed_at", Value: 1}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create fraud list changes kind index: %w", err)
+	}
+
+	fraudDecisions := m.db.Collection(FraudDecisionsCollection)
+	_, err = fraudDecisions.Indexes().CreateOne(context.TODO(), mongodb.IndexModel{
+		Keys:    map[string]interface{}{"id": 1},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create fraud decisions id index: %w", err)
+	}
+
+	_, err = fraudDecisions.Indexes().CreateOne(context.TODO(), mongodb.IndexModel{
+		Keys: bson.D{{Key: "customer_id", Value: 1}, {Key: "decided_at", Value: -1}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create fraud decisions customer_id index: %w", err)
+	}
+
+	fraudSettings := m.db.Collection(FraudSettingsCollection)
+	_, err = fraudSettings.Indexes().CreateOne(context.TODO(), mongodb.IndexModel{
+		Keys:    map[string]interface{}{"name": 1},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create fraud settings name index: %w", err)
+	}
+
+	fraudMaintenanceWindows := m.db.Collection(FraudMaintenanceWindowsCollection)
+	_, err = fraudMaintenanceWindows.Indexes().CreateOne(context.TODO(), mongodb.IndexModel{
+		Keys:    map[string]interface{}{"id": 1},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create fraud maintenance windows id index: %w", err)
+	}
+
+	fraudTallies := m.db.Collection(FraudTalliesCollection)
+	_, err = fraudTallies.Indexes().CreateOne(context.TODO(), mongodb.IndexModel{
+		Keys:    map[string]interface{}{"customer_id": 1},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create fraud tallies customer_id index: %w", err)
+	}
+
+	promotionUses := m.db.Collection(PromotionUsesCollection)
+	_, err = promotionUses.Indexes().CreateOne(context.TODO(), mongodb.IndexModel{
+		Keys:    bson.D{{Key: "code", Value: 1}, {Key: "customer_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create promotion uses code_customer_id index: %w", err)
+	}
+
+	loyaltyVersions := m.db.Collection(LoyaltyVersionsCollection)
+	_, err = loyaltyVersions.Indexes().CreateOne(context.TODO(), mongodb.IndexModel{
+		Keys:    map[string]interface{}{"customer_id": 1},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create loyalty versions customer_id index: %w", err)
+	}
+
 	return nil
 }
 
+// dropIndex drops an index which is no longer used, if it exists.
+func dropIndex(coll *mongodb.Collection, name string) error {
+	_, err := coll.Indexes().DropOne(context.TODO(), name)
+	var cmdErr mongodb.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Name == "IndexNotFound" {
+		return nil
+	}
+	return err
+}
+
 // InsertOrder inserts an Order into the MongoDB instance (with upsert semantics for idempotency)
 func (m *MongoDB) InsertOrder(ctx context.Context, order *OrderStatus) error {
 	_, err := m.db.Collection(OrdersCollection).UpdateOne(
@@ -123,6 +878,16 @@ func (m *MongoDB) InsertOrder(ctx context.Context, order *OrderStatus) error {
 	return err
 }
 
+// GetOrder returns an Order from the MongoDB instance.
+// It returns ErrOrderNotFound if there is no Order with the ID.
+func (m *MongoDB) GetOrder(ctx context.Context, id string, result *OrderStatus) error {
+	err := m.db.Collection(OrdersCollection).FindOne(ctx, bson.M{"id": id}).Decode(result)
+	if err == mongo.ErrNoDocuments {
+		return ErrOrderNotFound
+	}
+	return err
+}
+
 // UpdateOrderStatus updates an Order in the MongoDB instance
 func (m *MongoDB) UpdateOrderStatus(ctx context.Context, id string, status string) error {
 	var fields bson.M
@@ -187,7 +952,7 @@ func (m *MongoDB) GetShipments(ctx context.Context, result *[]ShipmentStatus) er
 // GetPendingShipments returns a list of pending Shipments from the MongoDB instance
 func (m *MongoDB) GetPendingShipments(ctx context.Context, result *[]ShipmentStatus) error {
 	res, err := m.db.Collection(ShipmentCollection).Find(ctx, bson.M{
-		"status": bson.M{"$ne": "delivered"},
+		"status": bson.M{"$nin": bson.A{"delivered", "cancelled"}},
 	}, &options.FindOptions{})
 	if err != nil {
 		return err
@@ -196,86 +961,2097 @@ func (m *MongoDB) GetPendingShipments(ctx context.Context, result *[]ShipmentSta
 	return res.All(ctx, result)
 }
 
-// Close closes the connection to the MongoDB instance
-func (m *MongoDB) Close() error {
-	return m.client.Disconnect(context.Background())
-}
+// GetInventoryLevels returns a list of stock levels for all SKUs from the MongoDB instance
+func (m *MongoDB) GetInventoryLevels(ctx context.Context, result *[]InventoryLevel) error {
+	res, err := m.db.Collection(InventoryCollection).Find(ctx, bson.M{}, &options.FindOptions{
+		Sort: bson.D{{Key: "sku", Value: 1}, {Key: "warehouse", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
 
-// SQLiteDB is a struct that implements the DB interface for SQLite
-type SQLiteDB struct {
-	path string
-	db   *sqlx.DB
+	return res.All(ctx, result)
 }
 
-//go:embed schema.sql
-var sqliteSchema string
+// GetInventoryLevelsForSKU returns the stock levels for a SKU at each warehouse from the MongoDB instance
+func (m *MongoDB) GetInventoryLevelsForSKU(ctx context.Context, sku string, result *[]InventoryLevel) error {
+	res, err := m.db.Collection(InventoryCollection).Find(ctx, bson.M{"sku": sku}, &options.FindOptions{
+		Sort: bson.M{"warehouse": 1},
+	})
+	if err != nil {
+		return err
+	}
+
+	return res.All(ctx, result)
+}
+
+// SetInventoryLevel sets the stock level of a SKU at a warehouse in the MongoDB instance
+func (m *MongoDB) SetInventoryLevel(ctx context.Context, level *InventoryLevel) error {
+	_, err := m.db.Collection(InventoryCollection).UpdateOne(
+		ctx,
+		bson.M{"warehouse": level.Warehouse, "sku": level.SKU},
+		bson.M{"$set": bson.M{"quantity": level.Quantity}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// AdjustInventoryLevel atomically adds delta to the stock level of a SKU at a warehouse in the MongoDB instance.
+// It returns ErrInsufficientStock if the adjustment would take the stock level below zero.
+func (m *MongoDB) AdjustInventoryLevel(ctx context.Context, warehouse string, sku string, delta int32) error {
+	if delta >= 0 {
+		_, err := m.db.Collection(InventoryCollection).UpdateOne(
+			ctx,
+			bson.M{"warehouse": warehouse, "sku": sku},
+			bson.M{"$inc": bson.M{"quantity": delta}},
+			options.Update().SetUpsert(true),
+		)
+		return err
+	}
+
+	res, err := m.db.Collection(InventoryCollection).UpdateOne(
+		ctx,
+		bson.M{"warehouse": warehouse, "sku": sku, "quantity": bson.M{"$gte": -delta}},
+		bson.M{"$inc": bson.M{"quantity": delta}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrInsufficientStock
+	}
+
+	return nil
+}
+
+// ReserveInventory records a reservation and decrements the stock level for it in the MongoDB instance.
+// Reserving the same SKU for the same Order more than once, even concurrently, has no further effect.
+// It returns ErrInsufficientStock if the warehouse does not hold enough stock.
+func (m *MongoDB) ReserveInventory(ctx context.Context, reservation *InventoryReservation) error {
+	inventory := m.db.Collection(InventoryCollection)
+
+	// The stock is taken and the reservation recorded on the level in one update, so neither happens without the
+	// other, and only one attempt can match a level the Order has not already reserved from.
+	res, err := inventory.UpdateOne(
+		ctx,
+		bson.M{
+			"warehouse":             reservation.Warehouse,
+			"sku":                   reservation.SKU,
+			"quantity":              bson.M{"$gte": reservation.Quantity},
+			"reservations.order_id": bson.M{"$ne": reservation.OrderID},
+		},
+		bson.M{
+			"$inc":  bson.M{"quantity": -reservation.Quantity},
+			"$push": bson.M{"reservations": reservation},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		reserved, err := inventory.CountDocuments(ctx, bson.M{"sku": reservation.SKU, "reservations.order_id": reservation.OrderID})
+		if err != nil {
+			return err
+		}
+		if reserved == 0 {
+			return ErrInsufficientStock
+		}
+		// Already reserved for this Order.
+	}
+
+	// A concurrent retry may have reserved the SKU for the Order from another warehouse. Every attempt keeps only
+	// the reservation from the first warehouse by name, so they all agree on which to give back.
+	var reservations []InventoryReservation
+	if err := m.findInventoryReservations(ctx, reservation.OrderID, reservation.SKU, &reservations); err != nil {
+		return err
+	}
+	for i := 1; i < len(reservations); i++ {
+		if err := m.returnInventoryReservation(ctx, reservations[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetInventoryReservations returns the Inventory reservations for an Order from the MongoDB instance
+func (m *MongoDB) GetInventoryReservations(ctx context.Context, orderID string, result *[]InventoryReservation) error {
+	return m.findInventoryReservations(ctx, orderID, "", result)
+}
+
+// findInventoryReservations returns an Order's reservations of a SKU, or of every SKU if sku is empty, ordered by SKU
+// and warehouse.
+func (m *MongoDB) findInventoryReservations(ctx context.Context, orderID string, sku string, result *[]InventoryReservation) error {
+	match := bson.M{"reservations.order_id": orderID}
+	if sku != "" {
+		match["sku"] = sku
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$unwind", Value: "$reservations"}},
+		{{Key: "$match", Value: bson.M{"reservations.order_id": orderID}}},
+		{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$reservations"}}},
+		{{Key: "$sort", Value: bson.D{{Key: "sku", Value: 1}, {Key: "warehouse", Value: 1}}}},
+	}
+
+	res, err := m.db.Collection(InventoryCollection).Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+
+	return res.All(ctx, result)
+}
+
+// ReleaseInventory removes the reservation of a SKU for an Order and returns the stock to its warehouse in the MongoDB instance.
+// Releasing a SKU which is not reserved has no effect.
+func (m *MongoDB) ReleaseInventory(ctx context.Context, orderID string, sku string) error {
+	var reservations []InventoryReservation
+	if err := m.findInventoryReservations(ctx, orderID, sku, &reservations); err != nil {
+		return err
+	}
+
+	for _, reservation := range reservations {
+		if err := m.returnInventoryReservation(ctx, reservation); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// returnInventoryReservation removes a reservation from its stock level and gives the stock back in one update, which
+// only one attempt can match.
+func (m *MongoDB) returnInventoryReservation(ctx context.Context, reservation InventoryReservation) error {
+	_, err := m.db.Collection(InventoryCollection).UpdateOne(
+		ctx,
+		bson.M{"warehouse": reservation.Warehouse, "sku": reservation.SKU, "reservations.order_id": reservation.OrderID},
+		bson.M{
+			"$inc":  bson.M{"quantity": reservation.Quantity},
+			"$pull": bson.M{"reservations": bson.M{"order_id": reservation.OrderID}},
+		},
+	)
+	return err
+}
+
+// GetProducts returns the product catalog from the MongoDB instance
+func (m *MongoDB) GetProducts(ctx context.Context, result *[]Product) error {
+	res, err := m.db.Collection(ProductsCollection).Find(ctx, bson.M{}, &options.FindOptions{
+		Sort: bson.M{"sku": 1},
+	})
+	if err != nil {
+		return err
+	}
+
+	return res.All(ctx, result)
+}
+
+// GetProduct returns a product from the MongoDB instance.
+// It returns ErrProductNotFound if the SKU is not in the catalog.
+func (m *MongoDB) GetProduct(ctx context.Context, sku string, result *Product) error {
+	err := m.db.Collection(ProductsCollection).FindOne(ctx, bson.M{"sku": sku}).Decode(result)
+	if err == mongo.ErrNoDocuments {
+		return ErrProductNotFound
+	}
+	return err
+}
+
+// UpsertProduct inserts or replaces a product in the MongoDB instance
+func (m *MongoDB) UpsertProduct(ctx context.Context, product *Product) error {
+	_, err := m.db.Collection(ProductsCollection).ReplaceOne(
+		ctx,
+		bson.M{"sku": product.SKU},
+		product,
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+// DeleteProduct removes a product from the MongoDB instance.
+// It returns ErrProductNotFound if the SKU is not in the catalog.
+func (m *MongoDB) DeleteProduct(ctx context.Context, sku string) error {
+	res, err := m.db.Collection(ProductsCollection).DeleteOne(ctx, bson.M{"sku": sku})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrProductNotFound
+	}
+	return nil
+}
+
+// UpsertInvoice inserts or replaces an invoice and its lines in the MongoDB instance
+func (m *MongoDB) UpsertInvoice(ctx context.Context, invoice *Invoice) error {
+	_, err := m.db.Collection(InvoicesCollection).ReplaceOne(
+		ctx,
+		bson.M{"reference": invoice.Reference},
+		invoice,
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+// GetInvoices returns all invoices, without their lines, from the MongoDB instance
+func (m *MongoDB) GetInvoices(ctx context.Context, result *[]Invoice) error {
+	return m.findInvoices(ctx, bson.M{}, result)
+}
+
+// GetCustomerInvoices returns a customer's invoices, without their lines, from the MongoDB instance
+func (m *MongoDB) GetCustomerInvoices(ctx context.Context, customerID string, result *[]Invoice) error {
+	return m.findInvoices(ctx, bson.M{"customer_id": customerID}, result)
+}
+
+func (m *MongoDB) findInvoices(ctx context.Context, filter bson.M, result *[]Invoice) error {
+	res, err := m.db.Collection(InvoicesCollection).Find(ctx, filter, &options.FindOptions{
+		Sort:       bson.D{{Key: "created_at", Value: -1}, {Key: "reference", Value: 1}},
+		Projection: bson.M{"items": 0},
+	})
+	if err != nil {
+		return err
+	}
+
+	return res.All(ctx, result)
+}
+
+// GetInvoice returns an invoice and its lines from the MongoDB instance.
+// It returns ErrInvoiceNotFound if there is no invoice with the reference.
+func (m *MongoDB) GetInvoice(ctx context.Context, reference string, result *Invoice) error {
+	err := m.db.Collection(InvoicesCollection).FindOne(ctx, bson.M{"reference": reference}).Decode(result)
+	if err == mongo.ErrNoDocuments {
+		return ErrInvoiceNotFound
+	}
+	return err
+}
+
+// InsertLedgerTransaction records a ledger transaction and its entries in the MongoDB instance.
+// Inserting a transaction with the same ID more than once has no further effect.
+func (m *MongoDB) InsertLedgerTransaction(ctx context.Context, transaction *LedgerTransaction) error {
+	_, err := m.db.Collection(LedgerCollection).UpdateOne(
+		ctx,
+		bson.M{"id": transaction.ID},
+		bson.M{"$setOnInsert": transaction},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// GetLedgerBalances returns the total debits and credits to each account from the MongoDB instance.
+// If customerID is not empty, only that customer's transactions are included.
+func (m *MongoDB) GetLedgerBalances(ctx context.Context, customerID string, result *[]LedgerBalance) error {
+	var pipeline mongo.Pipeline
+	if customerID != "" {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{"customer_id": customerID}}})
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$unwind", Value: "$entries"}},
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":    "$entries.account",
+			"debit":  bson.M{"$sum": "$entries.debit"},
+			"credit": bson.M{"$sum": "$entries.credit"},
+		}}},
+		bson.D{{Key: "$project", Value: bson.M{"_id": 0, "account": "$_id", "debit": 1, "credit": 1}}},
+		bson.D{{Key: "$sort", Value: bson.M{"account": 1}}},
+	)
+
+	res, err := m.db.Collection(LedgerCollection).Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+
+	return res.All(ctx, result)
+}
+
+// GetUnbalancedLedgerTransactions returns the ledger transactions whose debits and credits differ from the MongoDB instance
+func (m *MongoDB) GetUnbalancedLedgerTransactions(ctx context.Context, result *[]LedgerTransactionTotal) error {
+	res, err := m.db.Collection(LedgerCollection).Aggregate(ctx, mongo.Pipeline{
+		bson.D{{Key: "$project", Value: bson.M{
+			"_id":            0,
+			"transaction_id": "$id",
+			"debit":          bson.M{"$sum": "$entries.debit"},
+			"credit":         bson.M{"$sum": "$entries.credit"},
+		}}},
+		bson.D{{Key: "$match", Value: bson.M{"$expr": bson.M{"$ne": bson.A{"$debit", "$credit"}}}}},
+		bson.D{{Key: "$sort", Value: bson.M{"transaction_id": 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	return res.All(ctx, result)
+}
+
+// UpsertPaymentMethod inserts or replaces a payment method in the MongoDB instance.
+// If the method is the customer's default, the customer's other methods are no longer their default.
+func (m *MongoDB) UpsertPaymentMethod(ctx context.Context, method *PaymentMethod) error {
+	if method.IsDefault {
+		_, err := m.db.Collection(PaymentMethodsCollection).UpdateMany(
+			ctx,
+			bson.M{"customer_id": method.CustomerID, "id": bson.M{"$ne": method.ID}},
+			bson.M{"$set": bson.M{"is_default": false}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := m.db.Collection(PaymentMethodsCollection).ReplaceOne(
+		ctx,
+		bson.M{"id": method.ID},
+		method,
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+// GetPaymentMethod returns a payment method from the MongoDB instance.
+// It returns ErrPaymentMethodNotFound if there is no payment method with the ID.
+func (m *MongoDB) GetPaymentMethod(ctx context.Context, id string, result *PaymentMethod) error {
+	err := m.db.Collection(PaymentMethodsCollection).FindOne(ctx, bson.M{"id": id}).Decode(result)
+	if err == mongo.ErrNoDocuments {
+		return ErrPaymentMethodNotFound
+	}
+	return err
+}
+
+// GetCustomerPaymentMethods returns a customer's payment methods, oldest first, from the MongoDB instance
+func (m *MongoDB) GetCustomerPaymentMethods(ctx context.Context, customerID string, result *[]PaymentMethod) error {
+	res, err := m.db.Collection(PaymentMethodsCollection).Find(ctx, bson.M{"customer_id": customerID}, &options.FindOptions{
+		Sort: bson.D{{Key: "created_at", Value: 1}, {Key: "id", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	return res.All(ctx, result)
+}
+
+// DeletePaymentMethod removes a payment method from the MongoDB instance.
+// It returns ErrPaymentMethodNotFound if there is no payment method with the ID.
+func (m *MongoDB) DeletePaymentMethod(ctx context.Context, id string) error {
+	res, err := m.db.Collection(PaymentMethodsCollection).DeleteOne(ctx, bson.M{"id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrPaymentMethodNotFound
+	}
+	return nil
+}
+
+// UpsertDispute inserts or replaces a dispute in the MongoDB instance
+func (m *MongoDB) UpsertDispute(ctx context.Context, dispute *Dispute) error {
+	_, err := m.db.Collection(DisputesCollection).ReplaceOne(
+		ctx,
+		bson.M{"id": dispute.ID},
+		dispute,
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+// GetDispute returns a dispute from the MongoDB instance.
+// It returns ErrDisputeNotFound if there is no dispute with the ID.
+func (m *MongoDB) GetDispute(ctx context.Context, id string, result *Dispute) error {
+	err := m.db.Collection(DisputesCollection).FindOne(ctx, bson.M{"id": id}).Decode(result)
+	if err == mongo.ErrNoDocuments {
+		return ErrDisputeNotFound
+	}
+	return err
+}
+
+// GetDisputes returns all disputes, newest first, from the MongoDB instance
+func (m *MongoDB) GetDisputes(ctx context.Context, result *[]Dispute) error {
+	return m.findDisputes(ctx, bson.M{}, result)
+}
+
+// GetInvoiceDisputes returns the disputes of an invoice, newest first, from the MongoDB instance
+func (m *MongoDB) GetInvoiceDisputes(ctx context.Context, reference string, result *[]Dispute) error {
+	return m.findDisputes(ctx, bson.M{"invoice_reference": reference}, result)
+}
+
+func (m *MongoDB) findDisputes(ctx context.Context, filter bson.M, result *[]Dispute) error {
+	res, err := m.db.Collection(DisputesCollection).Find(ctx, filter, &options.FindOptions{
+		Sort: bson.D{{Key: "opened_at", Value: -1}, {Key: "id", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	return res.All(ctx, result)
+}
+
+// UpsertPromotion inserts or replaces a promotion in the MongoDB instance
+func (m *MongoDB) UpsertPromotion(ctx context.Context, promotion *Promotion) error {
+	_, err := m.db.Collection(PromotionsCollection).ReplaceOne(
+		ctx,
+		bson.M{"code": promotion.Code},
+		promotion,
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+// GetPromotion returns a promotion from the MongoDB instance.
+// It returns ErrPromotionNotFound if there is no promotion with the code.
+func (m *MongoDB) GetPromotion(ctx context.Context, code string, result *Promotion) error {
+	err := m.db.Collection(PromotionsCollection).FindOne(ctx, bson.M{"code": code}).Decode(result)
+	if err == mongo.ErrNoDocuments {
+		return ErrPromotionNotFound
+	}
+	return err
+}
+
+// GetPromotions returns all promotions from the MongoDB instance
+func (m *MongoDB) GetPromotions(ctx context.Context, result *[]Promotion) error {
+	res, err := m.db.Collection(PromotionsCollection).Find(ctx, bson.M{}, &options.FindOptions{
+		Sort: bson.M{"code": 1},
+	})
+	if err != nil {
+		return err
+	}
+
+	return res.All(ctx, result)
+}
+
+// DeletePromotion removes a promotion from the MongoDB instance. Its redemptions are kept.
+// It returns ErrPromotionNotFound if there is no promotion with the code.
+func (m *MongoDB) DeletePromotion(ctx context.Context, code string) error {
+	res, err := m.db.Collection(PromotionsCollection).DeleteOne(ctx, bson.M{"code": code})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrPromotionNotFound
+	}
+	return nil
+}
+
+// RedeemPromotion records the use of a promotion on an invoice in the MongoDB instance.
+// Redeeming a promotion on the same invoice more than once has no further effect.
+// It returns ErrPromotionLimitReached if the customer has already used the promotion limit times, unless limit is zero.
+// A limited redemption is only kept if the customer has made no other since the limit was checked; otherwise it is
+// removed and the limit checked again, so concurrent redemptions cannot exceed it.
+func (m *MongoDB) RedeemPromotion(ctx context.Context, redemption *PromotionRedemption, limit int32) error {
+	redemptions := m.db.Collection(PromotionRedemptionsCollection)
+	uses := m.db.Collection(PromotionUsesCollection)
+
+	customer := bson.M{"code": redemption.Code, "customer_id": redemption.CustomerID}
+	invoice := bson.M{"code": redemption.Code, "reference": redemption.Reference}
+
+	for {
+		var use struct {
+			Version int64 `bson:"version"`
+		}
+		if limit > 0 {
+			err := uses.FindOne(ctx, customer).Decode(&use)
+			if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+				return err
+			}
+
+			count, err := redemptions.CountDocuments(ctx, customer)
+			if err != nil {
+				return err
+			}
+			if count >= int64(limit) {
+				redeemed, err := redemptions.CountDocuments(ctx, invoice)
+				if err != nil {
+					return err
+				}
+				if redeemed > 0 {
+					// Already redeemed on this invoice.
+					return nil
+				}
+				return ErrPromotionLimitReached
+			}
+		}
+
+		// The redemption is inserted before the version is bumped, so a redemption which reads the new version also
+		// counts it.
+		_, err := redemptions.InsertOne(ctx, redemption)
+		if mongo.IsDuplicateKeyError(err) {
+			// Already redeemed on this invoice.
+			return nil
+		}
+		if err != nil || limit == 0 {
+			return err
+		}
+
+		// The unique index on code and customer_id makes the upsert fail if another redemption has bumped the version.
+		_, err = uses.UpdateOne(ctx,
+			bson.M{"code": redemption.Code, "customer_id": redemption.CustomerID, "version": use.Version},
+			bson.M{"$inc": bson.M{"version": 1}},
+			options.Update().SetUpsert(true),
+		)
+		if err == nil {
+			return nil
+		}
+
+		if _, deleteErr := redemptions.DeleteOne(ctx, invoice); deleteErr != nil {
+			return errors.Join(err, deleteErr)
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+}
+
+// DeletePromotionRedemption removes the use of a promotion on an invoice from the MongoDB instance, so that it no longer
+// counts towards the customer's limit.
+// It returns ErrPromotionRedemptionNotFound if the promotion was not redeemed on the invoice.
+func (m *MongoDB) DeletePromotionRedemption(ctx context.Context, code string, reference string) error {
+	res, err := m.db.Collection(PromotionRedemptionsCollection).DeleteOne(ctx, bson.M{"code": code, "reference": reference})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrPromotionRedemptionNotFound
+	}
+	return nil
+}
+
+// GetPromotionRedemptions returns the redemptions of a promotion, oldest first, from the MongoDB instance
+func (m *MongoDB) GetPromotionRedemptions(ctx context.Context, code string, result *[]PromotionRedemption) error {
+	res, err := m.db.Collection(PromotionRedemptionsCollection).Find(ctx, bson.M{"code": code}, &options.FindOptions{
+		Sort: bson.D{{Key: "redeemed_at", Value: 1}, {Key: "reference", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	return res.All(ctx, result)
+}
+
+// RecordLoyaltyTransaction records a change to a customer's loyalty points balance in the MongoDB instance.
+// Recording a transaction with the same ID more than once has no further effect.
+// Unless overdraw is true, it returns ErrLoyaltyInsufficientPoints if the transaction would leave the balance negative.
+func (m *MongoDB) RecordLoyaltyTransaction(ctx context.Context, transaction *LoyaltyTransaction, overdraw bool) error {
+	coll := m.db.Collection(LoyaltyCollection)
+	versions := m.db.Collection(LoyaltyVersionsCollection)
+
+	// Credits only ever raise the balance, so they cannot make another transaction's check wrong.
+	if transaction.Points >= 0 {
+		_, err := coll.UpdateOne(
+			ctx,
+			bson.M{"id": transaction.ID},
+			bson.M{"$setOnInsert": transaction},
+			options.Update().SetUpsert(true),
+		)
+		return err
+	}
+
+	for {
+		var version struct {
+			Version int64 `bson:"version"`
+		}
+		err := versions.FindOne(ctx, bson.M{"customer_id": transaction.CustomerID}).Decode(&version)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return err
+		}
+
+		count, err := coll.CountDocuments(ctx, bson.M{"id": transaction.ID})
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+
+		if !overdraw {
+			res, err := coll.Aggregate(ctx, mongo.Pipeline{
+				bson.D{{Key: "$match", Value: bson.M{"customer_id": transaction.CustomerID}}},
+				bson.D{{Key: "$group", Value: bson.M{"_id": nil, "balance": bson.M{"$sum": "$points"}}}},
+			})
+			if err != nil {
+				return err
+			}
+
+			var balances []struct {
+				Balance int64 `bson:"balance"`
+			}
+			if err := res.All(ctx, &balances); err != nil {
+				return err
+			}
+
+			var balance int64
+			if len(balances) > 0 {
+				balance = balances[0].Balance
+			}
+			if balance+transaction.Points < 0 {
+				return ErrLoyaltyInsufficientPoints
+			}
+		}
+
+		// The debit is inserted before the version is bumped, so a debit which reads the new version also counts it.
+		_, err = coll.InsertOne(ctx, transaction)
+		if mongo.IsDuplicateKeyError(err) {
+			// Already recorded.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// The unique index on customer_id makes the upsert fail if another debit has bumped the version.
+		_, err = versions.UpdateOne(ctx,
+			bson.M{"customer_id": transaction.CustomerID, "version": version.Version},
+			bson.M{"$inc": bson.M{"version": 1}},
+			options.Update().SetUpsert(true),
+		)
+		if err == nil {
+			return nil
+		}
+
+		if _, deleteErr := coll.DeleteOne(ctx, bson.M{"id": transaction.ID}); deleteErr != nil {
+			return errors.Join(err, deleteErr)
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+}
+
+// GetLoyaltyTransactions returns a customer's loyalty points transactions, oldest first, from the MongoDB instance
+func (m *MongoDB) GetLoyaltyTransactions(ctx context.Context, customerID string, result *[]LoyaltyTransaction) error {
+	res, err := m.db.Collection(LoyaltyCollection).Find(ctx, bson.M{"customer_id": customerID}, &options.FindOptions{
+		Sort: bson.D{{Key: "created_at", Value: 1}, {Key: "id", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	return res.All(ctx, result)
+}
+
+// GetFraudRules returns all fraud rules, ordered by ID, from the MongoDB instance
+func (m *MongoDB) GetFraudRules(ctx context.Context, result *[]FraudRule) error {
+	set, err := m.activeFraudRuleSet(ctx)
+	if err != nil {
+		return err
+	}
+
+	res, err := m.db.Collection(FraudRulesCollection).Find(ctx, bson.M{"set": set}, &options.FindOptions{
+		Sort: bson.M{"id": 1},
+	})
+	if err != nil {
+		return err
+	}
+
+	return res.All(ctx, result)
+}
+
+// UpsertFraudRule inserts or replaces a fraud rule in the MongoDB instance
+func (m *MongoDB) UpsertFraudRule(ctx context.Context, rule *FraudRule) error {
+	set, err := m.activeFraudRuleSet(ctx)
+	if err != nil {
+		return err
+	}
+
+	return m.upsertFraudRule(ctx, set, rule)
+}
+
+// DeleteFraudRule removes a fraud rule from the MongoDB instance.
+// It returns ErrFraudRuleNotFound if there is no rule with the ID.
+func (m *MongoDB) DeleteFraudRule(ctx context.Context, id string) error {
+	set, err := m.activeFraudRuleSet(ctx)
+	if err != nil {
+		return err
+	}
+
+	res, err := m.db.Collection(FraudRulesCollection).DeleteOne(ctx, bson.M{"set": set, "id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrFraudRuleNotFound
+	}
+	return nil
+}
+
+// GetFraudSegments returns the segments customers belong to from the MongoDB instance
+func (m *MongoDB) GetFraudSegments(ctx context.Context, result *[]FraudSegment) error {
+	set, err := m.activeFraudRuleSet(ctx)
+	if err != nil {
+		return err
+	}
+
+	res, err := m.db.Collection(FraudSegmentsCollection).Find(ctx, bson.M{"set": set}, &options.FindOptions{
+		Sort: bson.M{"customer_id": 1},
+	})
+	if err != nil {
+		return err
+	}
+
+	return res.All(ctx, result)
+}
+
+// SetFraudSegment sets the segment a customer belongs to in the MongoDB instance.
+// An empty segment removes the customer from their segment.
+func (m *MongoDB) SetFraudSegment(ctx context.Context, segment *FraudSegment) error {
+	set, err := m.activeFraudRuleSet(ctx)
+	if err != nil {
+		return err
+	}
+
+	return m.setFraudSegment(ctx, set, segment)
+}
+
+// ReplaceFraudRules replaces all fraud rules and customer segments in the MongoDB instance.
+// The new rules and segments are written as a new set, which checks switch to only once it is complete, so they never
+// see a partly replaced set. The set it replaces is kept until the next replacement, for checks already reading it.
+func (m *MongoDB) ReplaceFraudRules(ctx context.Context, rules []FraudRule, segments []FraudSegment) error {
+	sets := m.db.Collection(FraudRuleSetsCollection)
+
+	var version struct {
+		Active int64 `bson:"active"`
+		Last   int64 `bson:"last"`
+	}
+	err := sets.FindOneAndUpdate(ctx,
+		bson.M{"_id": activeFraudRuleSetID},
+		bson.M{"$inc": bson.M{"last": 1}, "$setOnInsert": bson.M{"active": 0}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&version)
+	if err != nil {
+		return err
+	}
+	set := version.Last
+
+	if err := m.writeFraudRuleSet(ctx, set, rules, segments); err != nil {
+		return errors.Join(err, m.deleteFraudRuleSets(ctx, bson.M{"set": set}))
+	}
+
+	// Only switch forwards, so a replacement which started earlier cannot undo a later one.
+	err = sets.FindOneAndUpdate(ctx,
+		bson.M{"_id": activeFraudRuleSetID, "active": bson.M{"$lt": set}},
+		bson.M{"$set": bson.M{"active": set}},
+	).Decode(&version)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// A later replacement is already in use.
+		return m.deleteFraudRuleSets(ctx, bson.M{"set": set})
+	}
+	if err != nil {
+		return errors.Join(err, m.deleteFraudRuleSets(ctx, bson.M{"set": set}))
+	}
+
+	return m.deleteFraudRuleSets(ctx, bson.M{"set": bson.M{"$lt": version.Active}})
+}
+
+// activeFraudRuleSetID is the ID of the document in the FraudRuleSetsCollection which holds the set in use.
+const activeFraudRuleSetID = "active"
+
+func (m *MongoDB) activeFraudRuleSet(ctx context.Context) (int64, error) {
+	var version struct {
+		Active int64 `bson:"active"`
+	}
+	err := m.db.Collection(FraudRuleSetsCollection).FindOne(ctx, bson.M{"_id": activeFraudRuleSetID}).Decode(&version)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, nil
+	}
+	return version.Active, err
+}
+
+func (m *MongoDB) writeFraudRuleSet(ctx context.Context, set int64, rules []FraudRule, segments []FraudSegment) error {
+	for _, r := range rules {
+		if err := m.upsertFraudRule(ctx, set, &r); err != nil {
+			return err
+		}
+	}
+	for _, sg := range segments {
+		if err := m.setFraudSegment(ctx, set, &sg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MongoDB) deleteFraudRuleSets(ctx context.Context, filter bson.M) error {
+	if _, err := m.db.Collection(FraudRulesCollection).DeleteMany(ctx, filter); err != nil {
+		return err
+	}
+	_, err := m.db.Collection(FraudSegmentsCollection).DeleteMany(ctx, filter)
+	return err
+}
+
+// upsertFraudRule inserts or replaces a fraud rule in a set. The upsert takes the set from the filter.
+func (m *MongoDB) upsertFraudRule(ctx context.Context, set int64, rule *FraudRule) error {
+	_, err := m.db.Collection(FraudRulesCollection).UpdateOne(
+		ctx,
+		bson.M{"set": set, "id": rule.ID},
+		bson.M{"$set": rule},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// setFraudSegment sets the segment a customer belongs to in a set. The upsert takes the set from the filter.
+func (m *MongoDB) setFraudSegment(ctx context.Context, set int64, segment *FraudSegment) error {
+	coll := m.db.Collection(FraudSegmentsCollection)
+
+	if segment.Segment == "" {
+		_, err := coll.DeleteOne(ctx, bson.M{"set": set, "customer_id": segment.CustomerID})
+		return err
+	}
+
+	_, err := coll.UpdateOne(
+		ctx,
+		bson.M{"set": set, "customer_id": segment.CustomerID},
+		bson.M{"$set": segment},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// GetFraudCharges returns a customer's charges made at or after since, oldest first, from the MongoDB instance
+func (m *MongoDB) GetFraudCharges(ctx context.Context, customerID string, since time.Time, result *[]FraudCharge) error {
+	res, err := m.db.Collection(FraudChargesCollection).Find(ctx, bson.M{"customer_id": customerID, "created_at": bson.M{"$gte": since}}, &options.FindOptions{
+		Sort: bson.D{{Key: "created_at", Value: 1}, {Key: "id", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	return res.All(ctx, result)
+}
+
+// GetFirstFraudCharge returns a customer's oldest charge from the MongoDB instance.
+// It returns ErrFraudChargeNotFound if the customer has no charges.
+func (m *MongoDB) GetFirstFraudCharge(ctx context.Context, customerID string, result *FraudCharge) error {
+	err := m.db.Collection(FraudChargesCollection).FindOne(ctx, bson.M{"customer_id": customerID}, &options.FindOneOptions{
+		Sort: bson.D{{Key: "created_at", Value: 1}, {Key: "id", Value: 1}},
+	}).Decode(result)
+	if err == mongo.ErrNoDocuments {
+		return ErrFraudChargeNotFound
+	}
+	return err
+}
+
+// DeleteFraudCharges removes every customer's charges from the MongoDB instance
+func (m *MongoDB) DeleteFraudCharges(ctx context.Context) error {
+	_, err := m.db.Collection(FraudChargesCollection).DeleteMany(ctx, bson.M{})
+	return err
+}
+
+// CheckFraudCharge passes a customer's charges made at or after since to decide, and records the charge decide returns,
+// if any, in the MongoDB instance. Recording a charge with the same ID more than once has no further effect.
+// A charge is only kept if no other check has recorded one for the customer since the charges were read; otherwise it
+// is removed and the check is made again with the customer's current charges.
+func (m *MongoDB) CheckFraudCharge(ctx context.Context, customerID string, since time.Time, decide func([]FraudCharge) (*FraudCharge, error)) error {
+	tallies := m.db.Collection(FraudTalliesCollection)
+	charges := m.db.Collection(FraudChargesCollection)
+
+	for {
+		var tally struct {
+			Version int64 `bson:"version"`
+		}
+		err := tallies.FindOne(ctx, bson.M{"customer_id": customerID}).Decode(&tally)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return err
+		}
+
+		var history []FraudCharge
+		if err := m.GetFraudCharges(ctx, customerID, since, &history); err != nil {
+			return err
+		}
+
+		charge, err := decide(history)
+		if err != nil || charge == nil {
+			return err
+		}
+
+		// The charge is inserted before the version is bumped, so a check which reads the new version also sees it.
+		_, err = charges.InsertOne(ctx, charge)
+		if mongo.IsDuplicateKeyError(err) {
+			// Already recorded.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// The unique index on customer_id makes the upsert fail if another check has bumped the version.
+		_, err = tallies.UpdateOne(ctx,
+			bson.M{"customer_id": customerID, "version": tally.Version},
+			bson.M{"$inc": bson.M{"version": 1}},
+			options.Update().SetUpsert(true),
+		)
+		if err == nil {
+			return nil
+		}
+
+		if _, deleteErr := charges.DeleteOne(ctx, bson.M{"id": charge.ID}); deleteErr != nil {
+			return errors.Join(err, deleteErr)
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+}
+
+// DeleteFraudCharge removes a charge from the MongoDB instance
+func (m *MongoDB) DeleteFraudCharge(ctx context.Context, id string) error {
+	res, err := m.db.Collection(FraudChargesCollection).DeleteOne(ctx, bson.M{"id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrFraudChargeNotFound
+	}
+	return nil
+}
+
+// UpsertFraudReview inserts or replaces a fraud review in the MongoDB instance
+func (m *MongoDB) UpsertFraudReview(ctx context.Context, review *FraudReview) error {
+	_, err := m.db.Collection(FraudReviewsCollection).ReplaceOne(
+		ctx,
+		bson.M{"id": review.ID},
+		review,
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+// GetFraudReview returns a fraud review from the MongoDB instance.
+// It returns ErrFraudReviewNotFound if there is no review with the ID.
+func (m *MongoDB) GetFraudReview(ctx context.Context, id string, result *FraudReview) error {
+	err := m.db.Collection(FraudReviewsCollection).FindOne(ctx, bson.M{"id": id}).Decode(result)
+	if err == mongo.ErrNoDocuments {
+		return ErrFraudReviewNotFound
+	}
+	return err
+}
+
+// GetFraudReviews returns the fraud reviews with a status, or all of them if the status is empty, oldest first, from
+// the MongoDB instance
+func (m *MongoDB) GetFraudReviews(ctx context.Context, status string, result *[]FraudReview) error {
+	filter := bson.M{}
+	if status != "" {
+		filter["status"] = status
+	}
+
+	res, err := m.db.Collection(FraudReviewsCollection).Find(ctx, filter, &options.FindOptions{
+		Sort: bson.D{{Key: "opened_at", Value: 1}, {Key: "id", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	return res.All(ctx, result)
+}
+
+// GetFraudListEntries returns the allowlist and blocklist entries, ordered by kind and value, from the MongoDB instance
+func (m *MongoDB) GetFraudListEntries(ctx context.Context, result *[]FraudListEntry) error {
+	res, err := m.db.Collection(FraudListEntriesCollection).Find(ctx, bson.M{}, &options.FindOptions{
+		Sort: bson.D{{Key: "kind", Value: 1}, {Key: "value", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	return res.All(ctx, result)
+}
+
+// GetFraudListEntry returns the allowlist or blocklist entry for a customer or email domain from the MongoDB instance.
+// It returns ErrFraudListEntryNotFound if the value is on neither list.
+func (m *MongoDB) GetFraudListEntry(ctx context.Context, kind string, value string, result *FraudListEntry) error {
+	err := m.db.Collection(FraudListEntriesCollection).FindOne(ctx, bson.M{"kind": kind, "value": value}).Decode(result)
+	if err == mongo.ErrNoDocuments {
+		return ErrFraudListEntryNotFound
+	}
+	return err
+}
+
+// RecordFraudListChange applies a change to the allowlist or blocklist in the MongoDB instance, and adds it to the
+// audit trail. It returns ErrFraudListEntryNotFound if the change removes an entry which does not exist.
+func (m *MongoDB) RecordFraudListChange(ctx context.Context, change *FraudListChange) error {
+	filter := bson.M{"kind": change.Kind, "value": change.Value}
+
+	if change.List == "" {
+		res, err := m.db.Collection(FraudListEntriesCollection).DeleteOne(ctx, filter)
+		if err != nil {
+			return err
+		}
+		if res.DeletedCount == 0 {
+			return ErrFraudListEntryNotFound
+		}
+	} else {
+		_, err := m.db.Collection(FraudListEntriesCollection).ReplaceOne(
+			ctx,
+			filter,
+			FraudListEntry{
+				Kind:      change.Kind,
+				Value:     change.Value,
+				List:      change.List,
+				Note:      change.Note,
+				ChangedBy: change.ChangedBy,
+				ChangedAt: change.ChangedAt,
+			},
+			options.Replace().SetUpsert(true),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := m.db.Collection(FraudListChangesCollection).InsertOne(ctx, change)
+	return err
+}
+
+// GetFraudListChanges returns the changes to the allowlist and blocklist for a customer or email domain, or every
+// change if kind is empty, oldest first, from the MongoDB instance
+func (m *MongoDB) GetFraudListChanges(ctx context.Context, kind string, value string, result *[]FraudListChange) error {
+	filter := bson.M{}
+	if kind != "" {
+		filter["kind"] = kind
+		filter["value"] = value
+	}
+
+	res, err := m.db.Collection(FraudListChangesCollection).Find(ctx, filter, &options.FindOptions{
+		Sort: bson.D{{Key: "changed_at", Value: 1}, {Key: "id", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	return res.All(ctx, result)
+}
+
+// InsertFraudDecision records a decision made by the fraud check in the MongoDB instance
+func (m *MongoDB) InsertFraudDecision(ctx context.Context, decision *FraudDecision) error {
+	_, err := m.db.Collection(FraudDecisionsCollection).InsertOne(ctx, decision)
+	return err
+}
+
+// GetFraudDecision returns a decision made by the fraud check from the MongoDB instance.
+// It returns ErrFraudDecisionNotFound if there is no decision with the ID.
+func (m *MongoDB) GetFraudDecision(ctx context.Context, id string, result *FraudDecision) error {
+	err := m.db.Collection(FraudDecisionsCollection).FindOne(ctx, bson.M{"id": id}).Decode(result)
+	if err == mongo.ErrNoDocuments {
+		return ErrFraudDecisionNotFound
+	}
+	return err
+}
+
+// GetFraudDecisions returns the fraud check's decisions for a customer, or for everyone if the customer ID is empty,
+// newest first, from the MongoDB instance
+func (m *MongoDB) GetFraudDecisions(ctx context.Context, customerID string, result *[]FraudDecision) error {
+	filter := bson.M{}
+	if customerID != "" {
+		filter["customer_id"] = customerID
+	}
+
+	res, err := m.db.Collection(FraudDecisionsCollection).Find(ctx, filter, &options.FindOptions{
+		Sort: bson.D{{Key: "decided_at", Value: -1}, {Key: "id", Value: -1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	return res.All(ctx, result)
+}
+
+// GetFraudSetting returns a named fraud check setting from the MongoDB instance.
+// It returns ErrFraudSettingNotFound if the setting has not been set.
+func (m *MongoDB) GetFraudSetting(ctx context.Context, name string, result *FraudSetting) error {
+	err := m.db.Collection(FraudSettingsCollection).FindOne(ctx, bson.M{"name": name}).Decode(result)
+	if err == mongo.ErrNoDocuments {
+		return ErrFraudSettingNotFound
+	}
+	return err
+}
+
+// SetFraudSetting inserts or replaces a named fraud check setting in the MongoDB instance
+func (m *MongoDB) SetFraudSetting(ctx context.Context, setting *FraudSetting) error {
+	_, err := m.db.Collection(FraudSettingsCollection).ReplaceOne(
+		ctx,
+		bson.M{"name": setting.Name},
+		setting,
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+// DeleteFraudSetting removes a named fraud check setting, if it has been set, from the MongoDB instance
+func (m *MongoDB) DeleteFraudSetting(ctx context.Context, name string) error {
+	_, err := m.db.Collection(FraudSettingsCollection).DeleteOne(ctx, bson.M{"name": name})
+	return err
+}
+
+// UpsertFraudMaintenanceWindow inserts or replaces a fraud maintenance window in the MongoDB instance
+func (m *MongoDB) UpsertFraudMaintenanceWindow(ctx context.Context, window *FraudMaintenanceWindow) error {
+	_, err := m.db.Collection(FraudMaintenanceWindowsCollection).ReplaceOne(
+		ctx,
+		bson.M{"id": window.ID},
+		window,
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+// GetFraudMaintenanceWindow returns a fraud maintenance window from the MongoDB instance.
+// It returns ErrFraudMaintenanceWindowNotFound if there is no window with the ID.
+func (m *MongoDB) GetFraudMaintenanceWindow(ctx context.Context, id string, result *FraudMaintenanceWindow) error {
+	err := m.db.Collection(FraudMaintenanceWindowsCollection).FindOne(ctx, bson.M{"id": id}).Decode(result)
+	if err == mongo.ErrNoDocuments {
+		return ErrFraudMaintenanceWindowNotFound
+	}
+	return err
+}
+
+// GetFraudMaintenanceWindows returns every fraud maintenance window, earliest first, from the MongoDB instance
+func (m *MongoDB) GetFraudMaintenanceWindows(ctx context.Context, result *[]FraudMaintenanceWindow) error {
+	res, err := m.db.Collection(FraudMaintenanceWindowsCollection).Find(ctx, bson.M{}, &options.FindOptions{
+		Sort: bson.D{{Key: "starts_at", Value: 1}, {Key: "id", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	return res.All(ctx, result)
+}
+
+// DeleteFraudMaintenanceWindow removes a fraud maintenance window from the MongoDB instance.
+// It returns ErrFraudMaintenanceWindowNotFound if there is no window with the ID.
+func (m *MongoDB) DeleteFraudMaintenanceWindow(ctx context.Context, id string) error {
+	res, err := m.db.Collection(FraudMaintenanceWindowsCollection).DeleteOne(ctx, bson.M{"id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrFraudMaintenanceWindowNotFound
+	}
+	return nil
+}
+
+// Close closes the connection to the MongoDB instance
+func (m *MongoDB) Close() error {
+	return m.client.Disconnect(context.Background())
+}
+
+// SQLiteDB is a struct that implements the DB interface for SQLite
+type SQLiteDB struct {
+	path string
+	db   *sqlx.DB
+}
+
+//go:embed schema.sql
+var sqliteSchema string
+
+// Connect connects to a SQLite instance
+func (s *SQLiteDB) Connect(_ context.Context) error {
+	// Wait for locks held by other processes sharing the database, such as other replicas, rather than failing at once.
+	db, err := sqlx.Connect("sqlite", s.path+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		return err
+	}
+	s.db = db
+	db.SetMaxOpenConns(1) // SQLite does not support concurrent writes
+	return nil
+}
+
+// Setup sets up the SQLite instance
+func (s *SQLiteDB) Setup() error {
+	_, err := s.db.Exec(sqliteSchema)
+	return err
+}
+
+// Close closes the connection to the SQLite instance
+func (s *SQLiteDB) Close() error {
+	return s.db.Close()
+}
+
+// InsertOrder inserts an Order into the SQLite instance
+func (s *SQLiteDB) InsertOrder(ctx context.Context, order *OrderStatus) error {
+	_, err := s.db.NamedExecContext(ctx, "INSERT OR IGNORE INTO orders (id, customer_id, received_at, status) VALUES (:id, :customer_id, :received_at, :status)", order)
+	return err
+}
+
+// GetOrder returns an Order from the SQLite instance.
+// It returns ErrOrderNotFound if there is no Order with the ID.
+func (s *SQLiteDB) GetOrder(ctx context.Context, id string, result *OrderStatus) error {
+	err := s.db.GetContext(ctx, result, "SELECT id, customer_id, status, received_at FROM orders WHERE id = ?", id)
+	if err == sql.ErrNoRows {
+		return ErrOrderNotFound
+	}
+	return err
+}
+
+// UpdateOrderStatus updates an Order in the SQLite instance
+func (s *SQLiteDB) UpdateOrderStatus(ctx context.Context, id string, status string) error {
+	var err error
+	if status == "completed" {
+		_, err = s.db.ExecContext(ctx, "UPDATE orders SET status = ?, completed_at = ? WHERE id = ?", status, time.Now().UTC(), id)
+	} else {
+		_, err = s.db.ExecContext(ctx, "UPDATE orders SET status = ? WHERE id = ?", status, id)
+	}
+	return err
+}
+
+// GetOrders returns a list of Orders from the SQLite instance
+func (s *SQLiteDB) GetOrders(ctx context.Context, result *[]OrderStatus) error {
+	return s.db.SelectContext(ctx, result, "SELECT id, status, received_at FROM orders ORDER BY received_at DESC")
+}
+
+// CountCompletedOrdersInRange counts completed Orders in a given time range
+func (s *SQLiteDB) CountCompletedOrdersInRange(ctx context.Context, start time.Time, end time.Time) (int, error) {
+	var count int
+	err := s.db.GetContext(ctx, &count, "SELECT COUNT(*) FROM orders WHERE completed_at >= ? AND completed_at <= ?", start.UTC(), end.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// UpdateShipmentStatus updates a Shipment in the SQLite instance
+func (s *SQLiteDB) UpdateShipmentStatus(ctx context.Context, id string, status string) error {
+	_, err := s.db.ExecContext(ctx, "INSERT INTO shipments (id, booked_at, status) VALUES (?, ?, ?) ON CONFLICT(id) DO UPDATE SET status = ?", id, time.Now().UTC(), status, status)
+	return err
+}
+
+// GetShipments returns a list of Shipments from the SQLite instance
+func (s *SQLiteDB) GetShipments(ctx context.Context, result *[]ShipmentStatus) error {
+	return s.db.SelectContext(ctx, result, "SELECT id, status FROM shipments ORDER BY booked_at DESC")
+}
+
+// GetPendingShipments returns a list of pending Shipments from the SQLite instance
+func (s *SQLiteDB) GetPendingShipments(ctx context.Context, result *[]ShipmentStatus) error {
+	return s.db.SelectContext(ctx, result, "SELECT id, status FROM shipments WHERE status NOT IN ('delivered', 'cancelled')")
+}
+
+// GetInventoryLevels returns a list of stock levels for all SKUs from the SQLite instance
+func (s *SQLiteDB) GetInventoryLevels(ctx context.Context, result *[]InventoryLevel) error {
+	return s.db.SelectContext(ctx, result, "SELECT warehouse, sku, quantity FROM inventory ORDER BY sku, warehouse")
+}
+
+// GetInventoryLevelsForSKU returns the stock levels for a SKU at each warehouse from the SQLite instance
+func (s *SQLiteDB) GetInventoryLevelsForSKU(ctx context.Context, sku string, result *[]InventoryLevel) error {
+	return s.db.SelectContext(ctx, result, "SELECT warehouse, sku, quantity FROM inventory WHERE sku = ? ORDER BY warehouse", sku)
+}
+
+// SetInventoryLevel sets the stock level of a SKU at a warehouse in the SQLite instance
+func (s *SQLiteDB) SetInventoryLevel(ctx context.Context, level *InventoryLevel) error {
+	_, err := s.db.NamedExecContext(ctx, "INSERT INTO inventory (warehouse, sku, quantity) VALUES (:warehouse, :sku, :quantity) ON CONFLICT(warehouse, sku) DO UPDATE SET quantity = excluded.quantity", level)
+	return err
+}
+
+// AdjustInventoryLevel atomically adds delta to the stock level of a SKU at a warehouse in the SQLite instance.
+// It returns ErrInsufficientStock if the adjustment would take the stock level below zero.
+func (s *SQLiteDB) AdjustInventoryLevel(ctx context.Context, warehouse string, sku string, delta int32) error {
+	if delta >= 0 {
+		_, err := s.db.ExecContext(ctx, "INSERT INTO inventory (warehouse, sku, quantity) VALUES (?, ?, ?) ON CONFLICT(warehouse, sku) DO UPDATE SET quantity = quantity + excluded.quantity", warehouse, sku, delta)
+		return err
+	}
+
+	res, err := s.db.ExecContext(ctx, "UPDATE inventory SET quantity = quantity + ? WHERE warehouse = ? AND sku = ? AND quantity >= ?", delta, warehouse, sku, -delta)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrInsufficientStock
+	}
+
+	return nil
+}
+
+// ReserveInventory atomically decrements the stock level for a reservation and records it in the SQLite instance.
+// Reserving the same SKU for the same Order more than once has no further effect.
+// It returns ErrInsufficientStock if the warehouse does not hold enough stock.
+func (s *SQLiteDB) ReserveInventory(ctx context.Context, reservation *InventoryReservation) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.NamedExecContext(ctx, "INSERT OR IGNORE INTO inventory_reservations (order_id, warehouse, sku, quantity) VALUES (:order_id, :warehouse, :sku, :quantity)", reservation)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		// Already reserved for this Order.
+		return tx.Commit()
+	}
+
+	res, err = tx.ExecContext(ctx, "UPDATE inventory SET quantity = quantity - ? WHERE warehouse = ? AND sku = ? AND quantity >= ?", reservation.Quantity, reservation.Warehouse, reservation.SKU, reservation.Quantity)
+	if err != nil {
+		return err
+	}
+	n, err = res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrInsufficientStock
+	}
+
+	return tx.Commit()
+}
+
+// GetInventoryReservations returns the Inventory reservations for an Order from the SQLite instance
+func (s *SQLiteDB) GetInventoryReservations(ctx context.Context, orderID string, result *[]InventoryReservation) error {
+	return s.db.SelectContext(ctx, result, "SELECT order_id, warehouse, sku, quantity FROM inventory_reservations WHERE order_id = ? ORDER BY rowid", orderID)
+}
+
+// ReleaseInventory removes the reservation of a SKU for an Order and returns the stock to its warehouse in the SQLite instance.
+// Releasing a SKU which is not reserved has no effect.
+func (s *SQLiteDB) ReleaseInventory(ctx context.Context, orderID string, sku string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var reservation InventoryReservation
+	err = tx.GetContext(ctx, &reservation, "DELETE FROM inventory_reservations WHERE order_id = ? AND sku = ? RETURNING order_id, warehouse, sku, quantity", orderID, sku)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO inventory (warehouse, sku, quantity) VALUES (?, ?, ?) ON CONFLICT(warehouse, sku) DO UPDATE SET quantity = quantity + excluded.quantity", reservation.Warehouse, reservation.SKU, reservation.Quantity)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetProducts returns the product catalog from the SQLite instance
+func (s *SQLiteDB) GetProducts(ctx context.Context, result *[]Product) error {
+	return s.db.SelectContext(ctx, result, "SELECT sku, name, unit_price, weight, tax_class FROM products ORDER BY sku")
+}
+
+// GetProduct returns a product from the SQLite instance.
+// It returns ErrProductNotFound if the SKU is not in the catalog.
+func (s *SQLiteDB) GetProduct(ctx context.Context, sku string, result *Product) error {
+	err := s.db.GetContext(ctx, result, "SELECT sku, name, unit_price, weight, tax_class FROM products WHERE sku = ?", sku)
+	if err == sql.ErrNoRows {
+		return ErrProductNotFound
+	}
+	return err
+}
+
+// UpsertProduct inserts or replaces a product in the SQLite instance
+func (s *SQLiteDB) UpsertProduct(ctx context.Context, product *Product) error {
+	_, err := s.db.NamedExecContext(ctx, "INSERT INTO products (sku, name, unit_price, weight, tax_class) VALUES (:sku, :name, :unit_price, :weight, :tax_class) ON CONFLICT(sku) DO UPDATE SET name = excluded.name, unit_price = excluded.unit_price, weight = excluded.weight, tax_class = excluded.tax_class", product)
+	return err
+}
+
+// DeleteProduct removes a product from the SQLite instance.
+// It returns ErrProductNotFound if the SKU is not in the catalog.
+func (s *SQLiteDB) DeleteProduct(ctx context.Context, sku string) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM products WHERE sku = ?", sku)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrProductNotFound
+	}
+	return nil
+}
+
+// UpsertInvoice inserts or replaces an invoice and its lines in the SQLite instance
+func (s *SQLiteDB) UpsertInvoice(ctx context.Context, invoice *Invoice) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.NamedExecContext(ctx, "INSERT INTO invoices (reference, customer_id, charge_key, sub_total, shipping, tax, total, shipping_rule, currency, base_currency, exchange_rate, status, auth_code, created_at, updated_at) VALUES (:reference, :customer_id, :charge_key, :sub_total, :shipping, :tax, :total, :shipping_rule, :currency, :base_currency, :exchange_rate, :status, :auth_code, :created_at, :updated_at) ON CONFLICT(reference) DO UPDATE SET customer_id = excluded.customer_id, charge_key = excluded.charge_key, sub_total = excluded.sub_total, shipping = excluded.shipping, tax = excluded.tax, total = excluded.total, shipping_rule = excluded.shipping_rule, currency = excluded.currency, base_currency = excluded.base_currency, exchange_rate = excluded.exchange_rate, status = excluded.status, auth_code = excluded.auth_code, created_at = excluded.created_at, updated_at = excluded.updated_at", invoice)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM invoice_items WHERE reference = ?", invoice.Reference)
+	if err != nil {
+		return err
+	}
+
+	for i := range invoice.Items {
+		item := invoice.Items[i]
+		item.Reference = invoice.Reference
+
+		_, err = tx.NamedExecContext(ctx, "INSERT INTO invoice_items (reference, sku, quantity, sub_total, shipping, tax, total, tax_region, tax_class, tax_rate, promotion) VALUES (:reference, :sku, :quantity, :sub_total, :shipping, :tax, :total, :tax_region, :tax_class, :tax_rate, :promotion)", &item)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetInvoices returns all invoices, without their lines, from the SQLite instance
+func (s *SQLiteDB) GetInvoices(ctx context.Context, result *[]Invoice) error {
+	return s.db.SelectContext(ctx, result, "SELECT reference, customer_id, charge_key, sub_total, shipping, tax, total, shipping_rule, currency, base_currency, exchange_rate, status, auth_code, created_at, updated_at FROM invoices ORDER BY created_at DESC, reference")
+}
+
+// GetCustomerInvoices returns a customer's invoices, without their lines, from the SQLite instance
+func (s *SQLiteDB) GetCustomerInvoices(ctx context.Context, customerID string, result *[]Invoice) error {
+	return s.db.SelectContext(ctx, result, "SELECT reference, customer_id, charge_key, sub_total, shipping, tax, total, shipping_rule, currency, base_currency, exchange_rate, status, auth_code, created_at, updated_at FROM invoices WHERE customer_id = ? ORDER BY created_at DESC, reference", customerID)
+}
+
+// GetInvoice returns an invoice and its lines from the SQLite instance.
+// It returns ErrInvoiceNotFound if there is no invoice with the reference.
+func (s *SQLiteDB) GetInvoice(ctx context.Context, reference string, result *Invoice) error {
+	err := s.db.GetContext(ctx, result, "SELECT reference, customer_id, charge_key, sub_total, shipping, tax, total, shipping_rule, currency, base_currency, exchange_rate, status, auth_code, created_at, updated_at FROM invoices WHERE reference = ?", reference)
+	if err == sql.ErrNoRows {
+		return ErrInvoiceNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.db.SelectContext(ctx, &result.Items, "SELECT reference, sku, quantity, sub_total, shipping, tax, total, tax_region, tax_class, tax_rate, promotion FROM invoice_items WHERE reference = ? ORDER BY rowid", reference)
+}
+
+// InsertLedgerTransaction records a ledger transaction and its entries in the SQLite instance.
+// Inserting a transaction with the same ID more than once has no further effect.
+func (s *SQLiteDB) InsertLedgerTransaction(ctx context.Context, transaction *LedgerTransaction) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.NamedExecContext(ctx, "INSERT OR IGNORE INTO ledger_transactions (id, kind, reference, customer_id, created_at) VALUES (:id, :kind, :reference, :customer_id, :created_at)", transaction)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		// Already recorded.
+		return tx.Commit()
+	}
+
+	for i := range transaction.Entries {
+		entry := transaction.Entries[i]
+		entry.TransactionID = transaction.ID
+
+		_, err = tx.NamedExecContext(ctx, "INSERT INTO ledger_entries (transaction_id, account, debit, credit) VALUES (:transaction_id, :account, :debit, :credit)", &entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetLedgerBalances returns the total debits and credits to each account from the SQLite instance.
+// If customerID is not empty, only that customer's transactions are included.
+func (s *SQLiteDB) GetLedgerBalances(ctx context.Context, customerID string, result *[]LedgerBalance) error {
+	if customerID == "" {
+		return s.db.SelectContext(ctx, result, "SELECT account, SUM(debit) AS debit, SUM(credit) AS credit FROM ledger_entries GROUP BY account ORDER BY account")
+	}
+
+	return s.db.SelectContext(ctx, result, "SELECT e.account, SUM(e.debit) AS debit, SUM(e.credit) AS credit FROM ledger_entries e JOIN ledger_transactions t ON t.id = e.transaction_id WHERE t.customer_id = ? GROUP BY e.account ORDER BY e.account", customerID)
+}
+
+// GetUnbalancedLedgerTransactions returns the ledger transactions whose debits and credits differ from the SQLite instance
+func (s *SQLiteDB) GetUnbalancedLedgerTransactions(ctx context.Context, result *[]LedgerTransactionTotal) error {
+	return s.db.SelectContext(ctx, result, "SELECT t.id AS transaction_id, COALESCE(SUM(e.debit), 0) AS debit, COALESCE(SUM(e.credit), 0) AS credit FROM ledger_transactions t LEFT JOIN ledger_entries e ON e.transaction_id = t.id GROUP BY t.id HAVING SUM(e.debit) IS NOT SUM(e.credit) ORDER BY t.id")
+}
+
+// UpsertPaymentMethod inserts or replaces a payment method in the SQLite instance.
+// If the method is the customer's default, the customer's other methods are no longer their default.
+func (s *SQLiteDB) UpsertPaymentMethod(ctx context.Context, method *PaymentMethod) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if method.IsDefault {
+		_, err = tx.ExecContext(ctx, "UPDATE payment_methods SET is_default = FALSE WHERE customer_id = ? AND id != ?", method.CustomerID, method.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.NamedExecContext(ctx, "INSERT INTO payment_methods (id, customer_id, type, token, expiry_month, expiry_year, is_default, created_at) VALUES (:id, :customer_id, :type, :token, :expiry_month, :expiry_year, :is_default, :created_at) ON CONFLICT(id) DO UPDATE SET customer_id = excluded.customer_id, type = excluded.type, token = excluded.token, expiry_month = excluded.expiry_month, expiry_year = excluded.expiry_year, is_default = excluded.is_default, created_at = excluded.created_at", method)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetPaymentMethod returns a payment method from the SQLite instance.
+// It returns ErrPaymentMethodNotFound if there is no payment method with the ID.
+func (s *SQLiteDB) GetPaymentMethod(ctx context.Context, id string, result *PaymentMethod) error {
+	err := s.db.GetContext(ctx, result, "SELECT id, customer_id, type, token, expiry_month, expiry_year, is_default, created_at FROM payment_methods WHERE id = ?", id)
+	if err == sql.ErrNoRows {
+		return ErrPaymentMethodNotFound
+	}
+	return err
+}
+
+// GetCustomerPaymentMethods returns a customer's payment methods, oldest first, from the SQLite instance
+func (s *SQLiteDB) GetCustomerPaymentMethods(ctx context.Context, customerID string, result *[]PaymentMethod) error {
+	return s.db.SelectContext(ctx, result, "SELECT id, customer_id, type, token, expiry_month, expiry_year, is_default, created_at FROM payment_methods WHERE customer_id = ? ORDER BY created_at, id", customerID)
+}
+
+// DeletePaymentMethod removes a payment method from the SQLite instance.
+// It returns ErrPaymentMethodNotFound if there is no payment method with the ID.
+func (s *SQLiteDB) DeletePaymentMethod(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM payment_methods WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrPaymentMethodNotFound
+	}
+	return nil
+}
+
+// UpsertDispute inserts or replaces a dispute in the SQLite instance
+func (s *SQLiteDB) UpsertDispute(ctx context.Context, dispute *Dispute) error {
+	_, err := s.db.NamedExecContext(ctx, "INSERT INTO disputes (id, invoice_reference, customer_id, amount, reason, status, evidence_collected_at, shipment_status, shipment_updated_at, respond_by, opened_at, resolved_at) VALUES (:id, :invoice_reference, :customer_id, :amount, :reason, :status, :evidence_collected_at, :shipment_status, :shipment_updated_at, :respond_by, :opened_at, :resolved_at) ON CONFLICT(id) DO UPDATE SET invoice_reference = excluded.invoice_reference, customer_id = excluded.customer_id, amount = excluded.amount, reason = excluded.reason, status = excluded.status, evidence_collected_at = excluded.evidence_collected_at, shipment_status = excluded.shipment_status, shipment_updated_at = excluded.shipment_updated_at, respond_by = excluded.respond_by, opened_at = excluded.opened_at, resolved_at = excluded.resolved_at", dispute)
+	return err
+}
+
+// GetDispute returns a dispute from the SQLite instance.
+// It returns ErrDisputeNotFound if there is no dispute with the ID.
+func (s *SQLiteDB) GetDispute(ctx context.Context, id string, result *Dispute) error {
+	err := s.db.GetContext(ctx, result, "SELECT id, invoice_reference, customer_id, amount, reason, status, evidence_collected_at, shipment_status, shipment_updated_at, respond_by, opened_at, resolved_at FROM disputes WHERE id = ?", id)
+	if err == sql.ErrNoRows {
+		return ErrDisputeNotFound
+	}
+	return err
+}
 
-// Connect connects to a SQLite instance
-func (s *SQLiteDB) Connect(_ context.Context) error {
-	db, err := sqlx.Connect("sqlite", s.path)
+// GetDisputes returns all disputes, newest first, from the SQLite instance
+func (s *SQLiteDB) GetDisputes(ctx context.Context, result *[]Dispute) error {
+	return s.db.SelectContext(ctx, result, "SELECT id, invoice_reference, customer_id, amount, reason, status, evidence_collected_at, shipment_status, shipment_updated_at, respond_by, opened_at, resolved_at FROM disputes ORDER BY opened_at DESC, id")
+}
+
+// GetInvoiceDisputes returns the disputes of an invoice, newest first, from the SQLite instance
+func (s *SQLiteDB) GetInvoiceDisputes(ctx context.Context, reference string, result *[]Dispute) error {
+	return s.db.SelectContext(ctx, result, "SELECT id, invoice_reference, customer_id, amount, reason, status, evidence_collected_at, shipment_status, shipment_updated_at, respond_by, opened_at, resolved_at FROM disputes WHERE invoice_reference = ? ORDER BY opened_at DESC, id", reference)
+}
+
+// UpsertPromotion inserts or replaces a promotion and its SKUs in the SQLite instance
+func (s *SQLiteDB) UpsertPromotion(ctx context.Context, promotion *Promotion) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	s.db = db
-	db.SetMaxOpenConns(1) // SQLite does not support concurrent writes
+	defer tx.Rollback()
+
+	_, err = tx.NamedExecContext(ctx, "INSERT INTO promotions (code, description, type, rate, amount, buy_quantity, get_quantity, limit_per_customer, expires_at) VALUES (:code, :description, :type, :rate, :amount, :buy_quantity, :get_quantity, :limit_per_customer, :expires_at) ON CONFLICT(code) DO UPDATE SET description = excluded.description, type = excluded.type, rate = excluded.rate, amount = excluded.amount, buy_quantity = excluded.buy_quantity, get_quantity = excluded.get_quantity, limit_per_customer = excluded.limit_per_customer, expires_at = excluded.expires_at", promotion)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM promotion_skus WHERE code = ?", promotion.Code)
+	if err != nil {
+		return err
+	}
+
+	for _, sku := range promotion.SKUs {
+		_, err = tx.ExecContext(ctx, "INSERT OR IGNORE INTO promotion_skus (code, sku) VALUES (?, ?)", promotion.Code, sku)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetPromotion returns a promotion and its SKUs from the SQLite instance.
+// It returns ErrPromotionNotFound if there is no promotion with the code.
+func (s *SQLiteDB) GetPromotion(ctx context.Context, code string, result *Promotion) error {
+	err := s.db.GetContext(ctx, result, "SELECT code, description, type, rate, amount, buy_quantity, get_quantity, limit_per_customer, expires_at FROM promotions WHERE code = ?", code)
+	if err == sql.ErrNoRows {
+		return ErrPromotionNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.db.SelectContext(ctx, &result.SKUs, "SELECT sku FROM promotion_skus WHERE code = ? ORDER BY rowid", code)
+}
+
+// GetPromotions returns all promotions and their SKUs from the SQLite instance
+func (s *SQLiteDB) GetPromotions(ctx context.Context, result *[]Promotion) error {
+	err := s.db.SelectContext(ctx, result, "SELECT code, description, type, rate, amount, buy_quantity, get_quantity, limit_per_customer, expires_at FROM promotions ORDER BY code")
+	if err != nil {
+		return err
+	}
+
+	for i := range *result {
+		p := &(*result)[i]
+		if err := s.db.SelectContext(ctx, &p.SKUs, "SELECT sku FROM promotion_skus WHERE code = ? ORDER BY rowid", p.Code); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-// Setup sets up the SQLite instance
-func (s *SQLiteDB) Setup() error {
-	_, err := s.db.Exec(sqliteSchema)
+// DeletePromotion removes a promotion from the SQLite instance. Its redemptions are kept.
+// It returns ErrPromotionNotFound if there is no promotion with the code.
+func (s *SQLiteDB) DeletePromotion(ctx context.Context, code string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM promotions WHERE code = ?", code)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrPromotionNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM promotion_skus WHERE code = ?", code)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RedeemPromotion records the use of a promotion on an invoice in the SQLite instance.
+// Redeeming a promotion on the same invoice more than once has no further effect.
+// It returns ErrPromotionLimitReached if the customer has already used the promotion limit times, unless limit is zero.
+func (s *SQLiteDB) RedeemPromotion(ctx context.Context, redemption *PromotionRedemption, limit int32) error {
+	// The limit is checked by the insert itself, so concurrent redemptions cannot exceed it.
+	res, err := s.db.ExecContext(ctx,
+		"INSERT OR IGNORE INTO promotion_redemptions (code, customer_id, reference, redeemed_at) SELECT ?, ?, ?, ? WHERE ? = 0 OR (SELECT COUNT(*) FROM promotion_redemptions WHERE code = ? AND customer_id = ?) < ?",
+		redemption.Code, redemption.CustomerID, redemption.Reference, redemption.RedeemedAt,
+		limit, redemption.Code, redemption.CustomerID, limit,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+
+	var count int
+	err = s.db.GetContext(ctx, &count, "SELECT COUNT(*) FROM promotion_redemptions WHERE code = ? AND reference = ?", redemption.Code, redemption.Reference)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrPromotionLimitReached
+	}
+
+	// Already redeemed on this invoice.
+	return nil
+}
+
+// DeletePromotionRedemption removes the use of a promotion on an invoice from the SQLite instance, so that it no longer
+// counts towards the customer's limit.
+// It returns ErrPromotionRedemptionNotFound if the promotion was not redeemed on the invoice.
+func (s *SQLiteDB) DeletePromotionRedemption(ctx context.Context, code string, reference string) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM promotion_redemptions WHERE code = ? AND reference = ?", code, reference)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrPromotionRedemptionNotFound
+	}
+	return nil
+}
+
+// GetPromotionRedemptions returns the redemptions of a promotion, oldest first, from the SQLite instance
+func (s *SQLiteDB) GetPromotionRedemptions(ctx context.Context, code string, result *[]PromotionRedemption) error {
+	return s.db.SelectContext(ctx, result, "SELECT code, customer_id, reference, redeemed_at FROM promotion_redemptions WHERE code = ? ORDER BY redeemed_at, reference", code)
+}
+
+// RecordLoyaltyTransaction records a change to a customer's loyalty points balance in the SQLite instance.
+// Recording a transaction with the same ID more than once has no further effect.
+// Unless overdraw is true, it returns ErrLoyaltyInsufficientPoints if the transaction would leave the balance negative.
+func (s *SQLiteDB) RecordLoyaltyTransaction(ctx context.Context, transaction *LoyaltyTransaction, overdraw bool) error {
+	// The balance is checked by the insert itself, so concurrent transactions cannot overdraw it.
+	res, err := s.db.ExecContext(ctx,
+		"INSERT OR IGNORE INTO loyalty_transactions (id, customer_id, kind, points, reference, created_at) SELECT ?, ?, ?, ?, ?, ? WHERE ? OR ? >= 0 OR (SELECT COALESCE(SUM(points), 0) FROM loyalty_transactions WHERE customer_id = ?) + ? >= 0",
+		transaction.ID, transaction.CustomerID, transaction.Kind, transaction.Points, transaction.Reference, transaction.CreatedAt,
+		overdraw, transaction.Points, transaction.CustomerID, transaction.Points,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+
+	var count int
+	err = s.db.GetContext(ctx, &count, "SELECT COUNT(*) FROM loyalty_transactions WHERE id = ?", transaction.ID)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrLoyaltyInsufficientPoints
+	}
+
+	// Already recorded.
+	return nil
+}
+
+// GetLoyaltyTransactions returns a customer's loyalty points transactions, oldest first, from the SQLite instance
+func (s *SQLiteDB) GetLoyaltyTransactions(ctx context.Context, customerID string, result *[]LoyaltyTransaction) error {
+	return s.db.SelectContext(ctx, result, "SELECT id, customer_id, kind, points, reference, created_at FROM loyalty_transactions WHERE customer_id = ? ORDER BY created_at, id", customerID)
+}
+
+// GetFraudRules returns all fraud rules, ordered by ID, from the SQLite instance
+func (s *SQLiteDB) GetFraudRules(ctx context.Context, result *[]FraudRule) error {
+	return s.db.SelectContext(ctx, result, "SELECT id, type, customer_id, segment, limit_amount, review_amount, max_charges, time_window FROM fraud_rules ORDER BY id")
+}
+
+// UpsertFraudRule inserts or replaces a fraud rule in the SQLite instance
+func (s *SQLiteDB) UpsertFraudRule(ctx context.Context, rule *FraudRule) error {
+	_, err := s.db.NamedExecContext(ctx, "INSERT OR REPLACE INTO fraud_rules (id, type, customer_id, segment, limit_amount, review_amount, max_charges, time_window) VALUES (:id, :type, :customer_id, :segment, :limit_amount, :review_amount, :max_charges, :time_window)", rule)
 	return err
 }
 
-// Close closes the connection to the SQLite instance
-func (s *SQLiteDB) Close() error {
-	return s.db.Close()
+// DeleteFraudRule removes a fraud rule from the SQLite instance.
+// It returns ErrFraudRuleNotFound if there is no rule with the ID.
+func (s *SQLiteDB) DeleteFraudRule(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM fraud_rules WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrFraudRuleNotFound
+	}
+	return nil
 }
 
-// InsertOrder inserts an Order into the SQLite instance
-func (s *SQLiteDB) InsertOrder(ctx context.Context, order *OrderStatus) error {
-	_, err := s.db.NamedExecContext(ctx, "INSERT OR IGNORE INTO orders (id, customer_id, received_at, status) VALUES (:id, :customer_id, :received_at, :status)", order)
+// GetFraudSegments returns the segments customers belong to from the SQLite instance
+func (s *SQLiteDB) GetFraudSegments(ctx context.Context, result *[]FraudSegment) error {
+	return s.db.SelectContext(ctx, result, "SELECT customer_id, segment FROM fraud_segments ORDER BY customer_id")
+}
+
+// SetFraudSegment sets the segment a customer belongs to in the SQLite instance.
+// An empty segment removes the customer from their segment.
+func (s *SQLiteDB) SetFraudSegment(ctx context.Context, segment *FraudSegment) error {
+	if segment.Segment == "" {
+		_, err := s.db.ExecContext(ctx, "DELETE FROM fraud_segments WHERE customer_id = ?", segment.CustomerID)
+		return err
+	}
+
+	_, err := s.db.NamedExecContext(ctx, "INSERT OR REPLACE INTO fraud_segments (customer_id, segment) VALUES (:customer_id, :segment)", segment)
 	return err
 }
 
-// UpdateOrderStatus updates an Order in the SQLite instance
-func (s *SQLiteDB) UpdateOrderStatus(ctx context.Context, id string, status string) error {
-	var err error
-	if status == "completed" {
-		_, err = s.db.ExecContext(ctx, "UPDATE orders SET status = ?, completed_at = ? WHERE id = ?", status, time.Now().UTC(), id)
-	} else {
-		_, err = s.db.ExecContext(ctx, "UPDATE orders SET status = ? WHERE id = ?", status, id)
+// ReplaceFraudRules replaces all fraud rules and customer segments in the SQLite instance
+func (s *SQLiteDB) ReplaceFraudRules(ctx context.Context, rules []FraudRule, segments []FraudSegment) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM fraud_rules"); err != nil {
+		return err
+	}
+	for _, r := range rules {
+		_, err := tx.NamedExecContext(ctx, "INSERT OR REPLACE INTO fraud_rules (id, type, customer_id, segment, limit_amount, review_amount, max_charges, time_window) VALUES (:id, :type, :customer_id, :segment, :limit_amount, :review_amount, :max_charges, :time_window)", r)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM fraud_segments"); err != nil {
+		return err
+	}
+	for _, sg := range segments {
+		if sg.Segment == "" {
+			continue
+		}
+		_, err := tx.NamedExecContext(ctx, "INSERT OR REPLACE INTO fraud_segments (customer_id, segment) VALUES (:customer_id, :segment)", sg)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetFraudCharges returns a customer's charges made at or after since, oldest first, from the SQLite instance
+func (s *SQLiteDB) GetFraudCharges(ctx context.Context, customerID string, since time.Time, result *[]FraudCharge) error {
+	return s.db.SelectContext(ctx, result, "SELECT id, customer_id, amount, created_at, shipping_address FROM fraud_charges WHERE customer_id = ? AND created_at >= ? ORDER BY created_at, id", customerID, since.UTC())
+}
+
+// GetFirstFraudCharge returns a customer's oldest charge from the SQLite instance.
+// It returns ErrFraudChargeNotFound if the customer has no charges.
+func (s *SQLiteDB) GetFirstFraudCharge(ctx context.Context, customerID string, result *FraudCharge) error {
+	err := s.db.GetContext(ctx, result, "SELECT id, customer_id, amount, created_at, shipping_address FROM fraud_charges WHERE customer_id = ? ORDER BY created_at, id LIMIT 1", customerID)
+	if err == sql.ErrNoRows {
+		return ErrFraudChargeNotFound
 	}
 	return err
 }
 
-// GetOrders returns a list of Orders from the SQLite instance
-func (s *SQLiteDB) GetOrders(ctx context.Context, result *[]OrderStatus) error {
-	return s.db.SelectContext(ctx, result, "SELECT id, status, received_at FROM orders ORDER BY received_at DESC")
+// DeleteFraudCharges removes every customer's charges from the SQLite instance
+func (s *SQLiteDB) DeleteFraudCharges(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM fraud_charges")
+	return err
 }
 
-// CountCompletedOrdersInRange counts completed Orders in a given time range
-func (s *SQLiteDB) CountCompletedOrdersInRange(ctx context.Context, start time.Time, end time.Time) (int, error) {
-	var count int
-	err := s.db.GetContext(ctx, &count, "SELECT COUNT(*) FROM orders WHERE completed_at >= ? AND completed_at <= ?", start.UTC(), end.UTC())
+// CheckFraudCharge passes a customer's charges made at or after since to decide, and records the charge decide returns,
+// if any, in the SQLite instance. Recording a charge with the same ID more than once has no further effect.
+// The transaction takes the write lock before the charges are read, so no other check, even by another process, can
+// record a charge in between. decide must not use the database.
+func (s *SQLiteDB) CheckFraudCharge(ctx context.Context, customerID string, since time.Time, decide func([]FraudCharge) (*FraudCharge, error)) error {
+	conn, err := s.db.Connx(ctx)
 	if err != nil {
-		return 0, err
+		return err
 	}
-	return count, nil
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			_, _ = conn.ExecContext(context.Background(), "ROLLBACK")
+		}
+	}()
+
+	var history []FraudCharge
+	err = conn.SelectContext(ctx, &history, "SELECT id, customer_id, amount, created_at, shipping_address FROM fraud_charges WHERE customer_id = ? AND created_at >= ? ORDER BY created_at, id", customerID, since.UTC())
+	if err != nil {
+		return err
+	}
+
+	charge, err := decide(history)
+	if err != nil {
+		return err
+	}
+	if charge != nil {
+		_, err = conn.ExecContext(ctx, "INSERT OR IGNORE INTO fraud_charges (id, customer_id, amount, created_at, shipping_address) VALUES (?, ?, ?, ?, ?)", charge.ID, charge.CustomerID, charge.Amount, charge.CreatedAt.UTC(), charge.ShippingAddress)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := conn.ExecContext(ctx, "COMMIT"); err != nil {
+		return err
+	}
+	committed = true
+
+	return nil
 }
 
-// UpdateShipmentStatus updates a Shipment in the SQLite instance
-func (s *SQLiteDB) UpdateShipmentStatus(ctx context.Context, id string, status string) error {
-	_, err := s.db.ExecContext(ctx, "INSERT INTO shipments (id, booked_at, status) VALUES (?, ?, ?) ON CONFLICT(id) DO UPDATE SET status = ?", id, time.Now().UTC(), status, status)
+// DeleteFraudCharge removes a charge from the SQLite instance
+func (s *SQLiteDB) DeleteFraudCharge(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM fraud_charges WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrFraudChargeNotFound
+	}
+	return nil
+}
+
+// UpsertFraudReview inserts or replaces a fraud review in the SQLite instance
+func (s *SQLiteDB) UpsertFraudReview(ctx context.Context, review *FraudReview) error {
+	_, err := s.db.NamedExecContext(ctx, "INSERT INTO fraud_reviews (id, invoice_reference, customer_id, amount, currency, reason, status, reviewer, note, review_by, opened_at, resolved_at) VALUES (:id, :invoice_reference, :customer_id, :amount, :currency, :reason, :status, :reviewer, :note, :review_by, :opened_at, :resolved_at) ON CONFLICT(id) DO UPDATE SET invoice_reference = excluded.invoice_reference, customer_id = excluded.customer_id, amount = excluded.amount, currency = excluded.currency, reason = excluded.reason, status = excluded.status, reviewer = excluded.reviewer, note = excluded.note, review_by = excluded.review_by, opened_at = excluded.opened_at, resolved_at = excluded.resolved_at", review)
 	return err
 }
 
-// GetShipments returns a list of Shipments from the SQLite instance
-func (s *SQLiteDB) GetShipments(ctx context.Context, result *[]ShipmentStatus) error {
-	return s.db.SelectContext(ctx, result, "SELECT id, status FROM shipments ORDER BY booked_at DESC")
+// GetFraudReview returns a fraud review from the SQLite instance.
+// It returns ErrFraudReviewNotFound if there is no review with the ID.
+func (s *SQLiteDB) GetFraudReview(ctx context.Context, id string, result *FraudReview) error {
+	err := s.db.GetContext(ctx, result, "SELECT id, invoice_reference, customer_id, amount, currency, reason, status, reviewer, note, review_by, opened_at, resolved_at FROM fraud_reviews WHERE id = ?", id)
+	if err == sql.ErrNoRows {
+		return ErrFraudReviewNotFound
+	}
+	return err
 }
 
-// GetPendingShipments returns a list of pending Shipments from the SQLite instance
-func (s *SQLiteDB) GetPendingShipments(ctx context.Context, result *[]ShipmentStatus) error {
-	return s.db.SelectContext(ctx, result, "SELECT id, status FROM shipments WHERE status != 'delivered'")
+// GetFraudReviews returns the fraud reviews with a status, or all of them if the status is empty, oldest first, from
+// the SQLite instance
+func (s *SQLiteDB) GetFraudReviews(ctx context.Context, status string, result *[]FraudReview) error {
+	return s.db.SelectContext(ctx, result, "SELECT id, invoice_reference, customer_id, amount, currency, reason, status, reviewer, note, review_by, opened_at, resolved_at FROM fraud_reviews WHERE ? = '' OR status = ? ORDER BY opened_at, id", status, status)
+}
+
+// GetFraudListEntries returns the allowlist and blocklist entries, ordered by kind and value, from the SQLite instance
+func (s *SQLiteDB) GetFraudListEntries(ctx context.Context, result *[]FraudListEntry) error {
+	return s.db.SelectContext(ctx, result, "SELECT kind, value, list, note, changed_by, changed_at FROM fraud_list_entries ORDER BY kind, value")
+}
+
+// GetFraudListEntry returns the allowlist or blocklist entry for a customer or email domain from the SQLite instance.
+// It returns ErrFraudListEntryNotFound if the value is on neither list.
+func (s *SQLiteDB) GetFraudListEntry(ctx context.Context, kind string, value string, result *FraudListEntry) error {
+	err := s.db.GetContext(ctx, result, "SELECT kind, value, list, note, changed_by, changed_at FROM fraud_list_entries WHERE kind = ? AND value = ?", kind, value)
+	if err == sql.ErrNoRows {
+		return ErrFraudListEntryNotFound
+	}
+	return err
+}
+
+// RecordFraudListChange applies a change to the allowlist or blocklist in the SQLite instance, and adds it to the
+// audit trail. It returns ErrFraudListEntryNotFound if the change removes an entry which does not exist.
+func (s *SQLiteDB) RecordFraudListChange(ctx context.Context, change *FraudListChange) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if change.List == "" {
+		res, err := tx.ExecContext(ctx, "DELETE FROM fraud_list_entries WHERE kind = ? AND value = ?", change.Kind, change.Value)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrFraudListEntryNotFound
+		}
+	} else {
+		_, err := tx.NamedExecContext(ctx, "INSERT OR REPLACE INTO fraud_list_entries (kind, value, list, note, changed_by, changed_at) VALUES (:kind, :value, :list, :note, :changed_by, :changed_at)", change)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.NamedExecContext(ctx, "INSERT INTO fraud_list_changes (id, kind, value, list, note, changed_by, changed_at) VALUES (:id, :kind, :value, :list, :note, :changed_by, :changed_at)", change)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetFraudListChanges returns the changes to the allowlist and blocklist for a customer or email domain, or every
+// change if kind is empty, oldest first, from the SQLite instance
+func (s *SQLiteDB) GetFraudListChanges(ctx context.Context, kind string, value string, result *[]FraudListChange) error {
+	return s.db.SelectContext(ctx, result, "SELECT id, kind, value, list, note, changed_by, changed_at FROM fraud_list_changes WHERE ? = '' OR (kind = ? AND value = ?) ORDER BY changed_at, id", kind, kind, value)
+}
+
+// InsertFraudDecision records a decision made by the fraud check in the SQLite instance
+func (s *SQLiteDB) InsertFraudDecision(ctx context.Context, decision *FraudDecision) error {
+	_, err := s.db.NamedExecContext(ctx, "INSERT INTO fraud_decisions (id, customer_id, email, charge, idempotency_key, approved, outcome, reason, list, tally, charges, limit_amount, review_amount, maintenance_mode, decided_at, score) VALUES (:id, :customer_id, :email, :charge, :idempotency_key, :approved, :outcome, :reason, :list, :tally, :charges, :limit_amount, :review_amount, :maintenance_mode, :decided_at, :score)", decision)
+	return err
+}
+
+// GetFraudDecision returns a decision made by the fraud check from the SQLite instance.
+// It returns ErrFraudDecisionNotFound if there is no decision with the ID.
+func (s *SQLiteDB) GetFraudDecision(ctx context.Context, id string, result *FraudDecision) error {
+	err := s.db.GetContext(ctx, result, "SELECT id, customer_id, email, charge, idempotency_key, approved, outcome, reason, list, tally, charges, limit_amount, review_amount, maintenance_mode, decided_at, score FROM fraud_decisions WHERE id = ?", id)
+	if err == sql.ErrNoRows {
+		return ErrFraudDecisionNotFound
+	}
+	return err
+}
+
+// GetFraudDecisions returns the fraud check's decisions for a customer, or for everyone if the customer ID is empty,
+// newest first, from the SQLite instance
+func (s *SQLiteDB) GetFraudDecisions(ctx context.Context, customerID string, result *[]FraudDecision) error {
+	return s.db.SelectContext(ctx, result, "SELECT id, customer_id, email, charge, idempotency_key, approved, outcome, reason, list, tally, charges, limit_amount, review_amount, maintenance_mode, decided_at, score FROM fraud_decisions WHERE ? = '' OR customer_id = ? ORDER BY decided_at DESC, rowid DESC", customerID, customerID)
+}
+
+// GetFraudSetting returns a named fraud check setting from the SQLite instance.
+// It returns ErrFraudSettingNotFound if the setting has not been set.
+func (s *SQLiteDB) GetFraudSetting(ctx context.Context, name string, result *FraudSetting) error {
+	err := s.db.GetContext(ctx, result, "SELECT name, value FROM fraud_settings WHERE name = ?", name)
+	if err == sql.ErrNoRows {
+		return ErrFraudSettingNotFound
+	}
+	return err
+}
+
+// SetFraudSetting inserts or replaces a named fraud check setting in the SQLite instance
+func (s *SQLiteDB) SetFraudSetting(ctx context.Context, setting *FraudSetting) error {
+	_, err := s.db.NamedExecContext(ctx, "INSERT OR REPLACE INTO fraud_settings (name, value) VALUES (:name, :value)", setting)
+	return err
+}
+
+// DeleteFraudSetting removes a named fraud check setting, if it has been set, from the SQLite instance
+func (s *SQLiteDB) DeleteFraudSetting(ctx context.Context, name string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM fraud_settings WHERE name = ?", name)
+	return err
+}
+
+// UpsertFraudMaintenanceWindow inserts or replaces a fraud maintenance window in the SQLite instance
+func (s *SQLiteDB) UpsertFraudMaintenanceWindow(ctx context.Context, window *FraudMaintenanceWindow) error {
+	_, err := s.db.NamedExecContext(ctx, "INSERT OR REPLACE INTO fraud_maintenance_windows (id, reason, starts_at, ends_at, created_by, created_at) VALUES (:id, :reason, :starts_at, :ends_at, :created_by, :created_at)", window)
+	return err
+}
+
+// GetFraudMaintenanceWindow returns a fraud maintenance window from the SQLite instance.
+// It returns ErrFraudMaintenanceWindowNotFound if there is no window with the ID.
+func (s *SQLiteDB) GetFraudMaintenanceWindow(ctx context.Context, id string, result *FraudMaintenanceWindow) error {
+	err := s.db.GetContext(ctx, result, "SELECT id, reason, starts_at, ends_at, created_by, created_at FROM fraud_maintenance_windows WHERE id = ?", id)
+	if err == sql.ErrNoRows {
+		return ErrFraudMaintenanceWindowNotFound
+	}
+	return err
+}
+
+// GetFraudMaintenanceWindows returns every fraud maintenance window, earliest first, from the SQLite instance
+func (s *SQLiteDB) GetFraudMaintenanceWindows(ctx context.Context, result *[]FraudMaintenanceWindow) error {
+	return s.db.SelectContext(ctx, result, "SELECT id, reason, starts_at, ends_at, created_by, created_at FROM fraud_maintenance_windows ORDER BY starts_at, id")
+}
+
+// DeleteFraudMaintenanceWindow removes a fraud maintenance window from the SQLite instance.
+// It returns ErrFraudMaintenanceWindowNotFound if there is no window with the ID.
+func (s *SQLiteDB) DeleteFraudMaintenanceWindow(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM fraud_maintenance_windows WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrFraudMaintenanceWindowNotFound
+	}
+	return nil
 }
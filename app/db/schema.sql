@@ -17,3 +17,253 @@ CREATE TABLE IF NOT EXISTS shipments (
 
 CREATE INDEX IF NOT EXISTS shipments_booked_at ON shipments (booked_at DESC);
 CREATE INDEX IF NOT EXISTS shipments_pending ON shipments (status != 'delivered');
+
+CREATE TABLE IF NOT EXISTS inventory (
+    warehouse TEXT NOT NULL,
+    sku TEXT NOT NULL,
+    quantity INTEGER NOT NULL CHECK (quantity >= 0),
+    PRIMARY KEY (warehouse, sku)
+);
+
+CREATE INDEX IF NOT EXISTS inventory_sku ON inventory (sku);
+
+CREATE TABLE IF NOT EXISTS inventory_reservations (
+    order_id TEXT NOT NULL,
+    warehouse TEXT NOT NULL,
+    sku TEXT NOT NULL,
+    quantity INTEGER NOT NULL,
+    PRIMARY KEY (order_id, sku)
+);
+
+CREATE TABLE IF NOT EXISTS products (
+    sku TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    unit_price INTEGER NOT NULL CHECK (unit_price >= 0),
+    weight INTEGER NOT NULL CHECK (weight >= 0),
+    tax_class TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS invoices (
+    reference TEXT PRIMARY KEY,
+    customer_id TEXT NOT NULL,
+    charge_key TEXT NOT NULL,
+    sub_total INTEGER NOT NULL,
+    shipping INTEGER NOT NULL,
+    tax INTEGER NOT NULL,
+    total INTEGER NOT NULL,
+    shipping_rule TEXT NOT NULL,
+    currency TEXT NOT NULL DEFAULT '',
+    base_currency TEXT NOT NULL DEFAULT '',
+    exchange_rate INTEGER NOT NULL DEFAULT 0,
+    status TEXT NOT NULL,
+    auth_code TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS invoices_customer_id ON invoices (customer_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS invoices_created_at ON invoices (created_at DESC);
+
+CREATE TABLE IF NOT EXISTS invoice_items (
+    reference TEXT NOT NULL,
+    sku TEXT NOT NULL,
+    quantity INTEGER NOT NULL,
+    sub_total INTEGER NOT NULL,
+    shipping INTEGER NOT NULL,
+    tax INTEGER NOT NULL,
+    total INTEGER NOT NULL,
+    tax_region TEXT NOT NULL,
+    tax_class TEXT NOT NULL,
+    tax_rate INTEGER NOT NULL,
+    promotion TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS invoice_items_reference ON invoice_items (reference);
+
+CREATE TABLE IF NOT EXISTS ledger_transactions (
+    id TEXT PRIMARY KEY,
+    kind TEXT NOT NULL,
+    reference TEXT NOT NULL,
+    customer_id TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS ledger_transactions_customer_id ON ledger_transactions (customer_id);
+
+CREATE TABLE IF NOT EXISTS ledger_entries (
+    transaction_id TEXT NOT NULL,
+    account TEXT NOT NULL,
+    debit INTEGER NOT NULL CHECK (debit >= 0),
+    credit INTEGER NOT NULL CHECK (credit >= 0)
+);
+
+CREATE INDEX IF NOT EXISTS ledger_entries_transaction_id ON ledger_entries (transaction_id);
+
+CREATE TABLE IF NOT EXISTS payment_methods (
+    id TEXT PRIMARY KEY,
+    customer_id TEXT NOT NULL,
+    type TEXT NOT NULL,
+    token TEXT NOT NULL,
+    expiry_month INTEGER NOT NULL,
+    expiry_year INTEGER NOT NULL,
+    is_default BOOLEAN NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS payment_methods_customer_id ON payment_methods (customer_id);
+
+CREATE TABLE IF NOT EXISTS disputes (
+    id TEXT PRIMARY KEY,
+    invoice_reference TEXT NOT NULL,
+    customer_id TEXT NOT NULL,
+    amount INTEGER NOT NULL,
+    reason TEXT NOT NULL,
+    status TEXT NOT NULL,
+    evidence_collected_at TIMESTAMP NOT NULL,
+    shipment_status TEXT NOT NULL,
+    shipment_updated_at TIMESTAMP NOT NULL,
+    respond_by TIMESTAMP NOT NULL,
+    opened_at TIMESTAMP NOT NULL,
+    resolved_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS disputes_invoice_reference ON disputes (invoice_reference);
+
+CREATE TABLE IF NOT EXISTS promotions (
+    code TEXT PRIMARY KEY,
+    description TEXT NOT NULL,
+    type TEXT NOT NULL,
+    rate INTEGER NOT NULL,
+    amount INTEGER NOT NULL,
+    buy_quantity INTEGER NOT NULL,
+    get_quantity INTEGER NOT NULL,
+    limit_per_customer INTEGER NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS promotion_skus (
+    code TEXT NOT NULL,
+    sku TEXT NOT NULL,
+    PRIMARY KEY (code, sku)
+);
+
+CREATE TABLE IF NOT EXISTS promotion_redemptions (
+    code TEXT NOT NULL,
+    customer_id TEXT NOT NULL,
+    reference TEXT NOT NULL,
+    redeemed_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (code, reference)
+);
+
+CREATE INDEX IF NOT EXISTS promotion_redemptions_customer_id ON promotion_redemptions (code, customer_id);
+
+CREATE TABLE IF NOT EXISTS loyalty_transactions (
+    id TEXT PRIMARY KEY,
+    customer_id TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    points INTEGER NOT NULL,
+    reference TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS loyalty_transactions_customer_id ON loyalty_transactions (customer_id, created_at);
+
+CREATE TABLE IF NOT EXISTS fraud_rules (
+    id TEXT PRIMARY KEY,
+    type TEXT NOT NULL,
+    customer_id TEXT NOT NULL,
+    segment TEXT NOT NULL,
+    limit_amount INTEGER NOT NULL,
+    review_amount INTEGER NOT NULL DEFAULT 0,
+    max_charges INTEGER NOT NULL,
+    time_window TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS fraud_segments (
+    customer_id TEXT PRIMARY KEY,
+    segment TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS fraud_charges (
+    id TEXT PRIMARY KEY,
+    customer_id TEXT NOT NULL,
+    amount INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    shipping_address TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS fraud_charges_customer_id ON fraud_charges (customer_id, created_at);
+
+CREATE TABLE IF NOT EXISTS fraud_reviews (
+    id TEXT PRIMARY KEY,
+    invoice_reference TEXT NOT NULL,
+    customer_id TEXT NOT NULL,
+    amount INTEGER NOT NULL,
+    currency TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    status TEXT NOT NULL,
+    reviewer TEXT NOT NULL,
+    note TEXT NOT NULL,
+    review_by TIMESTAMP NOT NULL,
+    opened_at TIMESTAMP NOT NULL,
+    resolved_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS fraud_reviews_status ON fraud_reviews (status, opened_at);
+
+CREATE TABLE IF NOT EXISTS fraud_list_entries (
+    kind TEXT NOT NULL,
+    value TEXT NOT NULL,
+    list TEXT NOT NULL,
+    note TEXT NOT NULL,
+    changed_by TEXT NOT NULL,
+    changed_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (kind, value)
+);
+
+CREATE TABLE IF NOT EXISTS fraud_list_changes (
+    id TEXT PRIMARY KEY,
+    kind TEXT NOT NULL,
+    value TEXT NOT NULL,
+    list TEXT NOT NULL,
+    note TEXT NOT NULL,
+    changed_by TEXT NOT NULL,
+    changed_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS fraud_list_changes_kind ON fraud_list_changes (kind, value, changed_at);
+
+CREATE TABLE IF NOT EXISTS fraud_decisions (
+    id TEXT PRIMARY KEY,
+    customer_id TEXT NOT NULL,
+    email TEXT NOT NULL,
+    charge INTEGER NOT NULL,
+    idempotency_key TEXT NOT NULL,
+    approved BOOLEAN NOT NULL,
+    outcome TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    list TEXT NOT NULL,
+    tally INTEGER NOT NULL,
+    charges INTEGER NOT NULL,
+    limit_amount INTEGER NOT NULL,
+    review_amount INTEGER NOT NULL,
+    maintenance_mode BOOLEAN NOT NULL,
+    decided_at TIMESTAMP NOT NULL,
+    score INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS fraud_decisions_customer_id ON fraud_decisions (customer_id, decided_at DESC);
+
+CREATE TABLE IF NOT EXISTS fraud_settings (
+    name TEXT PRIMARY KEY,
+    value TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS fraud_maintenance_windows (
+    id TEXT PRIMARY KEY,
+    reason TEXT NOT NULL,
+    starts_at TIMESTAMP NOT NULL,
+    ends_at TIMESTAMP NOT NULL,
+    created_by TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
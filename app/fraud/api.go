@@ -1,69 +1,232 @@
 package fraud
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
+	"math"
 	"net/http"
-	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/temporalio/reference-app-orders-go/app/currency"
+	"github.com/temporalio/reference-app-orders-go/app/db"
 )
 
 // FraudLimitInput is the input for the SetLimit API.
 type FraudLimitInput struct {
-	Limit int32 `json:"limit"`
+	// Limit is the most each customer may be charged in total, in the base currency, or zero for no limit.
+	Limit currency.Money `json:"limit"`
+	// ReviewLimit is the total above which charges are held for a manager to review, or zero for no reviews.
+	ReviewLimit currency.Money `json:"reviewLimit,omitzero"`
 }
 
 // FraudSettingsResult is the result for the GetSettings API.
 type FraudSettingsResult struct {
-	Limit           int32 `json:"limit"`
-	MaintenanceMode bool  `json:"maintenanceMode"`
+	Limit           currency.Money `json:"limit"`
+	ReviewLimit     currency.Money `json:"reviewLimit,omitzero"`
+	MaintenanceMode bool           `json:"maintenanceMode"`
+	// Maintenance is the maintenance window in force, if the service is in maintenance mode.
+	Maintenance *MaintenanceWindow `json:"maintenance,omitempty"`
+}
+
+// MaintenanceInput is the input for the EnableMaintenance and ScheduleMaintenance APIs.
+type MaintenanceInput struct {
+	// Reason is shown to Billing while the fraud check is down, or empty for DefaultMaintenanceReason.
+	Reason string `json:"reason,omitempty"`
+	// StartsAt is when a scheduled window starts. Windows enabled with the EnableMaintenance API start at once.
+	StartsAt time.Time `json:"startsAt,omitzero"`
+	// EndsAt is when the window ends, or zero to last until maintenance mode is disabled.
+	EndsAt time.Time `json:"endsAt,omitzero"`
+	// CreatedBy names the manager opening the window.
+	CreatedBy string `json:"createdBy,omitempty"`
+}
+
+// MaintenanceWindow is a time during which the fraud check is down for maintenance, and answers every check with
+// status 503 Service Unavailable.
+type MaintenanceWindow struct {
+	ID       string    `json:"id"`
+	Reason   string    `json:"reason"`
+	StartsAt time.Time `json:"startsAt"`
+	// EndsAt is when the window ends, or zero if it lasts until maintenance mode is disabled.
+	EndsAt    time.Time `json:"endsAt,omitzero"`
+	CreatedBy string    `json:"createdBy,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
 }
 
 // FraudCheckInput is the input for the check endpoint.
 type FraudCheckInput struct {
 	CustomerID string `json:"customerId"`
-	Charge     int32  `json:"charge"`
+	// Email is the customer's email address, whose domain is matched against the allowlist and blocklist.
+	Email string `json:"email,omitempty"`
+	// ShippingAddress is where the order is shipped to, and SKUs are the products charged for. The scoring model
+	// uses them to score the charge's risk.
+	ShippingAddress string   `json:"shippingAddress,omitempty"`
+	SKUs            []string `json:"skus,omitempty"`
+	// Charge is the amount charged in the base currency, so charges in different currencies can be added up.
+	Charge currency.Money `json:"charge"`
+	// IdempotencyKey identifies the charge, so that checking it again does not count it twice, and so that it can be
+	// released if it is not authorized.
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+	// Approved is set when a manager has approved a charge which was held for review. The charge is recorded without
+	// being checked again.
+	Approved bool `json:"approved,omitempty"`
 }
 
 // FraudCheckResult is the result for the check endpoint.
+// A charge which is neither declined nor held for review is approved.
 type FraudCheckResult struct {
 	Declined bool `json:"declined"`
+	// Review is set when the charge is close enough to a limit that a manager should decide whether it goes ahead.
+	Review bool `json:"review,omitempty"`
+	// Reason is the ID of the rule which declined the charge or held it for review, or ReasonBlocklist if the customer
+	// or their email domain is on the blocklist, or ReasonScore if the charge's risk score passed a threshold.
+	Reason string `json:"reason,omitempty"`
+	// Score is the charge's risk score from 0 to 100, if a scoring model is configured.
+	Score int32 `json:"score,omitempty"`
+	// DecisionID is the ID of the stored Decision, which records what the result was based on.
+	DecisionID string `json:"decisionId,omitempty"`
+	// Maintenance is the maintenance window the fraud check is down for. It is only returned with status
+	// 503 Service Unavailable, when the charge was not checked.
+	Maintenance *MaintenanceWindow `json:"maintenance,omitempty"`
+}
+
+// ListEntryInput is the input for the SetListEntry API.
+type ListEntryInput struct {
+	// List is the list to put the customer or email domain on, ListAllow or ListBlock.
+	List string `json:"list"`
+	Note string `json:"note,omitempty"`
+	// ChangedBy names the manager making the change, for the audit trail.
+	ChangedBy string `json:"changedBy"`
+}
+
+// ListEntry is a customer or email domain on the allowlist or blocklist.
+type ListEntry struct {
+	// Kind is ListKindCustomer or ListKindDomain.
+	Kind string `json:"kind"`
+	// Value is the customer ID or email domain.
+	Value     string    `json:"value"`
+	List      string    `json:"list"`
+	Note      string    `json:"note,omitempty"`
+	ChangedBy string    `json:"changedBy"`
+	ChangedAt time.Time `json:"changedAt"`
+}
+
+// Decision is a record of a fraud check: what it was asked, what it decided, and the tally and settings it decided on.
+type Decision struct {
+	ID             string         `json:"id"`
+	CustomerID     string         `json:"customerId"`
+	Email          string         `json:"email,omitempty"`
+	Charge         currency.Money `json:"charge"`
+	IdempotencyKey string         `json:"idempotencyKey,omitempty"`
+	// Approved is set when the check was for a charge a manager had approved after a review.
+	Approved bool `json:"approved,omitempty"`
+	// Outcome is one of the Decision constants.
+	Outcome string `json:"outcome"`
+	// Reason is the reason given in the result: the rule which fired, ReasonBlocklist or ReasonScore.
+	Reason string `json:"reason,omitempty"`
+	// Score is the charge's risk score, if a scoring model is configured.
+	Score int32 `json:"score,omitempty"`
+	// List is the list the customer or their email domain was on, if any.
+	List string `json:"list,omitempty"`
+	// Tally is the total of the customer's earlier charges within the longest window of the rules which apply to them,
+	// and Charges how many there were.
+	Tally   currency.Money `json:"tally"`
+	Charges int32          `json:"charges"`
+	// Limit and ReviewLimit are the manager's limit in force, and MaintenanceMode whether the service was in
+	// maintenance mode.
+	Limit           currency.Money `json:"limit"`
+	ReviewLimit     currency.Money `json:"reviewLimit,omitzero"`
+	MaintenanceMode bool           `json:"maintenanceMode"`
+	DecidedAt       time.Time      `json:"decidedAt"`
+}
+
+// ListChange is a record of who added, moved or removed an allowlist or blocklist entry, and when.
+type ListChange struct {
+	ID    string `json:"id"`
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+	// List is the list the entry was put on, or empty if the entry was removed.
+	List      string    `json:"list,omitempty"`
+	Note      string    `json:"note,omitempty"`
+	ChangedBy string    `json:"changedBy"`
+	ChangedAt time.Time `json:"changedAt"`
+}
+
+// SegmentInput is the input for the SetSegment API.
+type SegmentInput struct {
+	// Segment is the segment the customer belongs to, or empty to remove them from their segment.
+	Segment string `json:"segment"`
 }
 
 type handlers struct {
-	limit               int32
-	maintenanceMode     bool
-	tallyLock           sync.Mutex
-	customerChargeTally map[string]int32
-	logger              *slog.Logger
+	db     db.DB
+	logger *slog.Logger
 }
 
-// Router implements the http.Handler interface for the Billing API
-func Router(logger *slog.Logger) http.Handler {
+// Router implements the http.Handler interface for the Fraud API
+func Router(db db.DB, logger *slog.Logger) http.Handler {
 	r := http.NewServeMux()
-	h := handlers{customerChargeTally: make(map[string]int32), logger: logger}
+	h := handlers{db: db, logger: logger}
 
 	r.HandleFunc("GET /settings", h.handleGetSettings)
 	r.HandleFunc("POST /limit", h.handleSetLimit)
-	r.HandleFunc("POST /maintenance", h.handleSetMaintenanceMode)
+	r.HandleFunc("POST /maintenance", h.handleEnableMaintenance)
+	r.HandleFunc("DELETE /maintenance", h.handleDisableMaintenance)
+	r.HandleFunc("GET /maintenance/windows", h.handleGetMaintenanceWindows)
+	r.HandleFunc("POST /maintenance/windows", h.handleScheduleMaintenance)
+	r.HandleFunc("DELETE /maintenance/windows/{id}", h.handleCancelMaintenanceWindow)
 	r.HandleFunc("POST /reset", h.handleReset)
+	r.HandleFunc("GET /rules", h.handleGetRules)
+	r.HandleFunc("PUT /rules", h.handleReplaceRules)
+	r.HandleFunc("POST /rules", h.handleUpsertRule)
+	r.HandleFunc("DELETE /rules/{id}", h.handleDeleteRule)
+	r.HandleFunc("PUT /segments/{customerId}", h.handleSetSegment)
+	r.HandleFunc("GET /scoring", h.handleGetScoringModel)
+	r.HandleFunc("PUT /scoring", h.handleSetScoringModel)
+	r.HandleFunc("DELETE /scoring", h.handleDeleteScoringModel)
+	r.HandleFunc("GET /lists", h.handleGetListEntries)
+	r.HandleFunc("GET /lists/changes", h.handleGetListChanges)
+	r.HandleFunc("GET /lists/{kind}/{value}", h.handleGetListEntry)
+	r.HandleFunc("PUT /lists/{kind}/{value}", h.handleSetListEntry)
+	r.HandleFunc("DELETE /lists/{kind}/{value}", h.handleDeleteListEntry)
 	r.HandleFunc("POST /check", h.handleRunCheck)
+	r.HandleFunc("DELETE /charges/{id}", h.handleReleaseCharge)
+	r.HandleFunc("GET /decisions", h.handleGetDecisions)
+	r.HandleFunc("GET /decisions/{id}", h.handleGetDecision)
 
 	return r
 }
 
-func (h *handlers) handleGetSettings(w http.ResponseWriter, _ *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+func (h *handlers) handleGetSettings(w http.ResponseWriter, r *http.Request) {
+	rules, err := GetRules(r.Context(), h.db)
+	if err != nil {
+		h.logger.Error("Failed to get fraud rules", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	err := json.NewEncoder(w).Encode(FraudSettingsResult{
-		Limit:           h.limit,
-		MaintenanceMode: h.maintenanceMode,
-	})
+	maintenance, err := activeMaintenance(r.Context(), h.db, time.Now())
 	if err != nil {
-		h.logger.Error("Failed to encode limit result", "error", err)
+		h.logger.Error("Failed to get maintenance windows", "error", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+
+	result := FraudSettingsResult{MaintenanceMode: maintenance != nil, Maintenance: maintenance}
+	for _, rule := range rules.Rules {
+		if rule.ID == LimitRuleID {
+			result.Limit = rule.Limit
+			result.ReviewLimit = rule.ReviewLimit
+		}
+	}
+
+	h.encode(w, result)
 }
 
+// handleSetLimit sets the limit rule, which caps how much every customer may be charged in total.
 func (h *handlers) handleSetLimit(w http.ResponseWriter, r *http.Request) {
 	var input FraudLimitInput
 
@@ -74,32 +237,157 @@ func (h *handlers) handleSetLimit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.limit = input.Limit
+	if input.Limit.Amount < 0 {
+		http.Error(w, "limit must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	if input.Limit.IsZero() {
+		err = h.db.DeleteFraudRule(r.Context(), LimitRuleID)
+		if errors.Is(err, db.ErrFraudRuleNotFound) {
+			err = nil
+		}
+	} else {
+		rule := Rule{ID: LimitRuleID, Type: RuleTypeSpend, Limit: input.Limit, ReviewLimit: input.ReviewLimit}
+		if err := rule.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		dbRule := ruleToDB(rule)
+		err = h.db.UpsertFraudRule(r.Context(), &dbRule)
+	}
+	if err != nil {
+		h.logger.Error("Failed to store limit", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// handleReset forgets every customer's charges, removes the limit rule and ends any maintenance window in force.
+// Other rules, and scheduled maintenance windows, are kept.
+func (h *handlers) handleReset(w http.ResponseWriter, r *http.Request) {
+	err := h.db.DeleteFraudCharges(r.Context())
+	if err == nil {
+		err = h.db.DeleteFraudRule(r.Context(), LimitRuleID)
+		if errors.Is(err, db.ErrFraudRuleNotFound) {
+			err = nil
+		}
+	}
+	if err == nil {
+		err = endMaintenance(r.Context(), h.db, time.Now())
+	}
+	if err != nil {
+		h.logger.Error("Failed to reset fraud check", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 }
 
-func (h *handlers) handleReset(http.ResponseWriter, *http.Request) {
-	h.tallyLock.Lock()
-	h.customerChargeTally = make(map[string]int32)
-	h.tallyLock.Unlock()
+func (h *handlers) handleGetRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := GetRules(r.Context(), h.db)
+	if err != nil {
+		h.logger.Error("Failed to get fraud rules", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	h.limit = 0
-	h.maintenanceMode = false
+	h.encode(w, rules)
 }
 
-func (h *handlers) handleSetMaintenanceMode(w http.ResponseWriter, _ *http.Request) {
-	h.maintenanceMode = true
+func (h *handlers) handleReplaceRules(w http.ResponseWriter, r *http.Request) {
+	var input RuleSet
+
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		h.logger.Error("Failed to decode fraud rules", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := input.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := StoreRules(r.Context(), h.db, input); err != nil {
+		h.logger.Error("Failed to store fraud rules", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.encode(w, input)
+}
+
+func (h *handlers) handleUpsertRule(w http.ResponseWriter, r *http.Request) {
+	var input Rule
+
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		h.logger.Error("Failed to decode fraud rule", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := input.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rule := ruleToDB(input)
+
+	err = h.db.UpsertFraudRule(r.Context(), &rule)
+	if err != nil {
+		h.logger.Error("Failed to store fraud rule", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.encode(w, input)
+}
+
+func (h *handlers) handleDeleteRule(w http.ResponseWriter, r *http.Request) {
+	err := h.db.DeleteFraudRule(r.Context(), r.PathValue("id"))
+	if err != nil {
+		if errors.Is(err, db.ErrFraudRuleNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			h.logger.Error("Failed to delete fraud rule", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
-func (h *handlers) handleRunCheck(w http.ResponseWriter, r *http.Request) {
-	var input FraudCheckInput
+func (h *handlers) handleSetSegment(w http.ResponseWriter, r *http.Request) {
+	var input SegmentInput
 
-	if h.maintenanceMode {
-		http.Error(w, "Fraud service is in maintenance mode", http.StatusServiceUnavailable)
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		h.logger.Error("Failed to decode segment", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.db.SetFraudSegment(r.Context(), &db.FraudSegment{CustomerID: r.PathValue("customerId"), Segment: input.Segment})
+	if err != nil {
+		h.logger.Error("Failed to store segment", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	h.encode(w, input)
+}
+
+// handleRunCheck checks a charge against the rules which apply to the customer. Charges which pass are recorded, so
+// that they count towards the customer's later checks. Charges held for review are not recorded until a manager
+// approves them. Customers on the blocklist are declined, and those on the allowlist are approved, before the rules
+// are consulted. Every check is stored as a Decision, whose ID is returned with the result.
+// During a maintenance window charges are not checked, and the result, with status 503 Service Unavailable, says why
+// and until when.
+func (h *handlers) handleRunCheck(w http.ResponseWriter, r *http.Request) {
+	var input FraudCheckInput
+
 	err := json.NewDecoder(r.Body).Decode(&input)
 	if err != nil {
 		h.logger.Error("Failed to decode charge input", "error", err)
@@ -107,18 +395,305 @@ func (h *handlers) handleRunCheck(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.tallyLock.Lock()
-	declined := h.limit > 0 && input.Charge+h.customerChargeTally[input.CustomerID] > h.limit
-	if !declined {
-		h.customerChargeTally[input.CustomerID] += input.Charge
+	now := time.Now().UTC()
+
+	maintenance, err := activeMaintenance(r.Context(), h.db, now)
+	if err != nil {
+		h.logger.Error("Failed to get maintenance windows", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	h.tallyLock.Unlock()
-	result := FraudCheckResult{Declined: declined}
 
-	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(result)
+	decision := Decision{
+		ID:              uuid.NewString(),
+		CustomerID:      input.CustomerID,
+		Email:           input.Email,
+		Charge:          input.Charge,
+		IdempotencyKey:  input.IdempotencyKey,
+		Approved:        input.Approved,
+		MaintenanceMode: maintenance != nil,
+		DecidedAt:       now,
+	}
+
+	result, err := h.check(r.Context(), input, &decision)
+	if err != nil {
+		h.logger.Error("Failed to check charge", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = h.db.InsertFraudDecision(r.Context(), decisionToDB(decision))
+	if err != nil {
+		h.logger.Error("Failed to store fraud decision", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result.DecisionID = decision.ID
+
+	if decision.Outcome == DecisionUnavailable {
+		result.Maintenance = maintenance
+		h.unavailable(w, result, now)
+		return
+	}
+
+	h.encode(w, result)
+}
+
+// check decides whether a charge may go ahead, filling in the decision with the outcome and what it was based on.
+func (h *handlers) check(ctx context.Context, input FraudCheckInput, decision *Decision) (FraudCheckResult, error) {
+	var result FraudCheckResult
+
+	rules, err := GetRules(ctx, h.db)
+	if err != nil {
+		return result, fmt.Errorf("failed to get fraud rules: %w", err)
+	}
+	for _, rule := range rules.Rules {
+		if rule.ID == LimitRuleID {
+			decision.Limit = rule.Limit
+			decision.ReviewLimit = rule.ReviewLimit
+		}
+	}
+
+	if decision.MaintenanceMode {
+		decision.Outcome = DecisionUnavailable
+		return result, nil
+	}
+
+	decision.List, err = h.listFor(ctx, input.CustomerID, input.Email)
+	if err != nil {
+		return result, fmt.Errorf("failed to check fraud lists: %w", err)
+	}
+
+	now := decision.DecidedAt
+	lookback := rules.Lookback(input.CustomerID, now)
+
+	// The scoring model may look further back than the charges the rules count.
+	since := lookback
+	if rules.Scoring != nil {
+		if scored := rules.Scoring.Since(now); scored.Before(since) {
+			since = scored
+		}
+	}
+
+	// The customer's first charge may be older than any charge the model scores.
+	var first time.Time
+	if rules.Scoring != nil && rules.Scoring.Weights.NewCustomer > 0 {
+		var charge db.FraudCharge
+		err := h.db.GetFirstFraudCharge(ctx, input.CustomerID, &charge)
+		switch {
+		case err == nil:
+			if input.IdempotencyKey == "" || charge.ID != input.IdempotencyKey {
+				first = charge.CreatedAt
+			}
+		case !errors.Is(err, db.ErrFraudChargeNotFound):
+			return result, fmt.Errorf("failed to get first charge: %w", err)
+		}
+	}
+
+	// The charges are read and the charge recorded as one step, so that concurrent checks for a customer cannot both
+	// pass a limit only one of them fits within.
+	err = h.db.CheckFraudCharge(ctx, input.CustomerID, since, func(charges []db.FraudCharge) (*db.FraudCharge, error) {
+		var passed bool
+		result, passed = decide(input, decision, rules, lookback, first, charges)
+		if result.Reason != "" || passed {
+			return nil, nil
+		}
+
+		// Allowlisted charges still count towards the customer's later checks.
+		id := input.IdempotencyKey
+		if id == "" {
+			id = uuid.NewString()
+		}
+		return &db.FraudCharge{
+			ID:              id,
+			CustomerID:      input.CustomerID,
+			Amount:          input.Charge.Amount,
+			CreatedAt:       now,
+			ShippingAddress: input.ShippingAddress,
+		}, nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("failed to check charge: %w", err)
+	}
+
+	return result, nil
+}
+
+// decide decides whether a charge may go ahead given the customer's earlier charges, filling in the decision with the
+// outcome and the tally it was based on. It also reports whether the charge has already passed, and so is recorded.
+// first is when the customer made their first charge, or zero if it is not needed or this is their first.
+func decide(input FraudCheckInput, decision *Decision, rules RuleSet, lookback time.Time, first time.Time, charges []db.FraudCharge) (FraudCheckResult, bool) {
+	var result FraudCheckResult
+	now := decision.DecidedAt
+
+	passed := false
+	history := make([]Charge, 0, len(charges))
+	for _, c := range charges {
+		if input.IdempotencyKey != "" && c.ID == input.IdempotencyKey {
+			passed = true
+			continue
+		}
+		history = append(history, Charge{Amount: currency.Money{Amount: c.Amount}, At: c.CreatedAt, ShippingAddress: c.ShippingAddress})
+	}
+
+	var counted []currency.Money
+	for _, c := range history {
+		if !c.At.Before(lookback) {
+			counted = append(counted, c.Amount)
+		}
+	}
+	decision.Charges = int32(len(counted))
+	var err error
+	if decision.Tally, err = currency.Sum(counted...); err != nil {
+		// A tally too large to add up is shown as the largest amount.
+		decision.Tally = currency.Money{Amount: math.MaxInt64}
+	}
+
+	charge := Charge{Amount: input.Charge, At: now, ShippingAddress: input.ShippingAddress}
+	if rules.Scoring != nil {
+		result.Score = rules.Scoring.Score(charge, input.SKUs, history, first)
+	}
+
+	switch {
+	case decision.List == ListBlock:
+		result.Declined, result.Reason = true, ReasonBlocklist
+	case passed, decision.List == ListAllow, input.Approved:
+		// The charge has already passed, or bypasses the rules.
+	default:
+		result.Reason, result.Review = rules.Check(input.CustomerID, input.Charge, now, history)
+		if rules.Scoring != nil && (result.Reason == "" || result.Review) {
+			// A high enough score declines a charge a rule would only hold for review.
+			switch {
+			case rules.Scoring.declines(result.Score):
+				result.Reason, result.Review = ReasonScore, false
+			case result.Reason == "" && rules.Scoring.reviews(result.Score):
+				result.Reason, result.Review = ReasonScore, true
+			}
+		}
+		result.Declined = result.Reason != "" && !result.Review
+	}
+
+	decision.Reason = result.Reason
+	decision.Score = result.Score
+	switch {
+	case result.Declined:
+		decision.Outcome = DecisionDeclined
+	case result.Review:
+		decision.Outcome = DecisionReview
+	default:
+		decision.Outcome = DecisionApproved
+	}
+
+	return result, passed
+}
+
+// handleReleaseCharge forgets a charge which passed the check, by its idempotency key, so that it no longer counts
+// towards the customer's later checks. Billing releases charges which the payment gateway declined, and
+// authorizations which were voided or expired.
+func (h *handlers) handleReleaseCharge(w http.ResponseWriter, r *http.Request) {
+	err := h.db.DeleteFraudCharge(r.Context(), r.PathValue("id"))
 	if err != nil {
-		h.logger.Error("Failed to encode charge result", "error", err)
+		if errors.Is(err, db.ErrFraudChargeNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			h.logger.Error("Failed to release charge", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *handlers) encode(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.logger.Error("Failed to encode fraud result", "error", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
+
+// GetRules reads the fraud check's rule set from the database.
+func GetRules(ctx context.Context, store db.DB) (RuleSet, error) {
+	rules := RuleSet{Rules: []Rule{}}
+
+	var dbRules []db.FraudRule
+	if err := store.GetFraudRules(ctx, &dbRules); err != nil {
+		return rules, err
+	}
+	for _, r := range dbRules {
+		rules.Rules = append(rules.Rules, ruleFromDB(r))
+	}
+
+	var segments []db.FraudSegment
+	if err := store.GetFraudSegments(ctx, &segments); err != nil {
+		return rules, err
+	}
+	if len(segments) > 0 {
+		rules.Segments = make(map[string]string, len(segments))
+		for _, s := range segments {
+			rules.Segments[s.CustomerID] = s.Segment
+		}
+	}
+
+	var scoring db.FraudSetting
+	err := store.GetFraudSetting(ctx, scoringSetting, &scoring)
+	if errors.Is(err, db.ErrFraudSettingNotFound) {
+		return rules, nil
+	}
+	if err != nil {
+		return rules, err
+	}
+	if err := json.Unmarshal([]byte(scoring.Value), &rules.Scoring); err != nil {
+		return rules, fmt.Errorf("failed to decode scoring model: %w", err)
+	}
+
+	return rules, nil
+}
+
+// StoreRules replaces the fraud check's rule set in the database.
+func StoreRules(ctx context.Context, store db.DB, rules RuleSet) error {
+	dbRules := make([]db.FraudRule, len(rules.Rules))
+	for i, r := range rules.Rules {
+		dbRules[i] = ruleToDB(r)
+	}
+
+	var segments []db.FraudSegment
+	for customerID, segment := range rules.Segments {
+		segments = append(segments, db.FraudSegment{CustomerID: customerID, Segment: segment})
+	}
+
+	if err := store.ReplaceFraudRules(ctx, dbRules, segments); err != nil {
+		return err
+	}
+
+	return storeScoringModel(ctx, store, rules.Scoring)
+}
+
+func ruleFromDB(r db.FraudRule) Rule {
+	return Rule{
+		ID:          r.ID,
+		Type:        r.Type,
+		CustomerID:  r.CustomerID,
+		Segment:     r.Segment,
+		Limit:       currency.Money{Amount: r.LimitAmount},
+		ReviewLimit: currency.Money{Amount: r.ReviewAmount},
+		MaxCharges:  r.MaxCharges,
+		Window:      r.TimeWindow,
+	}
+}
+
+func ruleToDB(r Rule) db.FraudRule {
+	return db.FraudRule{
+		ID:           r.ID,
+		Type:         r.Type,
+		CustomerID:   r.CustomerID,
+		Segment:      r.Segment,
+		LimitAmount:  r.Limit.Amount,
+		ReviewAmount: r.ReviewLimit.Amount,
+		MaxCharges:   r.MaxCharges,
+		TimeWindow:   r.Window,
+	}
+}
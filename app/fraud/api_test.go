@@ -1,21 +1,63 @@
 package fraud_test
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/temporalio/reference-app-orders-go/app/currency"
+	"github.com/temporalio/reference-app-orders-go/app/db"
 	"github.com/temporalio/reference-app-orders-go/app/fraud"
 )
 
+func newStore(t *testing.T) db.DB {
+	store := db.NewSQLiteDB(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, store.Connect(context.Background()))
+	require.NoError(t, store.Setup())
+	t.Cleanup(func() { store.Close() })
+
+	return store
+}
+
+func do(t *testing.T, r http.Handler, method string, path string, body string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, path, strings.NewReader(body))
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	return rr
+}
+
+func decode[T any](t *testing.T, rr *httptest.ResponseRecorder) T {
+	var v T
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&v))
+	return v
+}
+
+// decodeCheck decodes the result of a check, which must have been stored as a decision, without the decision's ID.
+func decodeCheck(t *testing.T, rr *httptest.ResponseRecorder) fraud.FraudCheckResult {
+	result := decode[fraud.FraudCheckResult](t, rr)
+	assert.NotEmpty(t, result.DecisionID)
+	result.DecisionID = ""
+	return result
+}
+
 func TestMaintenanceMode(t *testing.T) {
 
 	logger := slog.Default()
 
-	r := fraud.Router(logger)
+	r := fraud.Router(newStore(t), logger)
 
 	req, err := http.NewRequest("POST", "/check", strings.NewReader(`{"customer_id":"1","charge":100}`))
 	require.NoError(t, err)
@@ -52,3 +94,449 @@ func TestMaintenanceMode(t *testing.T) {
 	r.ServeHTTP(rr, req)
 	require.Equal(t, rr.Code, http.StatusOK)
 }
+
+func TestMaintenanceWindows(t *testing.T) {
+	r := fraud.Router(newStore(t), slog.Default())
+
+	endsAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	rr := do(t, r, "POST", "/maintenance", `{"reason":"Database upgrade","endsAt":"`+endsAt.Format(time.RFC3339)+`"}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	enabled := decode[fraud.MaintenanceWindow](t, rr)
+	assert.Equal(t, "Database upgrade", enabled.Reason)
+
+	// Checks are refused, saying why and until when.
+	rr = do(t, r, "POST", "/check", `{"customerId":"1","charge":100}`)
+	require.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+	result := decodeCheck(t, rr)
+	if assert.NotNil(t, result.Maintenance) {
+		assert.Equal(t, enabled.ID, result.Maintenance.ID)
+		assert.Equal(t, "Database upgrade", result.Maintenance.Reason)
+		assert.True(t, endsAt.Equal(result.Maintenance.EndsAt))
+	}
+
+	rr = do(t, r, "GET", "/settings", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	settings := decode[fraud.FraudSettingsResult](t, rr)
+	assert.True(t, settings.MaintenanceMode)
+	if assert.NotNil(t, settings.Maintenance) {
+		assert.Equal(t, enabled.ID, settings.Maintenance.ID)
+	}
+
+	rr = do(t, r, "DELETE", "/maintenance", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	rr = do(t, r, "POST", "/check", `{"customerId":"1","charge":100}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Nil(t, decodeCheck(t, rr).Maintenance)
+
+	// A scheduled window does not take effect until it starts.
+	startsAt := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	rr = do(t, r, "POST", "/maintenance/windows", `{"startsAt":"`+startsAt.Format(time.RFC3339)+`"}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	scheduled := decode[fraud.MaintenanceWindow](t, rr)
+	assert.Equal(t, fraud.DefaultMaintenanceReason, scheduled.Reason)
+
+	rr = do(t, r, "POST", "/check", `{"customerId":"1","charge":100}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	rr = do(t, r, "GET", "/maintenance/windows", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	windows := decode[[]fraud.MaintenanceWindow](t, rr)
+	if assert.Len(t, windows, 2) {
+		assert.Equal(t, enabled.ID, windows[0].ID)
+		assert.False(t, windows[0].EndsAt.IsZero())
+		assert.False(t, windows[0].EndsAt.After(time.Now()))
+		assert.Equal(t, scheduled.ID, windows[1].ID)
+		assert.True(t, windows[1].EndsAt.IsZero())
+	}
+
+	rr = do(t, r, "DELETE", "/maintenance/windows/"+enabled.ID, "")
+	require.Equal(t, http.StatusConflict, rr.Code)
+
+	rr = do(t, r, "DELETE", "/maintenance/windows/"+scheduled.ID, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	rr = do(t, r, "DELETE", "/maintenance/windows/"+scheduled.ID, "")
+	require.Equal(t, http.StatusNotFound, rr.Code)
+
+	for _, body := range []string{
+		`{}`,
+		`{"startsAt":"` + startsAt.Format(time.RFC3339) + `","endsAt":"` + startsAt.Add(-time.Hour).Format(time.RFC3339) + `"}`,
+		`{"startsAt":"2020-01-01T00:00:00Z","endsAt":"2020-01-02T00:00:00Z"}`,
+	} {
+		rr = do(t, r, "POST", "/maintenance/windows", body)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, body)
+	}
+
+	rr = do(t, r, "POST", "/maintenance", `{"startsAt":"`+startsAt.Format(time.RFC3339)+`"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestLargeCharges(t *testing.T) {
+	r := fraud.Router(newStore(t), slog.Default())
+
+	check := func(body string) bool {
+		req, err := http.NewRequest("POST", "/check", strings.NewReader(body))
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var result fraud.FraudCheckResult
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&result))
+		return result.Declined
+	}
+
+	req, err := http.NewRequest("POST", "/limit", strings.NewReader(`{"limit":3000000000}`))
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	// Limits and charges beyond 32 bits are compared exactly.
+	require.False(t, check(`{"customerId":"1","charge":2500000000}`))
+	require.True(t, check(`{"customerId":"1","charge":600000000}`))
+	require.False(t, check(`{"customerId":"1","charge":500000000}`))
+
+	// A tally which would overflow is over the limit.
+	require.True(t, check(`{"customerId":"1","charge":9223372036854775807}`))
+
+	req, err = http.NewRequest("POST", "/check", strings.NewReader(`{"customerId":"1","charge":9223372036854775808}`))
+	require.NoError(t, err)
+
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestRulesPersist(t *testing.T) {
+	store := newStore(t)
+	r := fraud.Router(store, slog.Default())
+
+	rr := do(t, r, "PUT", "/rules", `{"rules":[{"id":"daily","type":"spend","limit":10000,"window":"24h"},{"id":"vip-daily","type":"spend","segment":"vip","limit":50000,"window":"24h"}],"segments":{"alice":"vip"}}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = do(t, r, "PUT", "/rules", `{"rules":[{"id":"bad","type":"velocity","maxCharges":3}]}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	check := func(r http.Handler, body string) fraud.FraudCheckResult {
+		rr := do(t, r, "POST", "/check", body)
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		return decodeCheck(t, rr)
+	}
+
+	assert.Equal(t, fraud.FraudCheckResult{}, check(r, `{"customerId":"bob","charge":8000,"idempotencyKey":"b1"}`))
+	// Checking the same charge again does not count it twice.
+	assert.Equal(t, fraud.FraudCheckResult{}, check(r, `{"customerId":"bob","charge":8000,"idempotencyKey":"b1"}`))
+	assert.Equal(t, fraud.FraudCheckResult{Declined: true, Reason: "daily"}, check(r, `{"customerId":"bob","charge":8000,"idempotencyKey":"b2"}`))
+
+	// Alice's segment has a higher limit in place of the default.
+	assert.Equal(t, fraud.FraudCheckResult{}, check(r, `{"customerId":"alice","charge":40000}`))
+
+	// Another replica, or a restart, sees the same rules and charges.
+	other := fraud.Router(store, slog.Default())
+	assert.Equal(t, fraud.FraudCheckResult{Declined: true, Reason: "vip-daily"}, check(other, `{"customerId":"alice","charge":20000}`))
+	assert.Equal(t, fraud.FraudCheckResult{}, check(other, `{"customerId":"bob","charge":2000}`))
+
+	rr = do(t, other, "POST", "/rules", `{"id":"bob-velocity","type":"velocity","customerId":"bob","maxCharges":2,"window":"1h"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	rr = do(t, other, "PUT", "/segments/bob", `{"segment":"vip"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	// Bob now has the higher limit, but has made too many charges this hour.
+	assert.Equal(t, fraud.FraudCheckResult{Declined: true, Reason: "bob-velocity"}, check(r, `{"customerId":"bob","charge":100}`))
+
+	rr = do(t, r, "GET", "/rules", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	rules := decode[fraud.RuleSet](t, rr)
+	assert.Len(t, rules.Rules, 3)
+	assert.Equal(t, map[string]string{"alice": "vip", "bob": "vip"}, rules.Segments)
+
+	rr = do(t, r, "DELETE", "/rules/bob-velocity", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	rr = do(t, r, "DELETE", "/rules/bob-velocity", "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	assert.Equal(t, fraud.FraudCheckResult{}, check(r, `{"customerId":"bob","charge":100}`))
+}
+
+func TestLimitIsARule(t *testing.T) {
+	r := fraud.Router(newStore(t), slog.Default())
+
+	rr := do(t, r, "POST", "/limit", `{"limit":5000}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	rr = do(t, r, "GET", "/settings", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, int64(5000), decode[fraud.FraudSettingsResult](t, rr).Limit.Amount)
+
+	rr = do(t, r, "GET", "/rules", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, []fraud.Rule{{ID: fraud.LimitRuleID, Type: fraud.RuleTypeSpend, Limit: currency.Money{Amount: 5000}}}, decode[fraud.RuleSet](t, rr).Rules)
+
+	rr = do(t, r, "POST", "/reset", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	rr = do(t, r, "GET", "/rules", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, decode[fraud.RuleSet](t, rr).Rules)
+}
+
+func TestReviewChargesNearTheLimit(t *testing.T) {
+	r := fraud.Router(newStore(t), slog.Default())
+
+	rr := do(t, r, "POST", "/limit", `{"limit":5000,"reviewLimit":5000}`)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = do(t, r, "POST", "/limit", `{"limit":5000,"reviewLimit":4000}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	rr = do(t, r, "POST", "/check", `{"customerId":"1","charge":4500,"idempotencyKey":"a"}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, fraud.FraudCheckResult{Review: true, Reason: fraud.LimitRuleID}, decodeCheck(t, rr))
+
+	// A charge held for review does not count until it is approved.
+	rr = do(t, r, "POST", "/check", `{"customerId":"1","charge":1000,"idempotencyKey":"b"}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, fraud.FraudCheckResult{}, decodeCheck(t, rr))
+
+	rr = do(t, r, "POST", "/check", `{"customerId":"1","charge":4500,"idempotencyKey":"a","approved":true}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, fraud.FraudCheckResult{}, decodeCheck(t, rr))
+
+	rr = do(t, r, "POST", "/check", `{"customerId":"1","charge":1,"idempotencyKey":"c"}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, fraud.FraudCheckResult{Declined: true, Reason: fraud.LimitRuleID}, decodeCheck(t, rr))
+}
+
+func TestConcurrentChecksAcrossReplicas(t *testing.T) {
+	// Two replicas share one database.
+	path := filepath.Join(t.TempDir(), "test.db")
+	var replicas []http.Handler
+	for range 2 {
+		store := db.NewSQLiteDB(path)
+		require.NoError(t, store.Connect(context.Background()))
+		require.NoError(t, store.Setup())
+		t.Cleanup(func() { store.Close() })
+		replicas = append(replicas, fraud.Router(store, slog.Default()))
+	}
+
+	rr := do(t, replicas[0], "POST", "/limit", `{"limit":5000}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	// Only one of the charges fits within the limit, however the checks interleave.
+	results := make(chan fraud.FraudCheckResult, 10)
+	var wg sync.WaitGroup
+	for i := range cap(results) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rr := do(t, replicas[i%len(replicas)], "POST", "/check", fmt.Sprintf(`{"customerId":"1","charge":3000,"idempotencyKey":"%d"}`, i))
+			if assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String()) {
+				results <- decodeCheck(t, rr)
+			}
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	approved := 0
+	for result := range results {
+		if !result.Declined {
+			approved++
+		}
+	}
+	assert.Equal(t, 1, approved)
+}
+
+func TestReleasedChargesDoNotCount(t *testing.T) {
+	r := fraud.Router(newStore(t), slog.Default())
+
+	rr := do(t, r, "POST", "/limit", `{"limit":5000}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	rr = do(t, r, "POST", "/check", `{"customerId":"1","charge":4000,"idempotencyKey":"a"}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, fraud.FraudCheckResult{}, decodeCheck(t, rr))
+
+	rr = do(t, r, "POST", "/check", `{"customerId":"1","charge":2000,"idempotencyKey":"b"}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, fraud.FraudCheckResult{Declined: true, Reason: fraud.LimitRuleID}, decodeCheck(t, rr))
+
+	// The first charge was not authorized, so it is released and no longer counts.
+	rr = do(t, r, "DELETE", "/charges/a", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	rr = do(t, r, "DELETE", "/charges/a", "")
+	require.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = do(t, r, "POST", "/check", `{"customerId":"1","charge":2000,"idempotencyKey":"b"}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, fraud.FraudCheckResult{}, decodeCheck(t, rr))
+}
+
+func TestAllowlistAndBlocklist(t *testing.T) {
+	r := fraud.Router(newStore(t), slog.Default())
+
+	check := func(body string) fraud.FraudCheckResult {
+		rr := do(t, r, "POST", "/check", body)
+		require.Equal(t, http.StatusOK, rr.Code)
+		return decodeCheck(t, rr)
+	}
+
+	rr := do(t, r, "POST", "/limit", `{"limit":1000}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	rr = do(t, r, "PUT", "/lists/domain/Example.COM", `{"list":"block"}`)
+	require.Equal(t, http.StatusBadRequest, rr.Code, "changedBy is required")
+
+	rr = do(t, r, "PUT", "/lists/email/x", `{"list":"block","changedBy":"alice"}`)
+	require.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = do(t, r, "PUT", "/lists/domain/Example.COM", `{"list":"block","changedBy":"alice","note":"chargebacks"}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	rr = do(t, r, "PUT", "/lists/customer/2", `{"list":"allow","changedBy":"bob"}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	// Customers from a blocked domain are declined, however small the charge.
+	assert.Equal(t, fraud.FraudCheckResult{Declined: true, Reason: fraud.ReasonBlocklist},
+		check(`{"customerId":"1","email":"one@example.com","charge":1}`))
+
+	// An allowlisted customer is approved beyond the limit, even from a blocked domain.
+	assert.Equal(t, fraud.FraudCheckResult{}, check(`{"customerId":"2","email":"two@example.com","charge":5000}`))
+
+	// Removing the customer's entry leaves the domain's, and the allowlisted charge still counts.
+	rr = do(t, r, "DELETE", "/lists/customer/2?changedBy=bob", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	assert.Equal(t, fraud.FraudCheckResult{Declined: true, Reason: fraud.ReasonBlocklist},
+		check(`{"customerId":"2","email":"two@example.com","charge":1}`))
+	assert.Equal(t, fraud.FraudCheckResult{Declined: true, Reason: fraud.LimitRuleID},
+		check(`{"customerId":"2","email":"two@example.org","charge":1}`))
+
+	rr = do(t, r, "DELETE", "/lists/customer/2?changedBy=bob", "")
+	require.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = do(t, r, "GET", "/lists", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	entries := decode[[]fraud.ListEntry](t, rr)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "example.com", entries[0].Value)
+	assert.Equal(t, fraud.ListBlock, entries[0].List)
+	assert.Equal(t, "alice", entries[0].ChangedBy)
+
+	rr = do(t, r, "GET", "/lists/changes?kind=customer&value=2", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	changes := decode[[]fraud.ListChange](t, rr)
+	require.Len(t, changes, 2)
+	assert.Equal(t, fraud.ListAllow, changes[0].List)
+	assert.Equal(t, "", changes[1].List)
+	assert.Equal(t, "bob", changes[1].ChangedBy)
+	assert.False(t, changes[1].ChangedAt.Before(changes[0].ChangedAt))
+}
+
+func TestDecisionsAreRecorded(t *testing.T) {
+	r := fraud.Router(newStore(t), slog.Default())
+
+	rr := do(t, r, "POST", "/limit", `{"limit":5000,"reviewLimit":4000}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	rr = do(t, r, "POST", "/check", `{"customerId":"1","charge":3000,"idempotencyKey":"a"}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	approved := decode[fraud.FraudCheckResult](t, rr)
+
+	rr = do(t, r, "POST", "/check", `{"customerId":"1","email":"one@example.com","charge":2500,"idempotencyKey":"b"}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	declined := decode[fraud.FraudCheckResult](t, rr)
+	require.True(t, declined.Declined)
+
+	rr = do(t, r, "POST", "/check", `{"customerId":"2","charge":100}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	rr = do(t, r, "POST", "/maintenance", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	rr = do(t, r, "POST", "/check", `{"customerId":"1","charge":100,"idempotencyKey":"c"}`)
+	require.Equal(t, http.StatusServiceUnavailable, rr.Code)
+
+	rr = do(t, r, "GET", "/decisions/"+declined.DecisionID, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	decision := decode[fraud.Decision](t, rr)
+	assert.Equal(t, "1", decision.CustomerID)
+	assert.Equal(t, "one@example.com", decision.Email)
+	assert.Equal(t, "b", decision.IdempotencyKey)
+	assert.Equal(t, int64(2500), decision.Charge.Amount)
+	assert.Equal(t, fraud.DecisionDeclined, decision.Outcome)
+	assert.Equal(t, fraud.LimitRuleID, decision.Reason)
+	assert.Equal(t, int64(3000), decision.Tally.Amount)
+	assert.Equal(t, int32(1), decision.Charges)
+	assert.Equal(t, int64(5000), decision.Limit.Amount)
+	assert.Equal(t, int64(4000), decision.ReviewLimit.Amount)
+	assert.False(t, decision.MaintenanceMode)
+
+	rr = do(t, r, "GET", "/decisions?customerId=1", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	decisions := decode[[]fraud.Decision](t, rr)
+	require.Len(t, decisions, 3)
+	assert.Equal(t, fraud.DecisionUnavailable, decisions[0].Outcome)
+	assert.True(t, decisions[0].MaintenanceMode)
+	assert.Equal(t, declined.DecisionID, decisions[1].ID)
+	assert.Equal(t, approved.DecisionID, decisions[2].ID)
+	assert.Equal(t, fraud.DecisionApproved, decisions[2].Outcome)
+
+	rr = do(t, r, "GET", "/decisions/unknown", "")
+	require.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestScoringModel(t *testing.T) {
+	r := fraud.Router(newStore(t), slog.Default())
+
+	rr := do(t, r, "GET", "/scoring", "")
+	require.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = do(t, r, "PUT", "/scoring", `{"weights":{"sku":1}}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	rr = do(t, r, "PUT", "/scoring", `{"weights":{"amount":1}}`)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = do(t, r, "PUT", "/scoring", `{
+		"weights": {"sku": 3, "addresses": 1},
+		"reviewScore": 50,
+		"declineScore": 90,
+		"addresses": 2,
+		"skuCategories": {"voucher": "gift-cards", "phone": "electronics"},
+		"categoryRisk": {"gift-cards": 1, "electronics": 0.8}
+	}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	rr = do(t, r, "GET", "/rules", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	rules := decode[fraud.RuleSet](t, rr)
+	require.NotNil(t, rules.Scoring)
+	assert.Equal(t, int32(90), rules.Scoring.DeclineScore)
+
+	assert.Equal(t, fraud.FraudCheckResult{},
+		decodeCheck(t, do(t, r, "POST", "/check", `{"customerId":"1","charge":100,"shippingAddress":"1 High St","skus":["socks"]}`)))
+
+	// A risky product held for review, then a riskier one declined, by score alone.
+	assert.Equal(t, fraud.FraudCheckResult{Review: true, Reason: fraud.ReasonScore, Score: 60},
+		decodeCheck(t, do(t, r, "POST", "/check", `{"customerId":"1","charge":100,"shippingAddress":"1 High St","skus":["phone"]}`)))
+	assert.Equal(t, fraud.FraudCheckResult{Declined: true, Reason: fraud.ReasonScore, Score: 100},
+		decodeCheck(t, do(t, r, "POST", "/check", `{"customerId":"1","charge":100,"shippingAddress":"2 Low Rd","skus":["voucher"]}`)))
+
+	rr = do(t, r, "GET", "/decisions?customerId=1", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	decisions := decode[[]fraud.Decision](t, rr)
+	require.Len(t, decisions, 3)
+	assert.Equal(t, int32(100), decisions[0].Score)
+
+	rr = do(t, r, "DELETE", "/scoring", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	assert.Equal(t, fraud.FraudCheckResult{},
+		decodeCheck(t, do(t, r, "POST", "/check", `{"customerId":"1","charge":100,"shippingAddress":"2 Low Rd","skus":["voucher"]}`)))
+}
@@ -0,0 +1,102 @@
+package fraud
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/temporalio/reference-app-orders-go/app/currency"
+	"github.com/temporalio/reference-app-orders-go/app/db"
+)
+
+const (
+	// DecisionApproved is the outcome of a check which let the charge go ahead.
+	DecisionApproved = "approved"
+
+	// DecisionDeclined is the outcome of a check which declined the charge.
+	DecisionDeclined = "declined"
+
+	// DecisionReview is the outcome of a check which held the charge for a manager to review.
+	DecisionReview = "review"
+
+	// DecisionUnavailable is the outcome of a check made while the service was in maintenance mode, which was refused.
+	DecisionUnavailable = "unavailable"
+)
+
+// handleGetDecisions lists the fraud check's decisions, newest first. The customerId query parameter limits the list
+// to one customer's decisions.
+func (h *handlers) handleGetDecisions(w http.ResponseWriter, r *http.Request) {
+	var decisions []db.FraudDecision
+
+	err := h.db.GetFraudDecisions(r.Context(), r.URL.Query().Get("customerId"), &decisions)
+	if err != nil {
+		h.logger.Error("Failed to get fraud decisions", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	list := make([]Decision, len(decisions))
+	for i, d := range decisions {
+		list[i] = decisionFromDB(d)
+	}
+
+	h.encode(w, list)
+}
+
+func (h *handlers) handleGetDecision(w http.ResponseWriter, r *http.Request) {
+	var decision db.FraudDecision
+
+	err := h.db.GetFraudDecision(r.Context(), r.PathValue("id"), &decision)
+	if err != nil {
+		if errors.Is(err, db.ErrFraudDecisionNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			h.logger.Error("Failed to get fraud decision", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.encode(w, decisionFromDB(decision))
+}
+
+func decisionToDB(d Decision) *db.FraudDecision {
+	return &db.FraudDecision{
+		ID:              d.ID,
+		CustomerID:      d.CustomerID,
+		Email:           d.Email,
+		Charge:          d.Charge.Amount,
+		IdempotencyKey:  d.IdempotencyKey,
+		Approved:        d.Approved,
+		Outcome:         d.Outcome,
+		Reason:          d.Reason,
+		Score:           d.Score,
+		List:            d.List,
+		Tally:           d.Tally.Amount,
+		Charges:         d.Charges,
+		LimitAmount:     d.Limit.Amount,
+		ReviewAmount:    d.ReviewLimit.Amount,
+		MaintenanceMode: d.MaintenanceMode,
+		DecidedAt:       d.DecidedAt.UTC(),
+	}
+}
+
+func decisionFromDB(d db.FraudDecision) Decision {
+	return Decision{
+		ID:              d.ID,
+		CustomerID:      d.CustomerID,
+		Email:           d.Email,
+		Charge:          currency.Money{Amount: d.Charge},
+		IdempotencyKey:  d.IdempotencyKey,
+		Approved:        d.Approved,
+		Outcome:         d.Outcome,
+		Reason:          d.Reason,
+		Score:           d.Score,
+		List:            d.List,
+		Tally:           currency.Money{Amount: d.Tally},
+		Charges:         d.Charges,
+		Limit:           currency.Money{Amount: d.LimitAmount},
+		ReviewLimit:     currency.Money{Amount: d.ReviewAmount},
+		MaintenanceMode: d.MaintenanceMode,
+		DecidedAt:       d.DecidedAt,
+	}
+}
@@ -0,0 +1,259 @@
+package fraud
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/temporalio/reference-app-orders-go/app/db"
+)
+
+const (
+	// ListAllow is the allowlist. Charges for customers on it are approved without checking the rules.
+	ListAllow = "allow"
+
+	// ListBlock is the blocklist. Charges for customers on it are always declined.
+	ListBlock = "block"
+)
+
+const (
+	// ListKindCustomer is the kind of list entry which matches a customer ID.
+	ListKindCustomer = "customer"
+
+	// ListKindDomain is the kind of list entry which matches the domain of a customer's email address.
+	ListKindDomain = "domain"
+)
+
+// ReasonBlocklist is the reason given for charges declined because the customer is on the blocklist.
+const ReasonBlocklist = "blocklist"
+
+// listFor returns the list a customer is on, or an empty string if they are on neither. An entry for the customer
+// takes precedence over one for their email domain, so a customer can be allowed from a blocked domain.
+func (h *handlers) listFor(ctx context.Context, customerID string, email string) (string, error) {
+	var entry db.FraudListEntry
+
+	err := h.db.GetFraudListEntry(ctx, ListKindCustomer, customerID, &entry)
+	if err == nil {
+		return entry.List, nil
+	}
+	if !errors.Is(err, db.ErrFraudListEntryNotFound) {
+		return "", err
+	}
+
+	domain := emailDomain(email)
+	if domain == "" {
+		return "", nil
+	}
+
+	err = h.db.GetFraudListEntry(ctx, ListKindDomain, domain, &entry)
+	if errors.Is(err, db.ErrFraudListEntryNotFound) {
+		return "", nil
+	}
+
+	return entry.List, err
+}
+
+// emailDomain returns the domain of an email address, in lower case, or an empty string if it has none.
+func emailDomain(email string) string {
+	i := strings.LastIndex(email, "@")
+	if i < 0 {
+		return ""
+	}
+
+	return strings.ToLower(email[i+1:])
+}
+
+// listKey reads the kind and value of a list entry from the request path. Domains are matched in lower case.
+func listKey(r *http.Request) (kind string, value string, err error) {
+	kind, value = r.PathValue("kind"), r.PathValue("value")
+
+	switch kind {
+	case ListKindCustomer:
+	case ListKindDomain:
+		value = strings.ToLower(strings.TrimPrefix(value, "@"))
+	default:
+		return "", "", fmt.Errorf("unknown list entry kind: %s", kind)
+	}
+
+	return kind, value, nil
+}
+
+func (h *handlers) handleGetListEntries(w http.ResponseWriter, r *http.Request) {
+	var entries []db.FraudListEntry
+
+	err := h.db.GetFraudListEntries(r.Context(), &entries)
+	if err != nil {
+		h.logger.Error("Failed to get fraud list entries", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	list := make([]ListEntry, len(entries))
+	for i, e := range entries {
+		list[i] = listEntryFromDB(e)
+	}
+
+	h.encode(w, list)
+}
+
+func (h *handlers) handleGetListEntry(w http.ResponseWriter, r *http.Request) {
+	kind, value, err := listKey(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	var entry db.FraudListEntry
+
+	err = h.db.GetFraudListEntry(r.Context(), kind, value, &entry)
+	if err != nil {
+		if errors.Is(err, db.ErrFraudListEntryNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			h.logger.Error("Failed to get fraud list entry", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.encode(w, listEntryFromDB(entry))
+}
+
+// handleSetListEntry puts a customer or email domain on the allowlist or blocklist, moving it from the other list if
+// it is already on one.
+func (h *handlers) handleSetListEntry(w http.ResponseWriter, r *http.Request) {
+	kind, value, err := listKey(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	var input ListEntryInput
+
+	err = json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		h.logger.Error("Failed to decode list entry", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if input.List != ListAllow && input.List != ListBlock {
+		http.Error(w, fmt.Sprintf("list must be %q or %q", ListAllow, ListBlock), http.StatusBadRequest)
+		return
+	}
+	if input.ChangedBy == "" {
+		http.Error(w, "changedBy is required", http.StatusBadRequest)
+		return
+	}
+
+	change := db.FraudListChange{
+		ID:        uuid.NewString(),
+		Kind:      kind,
+		Value:     value,
+		List:      input.List,
+		Note:      input.Note,
+		ChangedBy: input.ChangedBy,
+		ChangedAt: time.Now().UTC(),
+	}
+
+	err = h.db.RecordFraudListChange(r.Context(), &change)
+	if err != nil {
+		h.logger.Error("Failed to store fraud list entry", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.encode(w, ListEntry{
+		Kind:      change.Kind,
+		Value:     change.Value,
+		List:      change.List,
+		Note:      change.Note,
+		ChangedBy: change.ChangedBy,
+		ChangedAt: change.ChangedAt,
+	})
+}
+
+// handleDeleteListEntry takes a customer or email domain off the allowlist or blocklist. The changedBy query parameter
+// names the manager making the change.
+func (h *handlers) handleDeleteListEntry(w http.ResponseWriter, r *http.Request) {
+	kind, value, err := listKey(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	changedBy := r.URL.Query().Get("changedBy")
+	if changedBy == "" {
+		http.Error(w, "changedBy is required", http.StatusBadRequest)
+		return
+	}
+
+	err = h.db.RecordFraudListChange(r.Context(), &db.FraudListChange{
+		ID:        uuid.NewString(),
+		Kind:      kind,
+		Value:     value,
+		Note:      r.URL.Query().Get("note"),
+		ChangedBy: changedBy,
+		ChangedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		if errors.Is(err, db.ErrFraudListEntryNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			h.logger.Error("Failed to delete fraud list entry", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleGetListChanges returns the audit trail of the allowlist and blocklist, oldest first. The kind and value query
+// parameters limit it to the changes to one customer or email domain.
+func (h *handlers) handleGetListChanges(w http.ResponseWriter, r *http.Request) {
+	kind, value := r.URL.Query().Get("kind"), r.URL.Query().Get("value")
+	if kind == ListKindDomain {
+		value = strings.ToLower(strings.TrimPrefix(value, "@"))
+	}
+
+	var changes []db.FraudListChange
+
+	err := h.db.GetFraudListChanges(r.Context(), kind, value, &changes)
+	if err != nil {
+		h.logger.Error("Failed to get fraud list changes", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	list := make([]ListChange, len(changes))
+	for i, c := range changes {
+		list[i] = ListChange{
+			ID:        c.ID,
+			Kind:      c.Kind,
+			Value:     c.Value,
+			List:      c.List,
+			Note:      c.Note,
+			ChangedBy: c.ChangedBy,
+			ChangedAt: c.ChangedAt,
+		}
+	}
+
+	h.encode(w, list)
+}
+
+func listEntryFromDB(e db.FraudListEntry) ListEntry {
+	return ListEntry{
+		Kind:      e.Kind,
+		Value:     e.Value,
+		List:      e.List,
+		Note:      e.Note,
+		ChangedBy: e.ChangedBy,
+		ChangedAt: e.ChangedAt,
+	}
+}
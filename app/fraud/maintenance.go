@@ -0,0 +1,269 @@
+package fraud
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/temporalio/reference-app-orders-go/app/db"
+)
+
+// DefaultMaintenanceReason is the reason given for a maintenance window opened without one.
+const DefaultMaintenanceReason = "Fraud service is in maintenance mode"
+
+// Active reports whether the window is in force at a time.
+func (m MaintenanceWindow) Active(at time.Time) bool {
+	return !m.StartsAt.After(at) && (m.EndsAt.IsZero() || at.Before(m.EndsAt))
+}
+
+// ended reports whether the window is over at a time.
+func (m MaintenanceWindow) ended(at time.Time) bool {
+	return !m.EndsAt.IsZero() && !at.Before(m.EndsAt)
+}
+
+// validate checks that a window can be opened at a time: it must not end before it starts, or already have ended.
+func (input MaintenanceInput) validate(now time.Time) error {
+	if input.EndsAt.IsZero() {
+		return nil
+	}
+	if !input.EndsAt.After(input.StartsAt) {
+		return fmt.Errorf("endsAt must be after startsAt")
+	}
+	if !input.EndsAt.After(now) {
+		return fmt.Errorf("endsAt must be in the future")
+	}
+
+	return nil
+}
+
+// getMaintenanceWindows reads every maintenance window from the database, earliest first.
+func getMaintenanceWindows(ctx context.Context, store db.DB) ([]MaintenanceWindow, error) {
+	var dbWindows []db.FraudMaintenanceWindow
+	if err := store.GetFraudMaintenanceWindows(ctx, &dbWindows); err != nil {
+		return nil, err
+	}
+
+	windows := make([]MaintenanceWindow, len(dbWindows))
+	for i, window := range dbWindows {
+		windows[i] = maintenanceWindowFromDB(window)
+	}
+
+	return windows, nil
+}
+
+// activeMaintenance returns the maintenance window in force at a time, or nil if there is none.
+// Where windows overlap, the one which ends last is returned, since the fraud check is down until then.
+func activeMaintenance(ctx context.Context, store db.DB, at time.Time) (*MaintenanceWindow, error) {
+	windows, err := getMaintenanceWindows(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+
+	var active *MaintenanceWindow
+	for _, window := range windows {
+		if !window.Active(at) {
+			continue
+		}
+		if active == nil || window.EndsAt.IsZero() || (!active.EndsAt.IsZero() && window.EndsAt.After(active.EndsAt)) {
+			active = &window
+		}
+	}
+
+	return active, nil
+}
+
+// endMaintenance ends every maintenance window in force at a time. Windows which have not yet started are kept.
+func endMaintenance(ctx context.Context, store db.DB, at time.Time) error {
+	windows, err := getMaintenanceWindows(ctx, store)
+	if err != nil {
+		return err
+	}
+
+	for _, window := range windows {
+		if !window.Active(at) {
+			continue
+		}
+		window.EndsAt = at
+		if err := store.UpsertFraudMaintenanceWindow(ctx, maintenanceWindowToDB(window)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unavailable answers a check made during a maintenance window. Retry-After says when to try again, if the window
+// has an end.
+func (h *handlers) unavailable(w http.ResponseWriter, result FraudCheckResult, now time.Time) {
+	if end := result.Maintenance.EndsAt; !end.IsZero() {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(end.Sub(now).Seconds()))))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusServiceUnavailable)
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		h.logger.Error("Failed to encode fraud result", "error", err)
+	}
+}
+
+// handleEnableMaintenance puts the fraud check into maintenance mode at once. The body is optional, and may give a
+// reason and when the window ends.
+func (h *handlers) handleEnableMaintenance(w http.ResponseWriter, r *http.Request) {
+	var input MaintenanceInput
+
+	if r.Body != nil {
+		err := json.NewDecoder(r.Body).Decode(&input)
+		if err != nil && !errors.Is(err, io.EOF) {
+			h.logger.Error("Failed to decode maintenance input", "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if !input.StartsAt.IsZero() {
+		http.Error(w, "startsAt is not allowed: schedule the window with POST /maintenance/windows", http.StatusBadRequest)
+		return
+	}
+	input.StartsAt = time.Now().UTC()
+
+	h.openMaintenanceWindow(w, r, input)
+}
+
+// handleDisableMaintenance takes the fraud check out of maintenance mode by ending every window in force.
+// Scheduled windows which have not yet started are kept.
+func (h *handlers) handleDisableMaintenance(w http.ResponseWriter, r *http.Request) {
+	if err := endMaintenance(r.Context(), h.db, time.Now().UTC()); err != nil {
+		h.logger.Error("Failed to end maintenance", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleGetMaintenanceWindows lists every maintenance window, past and scheduled, earliest first.
+func (h *handlers) handleGetMaintenanceWindows(w http.ResponseWriter, r *http.Request) {
+	windows, err := getMaintenanceWindows(r.Context(), h.db)
+	if err != nil {
+		h.logger.Error("Failed to get maintenance windows", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.encode(w, windows)
+}
+
+// handleScheduleMaintenance schedules a maintenance window.
+func (h *handlers) handleScheduleMaintenance(w http.ResponseWriter, r *http.Request) {
+	var input MaintenanceInput
+
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		h.logger.Error("Failed to decode maintenance input", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if input.StartsAt.IsZero() {
+		http.Error(w, "startsAt is required", http.StatusBadRequest)
+		return
+	}
+
+	h.openMaintenanceWindow(w, r, input)
+}
+
+func (h *handlers) openMaintenanceWindow(w http.ResponseWriter, r *http.Request, input MaintenanceInput) {
+	now := time.Now().UTC()
+
+	if err := input.validate(now); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	window := MaintenanceWindow{
+		ID:        uuid.NewString(),
+		Reason:    input.Reason,
+		StartsAt:  input.StartsAt.UTC(),
+		EndsAt:    input.EndsAt.UTC(),
+		CreatedBy: input.CreatedBy,
+		CreatedAt: now,
+	}
+	if window.Reason == "" {
+		window.Reason = DefaultMaintenanceReason
+	}
+
+	if err := h.db.UpsertFraudMaintenanceWindow(r.Context(), maintenanceWindowToDB(window)); err != nil {
+		h.logger.Error("Failed to store maintenance window", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.encode(w, window)
+}
+
+// handleCancelMaintenanceWindow removes a scheduled maintenance window, or ends it now if it is in force, so that the
+// decisions made during it can still be explained.
+func (h *handlers) handleCancelMaintenanceWindow(w http.ResponseWriter, r *http.Request) {
+	var dbWindow db.FraudMaintenanceWindow
+
+	err := h.db.GetFraudMaintenanceWindow(r.Context(), r.PathValue("id"), &dbWindow)
+	if err != nil {
+		if errors.Is(err, db.ErrFraudMaintenanceWindowNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			h.logger.Error("Failed to get maintenance window", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	window := maintenanceWindowFromDB(dbWindow)
+	now := time.Now().UTC()
+
+	switch {
+	case window.ended(now):
+		http.Error(w, "maintenance window has already ended", http.StatusConflict)
+		return
+	case window.Active(now):
+		window.EndsAt = now
+		err = h.db.UpsertFraudMaintenanceWindow(r.Context(), maintenanceWindowToDB(window))
+	default:
+		err = h.db.DeleteFraudMaintenanceWindow(r.Context(), window.ID)
+	}
+	if err != nil {
+		h.logger.Error("Failed to cancel maintenance window", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func maintenanceWindowToDB(window MaintenanceWindow) *db.FraudMaintenanceWindow {
+	return &db.FraudMaintenanceWindow{
+		ID:        window.ID,
+		Reason:    window.Reason,
+		StartsAt:  window.StartsAt.UTC(),
+		EndsAt:    window.EndsAt.UTC(),
+		CreatedBy: window.CreatedBy,
+		CreatedAt: window.CreatedAt.UTC(),
+	}
+}
+
+func maintenanceWindowFromDB(window db.FraudMaintenanceWindow) MaintenanceWindow {
+	return MaintenanceWindow{
+		ID:        window.ID,
+		Reason:    window.Reason,
+		StartsAt:  window.StartsAt,
+		EndsAt:    window.EndsAt,
+		CreatedBy: window.CreatedBy,
+		CreatedAt: window.CreatedAt,
+	}
+}
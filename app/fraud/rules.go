@@ -0,0 +1,246 @@
+package fraud
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/temporalio/reference-app-orders-go/app/currency"
+)
+
+const (
+	// RuleTypeSpend is the type of a rule which limits how much a customer may be charged within a window.
+	RuleTypeSpend = "spend"
+
+	// RuleTypeVelocity is the type of a rule which limits how many charges a customer may make within a window.
+	RuleTypeVelocity = "velocity"
+)
+
+// RuleTypes is the list of known rule types.
+var RuleTypes = []string{RuleTypeSpend, RuleTypeVelocity}
+
+// LimitRuleID is the ID of the spend rule managed by the limit endpoint, which applies to every customer for all time.
+const LimitRuleID = "limit"
+
+// Rule is a limit the fraud check places on customers' charges.
+type Rule struct {
+	// ID names the rule. It is returned as the reason for the charges the rule declines.
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	// CustomerID limits the rule to one customer, and Segment to the customers in a segment.
+	// A rule with neither applies to every customer.
+	CustomerID string `json:"customerId,omitempty"`
+	Segment    string `json:"segment,omitempty"`
+	// Limit is the most a customer may be charged within the window by a spend rule, in the base currency.
+	Limit currency.Money `json:"limit,omitzero"`
+	// ReviewLimit is the total within the window above which a spend rule holds charges for a manager to review,
+	// rather than approving them, or zero to approve every charge within the limit.
+	ReviewLimit currency.Money `json:"reviewLimit,omitzero"`
+	// MaxCharges is the most charges a customer may make within the window under a velocity rule.
+	MaxCharges int32 `json:"maxCharges,omitempty"`
+	// Window is the rolling period the rule counts charges over, such as "1h" or "720h", or empty for all time.
+	Window string `json:"window,omitempty"`
+}
+
+// Validate checks that a rule is complete and consistent.
+func (r Rule) Validate() error {
+	if r.ID == "" {
+		return fmt.Errorf("id is required")
+	}
+
+	if r.CustomerID != "" && r.Segment != "" {
+		return fmt.Errorf("a rule may apply to a customer or a segment, not both")
+	}
+
+	switch r.Type {
+	case RuleTypeSpend:
+		if r.Limit.Amount <= 0 {
+			return fmt.Errorf("limit must be positive")
+		}
+		if r.ReviewLimit.Amount < 0 || r.ReviewLimit.Amount >= r.Limit.Amount {
+			return fmt.Errorf("reviewLimit must be below the limit")
+		}
+	case RuleTypeVelocity:
+		if r.MaxCharges <= 0 {
+			return fmt.Errorf("maxCharges must be positive")
+		}
+		if r.Window == "" {
+			return fmt.Errorf("velocity rules need a window")
+		}
+		if !r.ReviewLimit.IsZero() {
+			return fmt.Errorf("only spend rules have a reviewLimit")
+		}
+	default:
+		return fmt.Errorf("unknown rule type: %s", r.Type)
+	}
+
+	if r.Window != "" {
+		d, err := time.ParseDuration(r.Window)
+		if err != nil {
+			return fmt.Errorf("invalid window %q: %w", r.Window, err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("window %q must be positive", r.Window)
+		}
+	}
+
+	return nil
+}
+
+// window returns the rule's window, or zero for all time.
+func (r Rule) window() time.Duration {
+	d, _ := time.ParseDuration(r.Window)
+	return d
+}
+
+// specificity ranks how closely the rule targets a customer: rules for the customer beat rules for their segment,
+// which beat rules for everyone.
+func (r Rule) specificity() int {
+	switch {
+	case r.CustomerID != "":
+		return 2
+	case r.Segment != "":
+		return 1
+	}
+
+	return 0
+}
+
+// RuleSet is the fraud check's configuration.
+type RuleSet struct {
+	Rules []Rule `json:"rules"`
+	// Segments maps customer IDs to the segment each belongs to.
+	Segments map[string]string `json:"segments,omitempty"`
+	// Scoring is the model which scores the risk of each charge, or nil to not score charges.
+	Scoring *ScoringModel `json:"scoring,omitempty"`
+}
+
+// LoadRules reads a rule set from a JSON file.
+func LoadRules(path string) (RuleSet, error) {
+	var rules RuleSet
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return rules, fmt.Errorf("failed to read fraud rules: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return rules, fmt.Errorf("failed to decode fraud rules: %w", err)
+	}
+
+	return rules, rules.Validate()
+}
+
+// Validate checks every rule in the set, and that no two rules share an ID.
+func (s RuleSet) Validate() error {
+	for i, r := range s.Rules {
+		if err := r.Validate(); err != nil {
+			return fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		if slices.ContainsFunc(s.Rules[:i], func(other Rule) bool { return other.ID == r.ID }) {
+			return fmt.Errorf("rule %s is given more than once", r.ID)
+		}
+	}
+
+	if s.Scoring != nil {
+		if err := s.Scoring.Validate(); err != nil {
+			return fmt.Errorf("scoring: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// applicable returns the rules which apply to a customer. Where rules of the same type and window target the customer
+// at different levels, only the most specific applies, so a customer or segment rule can raise a limit as well as
+// lower it.
+func (s RuleSet) applicable(customerID string) []Rule {
+	segment := s.Segments[customerID]
+
+	type key struct {
+		ruleType string
+		window   time.Duration
+	}
+
+	var candidates []Rule
+	best := make(map[key]int)
+	for _, r := range s.Rules {
+		if (r.CustomerID != "" && r.CustomerID != customerID) || (r.Segment != "" && r.Segment != segment) {
+			continue
+		}
+		candidates = append(candidates, r)
+		k := key{r.Type, r.window()}
+		best[k] = max(best[k], r.specificity())
+	}
+
+	return slices.DeleteFunc(candidates, func(r Rule) bool {
+		return r.specificity() < best[key{r.Type, r.window()}]
+	})
+}
+
+// Lookback returns how far back a customer's charges must go to check a new charge at a time, or the zero time if
+// all of them are needed.
+func (s RuleSet) Lookback(customerID string, at time.Time) time.Time {
+	var longest time.Duration
+	for _, r := range s.applicable(customerID) {
+		w := r.window()
+		if w == 0 {
+			return time.Time{}
+		}
+		longest = max(longest, w)
+	}
+
+	return at.Add(-longest)
+}
+
+// Charge is a charge a customer has already made which passed the fraud check.
+type Charge struct {
+	Amount currency.Money
+	At     time.Time
+	// ShippingAddress is where the order charged for was shipped to, or empty if it is not known.
+	ShippingAddress string
+}
+
+// Check decides whether a customer may be charged an amount at a time, given the charges they have already made.
+// It returns the ID of the first rule the charge would break. If it breaks none, it returns the ID of the first rule
+// whose review limit the charge would pass, with review set, or an empty string if the charge may go ahead.
+func (s RuleSet) Check(customerID string, amount currency.Money, at time.Time, history []Charge) (ruleID string, review bool) {
+	for _, r := range s.applicable(customerID) {
+		var since time.Time
+		if w := r.window(); w > 0 {
+			since = at.Add(-w)
+		}
+
+		spent := amount
+		count := int32(1)
+		var err error
+		for _, c := range history {
+			if c.At.Before(since) {
+				continue
+			}
+			count++
+			if err == nil {
+				spent, err = spent.Add(c.Amount)
+			}
+		}
+
+		switch r.Type {
+		case RuleTypeSpend:
+			// A total too large to add up is over any limit.
+			if err != nil || spent.Amount > r.Limit.Amount {
+				return r.ID, false
+			}
+			if !r.ReviewLimit.IsZero() && spent.Amount > r.ReviewLimit.Amount && ruleID == "" {
+				ruleID, review = r.ID, true
+			}
+		case RuleTypeVelocity:
+			if count > r.MaxCharges {
+				return r.ID, false
+			}
+		}
+	}
+
+	return ruleID, review
+}
@@ -0,0 +1,107 @@
+package fraud_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/temporalio/reference-app-orders-go/app/currency"
+	"github.com/temporalio/reference-app-orders-go/app/fraud"
+)
+
+func TestCheckWindows(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	rules := fraud.RuleSet{Rules: []fraud.Rule{
+		{ID: "hourly-velocity", Type: fraud.RuleTypeVelocity, MaxCharges: 2, Window: "1h"},
+		{ID: "daily-spend", Type: fraud.RuleTypeSpend, Limit: currency.Money{Amount: 10000}, Window: "24h"},
+	}}
+	require.NoError(t, rules.Validate())
+
+	history := []fraud.Charge{
+		{Amount: currency.Money{Amount: 6000}, At: now.Add(-25 * time.Hour)},
+		{Amount: currency.Money{Amount: 5000}, At: now.Add(-2 * time.Hour)},
+		{Amount: currency.Money{Amount: 1000}, At: now.Add(-30 * time.Minute)},
+	}
+
+	// Only the charges within each rule's window count.
+	assert.Equal(t, "", decision(rules.Check("alice", currency.Money{Amount: 4000}, now, history)))
+	assert.Equal(t, "daily-spend", decision(rules.Check("alice", currency.Money{Amount: 4001}, now, history)))
+
+	history = append(history, fraud.Charge{Amount: currency.Money{Amount: 100}, At: now.Add(-time.Minute)})
+	assert.Equal(t, "hourly-velocity", decision(rules.Check("alice", currency.Money{Amount: 100}, now, history)))
+
+	// The oldest charge is outside every window.
+	assert.Equal(t, now.Add(-24*time.Hour), rules.Lookback("alice", now))
+}
+
+func TestCheckMostSpecificRuleApplies(t *testing.T) {
+	rules := fraud.RuleSet{
+		Rules: []fraud.Rule{
+			{ID: "everyone", Type: fraud.RuleTypeSpend, Limit: currency.Money{Amount: 1000}},
+			{ID: "trusted", Type: fraud.RuleTypeSpend, Segment: "trusted", Limit: currency.Money{Amount: 5000}},
+			{ID: "carol", Type: fraud.RuleTypeSpend, CustomerID: "carol", Limit: currency.Money{Amount: 500}},
+		},
+		Segments: map[string]string{"bob": "trusted", "carol": "trusted"},
+	}
+	require.NoError(t, rules.Validate())
+
+	now := time.Now()
+	amount := currency.Money{Amount: 2000}
+
+	assert.Equal(t, "everyone", decision(rules.Check("alice", amount, now, nil)))
+	assert.Equal(t, "", decision(rules.Check("bob", amount, now, nil)))
+	assert.Equal(t, "carol", decision(rules.Check("carol", amount, now, nil)))
+
+	// With no windows, every charge is needed.
+	assert.True(t, rules.Lookback("alice", now).IsZero())
+}
+
+func TestCheckHoldsChargesNearTheLimitForReview(t *testing.T) {
+	rules := fraud.RuleSet{Rules: []fraud.Rule{
+		{ID: "hourly-velocity", Type: fraud.RuleTypeVelocity, MaxCharges: 1, Window: "1h"},
+		{ID: "spend", Type: fraud.RuleTypeSpend, Limit: currency.Money{Amount: 10000}, ReviewLimit: currency.Money{Amount: 8000}},
+	}}
+	require.NoError(t, rules.Validate())
+
+	now := time.Now()
+	history := []fraud.Charge{{Amount: currency.Money{Amount: 5000}, At: now.Add(-2 * time.Hour)}}
+
+	assert.Equal(t, "", decision(rules.Check("alice", currency.Money{Amount: 3000}, now, history)))
+	assert.Equal(t, "review:spend", decision(rules.Check("alice", currency.Money{Amount: 3001}, now, history)))
+	assert.Equal(t, "spend", decision(rules.Check("alice", currency.Money{Amount: 5001}, now, history)))
+
+	// A rule which declines the charge wins over one which would hold it for review.
+	history = append(history, fraud.Charge{Amount: currency.Money{Amount: 100}, At: now.Add(-time.Minute)})
+	assert.Equal(t, "hourly-velocity", decision(rules.Check("alice", currency.Money{Amount: 3001}, now, history)))
+}
+
+// decision formats the result of a check, prefixing the rule which held a charge for review with "review:".
+func decision(ruleID string, review bool) string {
+	if review {
+		return "review:" + ruleID
+	}
+
+	return ruleID
+}
+
+func TestValidateRules(t *testing.T) {
+	for _, rule := range []fraud.Rule{
+		{Type: fraud.RuleTypeSpend, Limit: currency.Money{Amount: 1}},
+		{ID: "r", Type: fraud.RuleTypeSpend},
+		{ID: "r", Type: fraud.RuleTypeVelocity, MaxCharges: 1},
+		{ID: "r", Type: fraud.RuleTypeVelocity, MaxCharges: 1, Window: "soon"},
+		{ID: "r", Type: fraud.RuleTypeSpend, Limit: currency.Money{Amount: 1}, CustomerID: "a", Segment: "b"},
+		{ID: "r", Type: "score"},
+		{ID: "r", Type: fraud.RuleTypeSpend, Limit: currency.Money{Amount: 1}, ReviewLimit: currency.Money{Amount: 1}},
+		{ID: "r", Type: fraud.RuleTypeVelocity, MaxCharges: 1, Window: "1h", ReviewLimit: currency.Money{Amount: 1}},
+	} {
+		assert.Error(t, rule.Validate(), rule)
+	}
+
+	assert.Error(t, fraud.RuleSet{Rules: []fraud.Rule{
+		{ID: "r", Type: fraud.RuleTypeSpend, Limit: currency.Money{Amount: 1}},
+		{ID: "r", Type: fraud.RuleTypeSpend, Limit: currency.Money{Amount: 2}},
+	}}.Validate())
+}
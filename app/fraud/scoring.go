@@ -0,0 +1,343 @@
+package fraud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/temporalio/reference-app-orders-go/app/db"
+)
+
+// ReasonScore is the reason given for charges declined or held for review because of their risk score.
+const ReasonScore = "score"
+
+// SignalWeights are how much each signal counts towards a charge's risk score. A signal with no weight is ignored.
+type SignalWeights struct {
+	// Amount weighs how large the charge is compared with the customer's earlier charges.
+	Amount float64 `json:"amount,omitempty"`
+	// Addresses weighs how many different addresses the customer's orders have been shipped to.
+	Addresses float64 `json:"addresses,omitempty"`
+	// NewCustomer weighs how recently the customer made their first charge.
+	NewCustomer float64 `json:"newCustomer,omitempty"`
+	// Burst weighs how many charges the customer has made in a short time.
+	Burst float64 `json:"burst,omitempty"`
+	// SKU weighs the riskiest category of the products charged for.
+	SKU float64 `json:"sku,omitempty"`
+}
+
+// ScoringModel scores the risk of a charge from 0 to 100, by weighing signals about the charge and the customer's
+// history which each run from 0, for no risk, to 1.
+type ScoringModel struct {
+	Weights SignalWeights `json:"weights"`
+	// ReviewScore is the score from which charges are held for review, and DeclineScore the score from which they are
+	// declined. Either may be zero to only report the score.
+	ReviewScore  int32 `json:"reviewScore,omitempty"`
+	DeclineScore int32 `json:"declineScore,omitempty"`
+	// AmountMultiple is how many times the customer's average charge a charge must be for the amount signal to be 1.
+	AmountMultiple float64 `json:"amountMultiple,omitempty"`
+	// Addresses is how many different shipping addresses, including the charge's, make the addresses signal 1.
+	Addresses int32 `json:"addresses,omitempty"`
+	// NewCustomer is how long after their first charge a customer stops counting as new, such as "720h".
+	// The signal is 1 for a customer's first charge and falls to 0 over that time.
+	NewCustomer string `json:"newCustomer,omitempty"`
+	// BurstCharges is how many charges within BurstWindow, including the charge, make the burst signal 1.
+	BurstWindow  string `json:"burstWindow,omitempty"`
+	BurstCharges int32  `json:"burstCharges,omitempty"`
+	// SKUCategories maps SKUs to risk categories, and CategoryRisk maps each category to its signal.
+	// SKUs without a category have no risk.
+	SKUCategories map[string]string  `json:"skuCategories,omitempty"`
+	CategoryRisk  map[string]float64 `json:"categoryRisk,omitempty"`
+	// History is how far back the customer's charges are scored, such as "2160h", or empty for defaultHistory.
+	// The burstWindow must fit within it, but the newCustomer signal looks back to the customer's first charge.
+	History string `json:"history,omitempty"`
+}
+
+// defaultHistory is how far back the customer's charges are scored when a model sets no History.
+const defaultHistory = 90 * 24 * time.Hour
+
+// Validate checks that the model's weights, thresholds and the settings of its weighted signals are usable.
+func (m ScoringModel) Validate() error {
+	w := m.Weights
+	for _, weight := range []float64{w.Amount, w.Addresses, w.NewCustomer, w.Burst, w.SKU} {
+		if weight < 0 {
+			return fmt.Errorf("weights must not be negative")
+		}
+	}
+	if w.Amount+w.Addresses+w.NewCustomer+w.Burst+w.SKU == 0 {
+		return fmt.Errorf("at least one signal must have a weight")
+	}
+
+	for _, score := range []int32{m.ReviewScore, m.DeclineScore} {
+		if score < 0 || score > 100 {
+			return fmt.Errorf("scores must be between 0 and 100")
+		}
+	}
+	if m.ReviewScore > 0 && m.DeclineScore > 0 && m.ReviewScore >= m.DeclineScore {
+		return fmt.Errorf("reviewScore must be below the declineScore")
+	}
+
+	if w.Amount > 0 && m.AmountMultiple <= 1 {
+		return fmt.Errorf("amountMultiple must be more than 1")
+	}
+	if w.Addresses > 0 && m.Addresses < 2 {
+		return fmt.Errorf("addresses must be at least 2")
+	}
+	if w.NewCustomer > 0 {
+		if err := positiveDuration("newCustomer", m.NewCustomer); err != nil {
+			return err
+		}
+	}
+	if w.Burst > 0 {
+		if err := positiveDuration("burstWindow", m.BurstWindow); err != nil {
+			return err
+		}
+		if m.BurstCharges < 2 {
+			return fmt.Errorf("burstCharges must be at least 2")
+		}
+	}
+	if m.History != "" {
+		if err := positiveDuration("history", m.History); err != nil {
+			return err
+		}
+	}
+	if w.Burst > 0 {
+		if d, _ := time.ParseDuration(m.BurstWindow); d > m.history() {
+			return fmt.Errorf("burstWindow %q must not be longer than the history", m.BurstWindow)
+		}
+	}
+	for category, risk := range m.CategoryRisk {
+		if risk < 0 || risk > 1 {
+			return fmt.Errorf("risk of category %s must be between 0 and 1", category)
+		}
+	}
+
+	return nil
+}
+
+// positiveDuration checks that a setting is a positive duration.
+func positiveDuration(name string, value string) error {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", name, value, err)
+	}
+	if d <= 0 {
+		return fmt.Errorf("%s %q must be positive", name, value)
+	}
+
+	return nil
+}
+
+// history returns how far back the customer's charges are scored.
+func (m ScoringModel) history() time.Duration {
+	if d, err := time.ParseDuration(m.History); err == nil && d > 0 {
+		return d
+	}
+
+	return defaultHistory
+}
+
+// Since returns the time from which the customer's charges are scored, for a charge at a time.
+func (m ScoringModel) Since(at time.Time) time.Time {
+	return at.Add(-m.history())
+}
+
+// Score returns the risk score of a charge for products, given the charges the customer has already made and when
+// they made their first, or zero if this is it. Only the charges since the model's History are scored.
+func (m ScoringModel) Score(charge Charge, skus []string, history []Charge, first time.Time) int32 {
+	w := m.Weights
+
+	since := m.Since(charge.At)
+	var recent []Charge
+	for _, c := range history {
+		if !c.At.Before(since) {
+			recent = append(recent, c)
+		}
+	}
+	history = recent
+
+	var total float64
+	if w.Amount > 0 {
+		total += w.Amount * m.amountSignal(charge, history)
+	}
+	if w.Addresses > 0 {
+		total += w.Addresses * m.addressesSignal(charge, history)
+	}
+	if w.NewCustomer > 0 {
+		total += w.NewCustomer * m.newCustomerSignal(charge, first)
+	}
+	if w.Burst > 0 {
+		total += w.Burst * m.burstSignal(charge, history)
+	}
+	if w.SKU > 0 {
+		total += w.SKU * m.skuSignal(skus)
+	}
+
+	return int32(math.Round(100 * total / (w.Amount + w.Addresses + w.NewCustomer + w.Burst + w.SKU)))
+}
+
+// declines reports whether a score is high enough to decline a charge.
+func (m ScoringModel) declines(score int32) bool {
+	return m.DeclineScore > 0 && score >= m.DeclineScore
+}
+
+// reviews reports whether a score is high enough to hold a charge for review.
+func (m ScoringModel) reviews(score int32) bool {
+	return m.ReviewScore > 0 && score >= m.ReviewScore
+}
+
+// amountSignal rises from 0, for a charge no larger than the customer's average, to 1 at AmountMultiple times it.
+// A customer's first charge has nothing to compare with, and scores 0.
+func (m ScoringModel) amountSignal(charge Charge, history []Charge) float64 {
+	if len(history) == 0 {
+		return 0
+	}
+
+	var sum float64
+	for _, c := range history {
+		sum += float64(c.Amount.Amount)
+	}
+	average := sum / float64(len(history))
+	if average <= 0 {
+		return 1
+	}
+
+	return clamp((float64(charge.Amount.Amount)/average - 1) / (m.AmountMultiple - 1))
+}
+
+// addressesSignal rises from 0, for a customer who has only used one shipping address, to 1 at Addresses.
+func (m ScoringModel) addressesSignal(charge Charge, history []Charge) float64 {
+	var addresses []string
+	for _, address := range append(shippingAddresses(history), charge.ShippingAddress) {
+		if address != "" && !slices.Contains(addresses, address) {
+			addresses = append(addresses, address)
+		}
+	}
+	if len(addresses) == 0 {
+		return 0
+	}
+
+	return clamp(float64(len(addresses)-1) / float64(m.Addresses-1))
+}
+
+func shippingAddresses(charges []Charge) []string {
+	addresses := make([]string, len(charges))
+	for i, c := range charges {
+		addresses[i] = c.ShippingAddress
+	}
+
+	return addresses
+}
+
+// newCustomerSignal falls from 1, at the customer's first charge, to 0 once NewCustomer has passed since it.
+func (m ScoringModel) newCustomerSignal(charge Charge, first time.Time) float64 {
+	d, _ := time.ParseDuration(m.NewCustomer)
+
+	if first.IsZero() || first.After(charge.At) {
+		first = charge.At
+	}
+
+	return clamp(1 - float64(charge.At.Sub(first))/float64(d))
+}
+
+// burstSignal rises from 0, for a charge with no others within BurstWindow before it, to 1 at BurstCharges.
+func (m ScoringModel) burstSignal(charge Charge, history []Charge) float64 {
+	d, _ := time.ParseDuration(m.BurstWindow)
+	since := charge.At.Add(-d)
+
+	count := 1
+	for _, c := range history {
+		if !c.At.Before(since) {
+			count++
+		}
+	}
+
+	return clamp(float64(count-1) / float64(m.BurstCharges-1))
+}
+
+// skuSignal is the risk of the riskiest category among the products charged for.
+func (m ScoringModel) skuSignal(skus []string) float64 {
+	var risk float64
+	for _, sku := range skus {
+		if category, ok := m.SKUCategories[sku]; ok {
+			risk = max(risk, m.CategoryRisk[category])
+		}
+	}
+
+	return clamp(risk)
+}
+
+func clamp(signal float64) float64 {
+	return min(max(signal, 0), 1)
+}
+
+// scoringSetting is the name of the fraud setting which holds the scoring model.
+const scoringSetting = "scoring"
+
+// storeScoringModel replaces the scoring model in the database, or removes it if the model is nil.
+func storeScoringModel(ctx context.Context, store db.DB, model *ScoringModel) error {
+	if model == nil {
+		return store.DeleteFraudSetting(ctx, scoringSetting)
+	}
+
+	value, err := json.Marshal(model)
+	if err != nil {
+		return fmt.Errorf("failed to encode scoring model: %w", err)
+	}
+
+	return store.SetFraudSetting(ctx, &db.FraudSetting{Name: scoringSetting, Value: string(value)})
+}
+
+func (h *handlers) handleGetScoringModel(w http.ResponseWriter, r *http.Request) {
+	rules, err := GetRules(r.Context(), h.db)
+	if err != nil {
+		h.logger.Error("Failed to get fraud rules", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if rules.Scoring == nil {
+		http.Error(w, "no scoring model is configured", http.StatusNotFound)
+		return
+	}
+
+	h.encode(w, rules.Scoring)
+}
+
+func (h *handlers) handleSetScoringModel(w http.ResponseWriter, r *http.Request) {
+	var input ScoringModel
+
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		h.logger.Error("Failed to decode scoring model", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := input.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := storeScoringModel(r.Context(), h.db, &input); err != nil {
+		h.logger.Error("Failed to store scoring model", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.encode(w, input)
+}
+
+// handleDeleteScoringModel stops charges being scored.
+func (h *handlers) handleDeleteScoringModel(w http.ResponseWriter, r *http.Request) {
+	if err := storeScoringModel(r.Context(), h.db, nil); err != nil {
+		h.logger.Error("Failed to delete scoring model", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,151 @@
+package fraud_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/temporalio/reference-app-orders-go/app/currency"
+	"github.com/temporalio/reference-app-orders-go/app/fraud"
+)
+
+func TestScoreSignals(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	history := []fraud.Charge{
+		{Amount: currency.Money{Amount: 1000}, At: now.Add(-60 * 24 * time.Hour), ShippingAddress: "1 High St"},
+		{Amount: currency.Money{Amount: 3000}, At: now.Add(-10 * 24 * time.Hour), ShippingAddress: "1 High St"},
+	}
+	charge := fraud.Charge{Amount: currency.Money{Amount: 6000}, At: now, ShippingAddress: "2 Low Rd"}
+
+	for _, tc := range []struct {
+		name  string
+		model fraud.ScoringModel
+		skus  []string
+		score int32
+	}{
+		{
+			// The charge is three times the average of 2000, half way to five times it.
+			name:  "amount",
+			model: fraud.ScoringModel{Weights: fraud.SignalWeights{Amount: 1}, AmountMultiple: 5},
+			score: 50,
+		},
+		{
+			name:  "addresses",
+			model: fraud.ScoringModel{Weights: fraud.SignalWeights{Addresses: 1}, Addresses: 5},
+			score: 25,
+		},
+		{
+			// The first charge was 60 of 240 days ago.
+			name:  "new customer",
+			model: fraud.ScoringModel{Weights: fraud.SignalWeights{NewCustomer: 1}, NewCustomer: "5760h"},
+			score: 75,
+		},
+		{
+			name:  "burst",
+			model: fraud.ScoringModel{Weights: fraud.SignalWeights{Burst: 1}, BurstWindow: "336h", BurstCharges: 3},
+			score: 50,
+		},
+		{
+			name: "riskiest sku",
+			model: fraud.ScoringModel{
+				Weights:       fraud.SignalWeights{SKU: 1},
+				SKUCategories: map[string]string{"phone": "electronics", "voucher": "gift-cards"},
+				CategoryRisk:  map[string]float64{"electronics": 0.4, "gift-cards": 0.9},
+			},
+			skus:  []string{"phone", "voucher", "socks"},
+			score: 90,
+		},
+		{
+			name: "weighted",
+			model: fraud.ScoringModel{
+				Weights:        fraud.SignalWeights{Amount: 3, Addresses: 1},
+				AmountMultiple: 5,
+				Addresses:      5,
+			},
+			score: 44,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.NoError(t, tc.model.Validate())
+			assert.Equal(t, tc.score, tc.model.Score(charge, tc.skus, history, history[0].At))
+		})
+	}
+}
+
+func TestScoreFirstCharge(t *testing.T) {
+	model := fraud.ScoringModel{
+		Weights:        fraud.SignalWeights{Amount: 1, NewCustomer: 1},
+		AmountMultiple: 5,
+		NewCustomer:    "720h",
+	}
+	require.NoError(t, model.Validate())
+
+	// A first charge has no history to be large against, but is from a brand new customer.
+	assert.Equal(t, int32(50), model.Score(fraud.Charge{Amount: currency.Money{Amount: 100000}, At: time.Now()}, nil, nil, time.Time{}))
+}
+
+func TestScoreHistory(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	history := []fraud.Charge{
+		{Amount: currency.Money{Amount: 100}, At: now.Add(-60 * 24 * time.Hour)},
+		{Amount: currency.Money{Amount: 2000}, At: now.Add(-10 * 24 * time.Hour)},
+	}
+	model := fraud.ScoringModel{
+		Weights:        fraud.SignalWeights{Amount: 1, NewCustomer: 1},
+		AmountMultiple: 5,
+		NewCustomer:    "5760h",
+		History:        "720h",
+	}
+	require.NoError(t, model.Validate())
+
+	// Only the recent charge is averaged, but the customer is as new as their first charge, 60 of 240 days ago.
+	charge := fraud.Charge{Amount: currency.Money{Amount: 6000}, At: now}
+	assert.Equal(t, int32(63), model.Score(charge, nil, history, history[0].At))
+}
+
+func TestValidateScoringModel(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		model fraud.ScoringModel
+		err   string
+	}{
+		{name: "no weights", model: fraud.ScoringModel{}, err: "at least one signal must have a weight"},
+		{name: "negative weight", model: fraud.ScoringModel{Weights: fraud.SignalWeights{Amount: -1}}, err: "weights must not be negative"},
+		{
+			name:  "review above decline",
+			model: fraud.ScoringModel{Weights: fraud.SignalWeights{SKU: 1}, ReviewScore: 80, DeclineScore: 70},
+			err:   "reviewScore must be below the declineScore",
+		},
+		{
+			name:  "score above 100",
+			model: fraud.ScoringModel{Weights: fraud.SignalWeights{SKU: 1}, DeclineScore: 101},
+			err:   "scores must be between 0 and 100",
+		},
+		{name: "amount multiple", model: fraud.ScoringModel{Weights: fraud.SignalWeights{Amount: 1}, AmountMultiple: 1}, err: "amountMultiple must be more than 1"},
+		{name: "addresses", model: fraud.ScoringModel{Weights: fraud.SignalWeights{Addresses: 1}, Addresses: 1}, err: "addresses must be at least 2"},
+		{name: "new customer", model: fraud.ScoringModel{Weights: fraud.SignalWeights{NewCustomer: 1}}, err: `invalid newCustomer ""`},
+		{
+			name:  "burst charges",
+			model: fraud.ScoringModel{Weights: fraud.SignalWeights{Burst: 1}, BurstWindow: "1h", BurstCharges: 1},
+			err:   "burstCharges must be at least 2",
+		},
+		{name: "history", model: fraud.ScoringModel{Weights: fraud.SignalWeights{SKU: 1}, History: "-1h"}, err: `history "-1h" must be positive`},
+		{
+			name:  "burst window beyond history",
+			model: fraud.ScoringModel{Weights: fraud.SignalWeights{Burst: 1}, BurstWindow: "2161h", BurstCharges: 2},
+			err:   `burstWindow "2161h" must not be longer than the history`,
+		},
+		{
+			name:  "category risk",
+			model: fraud.ScoringModel{Weights: fraud.SignalWeights{SKU: 1}, CategoryRisk: map[string]float64{"gift-cards": 2}},
+			err:   "risk of category gift-cards must be between 0 and 1",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.ErrorContains(t, tc.model.Validate(), tc.err)
+		})
+	}
+}
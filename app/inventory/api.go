@@ -0,0 +1,260 @@
+package inventory
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/temporalio/reference-app-orders-go/app/db"
+)
+
+// Item represents an item being reserved.
+type Item struct {
+	SKU      string `json:"sku"`
+	Quantity int32  `json:"quantity"`
+}
+
+// InventoryLevel holds the stock level of a SKU at a warehouse.
+type InventoryLevel struct {
+	Warehouse string `json:"warehouse"`
+	SKU       string `json:"sku"`
+	Quantity  int32  `json:"quantity"`
+}
+
+// InventoryAdjustment is used to add or remove stock of a SKU at a warehouse.
+type InventoryAdjustment struct {
+	Warehouse string `json:"warehouse"`
+	SKU       string `json:"sku"`
+	Delta     int32  `json:"delta"`
+}
+
+// ReserveItemsInput is the input for the reservations endpoint.
+type ReserveItemsInput struct {
+	OrderID string `json:"orderId"`
+	Items   []Item `json:"items"`
+}
+
+// Reservation is a reservation of items for an order from a single warehouse.
+type Reservation struct {
+	Available bool   `json:"available"`
+	Location  string `json:"location,omitempty"`
+	Items     []Item `json:"items"`
+}
+
+// ReserveItemsResult is the result for the reservations endpoint.
+type ReserveItemsResult struct {
+	Reservations []Reservation `json:"reservations"`
+}
+
+// ReleaseItemsInput is the input for the release endpoint.
+type ReleaseItemsInput struct {
+	OrderID string `json:"orderId"`
+	Items   []Item `json:"items"`
+}
+
+type handlers struct {
+	db     db.DB
+	logger *slog.Logger
+}
+
+// Router implements the http.Handler interface for the Inventory API
+func Router(db db.DB, logger *slog.Logger) http.Handler {
+	r := http.NewServeMux()
+
+	h := handlers{db: db, logger: logger}
+
+	r.HandleFunc("GET /inventory", h.handleListInventory)
+	r.HandleFunc("POST /inventory", h.handleSetInventoryLevel)
+	r.HandleFunc("POST /inventory/adjust", h.handleAdjustInventoryLevel)
+	r.HandleFunc("GET /inventory/{sku}", h.handleGetInventory)
+	r.HandleFunc("POST /reservations", h.handleReserveItems)
+	r.HandleFunc("POST /reservations/release", h.handleReleaseItems)
+	r.HandleFunc("GET /reservations/{orderId}", h.handleGetReservations)
+
+	return r
+}
+
+func (h *handlers) handleListInventory(w http.ResponseWriter, r *http.Request) {
+	levels := []db.InventoryLevel{}
+
+	err := h.db.GetInventoryLevels(r.Context(), &levels)
+	if err != nil {
+		h.logger.Error("Failed to list inventory", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.writeLevels(w, levels)
+}
+
+func (h *handlers) handleGetInventory(w http.ResponseWriter, r *http.Request) {
+	levels := []db.InventoryLevel{}
+
+	err := h.db.GetInventoryLevelsForSKU(r.Context(), r.PathValue("sku"), &levels)
+	if err != nil {
+		h.logger.Error("Failed to get inventory", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.writeLevels(w, levels)
+}
+
+func (h *handlers) writeLevels(w http.ResponseWriter, levels []db.InventoryLevel) {
+	list := make([]InventoryLevel, len(levels))
+	for i, l := range levels {
+		list[i] = InventoryLevel{
+			Warehouse: l.Warehouse,
+			SKU:       l.SKU,
+			Quantity:  l.Quantity,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		h.logger.Error("Failed to encode inventory", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h *handlers) handleSetInventoryLevel(w http.ResponseWriter, r *http.Request) {
+	var input InventoryLevel
+
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		h.logger.Error("Failed to decode inventory level", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if input.Warehouse == "" || input.SKU == "" || input.Quantity < 0 {
+		http.Error(w, "warehouse, sku and a non-negative quantity are required", http.StatusBadRequest)
+		return
+	}
+
+	err = h.db.SetInventoryLevel(r.Context(), &db.InventoryLevel{
+		Warehouse: input.Warehouse,
+		SKU:       input.SKU,
+		Quantity:  input.Quantity,
+	})
+	if err != nil {
+		h.logger.Error("Failed to set inventory level", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *handlers) handleAdjustInventoryLevel(w http.ResponseWriter, r *http.Request) {
+	var input InventoryAdjustment
+
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		h.logger.Error("Failed to decode inventory adjustment", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if input.Warehouse == "" || input.SKU == "" {
+		http.Error(w, "warehouse and sku are required", http.StatusBadRequest)
+		return
+	}
+
+	err = h.db.AdjustInventoryLevel(r.Context(), input.Warehouse, input.SKU, input.Delta)
+	if err != nil {
+		if errors.Is(err, db.ErrInsufficientStock) {
+			http.Error(w, err.Error(), http.StatusConflict)
+		} else {
+			h.logger.Error("Failed to adjust inventory level", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *handlers) handleReserveItems(w http.ResponseWriter, r *http.Request) {
+	var input ReserveItemsInput
+
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		h.logger.Error("Failed to decode reservation input", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if input.OrderID == "" {
+		http.Error(w, "orderId is required", http.StatusBadRequest)
+		return
+	}
+
+	for _, item := range input.Items {
+		if item.SKU == "" || item.Quantity < 1 {
+			http.Error(w, "items must have a sku and a positive quantity", http.StatusBadRequest)
+			return
+		}
+	}
+
+	result, err := reserveItems(r.Context(), h.db, &input)
+	if err != nil {
+		h.logger.Error("Failed to reserve items", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		h.logger.Error("Failed to encode reservations", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h *handlers) handleReleaseItems(w http.ResponseWriter, r *http.Request) {
+	var input ReleaseItemsInput
+
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		h.logger.Error("Failed to decode release input", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if input.OrderID == "" {
+		http.Error(w, "orderId is required", http.StatusBadRequest)
+		return
+	}
+
+	for _, item := range input.Items {
+		err := h.db.ReleaseInventory(r.Context(), input.OrderID, item.SKU)
+		if err != nil {
+			h.logger.Error("Failed to release items", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *handlers) handleGetReservations(w http.ResponseWriter, r *http.Request) {
+	reservations := []db.InventoryReservation{}
+
+	err := h.db.GetInventoryReservations(r.Context(), r.PathValue("orderId"), &reservations)
+	if err != nil {
+		h.logger.Error("Failed to get reservations", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(groupReservations(reservations, nil)); err != nil {
+		h.logger.Error("Failed to encode reservations", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,168 @@
+package inventory_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/temporalio/reference-app-orders-go/app/db"
+	"github.com/temporalio/reference-app-orders-go/app/inventory"
+)
+
+func newRouter(t *testing.T) http.Handler {
+	store := db.NewSQLiteDB(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, store.Connect(context.Background()))
+	require.NoError(t, store.Setup())
+	t.Cleanup(func() { store.Close() })
+
+	return inventory.Router(store, slog.Default())
+}
+
+func post(t *testing.T, r http.Handler, path string, body string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("POST", path, strings.NewReader(body))
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	return rr
+}
+
+func getLevels(t *testing.T, r http.Handler, sku string) map[string]int32 {
+	req, err := http.NewRequest("GET", "/inventory/"+sku, nil)
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var levels []inventory.InventoryLevel
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&levels))
+
+	result := make(map[string]int32)
+	for _, l := range levels {
+		result[l.Warehouse] = l.Quantity
+	}
+
+	return result
+}
+
+func reserve(t *testing.T, r http.Handler, orderID string, items string) inventory.ReserveItemsResult {
+	rr := post(t, r, "/reservations", fmt.Sprintf(`{"orderId":%q,"items":%s}`, orderID, items))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	var result inventory.ReserveItemsResult
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&result))
+
+	return result
+}
+
+func TestAdjustInventory(t *testing.T) {
+	r := newRouter(t)
+
+	rr := post(t, r, "/inventory", `{"warehouse":"Warehouse A","sku":"Boots","quantity":5}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	rr = post(t, r, "/inventory/adjust", `{"warehouse":"Warehouse A","sku":"Boots","delta":-2}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	rr = post(t, r, "/inventory/adjust", `{"warehouse":"Warehouse B","sku":"Boots","delta":4}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	rr = post(t, r, "/inventory/adjust", `{"warehouse":"Warehouse A","sku":"Boots","delta":-4}`)
+	require.Equal(t, http.StatusConflict, rr.Code)
+
+	require.Equal(t, map[string]int32{"Warehouse A": 3, "Warehouse B": 4}, getLevels(t, r, "Boots"))
+}
+
+func TestReservePrefersWarehousesAlreadyInUse(t *testing.T) {
+	r := newRouter(t)
+
+	post(t, r, "/inventory", `{"warehouse":"Warehouse A","sku":"Boots","quantity":5}`)
+	post(t, r, "/inventory", `{"warehouse":"Warehouse A","sku":"Socks","quantity":2}`)
+	post(t, r, "/inventory", `{"warehouse":"Warehouse B","sku":"Socks","quantity":10}`)
+
+	result := reserve(t, r, "order1", `[{"sku":"Boots","quantity":1},{"sku":"Socks","quantity":2}]`)
+
+	require.Equal(t, []inventory.Reservation{
+		{
+			Available: true,
+			Location:  "Warehouse A",
+			Items: []inventory.Item{
+				{SKU: "Boots", Quantity: 1},
+				{SKU: "Socks", Quantity: 2},
+			},
+		},
+	}, result.Reservations)
+
+	require.Equal(t, map[string]int32{"Warehouse A": 0, "Warehouse B": 10}, getLevels(t, r, "Socks"))
+}
+
+func TestReserveIsIdempotentPerOrder(t *testing.T) {
+	r := newRouter(t)
+
+	post(t, r, "/inventory", `{"warehouse":"Warehouse A","sku":"Boots","quantity":5}`)
+
+	first := reserve(t, r, "order1", `[{"sku":"Boots","quantity":2}]`)
+	second := reserve(t, r, "order1", `[{"sku":"Boots","quantity":2}]`)
+
+	require.Equal(t, first, second)
+	require.Equal(t, map[string]int32{"Warehouse A": 3}, getLevels(t, r, "Boots"))
+}
+
+func TestConcurrentReservationsDoNotOversell(t *testing.T) {
+	r := newRouter(t)
+
+	post(t, r, "/inventory", `{"warehouse":"Warehouse A","sku":"Boots","quantity":3}`)
+	post(t, r, "/inventory", `{"warehouse":"Warehouse B","sku":"Boots","quantity":2}`)
+
+	const orders = 10
+
+	var wg sync.WaitGroup
+	results := make([]inventory.ReserveItemsResult, orders)
+	for i := range orders {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = reserve(t, r, fmt.Sprintf("order%d", i), `[{"sku":"Boots","quantity":1}]`)
+		}()
+	}
+	wg.Wait()
+
+	reserved := 0
+	for _, result := range results {
+		require.Len(t, result.Reservations, 1)
+		if result.Reservations[0].Available {
+			reserved++
+		}
+	}
+
+	require.Equal(t, 5, reserved)
+	require.Equal(t, map[string]int32{"Warehouse A": 0, "Warehouse B": 0}, getLevels(t, r, "Boots"))
+}
+
+func TestReleaseReturnsStock(t *testing.T) {
+	r := newRouter(t)
+
+	post(t, r, "/inventory", `{"warehouse":"Warehouse A","sku":"Boots","quantity":5}`)
+
+	reserve(t, r, "order1", `[{"sku":"Boots","quantity":2}]`)
+	require.Equal(t, map[string]int32{"Warehouse A": 3}, getLevels(t, r, "Boots"))
+
+	rr := post(t, r, "/reservations/release", `{"orderId":"order1","items":[{"sku":"Boots","quantity":2}]}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, map[string]int32{"Warehouse A": 5}, getLevels(t, r, "Boots"))
+
+	// Releasing again has no effect.
+	rr = post(t, r, "/reservations/release", `{"orderId":"order1","items":[{"sku":"Boots","quantity":2}]}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, map[string]int32{"Warehouse A": 5}, getLevels(t, r, "Boots"))
+}
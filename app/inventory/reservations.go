@@ -0,0 +1,151 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/temporalio/reference-app-orders-go/app/db"
+)
+
+// reserveItems reserves stock for the items in an Order, picking a warehouse for each item based on the
+// quantity it has on hand. Items that no single warehouse can supply are returned in a Reservation with
+// Available set to false.
+// Reservations are recorded against the Order, so retrying a request reuses any stock already reserved
+// rather than reserving it again.
+func reserveItems(ctx context.Context, store db.DB, input *ReserveItemsInput) (*ReserveItemsResult, error) {
+	var existing []db.InventoryReservation
+	if err := store.GetInventoryReservations(ctx, input.OrderID, &existing); err != nil {
+		return nil, err
+	}
+
+	reserved := make(map[string]bool)
+	used := make(map[string]bool)
+	for _, r := range existing {
+		reserved[r.SKU] = true
+		used[r.Warehouse] = true
+	}
+
+	var unavailable []Item
+
+	for _, item := range mergeItems(input.Items) {
+		if reserved[item.SKU] {
+			continue
+		}
+
+		warehouse, err := reserveItem(ctx, store, input.OrderID, item, used)
+		if err != nil {
+			return nil, err
+		}
+		if warehouse == "" {
+			unavailable = append(unavailable, item)
+			continue
+		}
+
+		used[warehouse] = true
+	}
+
+	// Reload the reservations so the result reflects what was recorded, even if a concurrent retry
+	// of this request reserved some of the items first.
+	var reservations []db.InventoryReservation
+	if err := store.GetInventoryReservations(ctx, input.OrderID, &reservations); err != nil {
+		return nil, err
+	}
+
+	return groupReservations(reservations, unavailable), nil
+}
+
+// reserveItem reserves an item from a warehouse that has enough stock on hand, returning the warehouse chosen.
+// An empty warehouse is returned if no warehouse can supply the item.
+func reserveItem(ctx context.Context, store db.DB, orderID string, item Item, used map[string]bool) (string, error) {
+	var levels []db.InventoryLevel
+	if err := store.GetInventoryLevelsForSKU(ctx, item.SKU, &levels); err != nil {
+		return "", err
+	}
+
+	var candidates []db.InventoryLevel
+	for _, l := range levels {
+		if l.Quantity >= item.Quantity {
+			candidates = append(candidates, l)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		ci, cj := candidates[i], candidates[j]
+		// Prefer warehouses already supplying this order, to keep the number of shipments down.
+		if used[ci.Warehouse] != used[cj.Warehouse] {
+			return used[ci.Warehouse]
+		}
+		// Otherwise prefer the warehouse with the most stock on hand.
+		if ci.Quantity != cj.Quantity {
+			return ci.Quantity > cj.Quantity
+		}
+		return ci.Warehouse < cj.Warehouse
+	})
+
+	for _, c := range candidates {
+		err := store.ReserveInventory(ctx, &db.InventoryReservation{
+			OrderID:   orderID,
+			Warehouse: c.Warehouse,
+			SKU:       item.SKU,
+			Quantity:  item.Quantity,
+		})
+		if errors.Is(err, db.ErrInsufficientStock) {
+			// A concurrent reservation took the stock since we read the levels, try the next warehouse.
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		return c.Warehouse, nil
+	}
+
+	return "", nil
+}
+
+// mergeItems combines items for the same SKU, preserving the order in which SKUs first appear.
+func mergeItems(items []Item) []Item {
+	var merged []Item
+	index := make(map[string]int)
+
+	for _, item := range items {
+		if i, ok := index[item.SKU]; ok {
+			merged[i].Quantity += item.Quantity
+			continue
+		}
+		index[item.SKU] = len(merged)
+		merged = append(merged, item)
+	}
+
+	return merged
+}
+
+// groupReservations builds one Reservation per warehouse, in the order the warehouses were first reserved from.
+// Any unavailable items are returned first, in a Reservation with Available set to false.
+func groupReservations(reservations []db.InventoryReservation, unavailable []Item) *ReserveItemsResult {
+	result := ReserveItemsResult{Reservations: []Reservation{}}
+
+	if len(unavailable) > 0 {
+		result.Reservations = append(result.Reservations, Reservation{
+			Available: false,
+			Items:     unavailable,
+		})
+	}
+
+	index := make(map[string]int)
+	for _, r := range reservations {
+		i, ok := index[r.Warehouse]
+		if !ok {
+			i = len(result.Reservations)
+			index[r.Warehouse] = i
+			result.Reservations = append(result.Reservations, Reservation{
+				Available: true,
+				Location:  r.Warehouse,
+			})
+		}
+		result.Reservations[i].Items = append(result.Reservations[i].Items, Item{SKU: r.SKU, Quantity: r.Quantity})
+	}
+
+	return &result
+}
@@ -0,0 +1,100 @@
+package ledger
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/temporalio/reference-app-orders-go/app/db"
+)
+
+type handlers struct {
+	db     db.DB
+	logger *slog.Logger
+}
+
+// Router implements the http.Handler interface for the Ledger API
+func Router(db db.DB, logger *slog.Logger) http.Handler {
+	r := http.NewServeMux()
+
+	h := handlers{db: db, logger: logger}
+
+	r.HandleFunc("POST /transactions", h.handleRecordTransaction)
+	r.HandleFunc("GET /customers/{id}/balance", h.handleGetCustomerBalance)
+	r.HandleFunc("GET /check", h.handleCheck)
+
+	return r
+}
+
+func (h *handlers) handleRecordTransaction(w http.ResponseWriter, r *http.Request) {
+	var input Transaction
+
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		h.logger.Error("Failed to decode transaction", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := input.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	transaction := transactionToDB(input)
+
+	err = h.db.InsertLedgerTransaction(r.Context(), &transaction)
+	if err != nil {
+		h.logger.Error("Failed to record transaction", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *handlers) handleGetCustomerBalance(w http.ResponseWriter, r *http.Request) {
+	customerID := r.PathValue("id")
+
+	var balances []db.LedgerBalance
+
+	err := h.db.GetLedgerBalances(r.Context(), customerID, &balances)
+	if err != nil {
+		h.logger.Error("Failed to get balances", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	balance := CustomerBalance{
+		CustomerID: customerID,
+		Accounts:   accountBalancesFromDB(balances),
+	}
+	for _, b := range balance.Accounts {
+		if b.Account == AccountReceivable {
+			balance.Balance = b.Balance
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(balance); err != nil {
+		h.logger.Error("Failed to encode balance", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h *handlers) handleCheck(w http.ResponseWriter, r *http.Request) {
+	report, err := Check(r.Context(), h.db)
+	if err != nil {
+		h.logger.Error("Failed to check ledger", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		h.logger.Error("Failed to encode report", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
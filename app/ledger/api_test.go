@@ -0,0 +1,153 @@
+package ledger_test
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/temporalio/reference-app-orders-go/app/db"
+	"github.com/temporalio/reference-app-orders-go/app/ledger"
+)
+
+func newStore(t *testing.T) db.DB {
+	store := db.NewSQLiteDB(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, store.Connect(context.Background()))
+	require.NoError(t, store.Setup())
+	t.Cleanup(func() { store.Close() })
+
+	return store
+}
+
+func do(t *testing.T, r http.Handler, method string, path string, body any) *httptest.ResponseRecorder {
+	var payload string
+	if body != nil {
+		b, err := json.Marshal(body)
+		require.NoError(t, err)
+		payload = string(b)
+	}
+
+	req, err := http.NewRequest(method, path, strings.NewReader(payload))
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	return rr
+}
+
+var at = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func TestCustomerBalance(t *testing.T) {
+	r := ledger.Router(newStore(t), slog.Default())
+
+	charge := ledger.NewCharge("charge:1", "customer1", "order1:1", ledger.Amounts{SubTotal: 24000, Shipping: 900, Tax: 4800}, at)
+	rr := do(t, r, "POST", "/transactions", charge)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	// Recording the same transaction again has no effect.
+	rr = do(t, r, "POST", "/transactions", charge)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = do(t, r, "POST", "/transactions", ledger.NewRefund("refund:1", "customer1", "order1:1", ledger.Amounts{SubTotal: 12000, Tax: 2400}, at))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = do(t, r, "POST", "/transactions", ledger.NewCharge("charge:2", "customer2", "order2:1", ledger.Amounts{SubTotal: 1000}, at))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = do(t, r, "GET", "/customers/customer1/balance", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var balance ledger.CustomerBalance
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&balance))
+	assert.Equal(t, ledger.CustomerBalance{
+		CustomerID: "customer1",
+		Balance:    15300,
+		Accounts: []ledger.AccountBalance{
+			{Account: ledger.AccountReceivable, Debit: 29700, Credit: 14400, Balance: 15300},
+			{Account: ledger.AccountRevenue, Debit: 12000, Credit: 24000, Balance: -12000},
+			{Account: ledger.AccountShippingRevenue, Credit: 900, Balance: -900},
+			{Account: ledger.AccountTaxPayable, Debit: 2400, Credit: 4800, Balance: -2400},
+		},
+	}, balance)
+
+	rr = do(t, r, "GET", "/customers/unknown/balance", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&balance))
+	assert.Equal(t, int64(0), balance.Balance)
+	assert.Empty(t, balance.Accounts)
+
+	rr = do(t, r, "GET", "/check", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var report ledger.Report
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&report))
+	assert.True(t, report.Balanced())
+	assert.Equal(t, int64(45100), report.Debit)
+	assert.Equal(t, int64(45100), report.Credit)
+}
+
+func TestRejectsUnbalancedTransaction(t *testing.T) {
+	r := ledger.Router(newStore(t), slog.Default())
+
+	for _, transaction := range []ledger.Transaction{
+		{ID: "1", Kind: ledger.TransactionKindCharge, CustomerID: "customer1", Entries: []ledger.Entry{
+			{Account: ledger.AccountReceivable, Debit: 100},
+			{Account: ledger.AccountRevenue, Credit: 90},
+		}},
+		{ID: "2", Kind: ledger.TransactionKindCharge, CustomerID: "customer1", Entries: []ledger.Entry{
+			{Account: ledger.AccountReceivable, Debit: 100},
+		}},
+		{ID: "3", Kind: ledger.TransactionKindCharge, CustomerID: "customer1", Entries: []ledger.Entry{
+			{Account: ledger.AccountReceivable, Debit: 100},
+			{Account: "cash", Credit: 100},
+		}},
+		{ID: "4", Kind: ledger.TransactionKindCharge, CustomerID: "customer1", Entries: []ledger.Entry{
+			{Account: ledger.AccountReceivable, Debit: 100, Credit: 100},
+			{Account: ledger.AccountRevenue, Debit: 0},
+		}},
+		{Kind: ledger.TransactionKindCharge, CustomerID: "customer1", Entries: []ledger.Entry{
+			{Account: ledger.AccountReceivable, Debit: 100},
+			{Account: ledger.AccountRevenue, Credit: 100},
+		}},
+	} {
+		rr := do(t, r, "POST", "/transactions", transaction)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, "transaction %q", transaction.ID)
+	}
+}
+
+func TestCheckFindsUnbalancedTransactions(t *testing.T) {
+	store := newStore(t)
+
+	charge := ledger.NewCharge("charge:1", "customer1", "order1:1", ledger.Amounts{SubTotal: 1000, Tax: 200}, at)
+	require.NoError(t, charge.Validate())
+	r := ledger.Router(store, slog.Default())
+	rr := do(t, r, "POST", "/transactions", charge)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	// Write a transaction which bypasses validation, as a damaged or hand-edited ledger might contain.
+	require.NoError(t, store.InsertLedgerTransaction(context.Background(), &db.LedgerTransaction{
+		ID:         "broken",
+		Kind:       ledger.TransactionKindCharge,
+		CustomerID: "customer1",
+		CreatedAt:  at,
+		Entries: []db.LedgerEntry{
+			{Account: ledger.AccountReceivable, Debit: 500},
+			{Account: ledger.AccountRevenue, Credit: 400},
+		},
+	}))
+
+	report, err := ledger.Check(context.Background(), store)
+	require.NoError(t, err)
+	assert.False(t, report.Balanced())
+	assert.Equal(t, []string{"broken"}, report.Unbalanced)
+	assert.Equal(t, int64(1700), report.Debit)
+	assert.Equal(t, int64(1600), report.Credit)
+}
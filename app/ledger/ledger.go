@@ -0,0 +1,262 @@
+package ledger
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/temporalio/reference-app-orders-go/app/db"
+)
+
+const (
+	// AccountReceivable is the account for amounts charged to customers.
+	AccountReceivable = "customer_receivable"
+
+	// AccountRevenue is the account for sales of goods.
+	AccountRevenue = "revenue"
+
+	// AccountTaxPayable is the account for tax collected on behalf of the tax authorities.
+	AccountTaxPayable = "tax_payable"
+
+	// AccountShippingRevenue is the account for shipping charged to customers.
+	AccountShippingRevenue = "shipping_revenue"
+
+	// AccountChargebackLosses is the account for payments taken back by card issuers after a lost dispute.
+	AccountChargebackLosses = "chargeback_losses"
+)
+
+// Accounts is the list of known accounts.
+var Accounts = []string{AccountReceivable, AccountRevenue, AccountTaxPayable, AccountShippingRevenue, AccountChargebackLosses}
+
+const (
+	// TransactionKindCharge is the kind of transaction recorded when a payment is captured.
+	TransactionKindCharge = "charge"
+
+	// TransactionKindRefund is the kind of transaction recorded when a payment is refunded.
+	TransactionKindRefund = "refund"
+
+	// TransactionKindChargeback is the kind of transaction recorded when a dispute is lost.
+	TransactionKindChargeback = "chargeback"
+)
+
+// TransactionKinds is the list of known transaction kinds.
+var TransactionKinds = []string{TransactionKindCharge, TransactionKindRefund, TransactionKindChargeback}
+
+// Entry is a debit or credit to an account, in cents.
+// Exactly one of Debit and Credit is set.
+type Entry struct {
+	Account string `json:"account"`
+	Debit   int64  `json:"debit,omitempty"`
+	Credit  int64  `json:"credit,omitempty"`
+}
+
+// Transaction is a single money movement, recorded as entries whose debits and credits balance.
+// The ID identifies the movement, so that recording the same transaction again has no effect.
+type Transaction struct {
+	ID         string    `json:"id"`
+	Kind       string    `json:"kind"`
+	Reference  string    `json:"reference"`
+	CustomerID string    `json:"customerId"`
+	CreatedAt  time.Time `json:"createdAt"`
+	Entries    []Entry   `json:"entries"`
+}
+
+// Amounts are the parts of a charge or refund, in cents.
+type Amounts struct {
+	SubTotal int64
+	Shipping int64
+	Tax      int64
+}
+
+// Total returns the total amount.
+func (a Amounts) Total() int64 {
+	return a.SubTotal + a.Shipping + a.Tax
+}
+
+// NewCharge returns the transaction for a captured payment, which debits the customer and credits revenue, tax and
+// shipping.
+func NewCharge(id string, customerID string, reference string, amounts Amounts, at time.Time) Transaction {
+	entries := []Entry{{Account: AccountReceivable, Debit: amounts.Total()}}
+	for _, e := range amounts.entries() {
+		entries = append(entries, Entry{Account: e.Account, Credit: e.Debit})
+	}
+
+	return Transaction{
+		ID:         id,
+		Kind:       TransactionKindCharge,
+		Reference:  reference,
+		CustomerID: customerID,
+		CreatedAt:  at,
+		Entries:    entries,
+	}
+}
+
+// NewRefund returns the transaction for a refunded payment, which reverses the entries of a charge.
+func NewRefund(id string, customerID string, reference string, amounts Amounts, at time.Time) Transaction {
+	entries := amounts.entries()
+	entries = append(entries, Entry{Account: AccountReceivable, Credit: amounts.Total()})
+
+	return Transaction{
+		ID:         id,
+		Kind:       TransactionKindRefund,
+		Reference:  reference,
+		CustomerID: customerID,
+		CreatedAt:  at,
+		Entries:    entries,
+	}
+}
+
+// NewChargeback returns the transaction for a payment taken back by the card issuer, which credits the customer and
+// books the amount as a loss. The original sale still stands, so revenue, tax and shipping are left as they were.
+func NewChargeback(id string, customerID string, reference string, amount int64, at time.Time) Transaction {
+	return Transaction{
+		ID:         id,
+		Kind:       TransactionKindChargeback,
+		Reference:  reference,
+		CustomerID: customerID,
+		CreatedAt:  at,
+		Entries: []Entry{
+			{Account: AccountChargebackLosses, Debit: amount},
+			{Account: AccountReceivable, Credit: amount},
+		},
+	}
+}
+
+// entries returns debits for the non-zero parts of the amounts.
+func (a Amounts) entries() []Entry {
+	var entries []Entry
+	for _, e := range []Entry{
+		{Account: AccountRevenue, Debit: a.SubTotal},
+		{Account: AccountShippingRevenue, Debit: a.Shipping},
+		{Account: AccountTaxPayable, Debit: a.Tax},
+	} {
+		if e.Debit != 0 {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// Validate checks that a transaction is complete and that its debits and credits balance.
+func (t Transaction) Validate() error {
+	if t.ID == "" || t.CustomerID == "" {
+		return fmt.Errorf("id and customerId are required")
+	}
+	if !slices.Contains(TransactionKinds, t.Kind) {
+		return fmt.Errorf("unknown transaction kind: %s", t.Kind)
+	}
+	if len(t.Entries) < 2 {
+		return fmt.Errorf("a transaction must have at least two entries")
+	}
+
+	var debit, credit int64
+	for _, e := range t.Entries {
+		if !slices.Contains(Accounts, e.Account) {
+			return fmt.Errorf("unknown account: %s", e.Account)
+		}
+		if e.Debit < 0 || e.Credit < 0 || (e.Debit == 0) == (e.Credit == 0) {
+			return fmt.Errorf("each entry must have either a positive debit or a positive credit")
+		}
+		debit += e.Debit
+		credit += e.Credit
+	}
+	if debit != credit {
+		return fmt.Errorf("debits (%d) and credits (%d) do not balance", debit, credit)
+	}
+
+	return nil
+}
+
+// AccountBalance is the total debits and credits to an account, in cents.
+// Balance is debits less credits, so accounts which are credited, such as revenue, have a negative balance.
+type AccountBalance struct {
+	Account string `json:"account"`
+	Debit   int64  `json:"debit"`
+	Credit  int64  `json:"credit"`
+	Balance int64  `json:"balance"`
+}
+
+// CustomerBalance is the ledger balance for a customer.
+// Balance is the net amount charged to the customer, after refunds, in cents.
+type CustomerBalance struct {
+	CustomerID string           `json:"customerId"`
+	Balance    int64            `json:"balance"`
+	Accounts   []AccountBalance `json:"accounts"`
+}
+
+// Report is the result of checking that the books balance.
+type Report struct {
+	Accounts []AccountBalance `json:"accounts"`
+	Debit    int64            `json:"debit"`
+	Credit   int64            `json:"credit"`
+	// Unbalanced lists the transactions whose debits and credits differ.
+	Unbalanced []string `json:"unbalanced"`
+}
+
+// Balanced reports whether the books balance.
+func (r Report) Balanced() bool {
+	return r.Debit == r.Credit && len(r.Unbalanced) == 0
+}
+
+// Check checks that every transaction in the ledger balances, and that total debits across all accounts equal total
+// credits.
+func Check(ctx context.Context, store db.DB) (Report, error) {
+	var balances []db.LedgerBalance
+	if err := store.GetLedgerBalances(ctx, "", &balances); err != nil {
+		return Report{}, err
+	}
+
+	var unbalanced []db.LedgerTransactionTotal
+	if err := store.GetUnbalancedLedgerTransactions(ctx, &unbalanced); err != nil {
+		return Report{}, err
+	}
+
+	report := Report{
+		Accounts:   accountBalancesFromDB(balances),
+		Unbalanced: []string{},
+	}
+	for _, b := range report.Accounts {
+		report.Debit += b.Debit
+		report.Credit += b.Credit
+	}
+	for _, t := range unbalanced {
+		report.Unbalanced = append(report.Unbalanced, t.TransactionID)
+	}
+
+	return report, nil
+}
+
+func accountBalancesFromDB(balances []db.LedgerBalance) []AccountBalance {
+	accounts := make([]AccountBalance, len(balances))
+	for i, b := range balances {
+		accounts[i] = AccountBalance{
+			Account: b.Account,
+			Debit:   b.Debit,
+			Credit:  b.Credit,
+			Balance: b.Debit - b.Credit,
+		}
+	}
+	return accounts
+}
+
+func transactionToDB(t Transaction) db.LedgerTransaction {
+	entries := make([]db.LedgerEntry, len(t.Entries))
+	for i, e := range t.Entries {
+		entries[i] = db.LedgerEntry{
+			TransactionID: t.ID,
+			Account:       e.Account,
+			Debit:         e.Debit,
+			Credit:        e.Credit,
+		}
+	}
+
+	return db.LedgerTransaction{
+		ID:         t.ID,
+		Kind:       t.Kind,
+		Reference:  t.Reference,
+		CustomerID: t.CustomerID,
+		CreatedAt:  t.CreatedAt,
+		Entries:    entries,
+	}
+}
@@ -0,0 +1,126 @@
+package loyalty
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/temporalio/reference-app-orders-go/app/db"
+)
+
+type handlers struct {
+	db     db.DB
+	logger *slog.Logger
+}
+
+// Router implements the http.Handler interface for the Loyalty API
+func Router(db db.DB, logger *slog.Logger) http.Handler {
+	r := http.NewServeMux()
+
+	h := handlers{db: db, logger: logger}
+
+	r.HandleFunc("GET /customers/{id}/loyalty", h.handleGetAccount)
+	r.HandleFunc("POST /customers/{id}/loyalty/transactions", h.handleRecordTransaction)
+
+	return r
+}
+
+func (h *handlers) handleGetAccount(w http.ResponseWriter, r *http.Request) {
+	customerID := r.PathValue("id")
+
+	var transactions []db.LoyaltyTransaction
+
+	err := h.db.GetLoyaltyTransactions(r.Context(), customerID, &transactions)
+	if err != nil {
+		h.logger.Error("Failed to list loyalty transactions", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	account := Account{CustomerID: customerID, History: []Transaction{}}
+	for _, t := range transactions {
+		account.Balance += t.Points
+		account.History = append(account.History, transactionFromDB(t))
+	}
+
+	h.encode(w, account)
+}
+
+// handleRecordTransaction records a change to a customer's points balance. Recording a transaction again has no
+// further effect, so callers may safely retry. Redemptions which the balance does not cover are refused with a
+// conflict, but reversals may take the balance below zero, as the points may already have been spent.
+func (h *handlers) handleRecordTransaction(w http.ResponseWriter, r *http.Request) {
+	customerID := r.PathValue("id")
+
+	var input Transaction
+
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		h.logger.Error("Failed to decode loyalty transaction", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if input.CustomerID != "" && input.CustomerID != customerID {
+		http.Error(w, "customerId does not match the customer", http.StatusBadRequest)
+		return
+	}
+	input.CustomerID = customerID
+
+	if input.CreatedAt.IsZero() {
+		input.CreatedAt = time.Now().UTC()
+	}
+
+	if err := input.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	transaction := transactionToDB(input)
+
+	err = h.db.RecordLoyaltyTransaction(r.Context(), &transaction, input.Kind == KindReversal)
+	if err != nil {
+		if errors.Is(err, db.ErrLoyaltyInsufficientPoints) {
+			http.Error(w, err.Error(), http.StatusConflict)
+		} else {
+			h.logger.Error("Failed to record loyalty transaction", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.encode(w, input)
+}
+
+func (h *handlers) encode(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.logger.Error("Failed to encode loyalty account", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func transactionFromDB(t db.LoyaltyTransaction) Transaction {
+	return Transaction{
+		ID:         t.ID,
+		CustomerID: t.CustomerID,
+		Kind:       t.Kind,
+		Points:     t.Points,
+		Reference:  t.Reference,
+		CreatedAt:  t.CreatedAt.UTC(),
+	}
+}
+
+func transactionToDB(t Transaction) db.LoyaltyTransaction {
+	return db.LoyaltyTransaction{
+		ID:         t.ID,
+		CustomerID: t.CustomerID,
+		Kind:       t.Kind,
+		Points:     t.Points,
+		Reference:  t.Reference,
+		CreatedAt:  t.CreatedAt.UTC(),
+	}
+}
@@ -0,0 +1,111 @@
+package loyalty_test
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/temporalio/reference-app-orders-go/app/db"
+	"github.com/temporalio/reference-app-orders-go/app/loyalty"
+)
+
+func newRouter(t *testing.T) http.Handler {
+	store := db.NewSQLiteDB(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, store.Connect(context.Background()))
+	require.NoError(t, store.Setup())
+	t.Cleanup(func() { store.Close() })
+
+	return loyalty.Router(store, slog.Default())
+}
+
+func do(t *testing.T, r http.Handler, method string, path string, body string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, path, strings.NewReader(body))
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	return rr
+}
+
+func decode[T any](t *testing.T, rr *httptest.ResponseRecorder) T {
+	var v T
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&v))
+	return v
+}
+
+func TestAccount(t *testing.T) {
+	r := newRouter(t)
+
+	rr := do(t, r, "GET", "/customers/alice/loyalty", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, loyalty.Account{CustomerID: "alice", History: []loyalty.Transaction{}}, decode[loyalty.Account](t, rr))
+
+	rr = do(t, r, "POST", "/customers/alice/loyalty/transactions", `{"id":"accrual:A1:1","kind":"accrual","points":300,"reference":"A1:1","createdAt":"2024-01-01T00:00:00Z"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	// Recording the same transaction again has no further effect.
+	rr = do(t, r, "POST", "/customers/alice/loyalty/transactions", `{"id":"accrual:A1:1","kind":"accrual","points":300,"reference":"A1:1","createdAt":"2024-01-01T00:00:00Z"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = do(t, r, "POST", "/customers/alice/loyalty/transactions", `{"id":"redemption:A2","kind":"redemption","points":-200,"reference":"A2","createdAt":"2024-01-02T00:00:00Z"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = do(t, r, "POST", "/customers/alice/loyalty/transactions", `{"id":"return:A2","kind":"return","points":50,"reference":"A2","createdAt":"2024-01-03T00:00:00Z"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = do(t, r, "GET", "/customers/alice/loyalty", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	account := decode[loyalty.Account](t, rr)
+	assert.Equal(t, int64(150), account.Balance)
+	require.Len(t, account.History, 3)
+	assert.Equal(t, loyalty.KindAccrual, account.History[0].Kind)
+	assert.Equal(t, loyalty.KindRedemption, account.History[1].Kind)
+	assert.Equal(t, loyalty.KindReturn, account.History[2].Kind)
+
+	// Other customers' points are kept apart.
+	rr = do(t, r, "GET", "/customers/bob/loyalty", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Zero(t, decode[loyalty.Account](t, rr).Balance)
+}
+
+func TestRedeemMoreThanBalance(t *testing.T) {
+	r := newRouter(t)
+
+	rr := do(t, r, "POST", "/customers/alice/loyalty/transactions", `{"id":"accrual:A1:1","kind":"accrual","points":100,"reference":"A1:1"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = do(t, r, "POST", "/customers/alice/loyalty/transactions", `{"id":"redemption:A2","kind":"redemption","points":-101,"reference":"A2"}`)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	// Points may be taken back even once they have been spent.
+	rr = do(t, r, "POST", "/customers/alice/loyalty/transactions", `{"id":"redemption:A2","kind":"redemption","points":-100,"reference":"A2"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = do(t, r, "POST", "/customers/alice/loyalty/transactions", `{"id":"reversal:R1","kind":"reversal","points":-100,"reference":"A1:1"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = do(t, r, "GET", "/customers/alice/loyalty", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, int64(-100), decode[loyalty.Account](t, rr).Balance)
+}
+
+func TestInvalidTransaction(t *testing.T) {
+	r := newRouter(t)
+
+	rr := do(t, r, "POST", "/customers/alice/loyalty/transactions", `{"id":"accrual:A1:1","kind":"accrual","points":-5,"reference":"A1:1"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = do(t, r, "POST", "/customers/alice/loyalty/transactions", `{"id":"bonus:1","kind":"bonus","points":5}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = do(t, r, "POST", "/customers/alice/loyalty/transactions", `{"id":"accrual:A1:1","customerId":"bob","kind":"accrual","points":5}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
@@ -0,0 +1,164 @@
+package loyalty
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/temporalio/reference-app-orders-go/app/currency"
+)
+
+const (
+	// KindAccrual is the kind of transaction which awards points for a completed fulfillment.
+	KindAccrual = "accrual"
+
+	// KindRedemption is the kind of transaction which spends points as payment for an order.
+	KindRedemption = "redemption"
+
+	// KindReturn is the kind of transaction which gives back redeemed points an order did not use.
+	KindReturn = "return"
+
+	// KindReversal is the kind of transaction which takes back points awarded for a refunded fulfillment.
+	KindReversal = "reversal"
+)
+
+// Kinds is the list of known transaction kinds.
+var Kinds = []string{KindAccrual, KindRedemption, KindReturn, KindReversal}
+
+// Transaction is a change to a customer's points balance.
+// The ID identifies the change, so that recording the same transaction again has no effect.
+type Transaction struct {
+	ID         string `json:"id"`
+	CustomerID string `json:"customerId"`
+	Kind       string `json:"kind"`
+	// Points are added to the balance by accruals and returns, and taken away by redemptions and reversals.
+	Points int64 `json:"points"`
+	// Reference is the fulfillment points were awarded for, or the order they were spent on.
+	Reference string    `json:"reference"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Validate checks that a transaction is complete and moves points in the direction its kind requires.
+func (t Transaction) Validate() error {
+	if t.ID == "" {
+		return fmt.Errorf("id is required")
+	}
+	if t.CustomerID == "" {
+		return fmt.Errorf("customerId is required")
+	}
+	if !slices.Contains(Kinds, t.Kind) {
+		return fmt.Errorf("unknown transaction kind: %s", t.Kind)
+	}
+
+	switch t.Kind {
+	case KindAccrual, KindReturn:
+		if t.Points < 0 {
+			return fmt.Errorf("%s points must not be negative", t.Kind)
+		}
+	case KindRedemption, KindReversal:
+		if t.Points > 0 {
+			return fmt.Errorf("%s points must not be positive", t.Kind)
+		}
+	}
+
+	return nil
+}
+
+// Account is a customer's points balance and the transactions which make it up.
+type Account struct {
+	CustomerID string `json:"customerId"`
+	Balance    int64  `json:"balance"`
+	// History lists the transactions, oldest first.
+	History []Transaction `json:"history"`
+}
+
+// Rules are the loyalty scheme's rules for awarding and spending points.
+type Rules struct {
+	// PointsPerUnit is the number of points awarded for each whole unit of the base currency spent on products.
+	PointsPerUnit int64 `json:"pointsPerUnit"`
+	// SKUs maps products to the points awarded for each unit bought, instead of the points for the amount spent.
+	SKUs map[string]int64 `json:"skus"`
+	// PointValue is what one point pays for when it is redeemed, in cents of the base currency.
+	PointValue int64 `json:"pointValue"`
+}
+
+//go:embed rules.json
+var defaultRules []byte
+
+// LoadRules reads loyalty rules from a JSON file, or returns the built-in rules if path is empty.
+func LoadRules(path string) (Rules, error) {
+	var rules Rules
+
+	data := defaultRules
+	if path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return rules, fmt.Errorf("failed to read loyalty rules: %w", err)
+		}
+	}
+
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return rules, fmt.Errorf("failed to decode loyalty rules: %w", err)
+	}
+
+	return rules, rules.Validate()
+}
+
+// Validate checks that the rules never award or spend a negative number of points.
+func (r Rules) Validate() error {
+	if r.PointsPerUnit < 0 {
+		return fmt.Errorf("pointsPerUnit must not be negative")
+	}
+	for sku, points := range r.SKUs {
+		if points < 0 {
+			return fmt.Errorf("points for %s must not be negative", sku)
+		}
+	}
+	if r.PointValue <= 0 {
+		return fmt.Errorf("pointValue must be positive")
+	}
+
+	return nil
+}
+
+// Line is a product bought on a fulfillment.
+type Line struct {
+	SKU      string
+	Quantity int32
+	// Amount is what the customer paid for the line after discounts, excluding tax and shipping, in the base currency.
+	Amount currency.Money
+}
+
+// Points returns the points awarded for a fulfillment's lines. Part units of currency earn nothing.
+func (r Rules) Points(lines []Line) (int64, error) {
+	// Points are counted with Money's overflow-checked arithmetic.
+	var total currency.Money
+
+	for _, line := range lines {
+		var points currency.Money
+		var err error
+
+		if perUnit, ok := r.SKUs[line.SKU]; ok {
+			points, err = currency.Money{Amount: perUnit}.Mul(int64(line.Quantity))
+		} else if line.Amount.Amount > 0 {
+			points, err = currency.Money{Amount: r.PointsPerUnit}.MulDiv(line.Amount.Amount, 100)
+		}
+		if err == nil {
+			total, err = total.Add(points)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return total.Amount, nil
+}
+
+// Value returns what a number of points pays for, in the base currency.
+func (r Rules) Value(points int64, base string) (currency.Money, error) {
+	return currency.NewMoney(r.PointValue, base).Mul(points)
+}
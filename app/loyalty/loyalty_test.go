@@ -0,0 +1,47 @@
+package loyalty_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/temporalio/reference-app-orders-go/app/currency"
+	"github.com/temporalio/reference-app-orders-go/app/loyalty"
+)
+
+func TestPoints(t *testing.T) {
+	rules := loyalty.Rules{PointsPerUnit: 2, SKUs: map[string]int64{"Hiking Boots": 100}, PointValue: 1}
+
+	points, err := rules.Points([]loyalty.Line{
+		// Boots earn their own points per pair, whatever was paid.
+		{SKU: "Hiking Boots", Quantity: 2, Amount: currency.NewMoney(12000, "GBP")},
+		// Other products earn on the amount spent, ignoring part units.
+		{SKU: "Guide Book", Quantity: 1, Amount: currency.NewMoney(1499, "GBP")},
+		// Lines paid for entirely by discounts earn nothing.
+		{SKU: "Socks", Quantity: 1, Amount: currency.NewMoney(0, "GBP")},
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(200+29), points)
+}
+
+func TestPointsOverflow(t *testing.T) {
+	rules := loyalty.Rules{SKUs: map[string]int64{"Hiking Boots": 1 << 62}, PointValue: 1}
+
+	_, err := rules.Points([]loyalty.Line{{SKU: "Hiking Boots", Quantity: 4}})
+	require.Error(t, err)
+}
+
+func TestLoadRules(t *testing.T) {
+	rules, err := loyalty.LoadRules("")
+	require.NoError(t, err)
+	require.Positive(t, rules.PointValue)
+
+	value, err := rules.Value(250, "GBP")
+	require.NoError(t, err)
+	require.Equal(t, currency.NewMoney(250*rules.PointValue, "GBP"), value)
+}
+
+func TestValidateRules(t *testing.T) {
+	require.Error(t, loyalty.Rules{PointsPerUnit: -1, PointValue: 1}.Validate())
+	require.Error(t, loyalty.Rules{SKUs: map[string]int64{"Socks": -5}, PointValue: 1}.Validate())
+	require.Error(t, loyalty.Rules{PointsPerUnit: 1}.Validate())
+}
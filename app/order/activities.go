@@ -4,19 +4,29 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"strings"
+	"net/url"
+	"time"
 
 	"github.com/temporalio/reference-app-orders-go/app/billing"
+	"github.com/temporalio/reference-app-orders-go/app/currency"
+	"github.com/temporalio/reference-app-orders-go/app/inventory"
+	"github.com/temporalio/reference-app-orders-go/app/loyalty"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
 )
 
 // Activities implements the order package's Activities.
 // Any state shared by the worker among the activities is stored here.
 type Activities struct {
-	BillingURL string
-	OrderURL   string
+	BillingURL    string
+	OrderURL      string
+	InventoryURL  string
+	ExchangeRates *currency.Table
+	LoyaltyRules  loyalty.Rules
 }
 
 var a Activities
@@ -95,62 +105,111 @@ type ReserveItemsResult struct {
 	Reservations []*Reservation
 }
 
-// ReserveItems reserves items to satisfy an order. It returns a list of reservations for the items.
+// GetExchangeRate returns the current exchange rate from the base currency to an order's currency.
+func (a *Activities) GetExchangeRate(_ context.Context, code string) (*currency.Rate, error) {
+	rate, err := a.ExchangeRates.Rate(code)
+	if errors.Is(err, currency.ErrUnsupportedCurrency) {
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(), "UnsupportedCurrency", err)
+	}
+
+	return rate, err
+}
+
+// ReserveItems reserves items to satisfy an order via the Inventory API. It returns a list of reservations for the items.
 // Any unavailable items will be returned in a Reservation with Available set to false.
-// In a real system this would involve an inventory database of some kind.
-// For our purposes we just split orders arbitrarily.
-func (a *Activities) ReserveItems(_ context.Context, input *ReserveItemsInput) (*ReserveItemsResult, error) {
+func (a *Activities) ReserveItems(ctx context.Context, input *ReserveItemsInput) (*ReserveItemsResult, error) {
 	if len(input.Items) < 1 {
 		return &ReserveItemsResult{}, nil
 	}
 
-	var reservations []*Reservation
-	var unavailableItems []*Item
-	var availableItems []*Item
+	reserveInput := inventory.ReserveItemsInput{OrderID: input.OrderID}
+	for _, i := range input.Items {
+		reserveInput.Items = append(reserveInput.Items, inventory.Item{SKU: i.SKU, Quantity: i.Quantity})
+	}
+
+	jsonInput, err := json.Marshal(reserveInput)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode input: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.InventoryURL+"/reservations", bytes.NewReader(jsonInput))
+	if err != nil {
+		return nil, fmt.Errorf("unable to build request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		body, _ := io.ReadAll(res.Body)
+		return nil, fmt.Errorf("%s: %s", http.StatusText(res.StatusCode), body)
+	}
+
+	var reserveResult inventory.ReserveItemsResult
+
+	err = json.NewDecoder(res.Body).Decode(&reserveResult)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, item := range input.Items {
-		if strings.Contains(item.SKU, "Adidas") {
-			unavailableItems = append(unavailableItems, item)
-		} else {
-			availableItems = append(availableItems, item)
+	var result ReserveItemsResult
+	for _, r := range reserveResult.Reservations {
+		reservation := &Reservation{
+			Available: r.Available,
+			Location:  r.Location,
+		}
+		for _, i := range r.Items {
+			reservation.Items = append(reservation.Items, &Item{SKU: i.SKU, Quantity: i.Quantity})
 		}
+		result.Reservations = append(result.Reservations, reservation)
 	}
 
-	if len(unavailableItems) > 0 {
-		reservations = append(
-			reservations,
-			&Reservation{
-				Available: false,
-				Items:     unavailableItems,
-			},
-		)
-	}
-
-	// First item from one warehouse
-	reservations = append(
-		reservations,
-		&Reservation{
-			Available: true,
-			Location:  "Warehouse A",
-			Items:     availableItems[0:1],
-		},
-	)
+	return &result, nil
+}
+
+// ReleaseItemsInput is the input to the ReleaseItems activity.
+type ReleaseItemsInput struct {
+	OrderID string
+	Items   []*Item
+}
+
+// ReleaseItems returns reserved items to stock via the Inventory API.
+// Releasing items which are not reserved has no effect, so this is safe to retry.
+func (a *Activities) ReleaseItems(ctx context.Context, input *ReleaseItemsInput) error {
+	releaseInput := inventory.ReleaseItemsInput{OrderID: input.OrderID}
+	for _, i := range input.Items {
+		releaseInput.Items = append(releaseInput.Items, inventory.Item{SKU: i.SKU, Quantity: i.Quantity})
+	}
+
+	jsonInput, err := json.Marshal(releaseInput)
+	if err != nil {
+		return fmt.Errorf("unable to encode input: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.InventoryURL+"/reservations/release", bytes.NewReader(jsonInput))
+	if err != nil {
+		return fmt.Errorf("unable to build request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
 
-	if len(availableItems) > 1 {
-		// Second fulfillment with all other items
-		reservations = append(
-			reservations,
-			&Reservation{
-				Available: true,
-				Location:  "Warehouse B",
-				Items:     availableItems[1:],
-			},
-		)
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		body, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("%s: %s", http.StatusText(res.StatusCode), body)
 	}
 
-	return &ReserveItemsResult{
-		Reservations: reservations,
-	}, nil
+	return nil
 }
 
 // ChargeInput is the input to the Charge activity.
@@ -181,7 +240,12 @@ func (a *Activities) Charge(ctx context.Context, input *ChargeInput) (*ChargeRes
 
 	if res.StatusCode < 200 || res.StatusCode >= 300 {
 		body, _ := io.ReadAll(res.Body)
-		return nil, fmt.Errorf("%s: %s", http.StatusText(res.StatusCode), body)
+		err := fmt.Errorf("%s: %s", http.StatusText(res.StatusCode), body)
+		// The fulfillment cannot be invoiced, for example because it contains an unknown SKU, so retrying will not help.
+		if res.StatusCode == http.StatusUnprocessableEntity {
+			return nil, temporal.NewNonRetryableApplicationError("charge rejected", "ChargeRejected", err)
+		}
+		return nil, err
 	}
 
 	var result ChargeResult
@@ -193,3 +257,306 @@ func (a *Activities) Charge(ctx context.Context, input *ChargeInput) (*ChargeRes
 
 	return &result, nil
 }
+
+// RefundInput is the input to the Refund activity.
+type RefundInput = billing.RefundInput
+
+// RefundResult is the result of the Refund activity.
+type RefundResult = billing.RefundResult
+
+// Refund refunds all or part of a charge via the Billing API
+func (a *Activities) Refund(ctx context.Context, input *RefundInput) (*RefundResult, error) {
+	jsonInput, err := json.Marshal(input)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode input: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.BillingURL+"/refund", bytes.NewReader(jsonInput))
+	if err != nil {
+		return nil, fmt.Errorf("unable to build request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		body, _ := io.ReadAll(res.Body)
+		err := fmt.Errorf("%s: %s", http.StatusText(res.StatusCode), body)
+		// Billing has rejected the refund, retrying will not help.
+		if res.StatusCode == http.StatusConflict || res.StatusCode == http.StatusBadRequest {
+			return nil, temporal.NewNonRetryableApplicationError("refund rejected", "RefundRejected", err)
+		}
+		return nil, err
+	}
+
+	var result RefundResult
+
+	err = json.NewDecoder(res.Body).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// CaptureInput is the input to the Capture activity.
+type CaptureInput = billing.CaptureInput
+
+// Capture captures an authorized charge via the Billing API
+func (a *Activities) Capture(ctx context.Context, input *CaptureInput) (*ChargeResult, error) {
+	return a.settleCharge(ctx, "/capture", input)
+}
+
+// VoidInput is the input to the Void activity.
+type VoidInput = billing.VoidInput
+
+// Void voids an authorized charge via the Billing API
+func (a *Activities) Void(ctx context.Context, input *VoidInput) (*ChargeResult, error) {
+	return a.settleCharge(ctx, "/void", input)
+}
+
+func (a *Activities) settleCharge(ctx context.Context, path string, input any) (*ChargeResult, error) {
+	jsonInput, err := json.Marshal(input)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode input: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.BillingURL+path, bytes.NewReader(jsonInput))
+	if err != nil {
+		return nil, fmt.Errorf("unable to build request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		body, _ := io.ReadAll(res.Body)
+		err := fmt.Errorf("%s: %s", http.StatusText(res.StatusCode), body)
+		// The charge can no longer be settled this way, for example because the authorization has expired.
+		if res.StatusCode == http.StatusConflict || res.StatusCode == http.StatusNotFound {
+			return nil, temporal.NewNonRetryableApplicationError("payment rejected", "PaymentRejected", err)
+		}
+		return nil, err
+	}
+
+	var result ChargeResult
+
+	err = json.NewDecoder(res.Body).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// LoyaltyPointsInput is the input to the RedeemLoyaltyPoints and ReturnLoyaltyPoints activities.
+type LoyaltyPointsInput struct {
+	CustomerID string
+	OrderID    string
+	Points     int64
+}
+
+// LoyaltyRedemptionResult is the result from the RedeemLoyaltyPoints activity.
+type LoyaltyRedemptionResult struct {
+	// PointValue is what each redeemed point pays for, in the base currency.
+	PointValue currency.Money
+}
+
+// RedeemLoyaltyPoints spends a customer's loyalty points on an order via the Billing API.
+// Redeeming points for the same order again has no further effect, so this is safe to retry.
+func (a *Activities) RedeemLoyaltyPoints(ctx context.Context, input *LoyaltyPointsInput) (*LoyaltyRedemptionResult, error) {
+	base := a.ExchangeRates.Base()
+
+	// The points must be worth an amount we can charge.
+	if _, err := a.LoyaltyRules.Value(input.Points, base); err != nil {
+		return nil, temporal.NewNonRetryableApplicationError("loyalty points rejected", "LoyaltyPointsRejected", err)
+	}
+
+	err := a.recordLoyaltyTransaction(ctx, loyalty.Transaction{
+		ID:         "redemption:" + input.OrderID,
+		CustomerID: input.CustomerID,
+		Kind:       loyalty.KindRedemption,
+		Points:     -input.Points,
+		Reference:  input.OrderID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := a.LoyaltyRules.Value(1, base)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoyaltyRedemptionResult{PointValue: value}, nil
+}
+
+// ReturnLoyaltyPoints gives back loyalty points redeemed for an order which it did not spend, via the Billing API.
+// Points are returned at most once per order, so this is safe to retry.
+func (a *Activities) ReturnLoyaltyPoints(ctx context.Context, input *LoyaltyPointsInput) error {
+	return a.recordLoyaltyTransaction(ctx, loyalty.Transaction{
+		ID:         "return:" + input.OrderID,
+		CustomerID: input.CustomerID,
+		Kind:       loyalty.KindReturn,
+		Points:     input.Points,
+		Reference:  input.OrderID,
+	})
+}
+
+// LoyaltyAccrualInput is the input to the AccrueLoyaltyPoints activity.
+type LoyaltyAccrualInput struct {
+	CustomerID    string
+	FulfillmentID string
+}
+
+// AccrueLoyaltyPoints awards a customer loyalty points for a completed fulfillment via the Billing API.
+// Points are awarded on what the customer paid for each product, going by the fulfillment's invoice.
+// Points are awarded at most once per fulfillment, so this is safe to retry.
+func (a *Activities) AccrueLoyaltyPoints(ctx context.Context, input *LoyaltyAccrualInput) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.BillingURL+"/invoices/"+url.PathEscape(input.FulfillmentID), nil)
+	if err != nil {
+		return 0, fmt.Errorf("unable to build request: %w", err)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		body, _ := io.ReadAll(res.Body)
+		return 0, fmt.Errorf("%s: %s", http.StatusText(res.StatusCode), body)
+	}
+
+	var invoice billing.Invoice
+
+	err = json.NewDecoder(res.Body).Decode(&invoice)
+	if err != nil {
+		return 0, err
+	}
+
+	// Discount lines follow the product line they discount.
+	var lines []loyalty.Line
+	for _, item := range invoice.Items {
+		// Invoices without an exchange rate are in the base currency.
+		amount := item.SubTotal
+		if rate := invoice.ExchangeRate; rate != nil {
+			if amount, err = rate.ToBase(currency.NewMoney(amount.Amount, rate.Currency)); err != nil {
+				return 0, temporal.NewNonRetryableApplicationError("invalid invoice", "InvalidInvoice", err)
+			}
+		}
+
+		if item.Promotion == "" || len(lines) == 0 {
+			lines = append(lines, loyalty.Line{SKU: item.SKU, Quantity: item.Quantity, Amount: amount})
+			continue
+		}
+
+		line := &lines[len(lines)-1]
+		if line.Amount, err = line.Amount.Add(amount); err != nil {
+			return 0, temporal.NewNonRetryableApplicationError("invalid invoice", "InvalidInvoice", err)
+		}
+	}
+
+	points, err := a.LoyaltyRules.Points(lines)
+	if err != nil {
+		return 0, temporal.NewNonRetryableApplicationError("invalid invoice", "InvalidInvoice", err)
+	}
+	if points == 0 {
+		return 0, nil
+	}
+
+	err = a.recordLoyaltyTransaction(ctx, loyalty.Transaction{
+		ID:         "accrual:" + input.FulfillmentID,
+		CustomerID: input.CustomerID,
+		Kind:       loyalty.KindAccrual,
+		Points:     points,
+		Reference:  input.FulfillmentID,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return points, nil
+}
+
+func (a *Activities) recordLoyaltyTransaction(ctx context.Context, transaction loyalty.Transaction) error {
+	jsonInput, err := json.Marshal(transaction)
+	if err != nil {
+		return fmt.Errorf("unable to encode transaction: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.BillingURL+"/customers/"+url.PathEscape(transaction.CustomerID)+"/loyalty/transactions", bytes.NewReader(jsonInput))
+	if err != nil {
+		return fmt.Errorf("unable to build request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		body, _ := io.ReadAll(res.Body)
+		err := fmt.Errorf("%s: %s", http.StatusText(res.StatusCode), body)
+		// The customer does not have the points, or the transaction is invalid, so retrying will not help.
+		if res.StatusCode == http.StatusConflict || res.StatusCode == http.StatusBadRequest {
+			return temporal.NewNonRetryableApplicationError("loyalty points rejected", "LoyaltyPointsRejected", err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+const (
+	// PaymentNotificationDeclined tells a customer that their payment was declined and will be retried.
+	PaymentNotificationDeclined = "paymentDeclined"
+
+	// PaymentNotificationFailed tells a customer that their payment will no longer be retried, so the fulfillment has failed.
+	PaymentNotificationFailed = "paymentFailed"
+)
+
+// PaymentNotification is the input to the NotifyCustomer activity.
+type PaymentNotification struct {
+	Kind          string
+	CustomerID    string
+	OrderID       string
+	FulfillmentID string
+	Total         currency.Money
+	// Currency is the currency of Total, or empty for the base currency.
+	Currency    string
+	NextRetryAt *time.Time
+	RetryUntil  *time.Time
+}
+
+// NotifyCustomer tells a customer about a problem with the payment for a fulfillment.
+// This reference implementation logs the notification rather than sending it.
+func (a *Activities) NotifyCustomer(ctx context.Context, notification *PaymentNotification) error {
+	activity.GetLogger(ctx).Info(
+		"Notify customer",
+		"Kind", notification.Kind,
+		"Customer", notification.CustomerID,
+		"Order", notification.OrderID,
+		"Fulfillment", notification.FulfillmentID,
+		"Amount", notification.Total,
+		"Currency", notification.Currency,
+		"NextRetryAt", notification.NextRetryAt,
+		"RetryUntil", notification.RetryUntil,
+	)
+
+	return nil
+}
@@ -1,13 +1,35 @@
 package order_test
 
 import (
+	"context"
+	"log/slog"
+	"net/http/httptest"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"github.com/temporalio/reference-app-orders-go/app/db"
+	"github.com/temporalio/reference-app-orders-go/app/inventory"
 	"github.com/temporalio/reference-app-orders-go/app/order"
 	"go.temporal.io/sdk/testsuite"
 )
 
+func newInventoryAPI(t *testing.T, levels ...db.InventoryLevel) *httptest.Server {
+	store := db.NewSQLiteDB(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, store.Connect(context.Background()))
+	require.NoError(t, store.Setup())
+	t.Cleanup(func() { store.Close() })
+
+	for _, l := range levels {
+		require.NoError(t, store.SetInventoryLevel(context.Background(), &l))
+	}
+
+	api := httptest.NewServer(inventory.Router(store, slog.Default()))
+	t.Cleanup(api.Close)
+
+	return api
+}
+
 func TestFulfillOrderZeroItems(t *testing.T) {
 	testSuite := testsuite.WorkflowTestSuite{}
 
@@ -34,7 +56,11 @@ func TestFulfillOrderZeroItems(t *testing.T) {
 func TestFulfillOrderOneItem(t *testing.T) {
 	testSuite := testsuite.WorkflowTestSuite{}
 
-	var a *order.Activities
+	api := newInventoryAPI(t,
+		db.InventoryLevel{Warehouse: "Warehouse A", SKU: "Hiking Boots", Quantity: 1},
+		db.InventoryLevel{Warehouse: "Warehouse B", SKU: "Hiking Boots", Quantity: 5},
+	)
+	a := &order.Activities{InventoryURL: api.URL}
 
 	env := testSuite.NewTestActivityEnvironment()
 	env.RegisterActivity(a.ReserveItems)
@@ -56,7 +82,7 @@ func TestFulfillOrderOneItem(t *testing.T) {
 		Reservations: []*order.Reservation{
 			{
 				Available: true,
-				Location:  "Warehouse A",
+				Location:  "Warehouse B",
 				Items: []*order.Item{
 					{SKU: "Hiking Boots", Quantity: 2},
 				},
@@ -70,7 +96,11 @@ func TestFulfillOrderOneItem(t *testing.T) {
 func TestFulfillOrderTwoItems(t *testing.T) {
 	testSuite := testsuite.WorkflowTestSuite{}
 
-	var a *order.Activities
+	api := newInventoryAPI(t,
+		db.InventoryLevel{Warehouse: "Warehouse A", SKU: "Hiking Boots", Quantity: 2},
+		db.InventoryLevel{Warehouse: "Warehouse B", SKU: "Tennis Shoes", Quantity: 1},
+	)
+	a := &order.Activities{InventoryURL: api.URL}
 
 	env := testSuite.NewTestActivityEnvironment()
 	env.RegisterActivity(a.ReserveItems)
@@ -110,3 +140,50 @@ func TestFulfillOrderTwoItems(t *testing.T) {
 
 	require.Equal(t, expected, result)
 }
+
+func TestFulfillOrderUnavailableItem(t *testing.T) {
+	testSuite := testsuite.WorkflowTestSuite{}
+
+	api := newInventoryAPI(t,
+		db.InventoryLevel{Warehouse: "Warehouse A", SKU: "Hiking Boots", Quantity: 2},
+		db.InventoryLevel{Warehouse: "Warehouse A", SKU: "Tennis Shoes", Quantity: 1},
+	)
+	a := &order.Activities{InventoryURL: api.URL}
+
+	env := testSuite.NewTestActivityEnvironment()
+	env.RegisterActivity(a.ReserveItems)
+
+	input := order.ReserveItemsInput{
+		OrderID: "test",
+		Items: []*order.Item{
+			{SKU: "Hiking Boots", Quantity: 2},
+			{SKU: "Tennis Shoes", Quantity: 2},
+		},
+	}
+
+	future, err := env.ExecuteActivity(a.ReserveItems, &input)
+	require.NoError(t, err)
+
+	var result order.ReserveItemsResult
+	require.NoError(t, future.Get(&result))
+
+	expected := order.ReserveItemsResult{
+		Reservations: []*order.Reservation{
+			{
+				Available: false,
+				Items: []*order.Item{
+					{SKU: "Tennis Shoes", Quantity: 2},
+				},
+			},
+			{
+				Available: true,
+				Location:  "Warehouse A",
+				Items: []*order.Item{
+					{SKU: "Hiking Boots", Quantity: 2},
+				},
+			},
+		},
+	}
+
+	require.Equal(t, expected, result)
+}
@@ -3,16 +3,20 @@ package order
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/temporalio/reference-app-orders-go/app/currency"
 	"github.com/temporalio/reference-app-orders-go/app/db"
 	"go.temporal.io/api/enums/v1"
 	"go.temporal.io/api/serviceerror"
 	"go.temporal.io/sdk/client"
 	"go.temporal.io/sdk/log"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
 )
 
 // TaskQueue is the default task queue for the Order system.
@@ -43,13 +47,52 @@ type OrderInput struct {
 	ID         string  `json:"id"`
 	CustomerID string  `json:"customerId"`
 	Items      []*Item `json:"items"`
+	// Email is the customer's email address. The fraud check matches its domain against the allowlist and blocklist.
+	Email string `json:"email,omitempty"`
+	// ShippingAddress is where the order is shipped to, which the fraud check's scoring model compares with the
+	// customer's earlier orders.
+	ShippingAddress string `json:"shippingAddress,omitempty"`
+	// Region is the destination region for the order, which determines the tax and shipping due.
+	Region string `json:"region,omitempty"`
+	// ShippingService is the shipping service level, such as "standard" or "express".
+	ShippingService string `json:"shippingService,omitempty"`
+	// PaymentMethodID is the payment method to charge, or empty for the customer's default method.
+	PaymentMethodID string `json:"paymentMethodId,omitempty"`
+	// Currency is the ISO 4217 code of the currency to charge in, such as "EUR", or empty for the base currency.
+	// Catalog prices are converted at the exchange rate when the order is placed.
+	Currency string `json:"currency,omitempty"`
+	// Dunning enables retrying declined payments. If it is nil, a fulfillment fails as soon as its payment is declined.
+	Dunning *DunningPolicy `json:"dunning,omitempty"`
+	// CouponCodes claim promotions, which are applied to each fulfillment as it is invoiced.
+	CouponCodes []string `json:"couponCodes,omitempty"`
+	// LoyaltyPoints are loyalty points to pay with. They pay for products on each fulfillment in turn, and any not
+	// needed are returned to the customer once the order is finished.
+	LoyaltyPoints int64 `json:"loyaltyPoints,omitempty"`
 }
 
+// DunningPolicy controls how a declined payment is retried before its fulfillment is failed.
+type DunningPolicy struct {
+	// RetryIntervals are the waits before each retry, such as "1h" or "24h". The last interval is repeated.
+	// Defaults to DefaultDunningRetryIntervals.
+	RetryIntervals []string `json:"retryIntervals,omitempty"`
+	// MaxDays is how many days after the first decline the payment is retried for.
+	// Defaults to DefaultDunningMaxDays.
+	MaxDays int32 `json:"maxDays,omitempty"`
+}
+
+// DefaultDunningRetryIntervals are the waits before each retry of a declined payment, unless the order says otherwise.
+var DefaultDunningRetryIntervals = []string{"1h", "24h", "72h"}
+
+// DefaultDunningMaxDays is how many days a declined payment is retried for, unless the order says otherwise.
+const DefaultDunningMaxDays = 7
+
 // OrderStatus holds the status of an Order workflow.
 type OrderStatus struct {
 	ID         string    `json:"id"`
 	CustomerID string    `json:"customerId"`
 	ReceivedAt time.Time `json:"receivedAt"`
+	// Currency is the currency the order is charged in, or empty for the base currency.
+	Currency string `json:"currency,omitempty"`
 
 	Status string `json:"status"`
 
@@ -91,6 +134,15 @@ const (
 
 	// OrderStatusTimedOut is the status of a timed out Order.
 	OrderStatusTimedOut = "timedOut"
+
+	// OrderStatusDisputed is the status of an Order with a payment disputed by the customer's card issuer.
+	OrderStatusDisputed = "disputed"
+
+	// OrderStatusDisputeWon is the status of an Order whose payment dispute was decided in the business's favour.
+	OrderStatusDisputeWon = "disputeWon"
+
+	// OrderStatusDisputeLost is the status of an Order whose disputed payment was charged back.
+	OrderStatusDisputeLost = "disputeLost"
 )
 
 // ListOrderEntry is an entry in the Order list.
@@ -110,23 +162,75 @@ type ShipmentStatus struct {
 
 // PaymentStatus holds the status of a Payment.
 type PaymentStatus struct {
-	SubTotal int32 `json:"subTotal"`
-	Tax      int32 `json:"tax"`
-	Shipping int32 `json:"shipping"`
-	Total    int32 `json:"total"`
+	SubTotal currency.Money `json:"subTotal"`
+	Tax      currency.Money `json:"tax"`
+	Shipping currency.Money `json:"shipping"`
+	Total    currency.Money `json:"total"`
+
+	// Refunded is the amount which has been refunded.
+	Refunded currency.Money `json:"refunded,omitzero"`
+
+	// Currency is the currency of the amounts.
+	Currency string `json:"currency,omitempty"`
+
+	// LoyaltyPoints are the loyalty points spent on the payment.
+	LoyaltyPoints int64 `json:"loyaltyPoints,omitempty"`
 
 	Status string `json:"status"`
+	// DeclineReason is why the most recent attempt at payment was declined.
+	DeclineReason string `json:"declineReason,omitempty"`
+	// FraudReviewID is the billing fraud review which held the most recent attempt at payment, if any.
+	FraudReviewID string `json:"fraudReviewId,omitempty"`
+	// FraudDecisionID is the fraud check's decision on the most recent attempt at payment, if any.
+	FraudDecisionID string `json:"fraudDecisionId,omitempty"`
+	// MaintenanceReason is why the fraud check was down for maintenance, if the most recent attempt at payment was
+	// queued or declined because of it.
+	MaintenanceReason string `json:"maintenanceReason,omitempty"`
+
+	// Retries is how many times a declined payment has been retried.
+	Retries int32 `json:"retries,omitempty"`
+	// NextRetryAt is when a declined payment will next be retried.
+	NextRetryAt *time.Time `json:"nextRetryAt,omitempty"`
+	// RetryUntil is when a declined payment will stop being retried, failing the fulfillment.
+	RetryUntil *time.Time `json:"retryUntil,omitempty"`
+}
+
+// UnmarshalJSON decodes the payment, giving its amounts the payment's currency.
+func (p *PaymentStatus) UnmarshalJSON(data []byte) error {
+	type payment PaymentStatus
+	if err := json.Unmarshal(data, (*payment)(p)); err != nil {
+		return err
+	}
+
+	currency.Apply(p.Currency, &p.SubTotal, &p.Tax, &p.Shipping, &p.Total, &p.Refunded)
+	return nil
 }
 
 const (
 	// PaymentStatusPending is the status of a pending payment.
 	PaymentStatusPending = "pending"
 
-	// PaymentStatusSuccess is the status of a successful payment.
-	PaymentStatusSuccess = "success"
+	// PaymentStatusReview is the status of a payment the fraud check has held for a manager to approve or reject.
+	PaymentStatusReview = "review"
+
+	// PaymentStatusQueued is the status of a payment made while the fraud check was down for maintenance, which is
+	// authorized once the maintenance window ends.
+	PaymentStatusQueued = "queued"
+
+	// PaymentStatusAuthorized is the status of a payment whose funds are held until the shipment is dispatched.
+	PaymentStatusAuthorized = "authorized"
+
+	// PaymentStatusCaptured is the status of a payment whose funds have been taken.
+	PaymentStatusCaptured = "captured"
+
+	// PaymentStatusVoided is the status of an authorized payment which was released without being captured.
+	PaymentStatusVoided = "voided"
 
 	// PaymentStatusFailed is the status of a failed payment.
 	PaymentStatusFailed = "failed"
+
+	// PaymentStatusRefunded is the status of a payment which has been refunded in full.
+	PaymentStatusRefunded = "refunded"
 )
 
 // Fulfillment holds a set of items that will be delivered in one shipment (due to location and stock level).
@@ -137,6 +241,15 @@ type Fulfillment struct {
 	// CustomerID is the ID of the customer that this fulfillment is for.
 	customerID string
 
+	// region is the destination region for the fulfillment.
+	region string
+
+	// shippingService is the shipping service level for the fulfillment.
+	shippingService string
+
+	// exchangeRate is the exchange rate taken when the order was placed, or nil to charge in the base currency.
+	exchangeRate *currency.Rate
+
 	// ID is an identifier for the fulfillment
 	ID string `json:"id"`
 
@@ -146,7 +259,7 @@ type Fulfillment struct {
 	// Location is the address for carrier pickup.
 	Location string `json:"location,omitempty"`
 
-	// Status is the status of the fulfillment, one of "unavailable", "pending", "processing", "dispatched", "delivered", "failed".
+	// Status is the status of the fulfillment, one of "unavailable", "pending", "processing", "paymentRetry", "completed", "cancelled", "failed".
 	Status string `json:"status"`
 
 	// PaymentStatus is the status of the payment for this fulfillment.
@@ -155,6 +268,35 @@ type Fulfillment struct {
 	// ShipmentStatus is the status of the shipment for this fulfillment.
 	Shipment *ShipmentStatus `json:"shipment,omitempty"`
 
+	// chargeKey is the idempotency key of the charge for this fulfillment, used to refund it.
+	chargeKey string
+
+	// dunning is the schedule for retrying a declined payment, or nil if declined payments are not retried.
+	dunning *dunningSchedule
+
+	// paymentMethodID is the payment method to charge, or empty for the customer's default method.
+	paymentMethodID string
+
+	// couponCodes claim the promotions to apply when the fulfillment is invoiced.
+	couponCodes []string
+
+	// email is the customer's email address, and shippingAddress where the order is shipped to, which are passed to
+	// the fraud check.
+	email           string
+	shippingAddress string
+
+	// loyalty is the order's unspent loyalty points, shared by its fulfillments, or nil if none were redeemed.
+	loyalty *loyaltyCredit
+
+	// retryRequested is set when the customer has supplied a new payment method, to retry the payment straight away.
+	retryRequested bool
+
+	// cancelRequested is set when the customer has asked for the order to be cancelled.
+	cancelRequested bool
+
+	// cancelShipment cancels the shipment for this fulfillment, while it is being processed.
+	cancelShipment workflow.CancelFunc
+
 	logger log.Logger
 }
 
@@ -168,6 +310,16 @@ const (
 	// FulfillmentStatusProcessing is the status of a processing Fulfillment.
 	FulfillmentStatusProcessing = "processing"
 
+	// FulfillmentStatusPaymentRetry is the status of a Fulfillment whose payment was declined and is waiting to be retried.
+	FulfillmentStatusPaymentRetry = "paymentRetry"
+
+	// FulfillmentStatusFraudReview is the status of a Fulfillment whose payment is awaiting fraud review.
+	FulfillmentStatusFraudReview = "fraudReview"
+
+	// FulfillmentStatusPaymentQueued is the status of a Fulfillment whose payment is queued until the fraud check's
+	// maintenance window ends.
+	FulfillmentStatusPaymentQueued = "paymentQueued"
+
 	// FulfillmentStatusCompleted is the status of a processing Fulfillment.
 	FulfillmentStatusCompleted = "completed"
 
@@ -197,6 +349,47 @@ const (
 	CustomerActionTimedOut = "timedOut"
 )
 
+// CancelOrderUpdateName is the name of the update used to cancel an Order.
+const CancelOrderUpdateName = "CancelOrder"
+
+// CancelOrderResult is the result of cancelling an Order.
+type CancelOrderResult struct {
+	Fulfillments []*FulfillmentCancellation `json:"fulfillments"`
+}
+
+// FulfillmentCancellation reports what happened to a Fulfillment when its Order was cancelled.
+type FulfillmentCancellation struct {
+	ID      string         `json:"id"`
+	Outcome string         `json:"outcome"`
+	Status  string         `json:"status"`
+	Payment *PaymentStatus `json:"payment,omitempty"`
+}
+
+const (
+	// CancellationOutcomeCancelled is the outcome for a Fulfillment which was cancelled.
+	CancellationOutcomeCancelled = "cancelled"
+
+	// CancellationOutcomeDispatched is the outcome for a Fulfillment whose shipment was already dispatched, so is left to complete.
+	CancellationOutcomeDispatched = "dispatched"
+
+	// CancellationOutcomeClosed is the outcome for a Fulfillment which had already completed, failed or been cancelled.
+	CancellationOutcomeClosed = "closed"
+)
+
+// UpdatePaymentMethodUpdateName is the name of the update used to supply a new payment method for an Order.
+const UpdatePaymentMethodUpdateName = "UpdatePaymentMethod"
+
+// PaymentMethodUpdate supplies a new payment method for an Order.
+type PaymentMethodUpdate struct {
+	PaymentMethodID string `json:"paymentMethodId"`
+}
+
+// PaymentMethodUpdateResult is the result of supplying a new payment method for an Order.
+type PaymentMethodUpdateResult struct {
+	// Fulfillments lists the fulfillments whose declined payments are being retried with the new payment method.
+	Fulfillments []string `json:"fulfillments"`
+}
+
 // OrderResult is the result of an Order workflow.
 type OrderResult struct {
 	Status string `json:"status"`
@@ -230,6 +423,8 @@ func Router(client client.Client, db db.DB, logger *slog.Logger) http.Handler {
 	r.HandleFunc("POST /orders/{id}/insert", h.handleInsertOrder)
 	r.HandleFunc("POST /orders/{id}/status", h.handleUpdateOrderStatus)
 	r.HandleFunc("POST /orders/{id}/action", h.handleCustomerAction)
+	r.HandleFunc("POST /orders/{id}/cancel", h.handleCancelOrder)
+	r.HandleFunc("POST /orders/{id}/payment-method", h.handleUpdatePaymentMethod)
 
 	return r
 }
@@ -313,6 +508,19 @@ func (h *handlers) handleGetOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Disputes are raised by Billing after the order has finished, so are only recorded in the database.
+	var stored db.OrderStatus
+	err = h.db.GetOrder(r.Context(), r.PathValue("id"), &stored)
+	if err != nil && !errors.Is(err, db.ErrOrderNotFound) {
+		h.logger.Error("Failed to get order", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	switch stored.Status {
+	case OrderStatusDisputed, OrderStatusDisputeWon, OrderStatusDisputeLost:
+		status.Status = stored.Status
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 
 	if err := json.NewEncoder(w).Encode(status); err != nil {
@@ -395,6 +603,105 @@ func (h *handlers) handleCustomerAction(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// handleCancelOrder cancels an order. It responds with a conflict if the order can no longer be cancelled.
+func (h *handlers) handleCancelOrder(w http.ResponseWriter, r *http.Request) {
+	id := OrderWorkflowID(r.PathValue("id"))
+
+	handle, err := h.temporal.UpdateWorkflow(r.Context(), client.UpdateWorkflowOptions{
+		WorkflowID:   id,
+		UpdateName:   CancelOrderUpdateName,
+		WaitForStage: client.WorkflowUpdateStageCompleted,
+	})
+	if err != nil {
+		var notFound *serviceerror.NotFound
+		if !errors.As(err, &notFound) {
+			h.logger.Error("Failed to cancel order", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// An update cannot be delivered to an order which has finished, which is also reported as not found.
+		if _, err := h.temporal.DescribeWorkflowExecution(r.Context(), id, ""); err == nil {
+			http.Error(w, "order has finished and can no longer be cancelled", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+
+	var result CancelOrderResult
+	if err := handle.Get(r.Context(), &result); err != nil {
+		var appErr *temporal.ApplicationError
+		if errors.As(err, &appErr) && appErr.Type() == cancelRejectedErrorType {
+			http.Error(w, appErr.Message(), http.StatusConflict)
+			return
+		}
+		h.logger.Error("Failed to get cancel result", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		h.logger.Error("Failed to encode cancel result", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h *handlers) handleUpdatePaymentMethod(w http.ResponseWriter, r *http.Request) {
+	var update PaymentMethodUpdate
+
+	err := json.NewDecoder(r.Body).Decode(&update)
+	if err != nil {
+		h.logger.Error("Failed to decode payment method", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id := OrderWorkflowID(r.PathValue("id"))
+
+	handle, err := h.temporal.UpdateWorkflow(r.Context(), client.UpdateWorkflowOptions{
+		WorkflowID:   id,
+		UpdateName:   UpdatePaymentMethodUpdateName,
+		Args:         []interface{}{update},
+		WaitForStage: client.WorkflowUpdateStageCompleted,
+	})
+	if err != nil {
+		var notFound *serviceerror.NotFound
+		if !errors.As(err, &notFound) {
+			h.logger.Error("Failed to update payment method", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// An update cannot be delivered to an order which has finished, which is also reported as not found.
+		if _, err := h.temporal.DescribeWorkflowExecution(r.Context(), id, ""); err == nil {
+			http.Error(w, "no payment is waiting to be retried", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+
+	var result PaymentMethodUpdateResult
+	if err := handle.Get(r.Context(), &result); err != nil {
+		var appErr *temporal.ApplicationError
+		if errors.As(err, &appErr) && appErr.Type() == paymentMethodRejectedErrorType {
+			http.Error(w, appErr.Message(), http.StatusConflict)
+			return
+		}
+		h.logger.Error("Failed to get payment method update result", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		h.logger.Error("Failed to encode payment method result", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
 func (h *handlers) handleGetStats(w http.ResponseWriter, _ *http.Request) {
 	resp, err := h.temporal.DescribeTaskQueueEnhanced(context.Background(), client.DescribeTaskQueueEnhancedOptions{
 		TaskQueue:     TaskQueue,
@@ -4,6 +4,8 @@ import (
 	"context"
 
 	"github.com/temporalio/reference-app-orders-go/app/config"
+	"github.com/temporalio/reference-app-orders-go/app/currency"
+	"github.com/temporalio/reference-app-orders-go/app/loyalty"
 	"github.com/temporalio/reference-app-orders-go/app/temporalutil"
 	"go.temporal.io/sdk/client"
 	"go.temporal.io/sdk/worker"
@@ -11,13 +13,26 @@ import (
 
 // RunWorker runs a Workflow and Activity worker for the Order system.
 func RunWorker(ctx context.Context, config config.AppConfig, client client.Client) error {
+	rates, err := currency.LoadRates(config.ExchangeRatesFile)
+	if err != nil {
+		return err
+	}
+	exchangeRates, err := currency.NewTable(rates)
+	if err != nil {
+		return err
+	}
+	loyaltyRules, err := loyalty.LoadRules(config.LoyaltyRulesFile)
+	if err != nil {
+		return err
+	}
+
 	w := worker.New(client, TaskQueue, worker.Options{
 		MaxConcurrentWorkflowTaskPollers: 8,
 		MaxConcurrentActivityTaskPollers: 8,
 	})
 
 	w.RegisterWorkflow(Order)
-	w.RegisterActivity(&Activities{BillingURL: config.BillingURL, OrderURL: config.OrderURL})
+	w.RegisterActivity(&Activities{BillingURL: config.BillingURL, OrderURL: config.OrderURL, InventoryURL: config.InventoryURL, ExchangeRates: exchangeRates, LoyaltyRules: loyaltyRules})
 
 	return w.Run(temporalutil.WorkerInterruptFromContext(ctx))
 }
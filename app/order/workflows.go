@@ -2,24 +2,50 @@ package order
 
 import (
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/temporalio/reference-app-orders-go/app/billing"
+	"github.com/temporalio/reference-app-orders-go/app/currency"
 	"github.com/temporalio/reference-app-orders-go/app/shipment"
 	"go.temporal.io/sdk/log"
+	"go.temporal.io/sdk/temporal"
 	"go.temporal.io/sdk/workflow"
 )
 
 type orderImpl struct {
-	id           string
-	customerID   string
-	receivedAt   time.Time
-	status       string
-	fulfillments []*Fulfillment
-	logger       log.Logger
+	id              string
+	customerID      string
+	email           string
+	shippingAddress string
+	region          string
+	service         string
+	paymentMethodID string
+	currency        string
+	couponCodes     []string
+	loyalty         *loyaltyCredit
+	exchangeRate    *currency.Rate
+	dunning         *dunningSchedule
+	receivedAt      time.Time
+	status          string
+	fulfillments    []*Fulfillment
+	logger          log.Logger
+
+	cancelRequested bool
+	cancelled       workflow.Future
+	setCancelled    workflow.Settable
+
+	// finished is set once the order has been processed, or has failed, so that updates stop waiting for it.
+	finished bool
 }
 
+// cancelRejectedErrorType is the error type used when an order can no longer be cancelled.
+const cancelRejectedErrorType = "CancelRejected"
+
+// paymentMethodRejectedErrorType is the error type used when an order's payment method cannot be updated.
+const paymentMethodRejectedErrorType = "PaymentMethodRejected"
+
 // Aggressively low for demo purposes.
 const customerActionTimeout = 30 * time.Second
 
@@ -31,7 +57,15 @@ func Order(ctx workflow.Context, input *OrderInput) (*OrderResult, error) {
 		return nil, err
 	}
 
-	return wf.run(ctx, input)
+	result, err := wf.run(ctx, input)
+	wf.finished = true
+
+	// Let a cancellation which is still in progress report its outcome before the workflow completes.
+	if err := workflow.Await(ctx, func() bool { return workflow.AllHandlersFinished(ctx) }); err != nil {
+		return nil, err
+	}
+
+	return result, err
 }
 
 func (wf *orderImpl) setup(ctx workflow.Context, input *OrderInput) error {
@@ -47,35 +81,106 @@ func (wf *orderImpl) setup(ctx workflow.Context, input *OrderInput) error {
 		return fmt.Errorf("order must contain items")
 	}
 
+	if input.Currency != "" && !currency.ValidCode(input.Currency) {
+		return fmt.Errorf("currency must be a three letter ISO 4217 code")
+	}
+
+	for i, code := range input.CouponCodes {
+		if code == "" {
+			return fmt.Errorf("coupon codes must not be empty")
+		}
+		if slices.Contains(input.CouponCodes[:i], code) {
+			return fmt.Errorf("coupon code %s is given more than once", code)
+		}
+	}
+
+	if input.LoyaltyPoints < 0 {
+		return fmt.Errorf("loyaltyPoints must not be negative")
+	}
+
+	if input.Dunning != nil {
+		dunning, err := newDunningSchedule(input.Dunning)
+		if err != nil {
+			return err
+		}
+		wf.dunning = dunning
+	}
+
 	wf.id = input.ID
 	wf.customerID = input.CustomerID
+	wf.email = input.Email
+	wf.shippingAddress = input.ShippingAddress
+	wf.region = input.Region
+	wf.service = input.ShippingService
+	wf.paymentMethodID = input.PaymentMethodID
+	wf.currency = input.Currency
+	wf.couponCodes = input.CouponCodes
 	wf.status = OrderStatusPending
 	wf.receivedAt = workflow.Now(ctx)
 
+	wf.cancelled, wf.setCancelled = workflow.NewFuture(ctx)
+
 	wf.logger = log.With(
 		workflow.GetLogger(ctx),
 		"orderID", wf.id,
 		"customerId", wf.customerID,
 	)
 
+	err := workflow.SetUpdateHandlerWithOptions(ctx, CancelOrderUpdateName, wf.handleCancel,
+		workflow.UpdateHandlerOptions{Validator: wf.validateCancel},
+	)
+	if err != nil {
+		return err
+	}
+
+	err = workflow.SetUpdateHandlerWithOptions(ctx, UpdatePaymentMethodUpdateName, wf.handleUpdatePaymentMethod,
+		workflow.UpdateHandlerOptions{Validator: wf.validateUpdatePaymentMethod},
+	)
+	if err != nil {
+		return err
+	}
+
 	return workflow.SetQueryHandler(ctx, StatusQuery, func() (*OrderStatus, error) {
 		return &OrderStatus{
 			ID:           wf.id,
 			Status:       wf.status,
 			CustomerID:   wf.customerID,
 			ReceivedAt:   wf.receivedAt,
+			Currency:     wf.currency,
 			Fulfillments: wf.fulfillments,
 		}, nil
 	})
 }
 
 func (wf *orderImpl) run(ctx workflow.Context, order *OrderInput) (*OrderResult, error) {
+	if err := wf.takeExchangeRate(ctx); err != nil {
+		return nil, err
+	}
+
 	// Insert the initial order record into the database
 	if err := wf.insertOrder(ctx); err != nil {
 		return nil, err
 	}
 
-	err := wf.buildFulfillments(ctx, order.Items)
+	if order.LoyaltyPoints > 0 {
+		if err := wf.redeemLoyaltyPoints(ctx, order.LoyaltyPoints); err != nil {
+			wf.logger.Error("Failed to redeem loyalty points", "error", err)
+			err := wf.updateStatus(ctx, OrderStatusFailed)
+			return &OrderResult{Status: wf.status}, err
+		}
+	}
+
+	result, err := wf.fulfill(ctx, order.Items)
+	if err != nil {
+		// The order will not spend the points now, so give them back.
+		wf.returnLoyaltyPoints(ctx)
+	}
+	return result, err
+}
+
+// fulfill reserves the order's items and processes its fulfillments.
+func (wf *orderImpl) fulfill(ctx workflow.Context, items []*Item) (*OrderResult, error) {
+	err := wf.buildFulfillments(ctx, items)
 	if err != nil {
 		return nil, err
 	}
@@ -93,11 +198,18 @@ func (wf *orderImpl) run(ctx workflow.Context, order *OrderInput) (*OrderResult,
 
 		switch action {
 		case CustomerActionCancel:
+			if err := wf.cancelAllFulfillments(ctx); err != nil {
+				return nil, err
+			}
+			wf.returnLoyaltyPoints(ctx)
 			err := wf.updateStatus(ctx, OrderStatusCancelled)
 			return &OrderResult{Status: wf.status}, err
 		case CustomerActionTimedOut:
+			if err := wf.cancelAllFulfillments(ctx); err != nil {
+				return nil, err
+			}
+			wf.returnLoyaltyPoints(ctx)
 			err := wf.updateStatus(ctx, OrderStatusTimedOut)
-			wf.cancelAllFulfillments()
 			return &OrderResult{Status: wf.status}, err
 		case CustomerActionAmend:
 			wf.cancelUnavailableFulfillments()
@@ -123,10 +235,15 @@ func (wf *orderImpl) run(ctx workflow.Context, order *OrderInput) (*OrderResult,
 
 	workflow.Await(ctx, func() bool { return completed == len(wf.fulfillments) })
 
+	wf.returnLoyaltyPoints(ctx)
+
 	status := OrderStatusCompleted
 	if wf.allFulfillmentsFailed() {
 		status = OrderStatusFailed
 	}
+	if wf.cancelRequested && wf.noFulfillmentsCompleted() {
+		status = OrderStatusCancelled
+	}
 	if err := wf.updateStatus(ctx, status); err != nil {
 		return nil, err
 	}
@@ -148,6 +265,79 @@ func (wf *orderImpl) insertOrder(ctx workflow.Context) error {
 	return workflow.ExecuteLocalActivity(ctx, a.InsertOrder, insert).Get(ctx, nil)
 }
 
+// takeExchangeRate fixes the exchange rate for the order, so every fulfillment is priced at the rate in effect when
+// the order was placed.
+func (wf *orderImpl) takeExchangeRate(ctx workflow.Context) error {
+	if wf.currency == "" {
+		return nil
+	}
+
+	ctx = workflow.WithLocalActivityOptions(ctx, workflow.LocalActivityOptions{
+		ScheduleToCloseTimeout: 5 * time.Second,
+	})
+	return workflow.ExecuteLocalActivity(ctx, a.GetExchangeRate, wf.currency).Get(ctx, &wf.exchangeRate)
+}
+
+// redeemLoyaltyPoints spends the customer's loyalty points on the order, for its fulfillments to pay with.
+func (wf *orderImpl) redeemLoyaltyPoints(ctx workflow.Context, points int64) error {
+	ctx = workflow.WithActivityOptions(ctx,
+		workflow.ActivityOptions{
+			StartToCloseTimeout: 30 * time.Second,
+		},
+	)
+
+	var result LoyaltyRedemptionResult
+
+	err := workflow.ExecuteActivity(ctx,
+		a.RedeemLoyaltyPoints,
+		&LoyaltyPointsInput{
+			CustomerID: wf.customerID,
+			OrderID:    wf.id,
+			Points:     points,
+		},
+	).Get(ctx, &result)
+	if err != nil {
+		return err
+	}
+
+	wf.loyalty = &loyaltyCredit{points: points, value: result.PointValue}
+
+	wf.logger.Info("Loyalty points redeemed", "points", points)
+
+	return nil
+}
+
+// returnLoyaltyPoints gives back any loyalty points the order's fulfillments did not spend.
+// Failing to return the points does not affect the order.
+func (wf *orderImpl) returnLoyaltyPoints(ctx workflow.Context) {
+	if wf.loyalty == nil || wf.loyalty.points == 0 {
+		return
+	}
+
+	ctx = workflow.WithActivityOptions(ctx,
+		workflow.ActivityOptions{
+			ScheduleToCloseTimeout: 5 * time.Minute,
+		},
+	)
+
+	err := workflow.ExecuteActivity(ctx,
+		a.ReturnLoyaltyPoints,
+		&LoyaltyPointsInput{
+			CustomerID: wf.customerID,
+			OrderID:    wf.id,
+			Points:     wf.loyalty.points,
+		},
+	).Get(ctx, nil)
+	if err != nil {
+		wf.logger.Error("Failed to return loyalty points", "points", wf.loyalty.points, "error", err)
+		return
+	}
+
+	wf.logger.Info("Loyalty points returned", "points", wf.loyalty.points)
+
+	wf.loyalty.points = 0
+}
+
 func (wf *orderImpl) updateStatus(ctx workflow.Context, status string) error {
 	wf.status = status
 
@@ -186,9 +376,18 @@ func (wf *orderImpl) buildFulfillments(ctx workflow.Context, items []*Item) erro
 		id := fmt.Sprintf("%s:%d", wf.id, i+1)
 		logger := log.With(wf.logger, "fulfillment", id)
 		f := &Fulfillment{
-			orderID:    wf.id,
-			customerID: wf.customerID,
-			logger:     logger,
+			orderID:         wf.id,
+			customerID:      wf.customerID,
+			email:           wf.email,
+			shippingAddress: wf.shippingAddress,
+			region:          wf.region,
+			shippingService: wf.service,
+			exchangeRate:    wf.exchangeRate,
+			paymentMethodID: wf.paymentMethodID,
+			couponCodes:     wf.couponCodes,
+			loyalty:         wf.loyalty,
+			dunning:         wf.dunning,
+			logger:          logger,
 
 			ID:       id,
 			Items:    r.Items,
@@ -224,12 +423,16 @@ func (wf *orderImpl) cancelUnavailableFulfillments() {
 	}
 }
 
-func (wf *orderImpl) cancelAllFulfillments() {
+func (wf *orderImpl) cancelAllFulfillments(ctx workflow.Context) error {
 	wf.logger.Info("Cancelling all fulfillments")
 
 	for _, f := range wf.fulfillments {
-		f.Status = FulfillmentStatusCancelled
+		if err := f.cancel(ctx); err != nil {
+			return err
+		}
 	}
+
+	return nil
 }
 
 func (wf *orderImpl) allFulfillmentsFailed() bool {
@@ -243,6 +446,121 @@ func (wf *orderImpl) allFulfillmentsFailed() bool {
 	return failures >= 1 && failures == len(wf.fulfillments)
 }
 
+func (wf *orderImpl) noFulfillmentsCompleted() bool {
+	for _, f := range wf.fulfillments {
+		if f.Status == FulfillmentStatusCompleted {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (wf *orderImpl) validateCancel(_ workflow.Context) error {
+	switch wf.status {
+	case OrderStatusCompleted, OrderStatusFailed, OrderStatusCancelled, OrderStatusTimedOut:
+		return temporal.NewApplicationError(fmt.Sprintf("order is %s and can no longer be cancelled", wf.status), cancelRejectedErrorType)
+	}
+
+	return nil
+}
+
+// handleCancel cancels every fulfillment which has not yet been dispatched, and waits for them to settle before
+// reporting the outcome for each fulfillment.
+func (wf *orderImpl) handleCancel(ctx workflow.Context) (*CancelOrderResult, error) {
+	// Fulfillments are not known until items have been reserved.
+	if err := workflow.Await(ctx, func() bool { return wf.status != OrderStatusPending || wf.finished }); err != nil {
+		return nil, err
+	}
+
+	closed := make(map[string]bool)
+	for _, f := range wf.fulfillments {
+		closed[f.ID] = f.closed()
+	}
+
+	if !wf.cancelRequested {
+		wf.logger.Info("Cancellation requested")
+
+		wf.cancelRequested = true
+		wf.setCancelled.Set(nil, nil)
+
+		for _, f := range wf.fulfillments {
+			f.requestCancel()
+		}
+	}
+
+	err := workflow.Await(ctx, func() bool {
+		if wf.finished {
+			return true
+		}
+		for _, f := range wf.fulfillments {
+			if !f.closed() && !f.dispatched() {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result CancelOrderResult
+	for _, f := range wf.fulfillments {
+		c := &FulfillmentCancellation{
+			ID:      f.ID,
+			Status:  f.Status,
+			Payment: f.Payment,
+		}
+		switch {
+		case closed[f.ID]:
+			c.Outcome = CancellationOutcomeClosed
+		case f.Status == FulfillmentStatusCancelled:
+			c.Outcome = CancellationOutcomeCancelled
+		case f.dispatched():
+			c.Outcome = CancellationOutcomeDispatched
+		default:
+			c.Outcome = CancellationOutcomeClosed
+		}
+		result.Fulfillments = append(result.Fulfillments, c)
+	}
+
+	return &result, nil
+}
+
+func (wf *orderImpl) validateUpdatePaymentMethod(_ workflow.Context, update PaymentMethodUpdate) error {
+	if update.PaymentMethodID == "" {
+		return temporal.NewApplicationError("paymentMethodId is required", paymentMethodRejectedErrorType)
+	}
+
+	for _, f := range wf.fulfillments {
+		if f.Status == FulfillmentStatusPaymentRetry {
+			return nil
+		}
+	}
+
+	return temporal.NewApplicationError("no payment is waiting to be retried", paymentMethodRejectedErrorType)
+}
+
+// handleUpdatePaymentMethod switches the order to a new payment method, and retries any declined payments with it
+// straight away.
+func (wf *orderImpl) handleUpdatePaymentMethod(_ workflow.Context, update PaymentMethodUpdate) (*PaymentMethodUpdateResult, error) {
+	wf.logger.Info("Payment method updated", "paymentMethodId", update.PaymentMethodID)
+
+	result := PaymentMethodUpdateResult{Fulfillments: []string{}}
+	for _, f := range wf.fulfillments {
+		if f.closed() {
+			continue
+		}
+		f.paymentMethodID = update.PaymentMethodID
+		if f.Status == FulfillmentStatusPaymentRetry {
+			f.retryRequested = true
+			result.Fulfillments = append(result.Fulfillments, f.ID)
+		}
+	}
+
+	return &result, nil
+}
+
 func (wf *orderImpl) waitForCustomer(ctx workflow.Context) (string, error) {
 	var signal CustomerActionSignal
 
@@ -272,6 +590,12 @@ func (wf *orderImpl) waitForCustomer(ctx workflow.Context) (string, error) {
 		cancelTimer()
 	})
 
+	s.AddFuture(wf.cancelled, func(workflow.Future) {
+		signal.Action = CustomerActionCancel
+
+		cancelTimer()
+	})
+
 	wf.logger.Info("Waiting for customer action")
 
 	s.Select(ctx)
@@ -319,25 +643,513 @@ func (f *Fulfillment) process(ctx workflow.Context) error {
 		return nil
 	}
 
+	if f.cancelRequested {
+		return f.cancel(ctx)
+	}
+
 	f.Status = FulfillmentStatusProcessing
 
-	err := f.processPayment(ctx)
-	if err != nil || f.Payment.Status != PaymentStatusSuccess {
-		f.Status = FulfillmentStatusFailed
-		return err
+	err := f.authorizePayment(ctx)
+	if err == nil && f.Payment.Status == PaymentStatusFailed && f.dunning != nil {
+		err = f.retryPayment(ctx)
+		if err == nil && f.cancelRequested && f.Payment.Status != PaymentStatusAuthorized {
+			return f.cancelAfterPayment(ctx)
+		}
+	}
+	if err == nil && f.cancelRequested && paymentHeld(f.Payment.Status) {
+		return f.cancelAfterPayment(ctx)
+	}
+	if err != nil || f.Payment.Status != PaymentStatusAuthorized {
+		return f.fail(ctx, err)
 	}
 
-	if err := f.processShipment(ctx); err != nil {
-		f.Status = FulfillmentStatusFailed
+	if f.cancelRequested {
+		return f.cancelAfterPayment(ctx)
+	}
+
+	run := f.startShipment(ctx)
+
+	// The payment is captured once the carrier has the shipment, which we learn from the shipment status signals.
+	err = workflow.Await(ctx, func() bool { return f.dispatched() || run.IsReady() })
+	if err != nil {
 		return err
 	}
 
+	if f.dispatched() {
+		if err := f.capturePayment(ctx); err != nil {
+			return f.failAfterDispatch(ctx, run, err)
+		}
+	}
+
+	err = f.awaitShipment(ctx, run)
+	if f.cancelRequested && temporal.IsCanceledError(err) {
+		return f.cancelAfterPayment(ctx)
+	}
+	if err != nil {
+		if f.Payment.Status == PaymentStatusCaptured {
+			f.Status = FulfillmentStatusFailed
+			return err
+		}
+		return f.fail(ctx, err)
+	}
+
+	// The shipment may complete without us seeing it dispatched.
+	if f.Payment.Status == PaymentStatusAuthorized {
+		if err := f.capturePayment(ctx); err != nil {
+			f.Status = FulfillmentStatusFailed
+			return err
+		}
+	}
+
 	f.Status = FulfillmentStatusCompleted
 
+	f.accrueLoyaltyPoints(ctx)
+
+	return nil
+}
+
+// loyaltyCredit is the loyalty points redeemed for an order which its fulfillments have not spent.
+type loyaltyCredit struct {
+	points int64
+	// value is what each point pays for, in the base currency.
+	value currency.Money
+	// charging is set from when a charge takes the points until it knows how many its invoice uses.
+	charging bool
+}
+
+// take reserves all the unspent points for a charge, returning how many were taken and what they pay for.
+// Charges are made one at a time, so each may use the points those before it left. Every take must be followed by a
+// settle.
+func (c *loyaltyCredit) take(ctx workflow.Context) (int64, currency.Money, error) {
+	if c == nil {
+		return 0, currency.Money{}, nil
+	}
+
+	if err := workflow.Await(ctx, func() bool { return !c.charging }); err != nil {
+		return 0, currency.Money{}, err
+	}
+
+	credit, err := c.value.Mul(c.points)
+	if err != nil {
+		return 0, currency.Money{}, err
+	}
+
+	points := c.points
+	c.points = 0
+	c.charging = true
+
+	return points, credit, nil
+}
+
+// settle puts back the points reserved for a charge which it did not use, returning how many it used.
+// Part of a point used counts as a whole one.
+func (c *loyaltyCredit) settle(reserved int64, used currency.Money) int64 {
+	if c == nil {
+		return 0
+	}
+
+	c.charging = false
+
+	spent := int64(0)
+	if used.Amount > 0 {
+		spent = min(reserved, (used.Amount+c.value.Amount-1)/c.value.Amount)
+	}
+	c.points += reserved - spent
+
+	return spent
+}
+
+// accrueLoyaltyPoints awards the customer loyalty points for the completed fulfillment.
+// Failing to award the points does not affect the fulfillment.
+func (f *Fulfillment) accrueLoyaltyPoints(ctx workflow.Context) {
+	ctx = workflow.WithActivityOptions(ctx,
+		workflow.ActivityOptions{
+			ScheduleToCloseTimeout: 5 * time.Minute,
+		},
+	)
+
+	var points int64
+
+	err := workflow.ExecuteActivity(ctx,
+		a.AccrueLoyaltyPoints,
+		&LoyaltyAccrualInput{
+			CustomerID:    f.customerID,
+			FulfillmentID: f.ID,
+		},
+	).Get(ctx, &points)
+	if err != nil {
+		f.logger.Warn("Failed to accrue loyalty points", "error", err)
+		return
+	}
+
+	f.logger.Info("Loyalty points accrued", "points", points)
+}
+
+// refundLoyaltyPoints gives the points spent on the fulfillment's payment back to the order, once the payment has been
+// voided or refunded.
+func (f *Fulfillment) refundLoyaltyPoints() {
+	if f.loyalty == nil || f.Payment.LoyaltyPoints == 0 {
+		return
+	}
+
+	f.loyalty.points += f.Payment.LoyaltyPoints
+	f.Payment.LoyaltyPoints = 0
+}
+
+// dunningSchedule is when to retry a declined payment.
+type dunningSchedule struct {
+	intervals []time.Duration
+	window    time.Duration
+}
+
+func newDunningSchedule(policy *DunningPolicy) (*dunningSchedule, error) {
+	intervals := policy.RetryIntervals
+	if len(intervals) == 0 {
+		intervals = DefaultDunningRetryIntervals
+	}
+
+	maxDays := policy.MaxDays
+	if maxDays == 0 {
+		maxDays = DefaultDunningMaxDays
+	}
+	if maxDays < 0 {
+		return nil, fmt.Errorf("dunning maxDays must not be negative")
+	}
+
+	s := &dunningSchedule{window: time.Duration(maxDays) * 24 * time.Hour}
+	for _, i := range intervals {
+		d, err := time.ParseDuration(i)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dunning retry interval %q: %w", i, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("dunning retry interval %q must be positive", i)
+		}
+		s.intervals = append(s.intervals, d)
+	}
+
+	return s, nil
+}
+
+// interval returns the wait before the given retry, counting from zero.
+func (s *dunningSchedule) interval(retry int) time.Duration {
+	return s.intervals[min(retry, len(s.intervals)-1)]
+}
+
+// retryPayment retries a declined payment on the dunning schedule until it is authorized, the fulfillment is
+// cancelled, or the dunning window closes. A new payment method from the customer is tried straight away.
+// If the payment is still declined when the window closes, the payment is left failed for the caller to handle.
+func (f *Fulfillment) retryPayment(ctx workflow.Context) error {
+	until := workflow.Now(ctx).Add(f.dunning.window)
+
+	for retry := 0; ; retry++ {
+		remaining := until.Sub(workflow.Now(ctx))
+		if remaining <= 0 {
+			f.logger.Info("Payment retries exhausted", "retries", retry)
+			f.notify(ctx, PaymentNotificationFailed)
+			return nil
+		}
+
+		wait := f.dunning.interval(retry)
+		next := workflow.Now(ctx).Add(wait)
+
+		f.Status = FulfillmentStatusPaymentRetry
+		f.Payment.RetryUntil = &until
+		if wait > remaining {
+			// There is no time for another scheduled retry, but the customer may still supply a new payment method.
+			wait = remaining
+			f.Payment.NextRetryAt = nil
+		} else {
+			f.Payment.NextRetryAt = &next
+		}
+
+		f.notify(ctx, PaymentNotificationDeclined)
+
+		f.logger.Info("Payment declined, waiting to retry", "retry", retry+1, "wait", wait)
+
+		retryRequested, err := workflow.AwaitWithTimeout(ctx, wait, func() bool { return f.retryRequested || f.cancelRequested })
+		if err != nil {
+			return err
+		}
+		if f.cancelRequested {
+			return nil
+		}
+		if !retryRequested && f.Payment.NextRetryAt == nil {
+			f.logger.Info("Payment retries exhausted", "retries", retry)
+			f.notify(ctx, PaymentNotificationFailed)
+			return nil
+		}
+		f.retryRequested = false
+
+		f.Status = FulfillmentStatusProcessing
+
+		if err := f.authorizePayment(ctx); err != nil {
+			return err
+		}
+		f.Payment.Retries = int32(retry + 1)
+
+		if f.Payment.Status == PaymentStatusAuthorized {
+			return nil
+		}
+	}
+}
+
+// notify tells the customer about the fulfillment's payment.
+// Failing to notify the customer does not affect the fulfillment.
+func (f *Fulfillment) notify(ctx workflow.Context, kind string) {
+	ctx = workflow.WithActivityOptions(ctx,
+		workflow.ActivityOptions{
+			ScheduleToCloseTimeout: time.Minute,
+		},
+	)
+
+	err := workflow.ExecuteActivity(ctx,
+		a.NotifyCustomer,
+		&PaymentNotification{
+			Kind:          kind,
+			CustomerID:    f.customerID,
+			OrderID:       f.orderID,
+			FulfillmentID: f.ID,
+			Total:         f.Payment.Total,
+			Currency:      f.Payment.Currency,
+			NextRetryAt:   f.Payment.NextRetryAt,
+			RetryUntil:    f.Payment.RetryUntil,
+		},
+	).Get(ctx, nil)
+	if err != nil {
+		f.logger.Warn("Failed to notify customer", "kind", kind, "error", err)
+	}
+}
+
+// failAfterDispatch fails a fulfillment whose payment could not be captured once the shipment was dispatched.
+// The items are already with the carrier, so the shipment is left to complete and nothing is returned to stock.
+func (f *Fulfillment) failAfterDispatch(ctx workflow.Context, run workflow.ChildWorkflowFuture, cause error) error {
+	f.logger.Error("Failed to capture payment for dispatched shipment", "error", cause)
+
+	if err := f.awaitShipment(ctx, run); err != nil {
+		f.logger.Error("Shipment failed", "error", err)
+	}
+
+	f.Status = FulfillmentStatusFailed
+
+	return cause
+}
+
+// closed returns true if the fulfillment has finished processing.
+func (f *Fulfillment) closed() bool {
+	switch f.Status {
+	case FulfillmentStatusCompleted, FulfillmentStatusFailed, FulfillmentStatusCancelled:
+		return true
+	}
+
+	return false
+}
+
+// dispatched returns true if the fulfillment's shipment is already with the carrier.
+func (f *Fulfillment) dispatched() bool {
+	if f.Shipment == nil {
+		return false
+	}
+
+	switch f.Shipment.Status {
+	case shipment.ShipmentStatusDispatched, shipment.ShipmentStatusDelivered:
+		return true
+	}
+
+	return false
+}
+
+// requestCancel asks the fulfillment to cancel at the next opportunity.
+// Fulfillments which have already been dispatched are left to complete.
+func (f *Fulfillment) requestCancel() {
+	if f.closed() || f.dispatched() {
+		return
+	}
+
+	f.cancelRequested = true
+
+	if f.cancelShipment != nil {
+		f.logger.Info("Cancelling shipment")
+		f.cancelShipment()
+	}
+}
+
+// cancelAfterPayment cancels a fulfillment which has already been paid for.
+func (f *Fulfillment) cancelAfterPayment(ctx workflow.Context) error {
+	var err error
+
+	switch f.Payment.Status {
+	case PaymentStatusAuthorized, PaymentStatusReview, PaymentStatusQueued:
+		err = f.voidPayment(ctx)
+	case PaymentStatusCaptured:
+		err = f.refundPayment(ctx)
+	}
+	if err != nil {
+		return f.fail(ctx, err)
+	}
+
+	return f.cancel(ctx)
+}
+
+// voidPayment releases an authorized payment without capturing it.
+func (f *Fulfillment) voidPayment(ctx workflow.Context) error {
+	ctx = workflow.WithActivityOptions(ctx,
+		workflow.ActivityOptions{
+			StartToCloseTimeout: 30 * time.Second,
+		},
+	)
+
+	err := workflow.ExecuteActivity(ctx,
+		a.Void,
+		&VoidInput{ChargeIdempotencyKey: f.chargeKey},
+	).Get(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	f.Payment.Status = PaymentStatusVoided
+	f.refundLoyaltyPoints()
+
+	f.logger.Info("Payment voided", "total", f.Payment.Total)
+
 	return nil
 }
 
-func (f *Fulfillment) processPayment(ctx workflow.Context) error {
+// capturePayment takes an authorized payment.
+func (f *Fulfillment) capturePayment(ctx workflow.Context) error {
+	ctx = workflow.WithActivityOptions(ctx,
+		workflow.ActivityOptions{
+			StartToCloseTimeout: 30 * time.Second,
+		},
+	)
+
+	err := workflow.ExecuteActivity(ctx,
+		a.Capture,
+		&CaptureInput{ChargeIdempotencyKey: f.chargeKey},
+	).Get(ctx, nil)
+	if err != nil {
+		f.Payment.Status = PaymentStatusFailed
+		return err
+	}
+
+	f.Payment.Status = PaymentStatusCaptured
+
+	f.logger.Info("Payment captured", "total", f.Payment.Total)
+
+	return nil
+}
+
+// refundPayment refunds a captured payment in full.
+func (f *Fulfillment) refundPayment(ctx workflow.Context) error {
+	if f.Payment == nil || f.Payment.Status != PaymentStatusCaptured {
+		return nil
+	}
+
+	ctx = workflow.WithActivityOptions(ctx,
+		workflow.ActivityOptions{
+			StartToCloseTimeout: 30 * time.Second,
+		},
+	)
+
+	var refundKey string
+	v := workflow.SideEffect(ctx, func(_ workflow.Context) any {
+		return uuid.NewString()
+	})
+	if err := v.Get(&refundKey); err != nil {
+		return err
+	}
+
+	var refund RefundResult
+
+	err := workflow.ExecuteActivity(ctx,
+		a.Refund,
+		&RefundInput{
+			CustomerID:           f.customerID,
+			InvoiceReference:     f.ID,
+			ChargeIdempotencyKey: f.chargeKey,
+			IdempotencyKey:       refundKey,
+		},
+	).Get(ctx, &refund)
+	if err != nil {
+		return err
+	}
+
+	f.Payment.Refunded = refund.TotalRefunded
+	f.Payment.Status = PaymentStatusRefunded
+	f.refundLoyaltyPoints()
+
+	f.logger.Info("Payment refunded", "total", refund.Total)
+
+	return nil
+}
+
+// cancel cancels the fulfillment, returning any reserved items to stock.
+func (f *Fulfillment) cancel(ctx workflow.Context) error {
+	switch f.Status {
+	case FulfillmentStatusCancelled:
+		return nil
+	case FulfillmentStatusUnavailable:
+		// Nothing was reserved for unavailable items.
+	default:
+		if err := f.releaseItems(ctx); err != nil {
+			return err
+		}
+	}
+
+	f.Status = FulfillmentStatusCancelled
+
+	return nil
+}
+
+// fail marks the fulfillment as failed, voiding any authorized payment and returning any reserved items to stock.
+// The original cause of the failure is returned in preference to any failure to void or release.
+func (f *Fulfillment) fail(ctx workflow.Context, cause error) error {
+	f.Status = FulfillmentStatusFailed
+
+	if f.Payment != nil && f.Payment.Status == PaymentStatusAuthorized {
+		if err := f.voidPayment(ctx); err != nil {
+			f.logger.Error("Failed to void payment", "error", err)
+			if cause == nil {
+				cause = err
+			}
+		}
+	}
+
+	if err := f.releaseItems(ctx); err != nil {
+		f.logger.Error("Failed to release items", "error", err)
+		if cause == nil {
+			return err
+		}
+	}
+
+	return cause
+}
+
+func (f *Fulfillment) releaseItems(ctx workflow.Context) error {
+	ctx = workflow.WithActivityOptions(ctx,
+		workflow.ActivityOptions{
+			StartToCloseTimeout: 30 * time.Second,
+		},
+	)
+
+	err := workflow.ExecuteActivity(ctx,
+		a.ReleaseItems,
+		ReleaseItemsInput{
+			OrderID: f.orderID,
+			Items:   f.Items,
+		},
+	).Get(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	f.logger.Info("Released items", "location", f.Location)
+
+	return nil
+}
+
+// authorizePayment invoices the fulfillment and authorizes payment for it.
+func (f *Fulfillment) authorizePayment(ctx workflow.Context) error {
 	var billingItems []billing.Item
 	for _, i := range f.Items {
 		billingItems = append(billingItems, billing.Item{SKU: i.SKU, Quantity: i.Quantity})
@@ -361,43 +1173,164 @@ func (f *Fulfillment) processPayment(ctx workflow.Context) error {
 		f.Payment.Status = PaymentStatusFailed
 		return err
 	}
+	f.chargeKey = chargeKey
 
-	c := workflow.ExecuteActivity(ctx,
-		a.Charge,
-		&ChargeInput{
-			CustomerID:     f.customerID,
-			Reference:      f.ID,
-			Items:          billingItems,
-			IdempotencyKey: chargeKey,
-		},
-	)
+	points, credit, err := f.loyalty.take(ctx)
+	if err != nil {
+		f.Payment.Status = PaymentStatusFailed
+		return err
+	}
+
+	input := &ChargeInput{
+		CustomerID:      f.customerID,
+		Email:           f.email,
+		ShippingAddress: f.shippingAddress,
+		Reference:       f.ID,
+		Items:           billingItems,
+		IdempotencyKey:  chargeKey,
+		Region:          f.region,
+		Origin:          f.Location,
+		ShippingService: f.shippingService,
+		PaymentMethodID: f.paymentMethodID,
+		ExchangeRate:    f.exchangeRate,
+		CouponCodes:     f.couponCodes,
+		LoyaltyCredit:   credit,
+	}
+
+	c := workflow.ExecuteActivity(ctx, a.Charge, input)
 	if err := c.Get(ctx, &charge); err != nil {
+		f.loyalty.settle(points, currency.Money{})
 		f.Payment.Status = PaymentStatusFailed
 		return err
 	}
 
 	p := f.Payment
 
+	held := chargeHeld(charge.Status)
+	if held {
+		// A held payment may wait a long time, so it keeps only the points its invoice uses, and lets the order's
+		// other fulfillments be charged meanwhile.
+		p.LoyaltyPoints = f.loyalty.settle(points, charge.LoyaltyCredit)
+
+		if err := f.awaitHeldPayment(ctx, input, &charge); err != nil {
+			f.refundLoyaltyPoints()
+			p.Status = PaymentStatusFailed
+			return err
+		}
+		if chargeHeld(charge.Status) {
+			// The fulfillment was cancelled while the payment was held, and the payment is left to be voided, which
+			// gives back its points.
+			return nil
+		}
+	}
+
 	p.SubTotal = charge.SubTotal
 	p.Tax = charge.Tax
 	p.Shipping = charge.Shipping
 	p.Total = charge.Total
-	if charge.Success {
-		p.Status = PaymentStatusSuccess
-	} else {
+	p.Currency = charge.Currency
+	p.DeclineReason = charge.DeclineReason
+	p.FraudDecisionID = charge.FraudDecisionID
+	p.MaintenanceReason = charge.MaintenanceReason
+	switch {
+	case charge.Success && !held:
+		p.Status = PaymentStatusAuthorized
+		p.LoyaltyPoints = f.loyalty.settle(points, charge.LoyaltyCredit)
+	case charge.Success:
+		p.Status = PaymentStatusAuthorized
+	case !held:
 		p.Status = PaymentStatusFailed
+		f.loyalty.settle(points, currency.Money{})
+	default:
+		p.Status = PaymentStatusFailed
+		f.refundLoyaltyPoints()
 	}
 
-	f.logger.Info("Payment processed", "total", p.Total, "status", p.Status)
+	f.logger.Info("Payment authorized", "total", p.Total, "status", p.Status)
+
+	return nil
+}
+
+// heldPaymentPollInterval is how often a fulfillment whose payment is held checks whether it has gone ahead.
+const heldPaymentPollInterval = time.Minute
+
+// chargeHeld reports whether a charge is held for fraud review, or queued while the fraud check is down for
+// maintenance, rather than authorized or declined.
+func chargeHeld(status string) bool {
+	return status == billing.ChargeStatusReview || status == billing.ChargeStatusQueued
+}
+
+// paymentHeld reports whether a payment is held for fraud review or queued.
+func paymentHeld(status string) bool {
+	return status == PaymentStatusReview || status == PaymentStatusQueued
+}
+
+// awaitHeldPayment waits until a payment the fraud check held for review has been decided by a manager, or a payment
+// queued while the fraud check was down for maintenance has been tried again, updating the charge with the outcome.
+// It stops waiting if the fulfillment is cancelled.
+func (f *Fulfillment) awaitHeldPayment(ctx workflow.Context, input *ChargeInput, charge *ChargeResult) error {
+	status := f.Status
+	defer func() { f.Status = status }()
+
+	for chargeHeld(charge.Status) {
+		f.showHeldPayment(charge)
+
+		_, err := workflow.AwaitWithTimeout(ctx, heldPaymentPollInterval, func() bool { return f.cancelRequested })
+		if err != nil {
+			return err
+		}
+		if f.cancelRequested {
+			return nil
+		}
+
+		// Charging again with the same idempotency key returns the held charge's current result.
+		var result ChargeResult
+		if err := workflow.ExecuteActivity(ctx, a.Charge, input).Get(ctx, &result); err != nil {
+			return err
+		}
+		*charge = result
+	}
 
 	return nil
 }
 
-func (f *Fulfillment) processShipment(ctx workflow.Context) error {
-	ctx = workflow.WithChildOptions(ctx,
+// showHeldPayment shows the fulfillment and its payment as held for fraud review or queued, as the charge is.
+func (f *Fulfillment) showHeldPayment(charge *ChargeResult) {
+	p := f.Payment
+	previous := p.Status
+
+	p.FraudDecisionID = charge.FraudDecisionID
+	p.MaintenanceReason = charge.MaintenanceReason
+	if charge.Status == billing.ChargeStatusQueued {
+		p.Status = PaymentStatusQueued
+		f.Status = FulfillmentStatusPaymentQueued
+	} else {
+		p.Status = PaymentStatusReview
+		p.FraudReviewID = charge.FraudReviewID
+		f.Status = FulfillmentStatusFraudReview
+	}
+
+	if p.Status == previous {
+		return
+	}
+	if p.Status == PaymentStatusQueued {
+		f.logger.Info("Payment queued during fraud maintenance", "reason", charge.MaintenanceReason, "retry_at", charge.RetryAt)
+	} else {
+		f.logger.Info("Payment held for fraud review", "review", charge.FraudReviewID)
+	}
+}
+
+// startShipment starts the Shipment workflow for the fulfillment.
+func (f *Fulfillment) startShipment(ctx workflow.Context) workflow.ChildWorkflowFuture {
+	shipmentCtx, cancel := workflow.WithCancel(ctx)
+	f.cancelShipment = cancel
+
+	shipmentCtx = workflow.WithChildOptions(shipmentCtx,
 		workflow.ChildWorkflowOptions{
 			TaskQueue:  shipment.TaskQueue,
 			WorkflowID: shipment.ShipmentWorkflowID(f.ID),
+			// The shipment decides whether it can still be cancelled, so wait for its decision.
+			WaitForCancellation: true,
 		},
 	)
 
@@ -412,7 +1345,7 @@ func (f *Fulfillment) processShipment(ctx workflow.Context) error {
 		UpdatedAt: workflow.Now(ctx),
 	}
 
-	err := workflow.ExecuteChildWorkflow(ctx,
+	return workflow.ExecuteChildWorkflow(shipmentCtx,
 		shipment.Shipment,
 		shipment.ShipmentInput{
 			RequestorWID: workflow.GetInfo(ctx).WorkflowExecution.ID,
@@ -420,7 +1353,13 @@ func (f *Fulfillment) processShipment(ctx workflow.Context) error {
 			ID:    f.ID,
 			Items: shippingItems,
 		},
-	).Get(ctx, nil)
+	)
+}
+
+// awaitShipment waits for the Shipment workflow to complete.
+func (f *Fulfillment) awaitShipment(ctx workflow.Context, run workflow.ChildWorkflowFuture) error {
+	err := run.Get(ctx, nil)
+	f.cancelShipment = nil
 
 	f.logger.Info("Shipment processed", "status", f.Shipment.Status)
 
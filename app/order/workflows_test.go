@@ -2,29 +2,68 @@ package order_test
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/temporalio/reference-app-orders-go/app/billing"
+	"github.com/temporalio/reference-app-orders-go/app/currency"
 	"github.com/temporalio/reference-app-orders-go/app/order"
 	"github.com/temporalio/reference-app-orders-go/app/shipment"
+	"go.temporal.io/sdk/temporal"
 	"go.temporal.io/sdk/testsuite"
 	"go.temporal.io/sdk/workflow"
 )
 
+// money returns an amount in an unspecified currency.
+func money(amount int64) currency.Money {
+	return currency.Money{Amount: amount}
+}
+
+// reserveItems stands in for the Inventory API: any SKU containing "Adidas" is out of stock, the first
+// available item ships from Warehouse A and the remainder from Warehouse B.
+func reserveItems(_ context.Context, input *order.ReserveItemsInput) (*order.ReserveItemsResult, error) {
+	var result order.ReserveItemsResult
+	var unavailable, available []*order.Item
+
+	for _, item := range input.Items {
+		if strings.Contains(item.SKU, "Adidas") {
+			unavailable = append(unavailable, item)
+		} else {
+			available = append(available, item)
+		}
+	}
+
+	if len(unavailable) > 0 {
+		result.Reservations = append(result.Reservations, &order.Reservation{Available: false, Items: unavailable})
+	}
+	if len(available) > 0 {
+		result.Reservations = append(result.Reservations, &order.Reservation{Available: true, Location: "Warehouse A", Items: available[0:1]})
+	}
+	if len(available) > 1 {
+		result.Reservations = append(result.Reservations, &order.Reservation{Available: true, Location: "Warehouse B", Items: available[1:]})
+	}
+
+	return &result, nil
+}
+
 func TestOrderWorkflow(t *testing.T) {
 	s := testsuite.WorkflowTestSuite{}
 	env := s.NewTestWorkflowEnvironment()
 	var a *order.Activities
 
-	env.RegisterActivity(a.ReserveItems)
+	env.OnActivity(a.ReserveItems, mock.Anything, mock.Anything).Return(reserveItems)
 	env.OnActivity(a.InsertOrder, mock.Anything, mock.Anything).Return(func(ctx context.Context, input *order.OrderStatusInsert) error {
 		return nil
 	})
 	env.OnActivity(a.Charge, mock.Anything, mock.Anything).Return(func(ctx context.Context, input *order.ChargeInput) (*order.ChargeResult, error) {
 		return &order.ChargeResult{Success: true}, nil
 	})
+	env.OnActivity(a.Capture, mock.Anything, mock.Anything).Return(&order.ChargeResult{}, nil)
 	env.OnActivity(a.UpdateOrderStatus, mock.Anything, mock.Anything).Return(func(ctx context.Context, input *order.OrderStatusUpdate) error {
 		return nil
 	})
@@ -58,13 +97,14 @@ func TestOrderShipmentStatus(t *testing.T) {
 	env := s.NewTestWorkflowEnvironment()
 	var a *order.Activities
 
-	env.RegisterActivity(a.ReserveItems)
+	env.OnActivity(a.ReserveItems, mock.Anything, mock.Anything).Return(reserveItems)
 	env.OnActivity(a.InsertOrder, mock.Anything, mock.Anything).Return(func(_ctx context.Context, _input *order.OrderStatusInsert) error {
 		return nil
 	})
 	env.OnActivity(a.Charge, mock.Anything, mock.Anything).Return(func(_ctx context.Context, _input *order.ChargeInput) (*order.ChargeResult, error) {
 		return &order.ChargeResult{Success: true}, nil
 	})
+	env.OnActivity(a.Capture, mock.Anything, mock.Anything).Return(&order.ChargeResult{}, nil)
 	env.OnActivity(a.UpdateOrderStatus, mock.Anything, mock.Anything).Return(func(_ctx context.Context, _input *order.OrderStatusUpdate) error {
 		return nil
 	})
@@ -109,18 +149,81 @@ func TestOrderShipmentStatus(t *testing.T) {
 	assert.Equal(t, shipment.ShipmentStatusDelivered, f.Shipment.Status)
 }
 
+func TestOrderInCurrency(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	var a *order.Activities
+
+	rate := &currency.Rate{Base: "GBP", Currency: "EUR", Rate: 1170000}
+
+	env.OnActivity(a.GetExchangeRate, mock.Anything, "EUR").Return(rate, nil).Once()
+	env.OnActivity(a.ReserveItems, mock.Anything, mock.Anything).Return(reserveItems)
+	env.OnActivity(a.InsertOrder, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(a.Charge, mock.Anything, mock.Anything).Return(func(_ context.Context, input *order.ChargeInput) (*order.ChargeResult, error) {
+		// Every fulfillment is charged at the rate taken when the order was placed.
+		assert.Equal(t, rate, input.ExchangeRate)
+		return &order.ChargeResult{Success: true, Total: currency.NewMoney(1170, "EUR"), Currency: "EUR", ExchangeRate: input.ExchangeRate}, nil
+	}).Times(2)
+	env.OnActivity(a.Capture, mock.Anything, mock.Anything).Return(&order.ChargeResult{}, nil)
+	env.OnActivity(a.UpdateOrderStatus, mock.Anything, mock.Anything).Return(nil)
+	env.OnWorkflow(shipment.Shipment, mock.Anything, mock.Anything).Return(&shipment.ShipmentResult{CourierReference: "test"}, nil)
+
+	env.ExecuteWorkflow(order.Order, &order.OrderInput{
+		ID:         "1234",
+		CustomerID: "1234",
+		Currency:   "EUR",
+		Items: []*order.Item{
+			{SKU: "test1", Quantity: 1},
+			{SKU: "test2", Quantity: 3},
+		},
+	})
+	assert.NoError(t, env.GetWorkflowError())
+
+	var status order.OrderStatus
+	v, err := env.QueryWorkflow(order.StatusQuery, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, v.Get(&status))
+
+	assert.Equal(t, "EUR", status.Currency)
+	for _, f := range status.Fulfillments {
+		assert.Equal(t, "EUR", f.Payment.Currency)
+		assert.Equal(t, currency.NewMoney(1170, "EUR"), f.Payment.Total)
+
+		// The Order API gives payment amounts as bare numbers, with their currency alongside.
+		data, err := json.Marshal(f.Payment)
+		assert.NoError(t, err)
+		assert.Contains(t, string(data), `"total":1170,`)
+	}
+	env.AssertExpectations(t)
+}
+
+func TestOrderRejectsInvalidCurrency(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+
+	env.ExecuteWorkflow(order.Order, &order.OrderInput{
+		ID:         "1234",
+		CustomerID: "1234",
+		Currency:   "euro",
+		Items:      []*order.Item{{SKU: "test1", Quantity: 1}},
+	})
+
+	assert.ErrorContains(t, env.GetWorkflowError(), "currency must be a three letter ISO 4217 code")
+}
+
 func TestOrderAmendWithUnavailableItems(t *testing.T) {
 	s := testsuite.WorkflowTestSuite{}
 	env := s.NewTestWorkflowEnvironment()
 	var a *order.Activities
 
-	env.RegisterActivity(a.ReserveItems)
+	env.OnActivity(a.ReserveItems, mock.Anything, mock.Anything).Return(reserveItems)
 	env.OnActivity(a.InsertOrder, mock.Anything, mock.Anything).Return(func(_ctx context.Context, _input *order.OrderStatusInsert) error {
 		return nil
 	})
 	env.OnActivity(a.Charge, mock.Anything, mock.Anything).Return(func(_ctx context.Context, _input *order.ChargeInput) (*order.ChargeResult, error) {
 		return &order.ChargeResult{Success: true}, nil
 	})
+	env.OnActivity(a.Capture, mock.Anything, mock.Anything).Return(&order.ChargeResult{}, nil)
 	env.OnActivity(a.UpdateOrderStatus, mock.Anything, mock.Anything).Return(func(_ctx context.Context, _input *order.OrderStatusUpdate) error {
 		return nil
 	})
@@ -190,7 +293,7 @@ func TestOrderAmendWithUnavailableItems(t *testing.T) {
 	assert.Equal(t, order.FulfillmentStatusCancelled, f.Status)
 
 	f = status.Fulfillments[1]
-	assert.Equal(t, order.PaymentStatusSuccess, f.Payment.Status)
+	assert.Equal(t, order.PaymentStatusCaptured, f.Payment.Status)
 	assert.Equal(t, f.ID, f.Shipment.ID)
 
 	env.AssertWorkflowNumberOfCalls(t, "Shipment", 1)
@@ -201,13 +304,18 @@ func TestOrderCancelWithUnavailableItems(t *testing.T) {
 	env := s.NewTestWorkflowEnvironment()
 	var a *order.Activities
 
-	env.RegisterActivity(a.ReserveItems)
+	env.OnActivity(a.ReserveItems, mock.Anything, mock.Anything).Return(reserveItems)
 	env.OnActivity(a.InsertOrder, mock.Anything, mock.Anything).Return(func(_ctx context.Context, _input *order.OrderStatusInsert) error {
 		return nil
 	})
 	env.OnActivity(a.UpdateOrderStatus, mock.Anything, mock.Anything).Return(func(_ctx context.Context, _input *order.OrderStatusUpdate) error {
 		return nil
 	})
+	var released []*order.ReleaseItemsInput
+	env.OnActivity(a.ReleaseItems, mock.Anything, mock.Anything).Return(func(_ctx context.Context, input *order.ReleaseItemsInput) error {
+		released = append(released, input)
+		return nil
+	})
 
 	orderInput := order.OrderInput{
 		ID:         "1234",
@@ -235,6 +343,11 @@ func TestOrderCancelWithUnavailableItems(t *testing.T) {
 	var result order.OrderResult
 	err := env.GetWorkflowResult(&result)
 	assert.NoError(t, err)
+	assert.Equal(t, order.OrderStatusCancelled, result.Status)
+
+	assert.Equal(t, []*order.ReleaseItemsInput{
+		{OrderID: "1234", Items: []*order.Item{{SKU: "test2", Quantity: 3}}},
+	}, released)
 }
 
 func TestOrderCancelAfterTimeout(t *testing.T) {
@@ -242,13 +355,18 @@ func TestOrderCancelAfterTimeout(t *testing.T) {
 	env := s.NewTestWorkflowEnvironment()
 	var a *order.Activities
 
-	env.RegisterActivity(a.ReserveItems)
+	env.OnActivity(a.ReserveItems, mock.Anything, mock.Anything).Return(reserveItems)
 	env.OnActivity(a.InsertOrder, mock.Anything, mock.Anything).Return(func(ctx context.Context, input *order.OrderStatusInsert) error {
 		return nil
 	})
 	env.OnActivity(a.UpdateOrderStatus, mock.Anything, mock.Anything).Return(func(ctx context.Context, input *order.OrderStatusUpdate) error {
 		return nil
 	})
+	var released []*order.ReleaseItemsInput
+	env.OnActivity(a.ReleaseItems, mock.Anything, mock.Anything).Return(func(_ctx context.Context, input *order.ReleaseItemsInput) error {
+		released = append(released, input)
+		return nil
+	})
 
 	orderInput := order.OrderInput{
 		ID:         "1234",
@@ -269,4 +387,938 @@ func TestOrderCancelAfterTimeout(t *testing.T) {
 	assert.NoError(t, err)
 
 	assert.Equal(t, order.OrderStatusTimedOut, result.Status)
+
+	assert.Equal(t, []*order.ReleaseItemsInput{
+		{OrderID: "1234", Items: []*order.Item{{SKU: "test2", Quantity: 3}}},
+	}, released)
+}
+
+func TestOrderReleasesItemsWhenPaymentFails(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	var a *order.Activities
+
+	env.OnActivity(a.ReserveItems, mock.Anything, mock.Anything).Return(reserveItems)
+	env.OnActivity(a.InsertOrder, mock.Anything, mock.Anything).Return(func(_ctx context.Context, _input *order.OrderStatusInsert) error {
+		return nil
+	})
+	env.OnActivity(a.Charge, mock.Anything, mock.Anything).Return(func(_ctx context.Context, input *order.ChargeInput) (*order.ChargeResult, error) {
+		return &order.ChargeResult{Success: input.Reference != "1234:1"}, nil
+	})
+	env.OnActivity(a.Capture, mock.Anything, mock.Anything).Return(&order.ChargeResult{}, nil)
+	env.OnActivity(a.UpdateOrderStatus, mock.Anything, mock.Anything).Return(func(_ctx context.Context, _input *order.OrderStatusUpdate) error {
+		return nil
+	})
+	var released []*order.ReleaseItemsInput
+	env.OnActivity(a.ReleaseItems, mock.Anything, mock.Anything).Return(func(_ctx context.Context, input *order.ReleaseItemsInput) error {
+		released = append(released, input)
+		return nil
+	})
+	env.OnWorkflow(shipment.Shipment, mock.Anything, mock.Anything).Return(func(_ctx workflow.Context, _input *shipment.ShipmentInput) (*shipment.ShipmentResult, error) {
+		return &shipment.ShipmentResult{CourierReference: "test"}, nil
+	})
+
+	orderInput := order.OrderInput{
+		ID:         "1234",
+		CustomerID: "1234",
+		Items: []*order.Item{
+			{SKU: "test1", Quantity: 1},
+			{SKU: "test2", Quantity: 3},
+		},
+	}
+
+	env.ExecuteWorkflow(
+		order.Order,
+		&orderInput,
+	)
+
+	var result order.OrderResult
+	err := env.GetWorkflowResult(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, order.OrderStatusCompleted, result.Status)
+
+	var status order.OrderStatus
+	v, err := env.QueryWorkflow(order.StatusQuery, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, v.Get(&status))
+
+	assert.Equal(t, order.FulfillmentStatusFailed, status.Fulfillments[0].Status)
+	assert.Equal(t, order.FulfillmentStatusCompleted, status.Fulfillments[1].Status)
+
+	assert.Equal(t, []*order.ReleaseItemsInput{
+		{OrderID: "1234", Items: []*order.Item{{SKU: "test1", Quantity: 1}}},
+	}, released)
+
+	env.AssertWorkflowNumberOfCalls(t, "Shipment", 1)
+}
+
+func TestOrderReleasesItemsWhenShipmentFails(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	var a *order.Activities
+
+	env.OnActivity(a.ReserveItems, mock.Anything, mock.Anything).Return(reserveItems)
+	env.OnActivity(a.InsertOrder, mock.Anything, mock.Anything).Return(func(_ctx context.Context, _input *order.OrderStatusInsert) error {
+		return nil
+	})
+	env.OnActivity(a.Charge, mock.Anything, mock.Anything).Return(func(_ctx context.Context, _input *order.ChargeInput) (*order.ChargeResult, error) {
+		return &order.ChargeResult{Success: true}, nil
+	})
+	var voided []*order.VoidInput
+	env.OnActivity(a.Void, mock.Anything, mock.Anything).Return(func(_ctx context.Context, input *order.VoidInput) (*order.ChargeResult, error) {
+		voided = append(voided, input)
+		return &order.ChargeResult{}, nil
+	})
+	env.OnActivity(a.UpdateOrderStatus, mock.Anything, mock.Anything).Return(func(_ctx context.Context, _input *order.OrderStatusUpdate) error {
+		return nil
+	})
+	var released []*order.ReleaseItemsInput
+	env.OnActivity(a.ReleaseItems, mock.Anything, mock.Anything).Return(func(_ctx context.Context, input *order.ReleaseItemsInput) error {
+		released = append(released, input)
+		return nil
+	})
+	env.OnWorkflow(shipment.Shipment, mock.Anything, mock.Anything).Return(func(_ctx workflow.Context, _input *shipment.ShipmentInput) (*shipment.ShipmentResult, error) {
+		return nil, errors.New("carrier unavailable")
+	})
+
+	orderInput := order.OrderInput{
+		ID:         "1234",
+		CustomerID: "1234",
+		Items: []*order.Item{
+			{SKU: "test1", Quantity: 1},
+		},
+	}
+
+	env.ExecuteWorkflow(
+		order.Order,
+		&orderInput,
+	)
+
+	var result order.OrderResult
+	err := env.GetWorkflowResult(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, order.OrderStatusFailed, result.Status)
+
+	assert.Equal(t, []*order.ReleaseItemsInput{
+		{OrderID: "1234", Items: []*order.Item{{SKU: "test1", Quantity: 1}}},
+	}, released)
+
+	// The payment was only authorized, so it is voided rather than refunded.
+	assert.Len(t, voided, 1)
+}
+
+func TestOrderCancelDuringProcessing(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	var a *order.Activities
+
+	env.OnActivity(a.ReserveItems, mock.Anything, mock.Anything).Return(reserveItems)
+	env.OnActivity(a.InsertOrder, mock.Anything, mock.Anything).Return(func(_ctx context.Context, _input *order.OrderStatusInsert) error {
+		return nil
+	})
+	env.OnActivity(a.Charge, mock.Anything, mock.Anything).Return(func(_ctx context.Context, _input *order.ChargeInput) (*order.ChargeResult, error) {
+		return &order.ChargeResult{Success: true, Total: money(1000)}, nil
+	})
+	env.OnActivity(a.UpdateOrderStatus, mock.Anything, mock.Anything).Return(func(_ctx context.Context, _input *order.OrderStatusUpdate) error {
+		return nil
+	})
+	var released []*order.ReleaseItemsInput
+	env.OnActivity(a.ReleaseItems, mock.Anything, mock.Anything).Return(func(_ctx context.Context, input *order.ReleaseItemsInput) error {
+		released = append(released, input)
+		return nil
+	})
+	var captured []*order.CaptureInput
+	env.OnActivity(a.Capture, mock.Anything, mock.Anything).Return(func(_ctx context.Context, input *order.CaptureInput) (*order.ChargeResult, error) {
+		captured = append(captured, input)
+		return &order.ChargeResult{}, nil
+	})
+	var voided []*order.VoidInput
+	env.OnActivity(a.Void, mock.Anything, mock.Anything).Return(func(_ctx context.Context, input *order.VoidInput) (*order.ChargeResult, error) {
+		voided = append(voided, input)
+		return &order.ChargeResult{}, nil
+	})
+	var sa *shipment.Activities
+	env.RegisterWorkflow(shipment.Shipment)
+	env.RegisterActivity(sa.BookShipment)
+	env.OnActivity(sa.UpdateShipmentStatus, mock.Anything, mock.Anything).Return(nil)
+
+	// The second shipment is dispatched before the cancellation, the first is only booked.
+	env.RegisterDelayedCallback(func() {
+		err := env.SignalWorkflowByID(
+			shipment.ShipmentWorkflowID("1234:2"),
+			shipment.ShipmentCarrierUpdateSignalName,
+			shipment.ShipmentCarrierUpdateSignal{Status: shipment.ShipmentStatusDispatched},
+		)
+		assert.NoError(t, err)
+	}, time.Second)
+
+	env.RegisterDelayedCallback(func() {
+		err := env.SignalWorkflowByID(
+			shipment.ShipmentWorkflowID("1234:2"),
+			shipment.ShipmentCarrierUpdateSignalName,
+			shipment.ShipmentCarrierUpdateSignal{Status: shipment.ShipmentStatusDelivered},
+		)
+		assert.NoError(t, err)
+	}, time.Minute)
+
+	orderInput := order.OrderInput{
+		ID:         "1234",
+		CustomerID: "1234",
+		Items: []*order.Item{
+			{SKU: "test1", Quantity: 1},
+			{SKU: "test2", Quantity: 3},
+		},
+	}
+
+	var cancelResult order.CancelOrderResult
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(order.CancelOrderUpdateName, "cancel", &testsuite.TestUpdateCallback{
+			OnReject: func(err error) {
+				assert.Fail(t, "cancel rejected", err)
+			},
+			OnAccept: func() {},
+			OnComplete: func(result interface{}, err error) {
+				assert.NoError(t, err)
+				cancelResult = *result.(*order.CancelOrderResult)
+			},
+		})
+	}, time.Second*2)
+
+	env.ExecuteWorkflow(
+		order.Order,
+		&orderInput,
+	)
+
+	var result order.OrderResult
+	err := env.GetWorkflowResult(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, order.OrderStatusCompleted, result.Status)
+
+	assert.Len(t, cancelResult.Fulfillments, 2)
+
+	c := cancelResult.Fulfillments[0]
+	assert.Equal(t, "1234:1", c.ID)
+	assert.Equal(t, order.CancellationOutcomeCancelled, c.Outcome)
+	assert.Equal(t, order.FulfillmentStatusCancelled, c.Status)
+	assert.Equal(t, order.PaymentStatusVoided, c.Payment.Status)
+
+	c = cancelResult.Fulfillments[1]
+	assert.Equal(t, "1234:2", c.ID)
+	assert.Equal(t, order.CancellationOutcomeDispatched, c.Outcome)
+	assert.Equal(t, order.PaymentStatusCaptured, c.Payment.Status)
+
+	assert.Equal(t, []*order.ReleaseItemsInput{
+		{OrderID: "1234", Items: []*order.Item{{SKU: "test1", Quantity: 1}}},
+	}, released)
+
+	// The dispatched fulfillment's payment is captured, the cancelled fulfillment's authorization is voided.
+	if assert.Len(t, captured, 1) && assert.Len(t, voided, 1) {
+		assert.NotEmpty(t, captured[0].ChargeIdempotencyKey)
+		assert.NotEmpty(t, voided[0].ChargeIdempotencyKey)
+		assert.NotEqual(t, captured[0].ChargeIdempotencyKey, voided[0].ChargeIdempotencyKey)
+	}
+}
+
+func TestOrderCancelBeforeProcessing(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	var a *order.Activities
+
+	env.OnActivity(a.ReserveItems, mock.Anything, mock.Anything).Return(reserveItems)
+	env.OnActivity(a.InsertOrder, mock.Anything, mock.Anything).Return(func(_ctx context.Context, _input *order.OrderStatusInsert) error {
+		return nil
+	})
+	env.OnActivity(a.UpdateOrderStatus, mock.Anything, mock.Anything).Return(func(_ctx context.Context, _input *order.OrderStatusUpdate) error {
+		return nil
+	})
+	env.OnActivity(a.ReleaseItems, mock.Anything, mock.Anything).Return(nil)
+
+	orderInput := order.OrderInput{
+		ID:         "1234",
+		CustomerID: "1234",
+		Items: []*order.Item{
+			{SKU: "Adidas", Quantity: 1},
+			{SKU: "test2", Quantity: 3},
+		},
+	}
+
+	var cancelResult order.CancelOrderResult
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(order.CancelOrderUpdateName, "cancel", &testsuite.TestUpdateCallback{
+			OnReject: func(err error) {
+				assert.Fail(t, "cancel rejected", err)
+			},
+			OnAccept: func() {},
+			OnComplete: func(result interface{}, err error) {
+				assert.NoError(t, err)
+				cancelResult = *result.(*order.CancelOrderResult)
+			},
+		})
+	}, time.Second)
+
+	env.ExecuteWorkflow(
+		order.Order,
+		&orderInput,
+	)
+
+	var result order.OrderResult
+	err := env.GetWorkflowResult(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, order.OrderStatusCancelled, result.Status)
+
+	assert.Len(t, cancelResult.Fulfillments, 2)
+	for _, c := range cancelResult.Fulfillments {
+		assert.Equal(t, order.CancellationOutcomeCancelled, c.Outcome)
+	}
+
+	env.AssertActivityNumberOfCalls(t, "Charge", 0)
+	env.AssertActivityNumberOfCalls(t, "ReleaseItems", 1)
+}
+
+func TestOrderCapturesPaymentWhenDispatched(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	var a *order.Activities
+
+	env.OnActivity(a.ReserveItems, mock.Anything, mock.Anything).Return(reserveItems)
+	env.OnActivity(a.InsertOrder, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(a.Charge, mock.Anything, mock.Anything).Return(&order.ChargeResult{Success: true, Total: money(1000)}, nil)
+	env.OnActivity(a.Capture, mock.Anything, mock.Anything).Return(&order.ChargeResult{}, nil).Once()
+	env.OnActivity(a.UpdateOrderStatus, mock.Anything, mock.Anything).Return(nil)
+	var sa *shipment.Activities
+	env.RegisterWorkflow(shipment.Shipment)
+	env.RegisterActivity(sa.BookShipment)
+	env.OnActivity(sa.UpdateShipmentStatus, mock.Anything, mock.Anything).Return(nil)
+
+	paymentStatus := func() string {
+		var status order.OrderStatus
+		v, err := env.QueryWorkflow(order.StatusQuery, nil)
+		assert.NoError(t, err)
+		assert.NoError(t, v.Get(&status))
+		return status.Fulfillments[0].Payment.Status
+	}
+
+	// The payment is only authorized until the carrier has the shipment.
+	env.RegisterDelayedCallback(func() {
+		assert.Equal(t, order.PaymentStatusAuthorized, paymentStatus())
+
+		err := env.SignalWorkflowByID(
+			shipment.ShipmentWorkflowID("1234:1"),
+			shipment.ShipmentCarrierUpdateSignalName,
+			shipment.ShipmentCarrierUpdateSignal{Status: shipment.ShipmentStatusDispatched},
+		)
+		assert.NoError(t, err)
+	}, time.Second)
+
+	env.RegisterDelayedCallback(func() {
+		assert.Equal(t, order.PaymentStatusCaptured, paymentStatus())
+
+		err := env.SignalWorkflowByID(
+			shipment.ShipmentWorkflowID("1234:1"),
+			shipment.ShipmentCarrierUpdateSignalName,
+			shipment.ShipmentCarrierUpdateSignal{Status: shipment.ShipmentStatusDelivered},
+		)
+		assert.NoError(t, err)
+	}, time.Minute)
+
+	orderInput := order.OrderInput{
+		ID:         "1234",
+		CustomerID: "1234",
+		Items: []*order.Item{
+			{SKU: "test1", Quantity: 1},
+		},
+	}
+
+	env.ExecuteWorkflow(
+		order.Order,
+		&orderInput,
+	)
+
+	var result order.OrderResult
+	err := env.GetWorkflowResult(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, order.OrderStatusCompleted, result.Status)
+	env.AssertExpectations(t)
+}
+
+func TestOrderRetriesDeclinedPayment(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	var a *order.Activities
+
+	env.OnActivity(a.ReserveItems, mock.Anything, mock.Anything).Return(reserveItems)
+	env.OnActivity(a.InsertOrder, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(a.UpdateOrderStatus, mock.Anything, mock.Anything).Return(nil)
+	var charges []time.Time
+	env.OnActivity(a.Charge, mock.Anything, mock.Anything).Return(func(_ctx context.Context, _input *order.ChargeInput) (*order.ChargeResult, error) {
+		charges = append(charges, env.Now())
+		// The first two attempts are declined.
+		return &order.ChargeResult{Success: len(charges) > 2, Total: money(1000)}, nil
+	})
+	env.OnActivity(a.Capture, mock.Anything, mock.Anything).Return(&order.ChargeResult{}, nil)
+	var notifications []*order.PaymentNotification
+	env.OnActivity(a.NotifyCustomer, mock.Anything, mock.Anything).Return(func(_ctx context.Context, input *order.PaymentNotification) error {
+		notifications = append(notifications, input)
+		return nil
+	})
+	env.OnWorkflow(shipment.Shipment, mock.Anything, mock.Anything).Return(&shipment.ShipmentResult{CourierReference: "test"}, nil)
+
+	orderInput := order.OrderInput{
+		ID:         "1234",
+		CustomerID: "1234",
+		Items: []*order.Item{
+			{SKU: "test1", Quantity: 1},
+		},
+		Dunning: &order.DunningPolicy{RetryIntervals: []string{"1h", "24h"}, MaxDays: 3},
+	}
+
+	env.ExecuteWorkflow(
+		order.Order,
+		&orderInput,
+	)
+
+	var result order.OrderResult
+	err := env.GetWorkflowResult(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, order.OrderStatusCompleted, result.Status)
+
+	if assert.Len(t, charges, 3) {
+		assert.Equal(t, time.Hour, charges[1].Sub(charges[0]).Round(time.Minute))
+		assert.Equal(t, 24*time.Hour, charges[2].Sub(charges[1]).Round(time.Minute))
+	}
+
+	if assert.Len(t, notifications, 2) {
+		for _, n := range notifications {
+			assert.Equal(t, order.PaymentNotificationDeclined, n.Kind)
+			assert.Equal(t, "1234:1", n.FulfillmentID)
+			assert.NotNil(t, n.NextRetryAt)
+		}
+	}
+
+	var status order.OrderStatus
+	v, err := env.QueryWorkflow(order.StatusQuery, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, v.Get(&status))
+
+	assert.Equal(t, order.FulfillmentStatusCompleted, status.Fulfillments[0].Status)
+	assert.Equal(t, int32(2), status.Fulfillments[0].Payment.Retries)
+}
+
+func TestOrderRetriesPaymentWithUpdatedMethod(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	var a *order.Activities
+
+	env.OnActivity(a.ReserveItems, mock.Anything, mock.Anything).Return(reserveItems)
+	env.OnActivity(a.InsertOrder, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(a.UpdateOrderStatus, mock.Anything, mock.Anything).Return(nil)
+	var charges []*order.ChargeInput
+	env.OnActivity(a.Charge, mock.Anything, mock.Anything).Return(func(_ctx context.Context, input *order.ChargeInput) (*order.ChargeResult, error) {
+		charges = append(charges, input)
+		return &order.ChargeResult{Success: input.PaymentMethodID == "card2", Total: money(1000)}, nil
+	})
+	env.OnActivity(a.Capture, mock.Anything, mock.Anything).Return(&order.ChargeResult{}, nil)
+	env.OnActivity(a.NotifyCustomer, mock.Anything, mock.Anything).Return(nil)
+	env.OnWorkflow(shipment.Shipment, mock.Anything, mock.Anything).Return(&shipment.ShipmentResult{CourierReference: "test"}, nil)
+
+	// Updating the payment method before any payment has been declined is rejected.
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(order.UpdatePaymentMethodUpdateName, "early", &testsuite.TestUpdateCallback{
+			OnReject: func(err error) {
+				assert.ErrorContains(t, err, "no payment is waiting to be retried")
+			},
+			OnAccept:   func() { assert.Fail(t, "early update accepted") },
+			OnComplete: func(interface{}, error) {},
+		}, order.PaymentMethodUpdate{PaymentMethodID: "card2"})
+	}, 0)
+
+	var updateResult order.PaymentMethodUpdateResult
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(order.UpdatePaymentMethodUpdateName, "update", &testsuite.TestUpdateCallback{
+			OnReject: func(err error) {
+				assert.Fail(t, "update rejected", err)
+			},
+			OnAccept: func() {},
+			OnComplete: func(result interface{}, err error) {
+				assert.NoError(t, err)
+				updateResult = *result.(*order.PaymentMethodUpdateResult)
+			},
+		}, order.PaymentMethodUpdate{PaymentMethodID: "card2"})
+	}, time.Minute)
+
+	orderInput := order.OrderInput{
+		ID:         "1234",
+		CustomerID: "1234",
+		Items: []*order.Item{
+			{SKU: "test1", Quantity: 1},
+		},
+		PaymentMethodID: "card1",
+		Dunning:         &order.DunningPolicy{},
+	}
+
+	start := env.Now()
+
+	env.ExecuteWorkflow(
+		order.Order,
+		&orderInput,
+	)
+
+	var result order.OrderResult
+	err := env.GetWorkflowResult(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, order.OrderStatusCompleted, result.Status)
+
+	assert.Equal(t, []string{"1234:1"}, updateResult.Fulfillments)
+
+	// The retry happens as soon as the payment method is updated, rather than after the scheduled hour.
+	if assert.Len(t, charges, 2) {
+		assert.Equal(t, "card1", charges[0].PaymentMethodID)
+		assert.Equal(t, "card2", charges[1].PaymentMethodID)
+	}
+	assert.Less(t, env.Now().Sub(start), time.Hour)
+}
+
+func TestOrderFailsWhenDunningWindowCloses(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	var a *order.Activities
+
+	env.OnActivity(a.ReserveItems, mock.Anything, mock.Anything).Return(reserveItems)
+	env.OnActivity(a.InsertOrder, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(a.UpdateOrderStatus, mock.Anything, mock.Anything).Return(nil)
+	env.OnActivity(a.Charge, mock.Anything, mock.Anything).Return(&order.ChargeResult{Success: false, Total: money(1000)}, nil)
+	var notifications []string
+	env.OnActivity(a.NotifyCustomer, mock.Anything, mock.Anything).Return(func(_ctx context.Context, input *order.PaymentNotification) error {
+		notifications = append(notifications, input.Kind)
+		return nil
+	})
+	var released []*order.ReleaseItemsInput
+	env.OnActivity(a.ReleaseItems, mock.Anything, mock.Anything).Return(func(_ctx context.Context, input *order.ReleaseItemsInput) error {
+		released = append(released, input)
+		return nil
+	})
+
+	orderInput := order.OrderInput{
+		ID:         "1234",
+		CustomerID: "1234",
+		Items: []*order.Item{
+			{SKU: "test1", Quantity: 1},
+		},
+		Dunning: &order.DunningPolicy{RetryIntervals: []string{"24h"}, MaxDays: 2},
+	}
+
+	start := env.Now()
+
+	env.ExecuteWorkflow(
+		order.Order,
+		&orderInput,
+	)
+
+	var result order.OrderResult
+	err := env.GetWorkflowResult(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, order.OrderStatusFailed, result.Status)
+
+	// Retries at one and two days, then the window closes.
+	env.AssertActivityNumberOfCalls(t, "Charge", 3)
+	assert.Equal(t, []string{
+		order.PaymentNotificationDeclined,
+		order.PaymentNotificationDeclined,
+		order.PaymentNotificationFailed,
+	}, notifications)
+	assert.Equal(t, 48*time.Hour, env.Now().Sub(start).Round(time.Hour))
+
+	assert.Equal(t, []*order.ReleaseItemsInput{
+		{OrderID: "1234", Items: []*order.Item{{SKU: "test1", Quantity: 1}}},
+	}, released)
+}
+
+func TestOrderRejectsInvalidDunningPolicy(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+
+	orderInput := order.OrderInput{
+		ID:         "1234",
+		CustomerID: "1234",
+		Items: []*order.Item{
+			{SKU: "test1", Quantity: 1},
+		},
+		Dunning: &order.DunningPolicy{RetryIntervals: []string{"tomorrow"}},
+	}
+
+	env.ExecuteWorkflow(
+		order.Order,
+		&orderInput,
+	)
+
+	err := env.GetWorkflowError()
+	assert.ErrorContains(t, err, "invalid dunning retry interval")
+}
+
+func TestOrderPaysWithLoyaltyPoints(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	var a *order.Activities
+
+	env.OnActivity(a.ReserveItems, mock.Anything, mock.Anything).Return(reserveItems)
+	env.OnActivity(a.InsertOrder, mock.Anything, mock.Anything).Return(func(_ctx context.Context, _input *order.OrderStatusInsert) error {
+		return nil
+	})
+	env.OnActivity(a.RedeemLoyaltyPoints, mock.Anything, mock.Anything).Return(func(_ctx context.Context, input *order.LoyaltyPointsInput) (*order.LoyaltyRedemptionResult, error) {
+		return &order.LoyaltyRedemptionResult{PointValue: money(2)}, nil
+	})
+	credits := map[string]currency.Money{}
+	env.OnActivity(a.Charge, mock.Anything, mock.Anything).Return(func(_ctx context.Context, input *order.ChargeInput) (*order.ChargeResult, error) {
+		credits[input.Reference] = input.LoyaltyCredit
+		// The first fulfillment's payment is declined, so its points are left for the second, which needs 6.41 of them.
+		if input.Reference == "1234:1" {
+			return &order.ChargeResult{Success: false}, nil
+		}
+		return &order.ChargeResult{Success: true, LoyaltyCredit: money(min(input.LoyaltyCredit.Amount, 641))}, nil
+	})
+	env.OnActivity(a.Capture, mock.Anything, mock.Anything).Return(&order.ChargeResult{}, nil)
+	env.OnActivity(a.ReleaseItems, mock.Anything, mock.Anything).Return(nil)
+	var accrued []string
+	env.OnActivity(a.AccrueLoyaltyPoints, mock.Anything, mock.Anything).Return(func(_ctx context.Context, input *order.LoyaltyAccrualInput) (int64, error) {
+		accrued = append(accrued, input.FulfillmentID)
+		return 64, nil
+	})
+	var returned []*order.LoyaltyPointsInput
+	env.OnActivity(a.ReturnLoyaltyPoints, mock.Anything, mock.Anything).Return(func(_ctx context.Context, input *order.LoyaltyPointsInput) error {
+		returned = append(returned, input)
+		return nil
+	})
+	env.OnActivity(a.UpdateOrderStatus, mock.Anything, mock.Anything).Return(func(_ctx context.Context, _input *order.OrderStatusUpdate) error {
+		return nil
+	})
+	env.OnWorkflow(shipment.Shipment, mock.Anything, mock.Anything).Return(func(_ctx workflow.Context, _input *shipment.ShipmentInput) (*shipment.ShipmentResult, error) {
+		return &shipment.ShipmentResult{CourierReference: "test"}, nil
+	})
+
+	orderInput := order.OrderInput{
+		ID:         "1234",
+		CustomerID: "1234",
+		Items: []*order.Item{
+			{SKU: "test1", Quantity: 1},
+			{SKU: "test2", Quantity: 3},
+		},
+		LoyaltyPoints: 500,
+	}
+
+	env.ExecuteWorkflow(
+		order.Order,
+		&orderInput,
+	)
+
+	var result order.OrderResult
+	err := env.GetWorkflowResult(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, order.OrderStatusCompleted, result.Status)
+
+	assert.Equal(t, map[string]currency.Money{"1234:1": money(1000), "1234:2": money(1000)}, credits)
+
+	var status order.OrderStatus
+	v, err := env.QueryWorkflow(order.StatusQuery, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, v.Get(&status))
+
+	// Part of a point used counts as a whole one.
+	assert.Zero(t, status.Fulfillments[0].Payment.LoyaltyPoints)
+	assert.Equal(t, int64(321), status.Fulfillments[1].Payment.LoyaltyPoints)
+
+	// Only the completed fulfillment earns points.
+	assert.Equal(t, []string{"1234:2"}, accrued)
+
+	assert.Equal(t, []*order.LoyaltyPointsInput{{CustomerID: "1234", OrderID: "1234", Points: 179}}, returned)
+}
+
+func TestOrderFailsWhenLoyaltyPointsAreRefused(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	var a *order.Activities
+
+	env.OnActivity(a.InsertOrder, mock.Anything, mock.Anything).Return(func(_ctx context.Context, _input *order.OrderStatusInsert) error {
+		return nil
+	})
+	env.OnActivity(a.RedeemLoyaltyPoints, mock.Anything, mock.Anything).Return(nil, temporal.NewNonRetryableApplicationError("loyalty points rejected", "LoyaltyPointsRejected", nil))
+	env.OnActivity(a.UpdateOrderStatus, mock.Anything, mock.Anything).Return(func(_ctx context.Context, _input *order.OrderStatusUpdate) error {
+		return nil
+	})
+
+	orderInput := order.OrderInput{
+		ID:         "1234",
+		CustomerID: "1234",
+		Items: []*order.Item{
+			{SKU: "test1", Quantity: 1},
+		},
+		LoyaltyPoints: 500,
+	}
+
+	env.ExecuteWorkflow(
+		order.Order,
+		&orderInput,
+	)
+
+	var result order.OrderResult
+	err := env.GetWorkflowResult(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, order.OrderStatusFailed, result.Status)
+
+	env.AssertNotCalled(t, "ReserveItems", mock.Anything, mock.Anything)
+}
+
+func TestOrderReturnsLoyaltyPointsWhenItFails(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	var a *order.Activities
+
+	env.OnActivity(a.InsertOrder, mock.Anything, mock.Anything).Return(func(_ctx context.Context, _input *order.OrderStatusInsert) error {
+		return nil
+	})
+	env.OnActivity(a.RedeemLoyaltyPoints, mock.Anything, mock.Anything).Return(&order.LoyaltyRedemptionResult{PointValue: money(2)}, nil)
+	env.OnActivity(a.ReserveItems, mock.Anything, mock.Anything).Return(nil, temporal.NewNonRetryableApplicationError("inventory unavailable", "InventoryUnavailable", nil))
+	var returned []*order.LoyaltyPointsInput
+	env.OnActivity(a.ReturnLoyaltyPoints, mock.Anything, mock.Anything).Return(func(_ctx context.Context, input *order.LoyaltyPointsInput) error {
+		returned = append(returned, input)
+		return nil
+	})
+
+	orderInput := order.OrderInput{
+		ID:         "1234",
+		CustomerID: "1234",
+		Items: []*order.Item{
+			{SKU: "test1", Quantity: 1},
+		},
+		LoyaltyPoints: 500,
+	}
+
+	env.ExecuteWorkflow(
+		order.Order,
+		&orderInput,
+	)
+
+	assert.ErrorContains(t, env.GetWorkflowError(), "inventory unavailable")
+	assert.Equal(t, []*order.LoyaltyPointsInput{{CustomerID: "1234", OrderID: "1234", Points: 500}}, returned)
+}
+
+func TestOrderWaitsForFraudReview(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	var a *order.Activities
+
+	env.OnActivity(a.ReserveItems, mock.Anything, mock.Anything).Return(reserveItems)
+	env.OnActivity(a.InsertOrder, mock.Anything, mock.Anything).Return(func(_ctx context.Context, _input *order.OrderStatusInsert) error {
+		return nil
+	})
+	// A manager approves the charge ten minutes after it is held.
+	start := env.Now()
+	env.OnActivity(a.Charge, mock.Anything, mock.Anything).Return(func(_ctx context.Context, _input *order.ChargeInput) (*order.ChargeResult, error) {
+		if env.Now().Sub(start) < 10*time.Minute {
+			return &order.ChargeResult{Status: billing.ChargeStatusReview, FraudReviewID: "review1", Total: money(1000)}, nil
+		}
+		return &order.ChargeResult{Success: true, Status: billing.ChargeStatusAuthorized, FraudReviewID: "review1", Total: money(1000)}, nil
+	})
+	env.OnActivity(a.Capture, mock.Anything, mock.Anything).Return(&order.ChargeResult{}, nil)
+	env.OnActivity(a.UpdateOrderStatus, mock.Anything, mock.Anything).Return(func(_ctx context.Context, _input *order.OrderStatusUpdate) error {
+		return nil
+	})
+	env.OnWorkflow(shipment.Shipment, mock.Anything, mock.Anything).Return(&shipment.ShipmentResult{CourierReference: "test"}, nil)
+
+	env.RegisterDelayedCallback(func() {
+		var status order.OrderStatus
+		v, err := env.QueryWorkflow(order.StatusQuery, nil)
+		assert.NoError(t, err)
+		assert.NoError(t, v.Get(&status))
+
+		if assert.Len(t, status.Fulfillments, 1) {
+			f := status.Fulfillments[0]
+			assert.Equal(t, order.FulfillmentStatusFraudReview, f.Status)
+			assert.Equal(t, order.PaymentStatusReview, f.Payment.Status)
+			assert.Equal(t, "review1", f.Payment.FraudReviewID)
+		}
+	}, 5*time.Minute)
+
+	env.ExecuteWorkflow(
+		order.Order,
+		&order.OrderInput{
+			ID:         "1234",
+			CustomerID: "1234",
+			Items:      []*order.Item{{SKU: "test1", Quantity: 1}},
+		},
+	)
+
+	var result order.OrderResult
+	assert.NoError(t, env.GetWorkflowResult(&result))
+	assert.Equal(t, order.OrderStatusCompleted, result.Status)
+	assert.GreaterOrEqual(t, env.Now().Sub(start), 10*time.Minute)
+}
+
+func TestOrderWaitsForQueuedPayment(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	var a *order.Activities
+
+	env.OnActivity(a.ReserveItems, mock.Anything, mock.Anything).Return(reserveItems)
+	env.OnActivity(a.InsertOrder, mock.Anything, mock.Anything).Return(func(_ctx context.Context, _input *order.OrderStatusInsert) error {
+		return nil
+	})
+	// The fraud check is down for maintenance for the first ten minutes.
+	start := env.Now()
+	env.OnActivity(a.Charge, mock.Anything, mock.Anything).Return(func(_ctx context.Context, _input *order.ChargeInput) (*order.ChargeResult, error) {
+		if env.Now().Sub(start) < 10*time.Minute {
+			return &order.ChargeResult{Status: billing.ChargeStatusQueued, MaintenanceReason: "Database upgrade", RetryAt: start.Add(10 * time.Minute)}, nil
+		}
+		return &order.ChargeResult{Success: true, Status: billing.ChargeStatusAuthorized, Total: money(1000)}, nil
+	})
+	env.OnActivity(a.Capture, mock.Anything, mock.Anything).Return(&order.ChargeResult{}, nil)
+	env.OnActivity(a.UpdateOrderStatus, mock.Anything, mock.Anything).Return(func(_ctx context.Context, _input *order.OrderStatusUpdate) error {
+		return nil
+	})
+	env.OnWorkflow(shipment.Shipment, mock.Anything, mock.Anything).Return(&shipment.ShipmentResult{CourierReference: "test"}, nil)
+
+	env.RegisterDelayedCallback(func() {
+		var status order.OrderStatus
+		v, err := env.QueryWorkflow(order.StatusQuery, nil)
+		assert.NoError(t, err)
+		assert.NoError(t, v.Get(&status))
+
+		if assert.Len(t, status.Fulfillments, 1) {
+			f := status.Fulfillments[0]
+			assert.Equal(t, order.FulfillmentStatusPaymentQueued, f.Status)
+			assert.Equal(t, order.PaymentStatusQueued, f.Payment.Status)
+			assert.Equal(t, "Database upgrade", f.Payment.MaintenanceReason)
+		}
+	}, 5*time.Minute)
+
+	env.ExecuteWorkflow(
+		order.Order,
+		&order.OrderInput{
+			ID:         "1234",
+			CustomerID: "1234",
+			Items:      []*order.Item{{SKU: "test1", Quantity: 1}},
+		},
+	)
+
+	var result order.OrderResult
+	assert.NoError(t, env.GetWorkflowResult(&result))
+	assert.Equal(t, order.OrderStatusCompleted, result.Status)
+	assert.GreaterOrEqual(t, env.Now().Sub(start), 10*time.Minute)
+}
+
+func TestOrderChargesOtherFulfillmentsDuringFraudReview(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	var a *order.Activities
+
+	env.OnActivity(a.ReserveItems, mock.Anything, mock.Anything).Return(reserveItems)
+	env.OnActivity(a.InsertOrder, mock.Anything, mock.Anything).Return(func(_ctx context.Context, _input *order.OrderStatusInsert) error {
+		return nil
+	})
+	env.OnActivity(a.RedeemLoyaltyPoints, mock.Anything, mock.Anything).Return(&order.LoyaltyRedemptionResult{PointValue: money(2)}, nil)
+	// The first fulfillment's charge is held for review for ten minutes, and uses 3.00 of the points.
+	start := env.Now()
+	credits := map[string]currency.Money{}
+	charged := map[string]time.Duration{}
+	env.OnActivity(a.Charge, mock.Anything, mock.Anything).Return(func(_ctx context.Context, input *order.ChargeInput) (*order.ChargeResult, error) {
+		credits[input.Reference] = input.LoyaltyCredit
+		if input.Reference == "1234:1" && env.Now().Sub(start) < 10*time.Minute {
+			return &order.ChargeResult{Status: billing.ChargeStatusReview, FraudReviewID: "review1", LoyaltyCredit: money(300)}, nil
+		}
+		if _, ok := charged[input.Reference]; !ok {
+			charged[input.Reference] = env.Now().Sub(start)
+		}
+		return &order.ChargeResult{Success: true, Status: billing.ChargeStatusAuthorized, LoyaltyCredit: money(min(input.LoyaltyCredit.Amount, 300))}, nil
+	})
+	env.OnActivity(a.Capture, mock.Anything, mock.Anything).Return(&order.ChargeResult{}, nil)
+	env.OnActivity(a.AccrueLoyaltyPoints, mock.Anything, mock.Anything).Return(int64(0), nil)
+	var returned []*order.LoyaltyPointsInput
+	env.OnActivity(a.ReturnLoyaltyPoints, mock.Anything, mock.Anything).Return(func(_ctx context.Context, input *order.LoyaltyPointsInput) error {
+		returned = append(returned, input)
+		return nil
+	})
+	env.OnActivity(a.UpdateOrderStatus, mock.Anything, mock.Anything).Return(func(_ctx context.Context, _input *order.OrderStatusUpdate) error {
+		return nil
+	})
+	env.OnWorkflow(shipment.Shipment, mock.Anything, mock.Anything).Return(&shipment.ShipmentResult{CourierReference: "test"}, nil)
+
+	env.ExecuteWorkflow(
+		order.Order,
+		&order.OrderInput{
+			ID:         "1234",
+			CustomerID: "1234",
+			Items: []*order.Item{
+				{SKU: "test1", Quantity: 1},
+				{SKU: "test2", Quantity: 3},
+			},
+			LoyaltyPoints: 500,
+		},
+	)
+
+	var result order.OrderResult
+	assert.NoError(t, env.GetWorkflowResult(&result))
+	assert.Equal(t, order.OrderStatusCompleted, result.Status)
+
+	// The second fulfillment is charged while the first is held, with the points the first did not use.
+	assert.Less(t, charged["1234:2"], 10*time.Minute)
+	assert.Equal(t, map[string]currency.Money{"1234:1": money(1000), "1234:2": money(700)}, credits)
+
+	var status order.OrderStatus
+	v, err := env.QueryWorkflow(order.StatusQuery, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, v.Get(&status))
+
+	assert.Equal(t, int64(150), status.Fulfillments[0].Payment.LoyaltyPoints)
+	assert.Equal(t, int64(150), status.Fulfillments[1].Payment.LoyaltyPoints)
+	assert.Equal(t, []*order.LoyaltyPointsInput{{CustomerID: "1234", OrderID: "1234", Points: 200}}, returned)
+}
+
+func TestOrderCancelDuringFraudReview(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	var a *order.Activities
+
+	env.OnActivity(a.ReserveItems, mock.Anything, mock.Anything).Return(reserveItems)
+	env.OnActivity(a.InsertOrder, mock.Anything, mock.Anything).Return(func(_ctx context.Context, _input *order.OrderStatusInsert) error {
+		return nil
+	})
+	env.OnActivity(a.Charge, mock.Anything, mock.Anything).Return(&order.ChargeResult{Status: billing.ChargeStatusReview, FraudReviewID: "review1"}, nil)
+	env.OnActivity(a.Void, mock.Anything, mock.Anything).Return(&order.ChargeResult{Status: billing.ChargeStatusVoided}, nil).Once()
+	env.OnActivity(a.ReleaseItems, mock.Anything, mock.Anything).Return(nil).Once()
+	env.OnActivity(a.UpdateOrderStatus, mock.Anything, mock.Anything).Return(func(_ctx context.Context, _input *order.OrderStatusUpdate) error {
+		return nil
+	})
+
+	var cancelResult order.CancelOrderResult
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(order.CancelOrderUpdateName, "cancel", &testsuite.TestUpdateCallback{
+			OnReject: func(err error) {
+				assert.Fail(t, "cancel rejected", err)
+			},
+			OnAccept: func() {},
+			OnComplete: func(result interface{}, err error) {
+				assert.NoError(t, err)
+				cancelResult = *result.(*order.CancelOrderResult)
+			},
+		})
+	}, time.Hour)
+
+	env.ExecuteWorkflow(
+		order.Order,
+		&order.OrderInput{
+			ID:         "1234",
+			CustomerID: "1234",
+			Items:      []*order.Item{{SKU: "test1", Quantity: 1}},
+		},
+	)
+
+	var result order.OrderResult
+	assert.NoError(t, env.GetWorkflowResult(&result))
+	assert.Equal(t, order.OrderStatusCancelled, result.Status)
+
+	// The held payment is withdrawn from review.
+	if assert.Len(t, cancelResult.Fulfillments, 1) {
+		assert.Equal(t, order.FulfillmentStatusCancelled, cancelResult.Fulfillments[0].Status)
+		assert.Equal(t, order.PaymentStatusVoided, cancelResult.Fulfillments[0].Payment.Status)
+	}
+	env.AssertExpectations(t)
 }
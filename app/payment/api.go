@@ -0,0 +1,320 @@
+package payment
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/temporalio/reference-app-orders-go/app/db"
+)
+
+const (
+	// MethodTypeCard is the type of a payment card, which has an expiry date.
+	MethodTypeCard = "card"
+
+	// MethodTypeBankAccount is the type of a bank account debited directly.
+	MethodTypeBankAccount = "bankAccount"
+)
+
+// MethodTypes is the list of known payment method types.
+var MethodTypes = []string{MethodTypeCard, MethodTypeBankAccount}
+
+// Method is a customer's stored payment method.
+// The card or account details are held by the payment processor, which identifies them by the token.
+type Method struct {
+	ID         string `json:"id"`
+	CustomerID string `json:"customerId"`
+	Type       string `json:"type"`
+	Token      string `json:"token"`
+	// ExpiryMonth and ExpiryYear are the last month the card can be used in. Only cards expire.
+	ExpiryMonth int32 `json:"expiryMonth,omitempty"`
+	ExpiryYear  int32 `json:"expiryYear,omitempty"`
+	// Default is true for the method charged when an order does not say which method to use.
+	Default   bool      `json:"default"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Expired returns true if the method can no longer be used at the given time.
+func (m Method) Expired(at time.Time) bool {
+	if m.Type != MethodTypeCard {
+		return false
+	}
+
+	// A card is valid until the end of its expiry month.
+	expires := time.Date(int(m.ExpiryYear), time.Month(m.ExpiryMonth)+1, 1, 0, 0, 0, 0, time.UTC)
+
+	return !at.Before(expires)
+}
+
+type handlers struct {
+	db     db.DB
+	logger *slog.Logger
+}
+
+// Router implements the http.Handler interface for the Payment Methods API
+func Router(db db.DB, logger *slog.Logger) http.Handler {
+	r := http.NewServeMux()
+
+	h := handlers{db: db, logger: logger}
+
+	r.HandleFunc("GET /customers/{id}/methods", h.handleListMethods)
+	r.HandleFunc("POST /customers/{id}/methods", h.handleCreateMethod)
+	r.HandleFunc("GET /customers/{id}/methods/default", h.handleGetDefaultMethod)
+	r.HandleFunc("GET /methods/{id}", h.handleGetMethod)
+	r.HandleFunc("POST /methods/{id}", h.handleUpdateMethod)
+	r.HandleFunc("DELETE /methods/{id}", h.handleDeleteMethod)
+
+	return r
+}
+
+func (h *handlers) handleListMethods(w http.ResponseWriter, r *http.Request) {
+	methods := []db.PaymentMethod{}
+
+	err := h.db.GetCustomerPaymentMethods(r.Context(), r.PathValue("id"), &methods)
+	if err != nil {
+		h.logger.Error("Failed to list payment methods", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	list := make([]Method, len(methods))
+	for i, m := range methods {
+		list[i] = methodFromDB(m)
+	}
+
+	h.encode(w, list)
+}
+
+func (h *handlers) handleCreateMethod(w http.ResponseWriter, r *http.Request) {
+	customerID := r.PathValue("id")
+
+	var input Method
+
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		h.logger.Error("Failed to decode payment method", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if input.CustomerID != "" && input.CustomerID != customerID {
+		http.Error(w, "customerId does not match the customer", http.StatusBadRequest)
+		return
+	}
+	input.CustomerID = customerID
+
+	if input.ID == "" {
+		input.ID = uuid.NewString()
+	}
+
+	if err := validateMethod(input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.db.GetPaymentMethod(r.Context(), input.ID, &db.PaymentMethod{})
+	if err == nil {
+		http.Error(w, fmt.Sprintf("payment method %s already exists", input.ID), http.StatusConflict)
+		return
+	}
+	if !errors.Is(err, db.ErrPaymentMethodNotFound) {
+		h.logger.Error("Failed to get payment method", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var existing []db.PaymentMethod
+	if err := h.db.GetCustomerPaymentMethods(r.Context(), customerID, &existing); err != nil {
+		h.logger.Error("Failed to list payment methods", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// A customer's first payment method is always their default.
+	if !slices.ContainsFunc(existing, func(m db.PaymentMethod) bool { return m.IsDefault }) {
+		input.Default = true
+	}
+	input.CreatedAt = time.Now().UTC()
+
+	method := methodToDB(input)
+
+	err = h.db.UpsertPaymentMethod(r.Context(), &method)
+	if err != nil {
+		h.logger.Error("Failed to store payment method", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	if err := json.NewEncoder(w).Encode(input); err != nil {
+		h.logger.Error("Failed to encode payment method", "error", err)
+	}
+}
+
+func (h *handlers) handleGetDefaultMethod(w http.ResponseWriter, r *http.Request) {
+	var methods []db.PaymentMethod
+
+	err := h.db.GetCustomerPaymentMethods(r.Context(), r.PathValue("id"), &methods)
+	if err != nil {
+		h.logger.Error("Failed to list payment methods", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	i := slices.IndexFunc(methods, func(m db.PaymentMethod) bool { return m.IsDefault })
+	if i < 0 {
+		http.Error(w, db.ErrPaymentMethodNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	h.encode(w, methodFromDB(methods[i]))
+}
+
+func (h *handlers) handleGetMethod(w http.ResponseWriter, r *http.Request) {
+	method, ok := h.getMethod(w, r)
+	if !ok {
+		return
+	}
+
+	h.encode(w, methodFromDB(method))
+}
+
+// handleUpdateMethod replaces the details of a payment method. It cannot be moved to another customer, and a
+// customer's default method can only be changed by making another method the default.
+func (h *handlers) handleUpdateMethod(w http.ResponseWriter, r *http.Request) {
+	existing, ok := h.getMethod(w, r)
+	if !ok {
+		return
+	}
+
+	var input Method
+
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		h.logger.Error("Failed to decode payment method", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if input.CustomerID != "" && input.CustomerID != existing.CustomerID {
+		http.Error(w, "payment methods cannot be moved to another customer", http.StatusBadRequest)
+		return
+	}
+
+	input.ID = existing.ID
+	input.CustomerID = existing.CustomerID
+	input.CreatedAt = existing.CreatedAt
+	input.Default = input.Default || existing.IsDefault
+
+	if err := validateMethod(input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	method := methodToDB(input)
+
+	err = h.db.UpsertPaymentMethod(r.Context(), &method)
+	if err != nil {
+		h.logger.Error("Failed to store payment method", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.encode(w, input)
+}
+
+func (h *handlers) handleDeleteMethod(w http.ResponseWriter, r *http.Request) {
+	err := h.db.DeletePaymentMethod(r.Context(), r.PathValue("id"))
+	if err != nil {
+		if errors.Is(err, db.ErrPaymentMethodNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			h.logger.Error("Failed to delete payment method", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// getMethod looks up the payment method named in the request path, writing an error response if it cannot.
+func (h *handlers) getMethod(w http.ResponseWriter, r *http.Request) (db.PaymentMethod, bool) {
+	var method db.PaymentMethod
+
+	err := h.db.GetPaymentMethod(r.Context(), r.PathValue("id"), &method)
+	if err != nil {
+		if errors.Is(err, db.ErrPaymentMethodNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			h.logger.Error("Failed to get payment method", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return method, false
+	}
+
+	return method, true
+}
+
+func (h *handlers) encode(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.logger.Error("Failed to encode payment method", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func validateMethod(m Method) error {
+	if m.Token == "" {
+		return fmt.Errorf("token is required")
+	}
+	if !slices.Contains(MethodTypes, m.Type) {
+		return fmt.Errorf("unknown payment method type: %s", m.Type)
+	}
+	if m.Type == MethodTypeCard {
+		if m.ExpiryMonth < 1 || m.ExpiryMonth > 12 {
+			return fmt.Errorf("expiryMonth must be between 1 and 12")
+		}
+		if m.ExpiryYear < 2000 {
+			return fmt.Errorf("expiryYear must be a four digit year")
+		}
+	} else if m.ExpiryMonth != 0 || m.ExpiryYear != 0 {
+		return fmt.Errorf("only cards have an expiry date")
+	}
+
+	return nil
+}
+
+func methodFromDB(m db.PaymentMethod) Method {
+	return Method{
+		ID:          m.ID,
+		CustomerID:  m.CustomerID,
+		Type:        m.Type,
+		Token:       m.Token,
+		ExpiryMonth: m.ExpiryMonth,
+		ExpiryYear:  m.ExpiryYear,
+		Default:     m.IsDefault,
+		CreatedAt:   m.CreatedAt,
+	}
+}
+
+func methodToDB(m Method) db.PaymentMethod {
+	return db.PaymentMethod{
+		ID:          m.ID,
+		CustomerID:  m.CustomerID,
+		Type:        m.Type,
+		Token:       m.Token,
+		ExpiryMonth: m.ExpiryMonth,
+		ExpiryYear:  m.ExpiryYear,
+		IsDefault:   m.Default,
+		CreatedAt:   m.CreatedAt,
+	}
+}
@@ -0,0 +1,142 @@
+package payment_test
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/temporalio/reference-app-orders-go/app/db"
+	"github.com/temporalio/reference-app-orders-go/app/payment"
+)
+
+func newRouter(t *testing.T) http.Handler {
+	store := db.NewSQLiteDB(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, store.Connect(context.Background()))
+	require.NoError(t, store.Setup())
+	t.Cleanup(func() { store.Close() })
+
+	return payment.Router(store, slog.Default())
+}
+
+func do(t *testing.T, r http.Handler, method string, path string, body string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, path, strings.NewReader(body))
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	return rr
+}
+
+func decode[T any](t *testing.T, rr *httptest.ResponseRecorder) T {
+	var v T
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&v))
+	return v
+}
+
+func TestPaymentMethodLifecycle(t *testing.T) {
+	r := newRouter(t)
+
+	rr := do(t, r, "POST", "/customers/customer1/methods", `{"id":"card1","type":"card","token":"tok_visa","expiryMonth":12,"expiryYear":2030}`)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+	// The first method is the customer's default.
+	card1 := decode[payment.Method](t, rr)
+	assert.Equal(t, "customer1", card1.CustomerID)
+	assert.True(t, card1.Default)
+
+	rr = do(t, r, "POST", "/customers/customer1/methods", `{"type":"bankAccount","token":"tok_bank"}`)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+
+	bank := decode[payment.Method](t, rr)
+	assert.NotEmpty(t, bank.ID)
+	assert.False(t, bank.Default)
+
+	rr = do(t, r, "POST", "/customers/customer1/methods", `{"id":"card1","type":"card","token":"tok_visa","expiryMonth":12,"expiryYear":2030}`)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	rr = do(t, r, "GET", "/customers/customer1/methods/default", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "card1", decode[payment.Method](t, rr).ID)
+
+	// Making another method the default replaces the previous default.
+	rr = do(t, r, "POST", "/methods/"+bank.ID, `{"type":"bankAccount","token":"tok_bank","default":true}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = do(t, r, "GET", "/customers/customer1/methods", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	methods := decode[[]payment.Method](t, rr)
+	require.Len(t, methods, 2)
+	assert.Equal(t, "card1", methods[0].ID)
+	assert.False(t, methods[0].Default)
+	assert.Equal(t, bank.ID, methods[1].ID)
+	assert.True(t, methods[1].Default)
+
+	// Updating the card keeps it where it is.
+	rr = do(t, r, "POST", "/methods/card1", `{"type":"card","token":"tok_visa","expiryMonth":1,"expiryYear":2031}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = do(t, r, "GET", "/methods/card1", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	card1 = decode[payment.Method](t, rr)
+	assert.Equal(t, int32(1), card1.ExpiryMonth)
+	assert.Equal(t, int32(2031), card1.ExpiryYear)
+	assert.False(t, card1.Default)
+
+	rr = do(t, r, "POST", "/methods/card1", `{"customerId":"customer2","type":"card","token":"tok_visa","expiryMonth":1,"expiryYear":2031}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = do(t, r, "DELETE", "/methods/"+bank.ID, "")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	rr = do(t, r, "DELETE", "/methods/"+bank.ID, "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = do(t, r, "GET", "/customers/customer1/methods/default", "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = do(t, r, "GET", "/customers/customer2/methods", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, decode[[]payment.Method](t, rr))
+}
+
+func TestRejectsInvalidPaymentMethod(t *testing.T) {
+	r := newRouter(t)
+
+	for _, body := range []string{
+		`{"type":"card","expiryMonth":12,"expiryYear":2030}`,
+		`{"type":"cash","token":"tok"}`,
+		`{"type":"card","token":"tok_visa"}`,
+		`{"type":"card","token":"tok_visa","expiryMonth":13,"expiryYear":2030}`,
+		`{"type":"card","token":"tok_visa","expiryMonth":12,"expiryYear":30}`,
+		`{"type":"bankAccount","token":"tok_bank","expiryMonth":12,"expiryYear":2030}`,
+		`{"customerId":"customer2","type":"bankAccount","token":"tok_bank"}`,
+	} {
+		rr := do(t, r, "POST", "/customers/customer1/methods", body)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, body)
+	}
+}
+
+func TestMethodExpired(t *testing.T) {
+	card := payment.Method{Type: payment.MethodTypeCard, ExpiryMonth: 6, ExpiryYear: 2024}
+
+	assert.False(t, card.Expired(time.Date(2024, 6, 30, 23, 59, 0, 0, time.UTC)))
+	assert.True(t, card.Expired(time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)))
+
+	card.ExpiryMonth = 12
+	assert.False(t, card.Expired(time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC)))
+	assert.True(t, card.Expired(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+	bank := payment.Method{Type: payment.MethodTypeBankAccount}
+	assert.False(t, bank.Expired(time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)))
+}
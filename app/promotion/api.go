@@ -0,0 +1,265 @@
+package promotion
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/temporalio/reference-app-orders-go/app/currency"
+	"github.com/temporalio/reference-app-orders-go/app/db"
+)
+
+// Redemption is the use of a promotion on an invoice.
+type Redemption struct {
+	Code       string `json:"code"`
+	CustomerID string `json:"customerId"`
+	// Reference is the invoice the promotion was used on. A promotion is redeemed at most once per invoice.
+	Reference  string    `json:"reference"`
+	RedeemedAt time.Time `json:"redeemedAt"`
+}
+
+type handlers struct {
+	db     db.DB
+	logger *slog.Logger
+}
+
+// Router implements the http.Handler interface for the Promotions API
+func Router(db db.DB, logger *slog.Logger) http.Handler {
+	r := http.NewServeMux()
+
+	h := handlers{db: db, logger: logger}
+
+	r.HandleFunc("GET /coupons", h.handleListPromotions)
+	r.HandleFunc("POST /coupons", h.handleUpsertPromotion)
+	r.HandleFunc("GET /coupons/{code}", h.handleGetPromotion)
+	r.HandleFunc("DELETE /coupons/{code}", h.handleDeletePromotion)
+	r.HandleFunc("GET /coupons/{code}/redemptions", h.handleListRedemptions)
+	r.HandleFunc("POST /coupons/{code}/redemptions", h.handleRedeem)
+	r.HandleFunc("DELETE /coupons/{code}/redemptions/{reference}", h.handleReleaseRedemption)
+
+	return r
+}
+
+func (h *handlers) handleListPromotions(w http.ResponseWriter, r *http.Request) {
+	promotions := []db.Promotion{}
+
+	err := h.db.GetPromotions(r.Context(), &promotions)
+	if err != nil {
+		h.logger.Error("Failed to list promotions", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	list := make([]Promotion, len(promotions))
+	for i, p := range promotions {
+		list[i] = promotionFromDB(p)
+	}
+
+	h.encode(w, list)
+}
+
+func (h *handlers) handleUpsertPromotion(w http.ResponseWriter, r *http.Request) {
+	var input Promotion
+
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		h.logger.Error("Failed to decode promotion", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := input.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	promotion := promotionToDB(input)
+
+	err = h.db.UpsertPromotion(r.Context(), &promotion)
+	if err != nil {
+		h.logger.Error("Failed to store promotion", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.encode(w, input)
+}
+
+func (h *handlers) handleGetPromotion(w http.ResponseWriter, r *http.Request) {
+	promotion, ok := h.getPromotion(w, r)
+	if !ok {
+		return
+	}
+
+	h.encode(w, promotionFromDB(promotion))
+}
+
+func (h *handlers) handleDeletePromotion(w http.ResponseWriter, r *http.Request) {
+	err := h.db.DeletePromotion(r.Context(), r.PathValue("code"))
+	if err != nil {
+		if errors.Is(err, db.ErrPromotionNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			h.logger.Error("Failed to delete promotion", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *handlers) handleListRedemptions(w http.ResponseWriter, r *http.Request) {
+	redemptions := []db.PromotionRedemption{}
+
+	err := h.db.GetPromotionRedemptions(r.Context(), r.PathValue("code"), &redemptions)
+	if err != nil {
+		h.logger.Error("Failed to list promotion redemptions", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	list := make([]Redemption, len(redemptions))
+	for i, rd := range redemptions {
+		list[i] = Redemption(rd)
+	}
+
+	h.encode(w, list)
+}
+
+// handleRedeem records the use of a promotion on an invoice. Redeeming a promotion again on the same invoice
+// succeeds without counting it twice, so callers may safely retry. It responds with a conflict if the promotion
+// has expired or the customer has used up their allowance.
+func (h *handlers) handleRedeem(w http.ResponseWriter, r *http.Request) {
+	promotion, ok := h.getPromotion(w, r)
+	if !ok {
+		return
+	}
+
+	var input Redemption
+
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		h.logger.Error("Failed to decode promotion redemption", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if input.CustomerID == "" || input.Reference == "" {
+		http.Error(w, "customerId and reference are required", http.StatusBadRequest)
+		return
+	}
+
+	input.Code = promotion.Code
+	input.RedeemedAt = time.Now().UTC()
+
+	var existing []db.PromotionRedemption
+	if err := h.db.GetPromotionRedemptions(r.Context(), promotion.Code, &existing); err != nil {
+		h.logger.Error("Failed to list promotion redemptions", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, rd := range existing {
+		if rd.Reference == input.Reference {
+			h.encode(w, Redemption(rd))
+			return
+		}
+	}
+
+	if promotionFromDB(promotion).Expired(input.RedeemedAt) {
+		http.Error(w, fmt.Sprintf("promotion %s has expired", promotion.Code), http.StatusConflict)
+		return
+	}
+
+	redemption := db.PromotionRedemption(input)
+
+	err = h.db.RedeemPromotion(r.Context(), &redemption, promotion.LimitPerCustomer)
+	if err != nil {
+		if errors.Is(err, db.ErrPromotionLimitReached) {
+			http.Error(w, err.Error(), http.StatusConflict)
+		} else {
+			h.logger.Error("Failed to redeem promotion", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.encode(w, input)
+}
+
+// handleReleaseRedemption gives back the use of a promotion on an invoice which was never paid, so that it no longer
+// counts towards the customer's limit.
+func (h *handlers) handleReleaseRedemption(w http.ResponseWriter, r *http.Request) {
+	err := h.db.DeletePromotionRedemption(r.Context(), r.PathValue("code"), r.PathValue("reference"))
+	if err != nil {
+		if errors.Is(err, db.ErrPromotionRedemptionNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			h.logger.Error("Failed to release promotion redemption", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// getPromotion looks up the promotion named in the request path, writing an error response if it cannot.
+func (h *handlers) getPromotion(w http.ResponseWriter, r *http.Request) (db.Promotion, bool) {
+	var promotion db.Promotion
+
+	err := h.db.GetPromotion(r.Context(), r.PathValue("code"), &promotion)
+	if err != nil {
+		if errors.Is(err, db.ErrPromotionNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			h.logger.Error("Failed to get promotion", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return promotion, false
+	}
+
+	return promotion, true
+}
+
+func (h *handlers) encode(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.logger.Error("Failed to encode promotion", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func promotionFromDB(p db.Promotion) Promotion {
+	return Promotion{
+		Code:             p.Code,
+		Description:      p.Description,
+		Type:             p.Type,
+		Rate:             p.Rate,
+		Amount:           currency.Money{Amount: p.Amount},
+		SKUs:             p.SKUs,
+		BuyQuantity:      p.BuyQuantity,
+		GetQuantity:      p.GetQuantity,
+		LimitPerCustomer: p.LimitPerCustomer,
+		ExpiresAt:        p.ExpiresAt.UTC(),
+	}
+}
+
+func promotionToDB(p Promotion) db.Promotion {
+	return db.Promotion{
+		Code:             p.Code,
+		Description:      p.Description,
+		Type:             p.Type,
+		Rate:             p.Rate,
+		Amount:           p.Amount.Amount,
+		SKUs:             p.SKUs,
+		BuyQuantity:      p.BuyQuantity,
+		GetQuantity:      p.GetQuantity,
+		LimitPerCustomer: p.LimitPerCustomer,
+		ExpiresAt:        p.ExpiresAt,
+	}
+}
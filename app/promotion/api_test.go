@@ -0,0 +1,140 @@
+package promotion_test
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/temporalio/reference-app-orders-go/app/db"
+	"github.com/temporalio/reference-app-orders-go/app/promotion"
+)
+
+func newRouter(t *testing.T) http.Handler {
+	store := db.NewSQLiteDB(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, store.Connect(context.Background()))
+	require.NoError(t, store.Setup())
+	t.Cleanup(func() { store.Close() })
+
+	return promotion.Router(store, slog.Default())
+}
+
+func do(t *testing.T, r http.Handler, method string, path string, body string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, path, strings.NewReader(body))
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	return rr
+}
+
+func decode[T any](t *testing.T, rr *httptest.ResponseRecorder) T {
+	var v T
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&v))
+	return v
+}
+
+func TestPromotionLifecycle(t *testing.T) {
+	r := newRouter(t)
+
+	rr := do(t, r, "POST", "/coupons", `{"code":"SOCKS3FOR2","type":"buyXGetY","buyQuantity":2,"getQuantity":1,"skus":["Socks"]}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = do(t, r, "POST", "/coupons", `{"code":"TENOFF","type":"percentage","rate":1000,"description":"10% off"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = do(t, r, "POST", "/coupons", `{"code":"BROKEN","type":"percentage"}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = do(t, r, "GET", "/coupons/SOCKS3FOR2", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, promotion.Promotion{
+		Code: "SOCKS3FOR2", Type: promotion.TypeBuyXGetY, BuyQuantity: 2, GetQuantity: 1, SKUs: []string{"Socks"},
+	}, decode[promotion.Promotion](t, rr))
+
+	rr = do(t, r, "GET", "/coupons", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	promotions := decode[[]promotion.Promotion](t, rr)
+	require.Len(t, promotions, 2)
+	assert.Equal(t, "SOCKS3FOR2", promotions[0].Code)
+	assert.Equal(t, "TENOFF", promotions[1].Code)
+
+	rr = do(t, r, "DELETE", "/coupons/TENOFF", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	rr = do(t, r, "GET", "/coupons/TENOFF", "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = do(t, r, "DELETE", "/coupons/TENOFF", "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestRedeemLimitPerCustomer(t *testing.T) {
+	r := newRouter(t)
+
+	rr := do(t, r, "POST", "/coupons", `{"code":"WELCOME","type":"fixedAmount","amount":500,"limitPerCustomer":1}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = do(t, r, "POST", "/coupons/WELCOME/redemptions", `{"customerId":"customer1","reference":"order1:1"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	// Redeeming again for the same invoice is not counted twice.
+	rr = do(t, r, "POST", "/coupons/WELCOME/redemptions", `{"customerId":"customer1","reference":"order1:1"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = do(t, r, "POST", "/coupons/WELCOME/redemptions", `{"customerId":"customer1","reference":"order2:1"}`)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	rr = do(t, r, "POST", "/coupons/WELCOME/redemptions", `{"customerId":"customer2","reference":"order3:1"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = do(t, r, "POST", "/coupons/NOSUCHCODE/redemptions", `{"customerId":"customer1","reference":"order1:1"}`)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = do(t, r, "GET", "/coupons/WELCOME/redemptions", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	redemptions := decode[[]promotion.Redemption](t, rr)
+	require.Len(t, redemptions, 2)
+	assert.Equal(t, "order1:1", redemptions[0].Reference)
+	assert.Equal(t, "order3:1", redemptions[1].Reference)
+}
+
+func TestReleaseRedemption(t *testing.T) {
+	r := newRouter(t)
+
+	rr := do(t, r, "POST", "/coupons", `{"code":"WELCOME","type":"fixedAmount","amount":500,"limitPerCustomer":1}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = do(t, r, "POST", "/coupons/WELCOME/redemptions", `{"customerId":"customer1","reference":"order1:1"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	// An invoice which was never paid gives the use back, so the customer can use the promotion again.
+	rr = do(t, r, "DELETE", "/coupons/WELCOME/redemptions/order1:1", "")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = do(t, r, "DELETE", "/coupons/WELCOME/redemptions/order1:1", "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = do(t, r, "POST", "/coupons/WELCOME/redemptions", `{"customerId":"customer1","reference":"order2:1"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+}
+
+func TestRedeemExpired(t *testing.T) {
+	r := newRouter(t)
+
+	expired := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	rr := do(t, r, "POST", "/coupons", `{"code":"LASTYEAR","type":"percentage","rate":500,"expiresAt":"`+expired+`"}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+	rr = do(t, r, "POST", "/coupons/LASTYEAR/redemptions", `{"customerId":"customer1","reference":"order1:1"}`)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), "expired")
+}
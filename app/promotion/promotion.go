@@ -0,0 +1,214 @@
+package promotion
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/temporalio/reference-app-orders-go/app/currency"
+)
+
+const (
+	// TypePercentage is the type of a promotion which takes a percentage off the price of products.
+	TypePercentage = "percentage"
+
+	// TypeFixedAmount is the type of a promotion which takes a fixed amount off the price of products.
+	TypeFixedAmount = "fixedAmount"
+
+	// TypeBuyXGetY is the type of a promotion which gives away some units of a product when others are bought.
+	TypeBuyXGetY = "buyXGetY"
+)
+
+// Types is the list of known promotion types.
+var Types = []string{TypePercentage, TypeFixedAmount, TypeBuyXGetY}
+
+// RateScale is the scale of percentage discounts, which are given in hundredths of a percent.
+const RateScale = 10000
+
+// Promotion is a discount which customers claim with a coupon code.
+type Promotion struct {
+	// Code is the coupon code which claims the promotion.
+	Code        string `json:"code"`
+	Description string `json:"description,omitempty"`
+	Type        string `json:"type"`
+	// Rate is the discount given by a percentage promotion, in hundredths of a percent.
+	Rate int32 `json:"rate,omitempty"`
+	// Amount is the discount given by a fixed amount promotion, in the base currency.
+	Amount currency.Money `json:"amount,omitzero"`
+	// SKUs are the products the promotion applies to, or empty for all products.
+	SKUs []string `json:"skus,omitempty"`
+	// BuyQuantity and GetQuantity define a buy X get Y promotion: for every BuyQuantity units of a product bought,
+	// the next GetQuantity units are free.
+	BuyQuantity int32 `json:"buyQuantity,omitempty"`
+	GetQuantity int32 `json:"getQuantity,omitempty"`
+	// LimitPerCustomer is how many fulfillments each customer may use the promotion on, or zero for no limit.
+	LimitPerCustomer int32 `json:"limitPerCustomer,omitempty"`
+	// ExpiresAt is when the promotion ends, or zero if it does not.
+	ExpiresAt time.Time `json:"expiresAt,omitzero"`
+}
+
+// Validate checks that a promotion is complete and consistent.
+func (p Promotion) Validate() error {
+	if p.Code == "" {
+		return fmt.Errorf("code is required")
+	}
+
+	switch p.Type {
+	case TypePercentage:
+		if p.Rate <= 0 || p.Rate > RateScale {
+			return fmt.Errorf("rate must be between 1 and %d", RateScale)
+		}
+	case TypeFixedAmount:
+		if p.Amount.Amount <= 0 {
+			return fmt.Errorf("amount must be positive")
+		}
+	case TypeBuyXGetY:
+		if p.BuyQuantity <= 0 || p.GetQuantity <= 0 {
+			return fmt.Errorf("buyQuantity and getQuantity must be positive")
+		}
+		if len(p.SKUs) == 0 {
+			return fmt.Errorf("buy X get Y promotions must name their skus")
+		}
+	default:
+		return fmt.Errorf("unknown promotion type: %s", p.Type)
+	}
+
+	if p.LimitPerCustomer < 0 {
+		return fmt.Errorf("limitPerCustomer must not be negative")
+	}
+
+	return nil
+}
+
+// Expired returns true if the promotion can no longer be used at the given time.
+func (p Promotion) Expired(at time.Time) bool {
+	return !p.ExpiresAt.IsZero() && !at.Before(p.ExpiresAt)
+}
+
+// appliesTo returns true if the promotion discounts a SKU.
+func (p Promotion) appliesTo(sku string) bool {
+	return len(p.SKUs) == 0 || slices.Contains(p.SKUs, sku)
+}
+
+// Line is a line of a fulfillment which promotions may discount.
+type Line struct {
+	SKU      string
+	Quantity int32
+	// Amount is the price of the line before discounts.
+	Amount currency.Money
+}
+
+// Discount is the amount taken off a line by a promotion.
+type Discount struct {
+	Code string
+	// Line is the index of the discounted line.
+	Line   int
+	Amount currency.Money
+}
+
+// Apply works out the discounts promotions give on a fulfillment's lines, which are priced in the currency of rate.
+// Promotions are applied in turn, each to what is left of the price after the promotions before it, so no line is
+// ever discounted below zero. Discounts are listed by promotion, then by line.
+func Apply(promotions []Promotion, lines []Line, rate currency.Rate) ([]Discount, error) {
+	remaining := make([]currency.Money, len(lines))
+	for i, line := range lines {
+		remaining[i] = line.Amount
+	}
+
+	var discounts []Discount
+
+	for _, p := range promotions {
+		amounts, err := p.discount(lines, remaining, rate)
+		if err != nil {
+			return nil, fmt.Errorf("promotion %s: %w", p.Code, err)
+		}
+
+		for i, amount := range amounts {
+			if amount.Amount <= 0 {
+				continue
+			}
+
+			if remaining[i], err = remaining[i].Sub(amount); err != nil {
+				return nil, err
+			}
+			discounts = append(discounts, Discount{Code: p.Code, Line: i, Amount: amount})
+		}
+	}
+
+	return discounts, nil
+}
+
+// discount returns the discount the promotion gives on each line, given what is left of their prices.
+func (p Promotion) discount(lines []Line, remaining []currency.Money, rate currency.Rate) ([]currency.Money, error) {
+	amounts := make([]currency.Money, len(lines))
+
+	switch p.Type {
+	case TypePercentage:
+		for i, line := range lines {
+			if !p.appliesTo(line.SKU) {
+				continue
+			}
+			amount, err := remaining[i].MulDiv(int64(p.Rate), RateScale)
+			if err != nil {
+				return nil, err
+			}
+			amounts[i] = amount
+		}
+
+	case TypeFixedAmount:
+		amount, err := rate.FromBase(p.Amount)
+		if err != nil {
+			return nil, err
+		}
+
+		// The amount is shared between the lines in proportion to their prices, with any remainder on the last.
+		var eligible []int
+		var total currency.Money
+		for i, line := range lines {
+			if p.appliesTo(line.SKU) && remaining[i].Amount > 0 {
+				eligible = append(eligible, i)
+				if total, err = total.Add(remaining[i]); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if len(eligible) == 0 {
+			return amounts, nil
+		}
+		if amount.Amount > total.Amount {
+			amount = total
+		}
+
+		left := amount
+		for n, i := range eligible {
+			if n == len(eligible)-1 {
+				amounts[i] = left
+				break
+			}
+			if amounts[i], err = amount.MulDiv(remaining[i].Amount, total.Amount); err != nil {
+				return nil, err
+			}
+			if left, err = left.Sub(amounts[i]); err != nil {
+				return nil, err
+			}
+		}
+
+	case TypeBuyXGetY:
+		for i, line := range lines {
+			if !p.appliesTo(line.SKU) || line.Quantity <= 0 {
+				continue
+			}
+			free := line.Quantity / (p.BuyQuantity + p.GetQuantity) * p.GetQuantity
+			amount, err := line.Amount.MulDiv(int64(free), int64(line.Quantity))
+			if err != nil {
+				return nil, err
+			}
+			amounts[i] = amount
+			if amount.Amount > remaining[i].Amount {
+				amounts[i] = remaining[i]
+			}
+		}
+	}
+
+	return amounts, nil
+}
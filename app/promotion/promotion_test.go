@@ -0,0 +1,131 @@
+package promotion_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/temporalio/reference-app-orders-go/app/currency"
+	"github.com/temporalio/reference-app-orders-go/app/promotion"
+)
+
+var gbp = currency.Rate{Base: "GBP", Currency: "GBP", Rate: currency.RateScale}
+
+func gbpMoney(amount int64) currency.Money {
+	return currency.NewMoney(amount, "GBP")
+}
+
+func TestApplyPercentage(t *testing.T) {
+	lines := []promotion.Line{
+		{SKU: "Hiking Boots", Quantity: 2, Amount: gbpMoney(16000)},
+		{SKU: "Guide Book", Quantity: 1, Amount: gbpMoney(1499)},
+	}
+
+	discounts, err := promotion.Apply([]promotion.Promotion{
+		{Code: "TENOFF", Type: promotion.TypePercentage, Rate: 1000},
+	}, lines, gbp)
+	require.NoError(t, err)
+
+	// Fractions of a penny are not given away.
+	require.Equal(t, []promotion.Discount{
+		{Code: "TENOFF", Line: 0, Amount: gbpMoney(1600)},
+		{Code: "TENOFF", Line: 1, Amount: gbpMoney(149)},
+	}, discounts)
+}
+
+func TestApplyFixedAmount(t *testing.T) {
+	eur, err := currency.NewTable(currency.Rates{Base: "GBP", Rates: map[string]int64{"EUR": 1170000}})
+	require.NoError(t, err)
+	rate, err := eur.Rate("EUR")
+	require.NoError(t, err)
+
+	lines := []promotion.Line{
+		{SKU: "Hiking Boots", Quantity: 1, Amount: currency.NewMoney(9360, "EUR")},
+		{SKU: "Guide Book", Quantity: 1, Amount: currency.NewMoney(1755, "EUR")},
+		{SKU: "Socks", Quantity: 1, Amount: currency.NewMoney(585, "EUR")},
+	}
+
+	// 10.00 GBP is 11.70 EUR, shared between the eligible lines by price.
+	discounts, err := promotion.Apply([]promotion.Promotion{
+		{Code: "TENNER", Type: promotion.TypeFixedAmount, Amount: currency.NewMoney(1000, "GBP"), SKUs: []string{"Hiking Boots", "Guide Book"}},
+	}, lines, *rate)
+	require.NoError(t, err)
+
+	require.Equal(t, []promotion.Discount{
+		{Code: "TENNER", Line: 0, Amount: currency.NewMoney(985, "EUR")},
+		{Code: "TENNER", Line: 1, Amount: currency.NewMoney(185, "EUR")},
+	}, discounts)
+
+	// A fixed amount never takes more than the price.
+	discounts, err = promotion.Apply([]promotion.Promotion{
+		{Code: "BIG", Type: promotion.TypeFixedAmount, Amount: currency.NewMoney(100000, "GBP"), SKUs: []string{"Socks"}},
+	}, lines, *rate)
+	require.NoError(t, err)
+	require.Equal(t, []promotion.Discount{{Code: "BIG", Line: 2, Amount: currency.NewMoney(585, "EUR")}}, discounts)
+}
+
+func TestApplyBuyXGetY(t *testing.T) {
+	lines := []promotion.Line{
+		{SKU: "Socks", Quantity: 7, Amount: gbpMoney(3500)},
+		{SKU: "Hiking Boots", Quantity: 3, Amount: gbpMoney(24000)},
+	}
+
+	// Buy two pairs of socks, get the third free: seven pairs includes two free.
+	discounts, err := promotion.Apply([]promotion.Promotion{
+		{Code: "SOCKS3FOR2", Type: promotion.TypeBuyXGetY, BuyQuantity: 2, GetQuantity: 1, SKUs: []string{"Socks"}},
+	}, lines, gbp)
+	require.NoError(t, err)
+
+	require.Equal(t, []promotion.Discount{{Code: "SOCKS3FOR2", Line: 0, Amount: gbpMoney(1000)}}, discounts)
+}
+
+func TestApplyStacked(t *testing.T) {
+	lines := []promotion.Line{
+		{SKU: "Socks", Quantity: 3, Amount: gbpMoney(1500)},
+	}
+
+	// Each promotion applies to what the ones before it left, and none takes the price below zero.
+	discounts, err := promotion.Apply([]promotion.Promotion{
+		{Code: "SOCKS3FOR2", Type: promotion.TypeBuyXGetY, BuyQuantity: 2, GetQuantity: 1, SKUs: []string{"Socks"}},
+		{Code: "HALF", Type: promotion.TypePercentage, Rate: 5000},
+		{Code: "TENNER", Type: promotion.TypeFixedAmount, Amount: gbpMoney(1000)},
+		{Code: "MORE", Type: promotion.TypeFixedAmount, Amount: gbpMoney(1000)},
+	}, lines, gbp)
+	require.NoError(t, err)
+
+	require.Equal(t, []promotion.Discount{
+		{Code: "SOCKS3FOR2", Line: 0, Amount: gbpMoney(500)},
+		{Code: "HALF", Line: 0, Amount: gbpMoney(500)},
+		{Code: "TENNER", Line: 0, Amount: gbpMoney(500)},
+	}, discounts)
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		promotion promotion.Promotion
+		err       string
+	}{
+		{promotion.Promotion{Code: "OK", Type: promotion.TypePercentage, Rate: 2500}, ""},
+		{promotion.Promotion{Type: promotion.TypePercentage, Rate: 2500}, "code is required"},
+		{promotion.Promotion{Code: "TOOMUCH", Type: promotion.TypePercentage, Rate: 10001}, "rate must be between"},
+		{promotion.Promotion{Code: "NOTHING", Type: promotion.TypeFixedAmount}, "amount must be positive"},
+		{promotion.Promotion{Code: "ANY", Type: promotion.TypeBuyXGetY, BuyQuantity: 1, GetQuantity: 1}, "must name their skus"},
+		{promotion.Promotion{Code: "WHAT", Type: "mystery"}, "unknown promotion type"},
+	} {
+		err := tc.promotion.Validate()
+		if tc.err == "" {
+			require.NoError(t, err, tc.promotion.Code)
+		} else {
+			require.ErrorContains(t, err, tc.err, tc.promotion.Code)
+		}
+	}
+}
+
+func TestExpired(t *testing.T) {
+	expiry := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	p := promotion.Promotion{Code: "SUMMER", ExpiresAt: expiry}
+
+	require.False(t, p.Expired(expiry.Add(-time.Second)))
+	require.True(t, p.Expired(expiry))
+	require.False(t, promotion.Promotion{Code: "ALWAYS"}.Expired(expiry))
+}
@@ -13,9 +13,11 @@ import (
 
 	prom "github.com/prometheus/client_golang/prometheus"
 	"github.com/temporalio/reference-app-orders-go/app/billing"
+	"github.com/temporalio/reference-app-orders-go/app/catalog"
 	"github.com/temporalio/reference-app-orders-go/app/config"
 	"github.com/temporalio/reference-app-orders-go/app/db"
 	"github.com/temporalio/reference-app-orders-go/app/fraud"
+	"github.com/temporalio/reference-app-orders-go/app/inventory"
 	"github.com/temporalio/reference-app-orders-go/app/order"
 	"github.com/temporalio/reference-app-orders-go/app/shipment"
 	"github.com/uber-go/tally/v4"
@@ -217,7 +219,7 @@ func RunAPIServers(ctx context.Context, config config.AppConfig, client client.C
 
 	db := db.CreateDB(config)
 
-	if slices.Contains(services, "orders") || slices.Contains(services, "shipment") {
+	if slices.Contains(services, "billing") || slices.Contains(services, "fraud") || slices.Contains(services, "order") || slices.Contains(services, "shipment") || slices.Contains(services, "inventory") || slices.Contains(services, "catalog") {
 		err := db.Connect(context.TODO())
 		if err != nil {
 			return fmt.Errorf("failed to connect to database: %w", err)
@@ -238,11 +240,20 @@ func RunAPIServers(ctx context.Context, config config.AppConfig, client client.C
 		switch service {
 		case "billing":
 			g.Go(func() error {
-				return runAPIServer(ctx, port, billing.Router(client, logger), logger)
+				return runAPIServer(ctx, port, billing.Router(client, db, logger), logger)
 			})
 		case "fraud":
+			if config.FraudRulesFile != "" {
+				rules, err := fraud.LoadRules(config.FraudRulesFile)
+				if err != nil {
+					return err
+				}
+				if err := fraud.StoreRules(ctx, db, rules); err != nil {
+					return fmt.Errorf("failed to store fraud rules: %w", err)
+				}
+			}
 			g.Go(func() error {
-				return runAPIServer(ctx, port, fraud.Router(logger), logger)
+				return runAPIServer(ctx, port, fraud.Router(db, logger), logger)
 			})
 		case "order":
 			g.Go(func() error {
@@ -252,6 +263,14 @@ func RunAPIServers(ctx context.Context, config config.AppConfig, client client.C
 			g.Go(func() error {
 				return runAPIServer(ctx, port, shipment.Router(client, db, logger), logger)
 			})
+		case "inventory":
+			g.Go(func() error {
+				return runAPIServer(ctx, port, inventory.Router(db, logger), logger)
+			})
+		case "catalog":
+			g.Go(func() error {
+				return runAPIServer(ctx, port, catalog.Router(db, logger), logger)
+			})
 		default:
 			return fmt.Errorf("unknown service: %s", service)
 		}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"go.temporal.io/sdk/log"
+	"go.temporal.io/sdk/temporal"
 	"go.temporal.io/sdk/workflow"
 )
 
@@ -37,6 +38,8 @@ const (
 	ShipmentStatusDispatched = "dispatched"
 	// ShipmentStatusDelivered represents a shipment that has been delivered to the customer
 	ShipmentStatusDelivered = "delivered"
+	// ShipmentStatusCancelled represents a shipment that was cancelled before the carrier picked it up
+	ShipmentStatusCancelled = "cancelled"
 )
 
 // ShipmentCarrierUpdateSignal is used by a carrier to update a shipment's status.
@@ -113,6 +116,9 @@ func (s *shipmentImpl) run(ctx workflow.Context, input *ShipmentInput) (*Shipmen
 			Items:     input.Items,
 		},
 	).Get(ctx, &result)
+	if temporal.IsCanceledError(err) {
+		return nil, s.cancel(ctx)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -132,7 +138,27 @@ func (s *shipmentImpl) handleCarrierUpdates(ctx workflow.Context) error {
 	var signal ShipmentCarrierUpdateSignal
 
 	for s.status != ShipmentStatusDelivered {
-		ch.Receive(ctx, &signal)
+		cancelled := false
+
+		sel := workflow.NewSelector(ctx)
+		sel.AddReceive(ch, func(c workflow.ReceiveChannel, _ bool) {
+			c.Receive(ctx, &signal)
+		})
+		sel.AddReceive(ctx.Done(), func(workflow.ReceiveChannel, bool) {
+			cancelled = true
+		})
+		sel.Select(ctx)
+
+		if cancelled {
+			if s.status == ShipmentStatusPending || s.status == ShipmentStatusBooked {
+				return s.cancel(ctx)
+			}
+
+			// The carrier already has the shipment, so it is too late to cancel.
+			s.logger.Info("Ignoring cancellation of dispatched shipment", "status", s.status)
+			ctx, _ = workflow.NewDisconnectedContext(ctx)
+			continue
+		}
 
 		s.logger.Info("Received carrier update", "status", signal.Status)
 
@@ -142,6 +168,18 @@ func (s *shipmentImpl) handleCarrierUpdates(ctx workflow.Context) error {
 	return nil
 }
 
+// cancel records the shipment as cancelled, and returns the cancellation error for the workflow.
+func (s *shipmentImpl) cancel(ctx workflow.Context) error {
+	s.logger.Info("Shipment cancelled")
+
+	ctx, _ = workflow.NewDisconnectedContext(ctx)
+	if err := s.updateStatus(ctx, ShipmentStatusCancelled); err != nil {
+		return err
+	}
+
+	return temporal.NewCanceledError()
+}
+
 func (s *shipmentImpl) updateStatus(ctx workflow.Context, status string) error {
 	s.status = status
 	s.updatedAt = workflow.Now(ctx)
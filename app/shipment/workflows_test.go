@@ -7,6 +7,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/temporalio/reference-app-orders-go/app/shipment"
+	"go.temporal.io/sdk/temporal"
 	"go.temporal.io/sdk/testsuite"
 )
 
@@ -77,3 +78,105 @@ func TestShipmentWorkflow(t *testing.T) {
 	err := env.GetWorkflowResult(&result)
 	assert.NoError(t, err)
 }
+
+func TestShipmentCancelWhenBooked(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	a := &shipment.Activities{}
+
+	shipmentInput := shipment.ShipmentInput{
+		RequestorWID: "parentwid",
+		ID:           "test",
+		Items: []shipment.Item{
+			{SKU: "test1", Quantity: 1},
+		},
+	}
+
+	env.RegisterActivity(a.BookShipment)
+	env.OnActivity(a.UpdateShipmentStatus, mock.Anything, mock.Anything).Return(nil)
+
+	env.RegisterDelayedCallback(func() {
+		env.CancelWorkflow()
+	}, time.Second*1)
+
+	env.OnSignalExternalWorkflow(mock.Anything,
+		"parentwid", "",
+		shipment.ShipmentStatusUpdatedSignalName,
+		mock.MatchedBy(func(arg shipment.ShipmentStatusUpdatedSignal) bool {
+			return arg.Status == shipment.ShipmentStatusBooked
+		}),
+	).Return(nil).Once()
+
+	env.OnSignalExternalWorkflow(mock.Anything,
+		"parentwid", "",
+		shipment.ShipmentStatusUpdatedSignalName,
+		mock.MatchedBy(func(arg shipment.ShipmentStatusUpdatedSignal) bool {
+			return arg.Status == shipment.ShipmentStatusCancelled
+		}),
+	).Return(nil).Once()
+
+	env.ExecuteWorkflow(
+		shipment.Shipment,
+		&shipmentInput,
+	)
+
+	err := env.GetWorkflowError()
+	assert.True(t, temporal.IsCanceledError(err))
+}
+
+func TestShipmentIgnoresCancelOnceDispatched(t *testing.T) {
+	s := testsuite.WorkflowTestSuite{}
+	env := s.NewTestWorkflowEnvironment()
+	a := &shipment.Activities{}
+
+	shipmentInput := shipment.ShipmentInput{
+		RequestorWID: "parentwid",
+		ID:           "test",
+		Items: []shipment.Item{
+			{SKU: "test1", Quantity: 1},
+		},
+	}
+
+	env.RegisterActivity(a.BookShipment)
+	env.OnActivity(a.UpdateShipmentStatus, mock.Anything, mock.Anything).Return(nil)
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(
+			shipment.ShipmentCarrierUpdateSignalName,
+			shipment.ShipmentCarrierUpdateSignal{
+				Status: shipment.ShipmentStatusDispatched,
+			},
+		)
+	}, time.Second*1)
+
+	env.RegisterDelayedCallback(func() {
+		env.CancelWorkflow()
+	}, time.Second*2)
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(
+			shipment.ShipmentCarrierUpdateSignalName,
+			shipment.ShipmentCarrierUpdateSignal{
+				Status: shipment.ShipmentStatusDelivered,
+			},
+		)
+	}, time.Second*3)
+
+	env.OnSignalExternalWorkflow(mock.Anything,
+		"parentwid", "",
+		shipment.ShipmentStatusUpdatedSignalName,
+		mock.MatchedBy(func(arg shipment.ShipmentStatusUpdatedSignal) bool {
+			return arg.Status != shipment.ShipmentStatusCancelled
+		}),
+	).Return(nil).Times(3)
+
+	env.ExecuteWorkflow(
+		shipment.Shipment,
+		&shipmentInput,
+	)
+
+	var result shipment.ShipmentResult
+	err := env.GetWorkflowResult(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, "test:1234", result.CourierReference)
+}
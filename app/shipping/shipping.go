@@ -0,0 +1,188 @@
+package shipping
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrNoRate is returned when no rate rule covers a parcel.
+var ErrNoRate = errors.New("no shipping rate")
+
+// Rates is the shipping rate configuration.
+type Rates struct {
+	// DefaultZone is used for destination regions which are not listed in Zones.
+	DefaultZone string `json:"defaultZone"`
+	// DefaultService is used for parcels which do not specify a service level.
+	DefaultService string `json:"defaultService"`
+	// Zones maps each destination region to its shipping zone.
+	Zones    map[string]string `json:"zones"`
+	Oversize Oversize          `json:"oversize"`
+	Rules    []Rule            `json:"rules"`
+}
+
+// Oversize describes the surcharge for parcels over a weight limit.
+type Oversize struct {
+	// Weight is the weight above which a parcel is oversize, in grams. Zero disables the surcharge.
+	Weight int32 `json:"weight"`
+	// Surcharge is added to the rate for oversize parcels, in cents.
+	Surcharge int32 `json:"surcharge"`
+}
+
+// Rule is a single entry in the rate table.
+type Rule struct {
+	ID string `json:"id"`
+	// Origin is the warehouse the rule applies to, or empty for all warehouses.
+	Origin  string `json:"origin,omitempty"`
+	Zone    string `json:"zone"`
+	Service string `json:"service"`
+	// MaxWeight is the heaviest parcel the rule applies to, in grams. Zero means there is no limit.
+	MaxWeight int32 `json:"maxWeight,omitempty"`
+	// Price is the cost of shipping a parcel, in cents.
+	Price int32 `json:"price"`
+}
+
+// Parcel is a shipment to be priced.
+type Parcel struct {
+	// Origin is the warehouse the parcel ships from.
+	Origin string
+	// Region is the destination region, or empty for the default zone.
+	Region string
+	// Service is the service level, or empty for the default service.
+	Service string
+	// Weight is the total weight of the parcel, in grams.
+	Weight int32
+}
+
+// Quote is the price of shipping a parcel, and how it was arrived at.
+type Quote struct {
+	RuleID    string `json:"ruleId"`
+	Origin    string `json:"origin"`
+	Zone      string `json:"zone"`
+	Service   string `json:"service"`
+	Weight    int32  `json:"weight"`
+	Rate      int32  `json:"rate"`
+	Oversize  bool   `json:"oversize,omitempty"`
+	Surcharge int32  `json:"surcharge,omitempty"`
+	Price     int32  `json:"price"`
+}
+
+//go:embed rates.json
+var defaultRates []byte
+
+// LoadRates reads shipping rates from a JSON file, or returns the built-in rates if path is empty.
+func LoadRates(path string) (Rates, error) {
+	var rates Rates
+
+	data := defaultRates
+	if path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return rates, fmt.Errorf("failed to read shipping rates: %w", err)
+		}
+	}
+
+	if err := json.Unmarshal(data, &rates); err != nil {
+		return rates, fmt.Errorf("failed to decode shipping rates: %w", err)
+	}
+
+	return rates, nil
+}
+
+// RateTable prices parcels using a set of rate rules.
+type RateTable struct {
+	rates Rates
+}
+
+// NewRateTable validates rates and returns a RateTable which applies them.
+func NewRateTable(rates Rates) (*RateTable, error) {
+	ids := make(map[string]bool, len(rates.Rules))
+
+	for _, r := range rates.Rules {
+		if r.ID == "" || r.Zone == "" || r.Service == "" {
+			return nil, fmt.Errorf("shipping rate rules require an id, zone and service")
+		}
+		if ids[r.ID] {
+			return nil, fmt.Errorf("duplicate shipping rate rule: %s", r.ID)
+		}
+		if r.MaxWeight < 0 || r.Price < 0 {
+			return nil, fmt.Errorf("shipping rate rule %s must not have a negative weight or price", r.ID)
+		}
+		ids[r.ID] = true
+	}
+
+	if rates.Oversize.Weight < 0 || rates.Oversize.Surcharge < 0 {
+		return nil, fmt.Errorf("oversize weight and surcharge must not be negative")
+	}
+
+	return &RateTable{rates: rates}, nil
+}
+
+// Quote returns the price of shipping a parcel.
+// Rules for the parcel's origin warehouse are preferred over rules for all warehouses, then the rule with the
+// lowest weight limit which covers the parcel is used.
+func (t *RateTable) Quote(p Parcel) (*Quote, error) {
+	zone, ok := t.rates.Zones[p.Region]
+	if !ok {
+		zone = t.rates.DefaultZone
+	}
+
+	service := p.Service
+	if service == "" {
+		service = t.rates.DefaultService
+	}
+
+	var best *Rule
+
+	for i := range t.rates.Rules {
+		r := &t.rates.Rules[i]
+
+		if r.Zone != zone || r.Service != service || (r.Origin != "" && r.Origin != p.Origin) {
+			continue
+		}
+		if r.MaxWeight != 0 && p.Weight > r.MaxWeight {
+			continue
+		}
+		if best == nil || better(r, best) {
+			best = r
+		}
+	}
+
+	if best == nil {
+		return nil, fmt.Errorf("%w: %s parcel of %dg from %q to %s", ErrNoRate, service, p.Weight, p.Origin, zone)
+	}
+
+	q := &Quote{
+		RuleID:  best.ID,
+		Origin:  p.Origin,
+		Zone:    zone,
+		Service: service,
+		Weight:  p.Weight,
+		Rate:    best.Price,
+		Price:   best.Price,
+	}
+
+	if t.rates.Oversize.Weight > 0 && p.Weight > t.rates.Oversize.Weight {
+		q.Oversize = true
+		q.Surcharge = t.rates.Oversize.Surcharge
+		q.Price += q.Surcharge
+	}
+
+	return q, nil
+}
+
+// better reports whether rule a should be used in preference to rule b, when both cover a parcel.
+// Ties are broken by keeping the earlier rule, so lookups are deterministic.
+func better(a *Rule, b *Rule) bool {
+	if (a.Origin != "") != (b.Origin != "") {
+		return a.Origin != ""
+	}
+	if a.MaxWeight == 0 || b.MaxWeight == 0 {
+		return b.MaxWeight == 0 && a.MaxWeight != 0
+	}
+
+	return a.MaxWeight < b.MaxWeight
+}
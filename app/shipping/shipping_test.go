@@ -0,0 +1,112 @@
+package shipping_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/temporalio/reference-app-orders-go/app/shipping"
+)
+
+func newRateTable(t *testing.T) *shipping.RateTable {
+	table, err := shipping.NewRateTable(shipping.Rates{
+		DefaultZone:    "domestic",
+		DefaultService: "standard",
+		Zones:          map[string]string{"GB": "domestic", "DE": "europe"},
+		Oversize:       shipping.Oversize{Weight: 20000, Surcharge: 2500},
+		Rules: []shipping.Rule{
+			{ID: "dom-heavy", Zone: "domestic", Service: "standard", Price: 1800},
+			{ID: "dom-10kg", Zone: "domestic", Service: "standard", MaxWeight: 10000, Price: 900},
+			{ID: "dom-2kg", Zone: "domestic", Service: "standard", MaxWeight: 2000, Price: 500},
+			{ID: "dom-express", Zone: "domestic", Service: "express", Price: 2900},
+			{ID: "a-dom-2kg", Origin: "Warehouse A", Zone: "domestic", Service: "standard", MaxWeight: 2000, Price: 400},
+			{ID: "eu-2kg", Zone: "europe", Service: "standard", MaxWeight: 2000, Price: 1100},
+		},
+	})
+	require.NoError(t, err)
+
+	return table
+}
+
+func TestQuote(t *testing.T) {
+	table := newRateTable(t)
+
+	for _, tc := range []struct {
+		name   string
+		parcel shipping.Parcel
+		rule   string
+		price  int32
+	}{
+		{"smallest band which fits", shipping.Parcel{Origin: "Warehouse B", Region: "GB", Weight: 1500}, "dom-2kg", 500},
+		{"band limit is inclusive", shipping.Parcel{Origin: "Warehouse B", Region: "GB", Weight: 2000}, "dom-2kg", 500},
+		{"next band up", shipping.Parcel{Origin: "Warehouse B", Region: "GB", Weight: 2001}, "dom-10kg", 900},
+		{"unlimited band", shipping.Parcel{Origin: "Warehouse B", Region: "GB", Weight: 15000}, "dom-heavy", 1800},
+		{"origin specific rule", shipping.Parcel{Origin: "Warehouse A", Region: "GB", Weight: 1500}, "a-dom-2kg", 400},
+		{"origin falls back to any warehouse", shipping.Parcel{Origin: "Warehouse A", Region: "GB", Weight: 5000}, "dom-10kg", 900},
+		{"default zone and service", shipping.Parcel{Origin: "Warehouse B", Weight: 100}, "dom-2kg", 500},
+		{"unknown region uses default zone", shipping.Parcel{Origin: "Warehouse B", Region: "FR", Weight: 100}, "dom-2kg", 500},
+		{"service level", shipping.Parcel{Origin: "Warehouse B", Region: "GB", Service: "express", Weight: 100}, "dom-express", 2900},
+		{"destination zone", shipping.Parcel{Origin: "Warehouse B", Region: "DE", Weight: 100}, "eu-2kg", 1100},
+		{"empty parcel", shipping.Parcel{Origin: "Warehouse B", Region: "GB"}, "dom-2kg", 500},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			q, err := table.Quote(tc.parcel)
+			require.NoError(t, err)
+			require.Equal(t, tc.rule, q.RuleID)
+			require.Equal(t, tc.price, q.Price)
+			require.False(t, q.Oversize)
+		})
+	}
+}
+
+func TestQuoteOversize(t *testing.T) {
+	table := newRateTable(t)
+
+	q, err := table.Quote(shipping.Parcel{Origin: "Warehouse B", Region: "GB", Weight: 20000})
+	require.NoError(t, err)
+	require.False(t, q.Oversize)
+	require.Equal(t, int32(1800), q.Price)
+
+	q, err = table.Quote(shipping.Parcel{Origin: "Warehouse B", Region: "GB", Weight: 20001})
+	require.NoError(t, err)
+	require.Equal(t, &shipping.Quote{
+		RuleID:    "dom-heavy",
+		Origin:    "Warehouse B",
+		Zone:      "domestic",
+		Service:   "standard",
+		Weight:    20001,
+		Rate:      1800,
+		Oversize:  true,
+		Surcharge: 2500,
+		Price:     4300,
+	}, q)
+
+	// There is no rule for heavy parcels to Europe.
+	_, err = table.Quote(shipping.Parcel{Origin: "Warehouse B", Region: "DE", Weight: 25000})
+	require.ErrorIs(t, err, shipping.ErrNoRate)
+}
+
+func TestQuoteNoRate(t *testing.T) {
+	table := newRateTable(t)
+
+	_, err := table.Quote(shipping.Parcel{Origin: "Warehouse B", Region: "DE", Service: "express", Weight: 100})
+	require.ErrorIs(t, err, shipping.ErrNoRate)
+}
+
+func TestLoadRates(t *testing.T) {
+	rates, err := shipping.LoadRates("")
+	require.NoError(t, err)
+	_, err = shipping.NewRateTable(rates)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "rates.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"rules":[{"id":"a","zone":"z","service":"s","price":1},{"id":"a","zone":"z","service":"s","price":2}]}`), 0o600))
+
+	rates, err = shipping.LoadRates(path)
+	require.NoError(t, err)
+	require.Len(t, rates.Rules, 2)
+
+	_, err = shipping.NewRateTable(rates)
+	require.ErrorContains(t, err, "duplicate shipping rate rule")
+}
@@ -0,0 +1,213 @@
+package tax
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+)
+
+const (
+	// RoundHalfUp rounds fractions of a cent of one half or more up.
+	RoundHalfUp = "halfUp"
+
+	// RoundHalfEven rounds fractions of a cent of exactly one half to the nearest even cent.
+	RoundHalfEven = "halfEven"
+
+	// RoundUp rounds any fraction of a cent up.
+	RoundUp = "up"
+
+	// RoundDown discards any fraction of a cent.
+	RoundDown = "down"
+)
+
+// RoundingModes is the list of supported rounding modes.
+var RoundingModes = []string{RoundHalfUp, RoundHalfEven, RoundUp, RoundDown}
+
+// ErrUnknownRegion is returned when there are no tax rules for a region.
+var ErrUnknownRegion = errors.New("unknown tax region")
+
+// ErrUnknownTaxClass is returned when a region has no rate for a tax class.
+var ErrUnknownTaxClass = errors.New("unknown tax class")
+
+// ErrAmountOutOfRange is returned when the tax on a line is too large to calculate.
+var ErrAmountOutOfRange = errors.New("taxable amount out of range")
+
+// Rules is the tax configuration for every region we sell into.
+type Rules struct {
+	// DefaultRegion is used for orders which do not specify a region.
+	DefaultRegion string   `json:"defaultRegion"`
+	Regions       []Region `json:"regions"`
+}
+
+// Region holds the tax rules for a single jurisdiction.
+type Region struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+	// Rates maps each tax class to its rate, in basis points (1/100th of a percent).
+	Rates map[string]int32 `json:"rates"`
+	// Exemptions lists SKUs which are not taxed in this region, whatever their tax class.
+	Exemptions []string `json:"exemptions,omitempty"`
+	// Rounding is how fractions of a cent are rounded, defaulting to halfUp.
+	Rounding string `json:"rounding,omitempty"`
+}
+
+// Line is an invoice line to be taxed.
+type Line struct {
+	SKU      string
+	TaxClass string
+	// Amount is the taxable amount for the line, in cents.
+	Amount int64
+}
+
+// LineTax is the tax calculated for an invoice line.
+type LineTax struct {
+	Region   string `json:"region"`
+	TaxClass string `json:"taxClass"`
+	// Rate is the rate applied, in basis points.
+	Rate     int32  `json:"rate"`
+	Exempt   bool   `json:"exempt,omitempty"`
+	Rounding string `json:"rounding"`
+	Taxable  int64  `json:"taxable"`
+	Amount   int64  `json:"amount"`
+}
+
+// Calculator calculates the tax due on invoice lines shipped to a region.
+// Implementations must be deterministic: the same region and lines must always give the same result.
+type Calculator interface {
+	Calculate(region string, lines []Line) ([]LineTax, error)
+}
+
+//go:embed rules.json
+var defaultRules []byte
+
+// LoadRules reads tax rules from a JSON file, or returns the built-in rules if path is empty.
+func LoadRules(path string) (Rules, error) {
+	var rules Rules
+
+	data := defaultRules
+	if path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return rules, fmt.Errorf("failed to read tax rules: %w", err)
+		}
+	}
+
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return rules, fmt.Errorf("failed to decode tax rules: %w", err)
+	}
+
+	return rules, nil
+}
+
+// RuleEngine is a Calculator which applies per-region rates, exemptions and rounding.
+type RuleEngine struct {
+	defaultRegion string
+	regions       map[string]Region
+}
+
+// NewRuleEngine validates rules and returns a RuleEngine which applies them.
+func NewRuleEngine(rules Rules) (*RuleEngine, error) {
+	e := &RuleEngine{
+		defaultRegion: rules.DefaultRegion,
+		regions:       make(map[string]Region, len(rules.Regions)),
+	}
+
+	for _, r := range rules.Regions {
+		if r.Code == "" {
+			return nil, fmt.Errorf("tax region code is required")
+		}
+		if _, ok := e.regions[r.Code]; ok {
+			return nil, fmt.Errorf("duplicate tax region: %s", r.Code)
+		}
+		if r.Rounding == "" {
+			r.Rounding = RoundHalfUp
+		}
+		if !slices.Contains(RoundingModes, r.Rounding) {
+			return nil, fmt.Errorf("unknown rounding mode for %s: %s", r.Code, r.Rounding)
+		}
+		for class, rate := range r.Rates {
+			if rate < 0 {
+				return nil, fmt.Errorf("negative tax rate for %s %s", r.Code, class)
+			}
+		}
+		e.regions[r.Code] = r
+	}
+
+	if _, ok := e.regions[e.defaultRegion]; e.defaultRegion != "" && !ok {
+		return nil, fmt.Errorf("default tax region has no rules: %s", e.defaultRegion)
+	}
+
+	return e, nil
+}
+
+// Calculate returns the tax for each line, in the same order as lines.
+func (e *RuleEngine) Calculate(region string, lines []Line) ([]LineTax, error) {
+	if region == "" {
+		region = e.defaultRegion
+	}
+
+	r, ok := e.regions[region]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownRegion, region)
+	}
+
+	result := make([]LineTax, len(lines))
+
+	for i, l := range lines {
+		rate, ok := r.Rates[l.TaxClass]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q in %s", ErrUnknownTaxClass, l.TaxClass, r.Code)
+		}
+
+		t := LineTax{
+			Region:   r.Code,
+			TaxClass: l.TaxClass,
+			Rate:     rate,
+			Rounding: r.Rounding,
+			Taxable:  l.Amount,
+		}
+
+		if slices.Contains(r.Exemptions, l.SKU) {
+			t.Rate = 0
+			t.Exempt = true
+		}
+
+		if t.Rate > 0 && l.Amount > math.MaxInt64/int64(t.Rate) {
+			return nil, fmt.Errorf("%w: %d for %s", ErrAmountOutOfRange, l.Amount, l.SKU)
+		}
+
+		t.Amount = round(l.Amount*int64(t.Rate), 10000, r.Rounding)
+
+		result[i] = t
+	}
+
+	return result, nil
+}
+
+// round divides n by d, rounding the remainder according to mode.
+// n and d must not be negative.
+func round(n int64, d int64, mode string) int64 {
+	q, rem := n/d, n%d
+
+	switch mode {
+	case RoundUp:
+		if rem > 0 {
+			q++
+		}
+	case RoundHalfEven:
+		if rem*2 > d || (rem*2 == d && q%2 == 1) {
+			q++
+		}
+	case RoundHalfUp:
+		if rem*2 >= d {
+			q++
+		}
+	}
+
+	return q
+}
@@ -0,0 +1,107 @@
+package tax_test
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/temporalio/reference-app-orders-go/app/tax"
+)
+
+func newEngine(t *testing.T) *tax.RuleEngine {
+	e, err := tax.NewRuleEngine(tax.Rules{
+		DefaultRegion: "GB",
+		Regions: []tax.Region{
+			{Code: "GB", Rates: map[string]int32{"standard": 2000, "zero": 0}, Exemptions: []string{"Child Seat"}},
+			{Code: "DE", Rates: map[string]int32{"standard": 1900}, Rounding: tax.RoundHalfEven},
+			{Code: "US-OR", Rates: map[string]int32{"standard": 725}, Rounding: tax.RoundDown},
+			{Code: "US-WA", Rates: map[string]int32{"standard": 725}, Rounding: tax.RoundUp},
+		},
+	})
+	require.NoError(t, err)
+
+	return e
+}
+
+func TestCalculate(t *testing.T) {
+	e := newEngine(t)
+
+	result, err := e.Calculate("", []tax.Line{
+		{SKU: "Nike Air", TaxClass: "standard", Amount: 12005},
+		{SKU: "Guide Book", TaxClass: "zero", Amount: 1500},
+		{SKU: "Child Seat", TaxClass: "standard", Amount: 9000},
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, []tax.LineTax{
+		{Region: "GB", TaxClass: "standard", Rate: 2000, Rounding: tax.RoundHalfUp, Taxable: 12005, Amount: 2401},
+		{Region: "GB", TaxClass: "zero", Rate: 0, Rounding: tax.RoundHalfUp, Taxable: 1500, Amount: 0},
+		{Region: "GB", TaxClass: "standard", Rate: 0, Exempt: true, Rounding: tax.RoundHalfUp, Taxable: 9000, Amount: 0},
+	}, result)
+}
+
+func TestRounding(t *testing.T) {
+	e := newEngine(t)
+
+	for _, tc := range []struct {
+		region string
+		amount int64
+		tax    int64
+	}{
+		// 1050 * 19% = 199.5, an exact half rounds to the even cent.
+		{"DE", 1050, 200},
+		// 1150 * 19% = 218.5
+		{"DE", 1150, 218},
+		// 1010 * 7.25% = 73.2225
+		{"US-OR", 1010, 73},
+		{"US-WA", 1010, 74},
+		// 1000 * 7.25% = 72.5, no fraction left over to round.
+		{"US-WA", 1000, 73},
+		{"US-OR", 1000, 72},
+	} {
+		result, err := e.Calculate(tc.region, []tax.Line{{SKU: "Nike Air", TaxClass: "standard", Amount: tc.amount}})
+		require.NoError(t, err)
+		require.Equal(t, tc.tax, result[0].Amount, "%s %d", tc.region, tc.amount)
+	}
+}
+
+func TestUnknownRegionAndClass(t *testing.T) {
+	e := newEngine(t)
+
+	_, err := e.Calculate("FR", []tax.Line{{SKU: "Nike Air", TaxClass: "standard", Amount: 100}})
+	require.ErrorIs(t, err, tax.ErrUnknownRegion)
+
+	_, err = e.Calculate("DE", []tax.Line{{SKU: "Nike Air", TaxClass: "zero", Amount: 100}})
+	require.ErrorIs(t, err, tax.ErrUnknownTaxClass)
+}
+
+func TestLargeAmounts(t *testing.T) {
+	e := newEngine(t)
+
+	// Amounts beyond 32 bits are taxed exactly.
+	result, err := e.Calculate("GB", []tax.Line{{SKU: "Nike Air", TaxClass: "standard", Amount: 50_000_000_000}})
+	require.NoError(t, err)
+	require.Equal(t, int64(10_000_000_000), result[0].Amount)
+
+	_, err = e.Calculate("GB", []tax.Line{{SKU: "Nike Air", TaxClass: "standard", Amount: math.MaxInt64 / 1000}})
+	require.ErrorIs(t, err, tax.ErrAmountOutOfRange)
+}
+
+func TestLoadRules(t *testing.T) {
+	rules, err := tax.LoadRules("")
+	require.NoError(t, err)
+	_, err = tax.NewRuleEngine(rules)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "rules.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"defaultRegion":"NZ","regions":[{"code":"NZ","rates":{"standard":1500},"rounding":"bankers"}]}`), 0o600))
+
+	rules, err = tax.LoadRules(path)
+	require.NoError(t, err)
+	require.Equal(t, "NZ", rules.DefaultRegion)
+
+	_, err = tax.NewRuleEngine(rules)
+	require.ErrorContains(t, err, "unknown rounding mode")
+}
@@ -17,9 +17,11 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/temporalio/reference-app-orders-go/app/billing"
+	"github.com/temporalio/reference-app-orders-go/app/catalog"
 	"github.com/temporalio/reference-app-orders-go/app/config"
 	"github.com/temporalio/reference-app-orders-go/app/db"
 	"github.com/temporalio/reference-app-orders-go/app/fraud"
+	"github.com/temporalio/reference-app-orders-go/app/inventory"
 	"github.com/temporalio/reference-app-orders-go/app/order"
 	"github.com/temporalio/reference-app-orders-go/app/shipment"
 	"github.com/testcontainers/testcontainers-go/modules/mongodb"
@@ -94,11 +96,6 @@ func Test_Order(t *testing.T) {
 
 	logger := slog.Default()
 
-	fraudAPI := httptest.NewServer(fraud.Router(logger))
-	defer fraudAPI.Close()
-	billingAPI := httptest.NewServer(billing.Router(c, logger))
-	defer billingAPI.Close()
-
 	mongoDBContainer, err := mongodb.Run(ctx, "mongo:6")
 	require.NoError(t, err)
 	defer mongoDBContainer.Terminate(ctx)
@@ -109,22 +106,50 @@ func Test_Order(t *testing.T) {
 	uri := fmt.Sprintf("mongodb://localhost:%s", port.Port())
 
 	config := config.AppConfig{
-		MongoURL:   uri,
-		BillingURL: billingAPI.URL,
-		FraudURL:   fraudAPI.URL,
+		MongoURL: uri,
 	}
 
 	db := db.CreateDB(config)
 	require.NoError(t, db.Connect(ctx))
 	require.NoError(t, db.Setup())
 
+	fraudAPI := httptest.NewServer(fraud.Router(db, logger))
+	defer fraudAPI.Close()
+	config.FraudURL = fraudAPI.URL
+
+	billingAPI := httptest.NewServer(billing.Router(c, db, logger))
+	defer billingAPI.Close()
 	orderAPI := httptest.NewServer(order.Router(c, db, logger))
 	defer orderAPI.Close()
 	shipmentAPI := httptest.NewServer(shipment.Router(c, db, logger))
 	defer shipmentAPI.Close()
+	inventoryAPI := httptest.NewServer(inventory.Router(db, logger))
+	defer inventoryAPI.Close()
+	catalogAPI := httptest.NewServer(catalog.Router(db, logger))
+	defer catalogAPI.Close()
 
+	config.BillingURL = billingAPI.URL
 	config.OrderURL = orderAPI.URL
 	config.ShipmentURL = shipmentAPI.URL
+	config.InventoryURL = inventoryAPI.URL
+	config.CatalogURL = catalogAPI.URL
+
+	res, err := postJSON(catalogAPI.URL+"/products", &catalog.Product{
+		SKU:       "Nike Air",
+		Name:      "Nike Air Max",
+		UnitPrice: 12000,
+		Weight:    900,
+	})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	res, err = postJSON(inventoryAPI.URL+"/inventory", &inventory.InventoryLevel{
+		Warehouse: "Warehouse A",
+		SKU:       "Nike Air",
+		Quantity:  10,
+	})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
 
 	g, ctx := errgroup.WithContext(ctx)
 
@@ -138,7 +163,7 @@ func Test_Order(t *testing.T) {
 		return order.RunWorker(ctx, config, c)
 	})
 
-	res, err := postJSON(orderAPI.URL+"/orders", &order.OrderInput{
+	res, err = postJSON(orderAPI.URL+"/orders", &order.OrderInput{
 		ID:         "order123",
 		CustomerID: "customer123",
 		Items: []*order.Item{
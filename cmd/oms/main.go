@@ -11,6 +11,8 @@ import (
 
 	"github.com/spf13/cobra"
 	"github.com/temporalio/reference-app-orders-go/app/config"
+	"github.com/temporalio/reference-app-orders-go/app/db"
+	"github.com/temporalio/reference-app-orders-go/app/ledger"
 	"github.com/temporalio/reference-app-orders-go/app/server"
 	"github.com/temporalio/reference-app-orders-go/app/temporalutil"
 	"go.temporal.io/sdk/client"
@@ -162,6 +164,58 @@ var codecCmd = &cobra.Command{
 	},
 }
 
+var ledgerCmd = &cobra.Command{
+	Use:   "ledger",
+	Short: "Ledger maintenance for the Billing system",
+}
+
+var ledgerCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check that every ledger transaction balances and that total debits equal total credits",
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		ctx := context.Background()
+
+		config, err := config.AppConfigFromEnv()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
+		store := db.CreateDB(config)
+		if err := store.Connect(ctx); err != nil {
+			return fmt.Errorf("failed to connect to database: %w", err)
+		}
+		defer store.Close()
+
+		if err := store.Setup(); err != nil {
+			return err
+		}
+
+		report, err := ledger.Check(ctx, store)
+		if err != nil {
+			return fmt.Errorf("failed to check ledger: %w", err)
+		}
+
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "%-20s %14s %14s %14s\n", "ACCOUNT", "DEBIT", "CREDIT", "BALANCE")
+		for _, b := range report.Accounts {
+			fmt.Fprintf(out, "%-20s %14d %14d %14d\n", b.Account, b.Debit, b.Credit, b.Balance)
+		}
+		fmt.Fprintf(out, "%-20s %14d %14d %14d\n", "TOTAL", report.Debit, report.Credit, report.Debit-report.Credit)
+
+		for _, id := range report.Unbalanced {
+			fmt.Fprintf(out, "Unbalanced transaction: %s\n", id)
+		}
+
+		if !report.Balanced() {
+			return fmt.Errorf("ledger does not balance")
+		}
+
+		fmt.Fprintln(out, "Ledger balances")
+
+		return nil
+	},
+}
+
 func init() {
 	// The encryption key ID is a string that can be used to look up an encryption
 	// key (e.g., from a key management system). If this option is specified, then
@@ -176,7 +230,7 @@ func init() {
 		"ID of key used to encrypt payload data (optional)")
 
 	workerCmd.PersistentFlags().StringSliceVarP(&workers, "services", "s", []string{"order", "shipment", "billing"}, "Workers to run")
-	apiCmd.PersistentFlags().StringSliceVarP(&apis, "services", "s", []string{"order", "shipment", "billing", "fraud"}, "API Servers to run")
+	apiCmd.PersistentFlags().StringSliceVarP(&apis, "services", "s", []string{"order", "shipment", "billing", "fraud", "inventory", "catalog"}, "API Servers to run")
 
 	codecCmd.PersistentFlags().IntVarP(&codecPort, "port", "p", defaultCodecPort,
 		"Port number on which the Codec Server will listen for requests")
@@ -186,6 +240,9 @@ func init() {
 	rootCmd.AddCommand(workerCmd)
 	rootCmd.AddCommand(apiCmd)
 	rootCmd.AddCommand(codecCmd)
+
+	ledgerCmd.AddCommand(ledgerCheckCmd)
+	rootCmd.AddCommand(ledgerCmd)
 }
 
 func main() {